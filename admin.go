@@ -0,0 +1,324 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// adminScanBatch is the SCAN COUNT hint and delete batch size used by admin operations
+const adminScanBatch = 100
+
+// flushableKeyTypes are the key namespaces of the plugin; flushes are confined
+// to them so keys of other applications sharing the Redis are never deleted
+var flushableKeyTypes = []string{"quota", "ratelimit", "concurrency", "distinct", "websocket"}
+
+// isAdminRequest reports whether req targets the admin endpoint
+func (q *quotaPlugin) isAdminRequest(req *http.Request) bool {
+	path := q.config.Admin.Path
+	return path != "" && (req.URL.Path == path || strings.HasPrefix(req.URL.Path, strings.TrimSuffix(path, "/")+"/"))
+}
+
+// serveAdmin handles admin operations, authenticated with the admin secret
+func (q *quotaPlugin) serveAdmin(rw http.ResponseWriter, req *http.Request) {
+	secret := req.Header.Get("X-Admin-Secret")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(q.config.Admin.Secret)) != 1 {
+		writeAdminJSON(rw, http.StatusUnauthorized, map[string]string{"error": "invalid admin secret"})
+		return
+	}
+
+	operation := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(q.config.Admin.Path, "/")), "/")
+	switch operation {
+	case "flush":
+		q.serveAdminFlush(rw, req)
+	case "concurrency":
+		q.serveAdminConcurrency(rw, req)
+	case "distinct":
+		q.serveAdminDistinct(rw, req)
+	case "inspect":
+		q.serveAdminInspect(rw, req)
+	case "export":
+		q.serveAdminExport(rw, req)
+	case "import":
+		q.serveAdminImport(rw, req)
+	default:
+		writeAdminJSON(rw, http.StatusNotFound, map[string]string{"error": "unknown admin operation"})
+	}
+}
+
+// serveAdminFlush deletes every key starting with the required prefix query parameter
+func (q *quotaPlugin) serveAdminFlush(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeAdminJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "flush requires POST"})
+		return
+	}
+
+	// Refuse to run without an explicit prefix so unrelated keys are never flushed
+	prefix := req.URL.Query().Get("prefix")
+	if prefix == "" {
+		writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": "prefix is required"})
+		return
+	}
+	if !isFlushablePrefix(prefix) {
+		writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": "prefix must start with a plugin key namespace"})
+		return
+	}
+
+	var deleted int64
+	for _, connection := range q.connections {
+		client, err := connection.get()
+		if err != nil {
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "deleted": deleted})
+			return
+		}
+
+		count, err := FlushPrefix(req.Context(), client, prefix)
+		deleted += count
+		if err != nil {
+			q.log.errorf("Admin flush of prefix %s failed: %v", prefix, err)
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "deleted": deleted})
+			return
+		}
+	}
+
+	q.log.infof("Admin flush deleted %d keys with prefix %s", deleted, prefix)
+	writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"prefix": prefix, "deleted": deleted})
+}
+
+// serveAdminConcurrency reports the in-flight and peak requests of the identifier query parameter
+func (q *quotaPlugin) serveAdminConcurrency(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAdminJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "concurrency requires GET"})
+		return
+	}
+
+	identifier := req.URL.Query().Get("identifier")
+	if identifier == "" {
+		writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": "identifier is required"})
+		return
+	}
+
+	// The identifier's counters live on the connection of its tracking manager
+	for _, manager := range q.currentManagers() {
+		if !manager.config.TrackConcurrency || manager.ensureInitialized() != nil {
+			continue
+		}
+		info, err := manager.concurrency.GetConcurrencyInfo(req.Context(), identifier)
+		if err != nil {
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if info.InFlight > 0 || info.Peak > 0 {
+			writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"identifier": identifier, "in_flight": info.InFlight, "peak": info.Peak})
+			return
+		}
+	}
+
+	writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"identifier": identifier, "in_flight": 0, "peak": 0})
+}
+
+// serveAdminDistinct reports the approximate distinct values seen in the current
+// window by every identifier with distinct counting enabled
+func (q *quotaPlugin) serveAdminDistinct(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAdminJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "distinct requires GET"})
+		return
+	}
+
+	counts := make(map[string]interface{})
+	for key, manager := range q.currentManagers() {
+		if manager.config.DistinctWindow == "" || manager.ensureInitialized() != nil {
+			continue
+		}
+		count, windowStart, err := manager.distinct.Count(req.Context())
+		if err != nil {
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		counts[key] = map[string]interface{}{
+			"count":        count,
+			"window":       manager.distinct.window.String(),
+			"window_start": windowStart,
+		}
+	}
+
+	writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"identifiers": counts})
+}
+
+// serveAdminInspect reports the stored token bucket of the identifier query
+// parameter for every identifier with a token bucket rate limit
+func (q *quotaPlugin) serveAdminInspect(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAdminJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "inspect requires GET"})
+		return
+	}
+
+	identifier := req.URL.Query().Get("identifier")
+	if identifier == "" {
+		writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": "identifier is required"})
+		return
+	}
+
+	buckets := make(map[string]interface{})
+	for key, manager := range q.currentManagers() {
+		rateLimit := manager.config.RateLimit
+		if !rateLimit.Enabled || rateLimit.Algorithm == AlgorithmSlidingWindow || manager.ensureInitialized() != nil {
+			continue
+		}
+		bucket, err := manager.rateLimiter.Inspect(req.Context(), identifier)
+		if err != nil {
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		buckets[key] = bucket
+	}
+
+	writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"identifier": identifier, "buckets": buckets})
+}
+
+// serveAdminExport dumps the quota, rate limit and connection counters of every
+// Redis connection as JSON, for backups and migrations
+func (q *quotaPlugin) serveAdminExport(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAdminJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "export requires GET"})
+		return
+	}
+
+	keys := []ExportedKey{}
+	for name, connection := range q.connections {
+		client, err := connection.get()
+		if err != nil {
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		exported, err := ExportState(req.Context(), client)
+		if err != nil {
+			q.log.errorf("Admin export failed: %v", err)
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		for _, key := range exported {
+			key.Connection = name
+			keys = append(keys, key)
+		}
+	}
+
+	q.log.infof("Admin export dumped %d keys", len(keys))
+	writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// serveAdminImport restores a dump produced by the export operation, writing
+// each key to the Redis connection it was exported from
+func (q *quotaPlugin) serveAdminImport(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeAdminJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "import requires POST"})
+		return
+	}
+
+	var dump struct {
+		Keys []ExportedKey `json:"keys"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&dump); err != nil {
+		writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid dump: %v", err)})
+		return
+	}
+
+	// Group keys by connection, refusing the dump before writing anything when
+	// it references a connection this configuration doesn't have
+	byConnection := make(map[string][]ExportedKey)
+	for _, key := range dump.Keys {
+		if _, ok := q.connections[key.Connection]; !ok {
+			writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown Redis connection: %s", key.Connection)})
+			return
+		}
+		if !isExportedKey(key.Key) {
+			writeAdminJSON(rw, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("key %q is not a plugin key", key.Key)})
+			return
+		}
+		byConnection[key.Connection] = append(byConnection[key.Connection], key)
+	}
+
+	imported := 0
+	for name, keys := range byConnection {
+		client, err := q.connections[name].get()
+		if err != nil {
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "imported": imported})
+			return
+		}
+
+		count, err := ImportState(req.Context(), client, keys)
+		imported += count
+		if err != nil {
+			q.log.errorf("Admin import failed: %v", err)
+			writeAdminJSON(rw, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "imported": imported})
+			return
+		}
+	}
+
+	q.log.infof("Admin import restored %d keys", imported)
+	writeAdminJSON(rw, http.StatusOK, map[string]interface{}{"imported": imported})
+}
+
+// isFlushablePrefix reports whether prefix lies within a plugin key namespace
+func isFlushablePrefix(prefix string) bool {
+	for _, keyType := range flushableKeyTypes {
+		if strings.HasPrefix(prefix, keyType+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// FlushPrefix deletes all keys starting with prefix using SCAN and batched DEL
+func FlushPrefix(ctx context.Context, client RedisClient, prefix string) (int64, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("prefix is required")
+	}
+
+	match := escapeGlob(prefix) + "*"
+	var deleted int64
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, adminScanBatch)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to scan keys: %w", err)
+		}
+
+		if len(keys) > 0 {
+			count, err := client.Del(ctx, keys...)
+			deleted += count
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete keys: %w", err)
+			}
+		}
+
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+// escapeGlob escapes Redis glob metacharacters so s matches literally
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// writeAdminJSON writes an admin response as JSON
+func writeAdminJSON(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(body)
+}
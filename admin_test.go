@@ -0,0 +1,155 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// adminRequest sends an admin request with secret to handler
+func adminRequest(handler http.Handler, method, target, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if secret != "" {
+		req.Header.Set("X-Admin-Secret", secret)
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestFlushPrefixRemovesOnlyPrefixedKeys(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+	// More keys than one SCAN batch
+	for i := 0; i < 2*adminScanBatch+5; i++ {
+		client.Set(ctx, fmt.Sprintf("staging:quota:u%d", i), 1, 0)
+	}
+	kept := []string{"production:quota:u1", "staging", "stagingx:quota:u1", "staging*:quota:u1"}
+	for _, key := range kept {
+		client.Set(ctx, key, 1, 0)
+	}
+
+	deleted, err := FlushPrefix(ctx, client, "staging:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2*adminScanBatch+5 {
+		t.Fatalf("deleted %d keys, want %d", deleted, 2*adminScanBatch+5)
+	}
+	if n, _ := client.Exists(ctx, kept...); n != int64(len(kept)) {
+		t.Fatalf("%d of %d unprefixed keys left", n, len(kept))
+	}
+
+	// Glob characters in the prefix match literally
+	if deleted, _ := FlushPrefix(ctx, client, "staging*"); deleted != 1 {
+		t.Fatalf("prefix staging* deleted %d keys, want 1", deleted)
+	}
+	if _, err := FlushPrefix(ctx, client, ""); err == nil {
+		t.Fatal("empty prefix accepted")
+	}
+}
+
+func TestServeAdminFlush(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Admin = AdminConfig{Path: "/_quota", Secret: "s3cret"}
+	})
+	ctx := context.Background()
+	server.mem.Set(ctx, "quota:staging-a:2024-01-01", 1, 0)
+	server.mem.Set(ctx, "quota:production-a:2024-01-01", 1, 0)
+	server.mem.Set(ctx, "session:a", 1, 0)
+
+	if rw := adminRequest(handler, http.MethodPost, "/_quota/flush?prefix=quota:staging-", "wrong"); rw.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: status %d, want 401", rw.Code)
+	}
+	if rw := adminRequest(handler, http.MethodPost, "/_quota/flush", "s3cret"); rw.Code != http.StatusBadRequest {
+		t.Fatalf("missing prefix: status %d, want 400", rw.Code)
+	}
+	if rw := adminRequest(handler, http.MethodGet, "/_quota/flush?prefix=quota:staging-", "s3cret"); rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET flush: status %d, want 405", rw.Code)
+	}
+	for _, prefix := range []string{"session:", "quota", "q"} {
+		if rw := adminRequest(handler, http.MethodPost, "/_quota/flush?prefix="+prefix, "s3cret"); rw.Code != http.StatusBadRequest {
+			t.Fatalf("prefix %q outside the plugin's keys: status %d, want 400", prefix, rw.Code)
+		}
+	}
+	if n, _ := server.mem.Exists(ctx, "quota:staging-a:2024-01-01", "session:a"); n != 2 {
+		t.Fatal("rejected flush deleted keys")
+	}
+
+	rw := adminRequest(handler, http.MethodPost, "/_quota/flush?prefix=quota:staging-", "s3cret")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("flush: status %d, body %s", rw.Code, rw.Body.String())
+	}
+	if n, _ := server.mem.Exists(ctx, "quota:staging-a:2024-01-01", "quota:production-a:2024-01-01", "session:a"); n != 2 {
+		t.Fatalf("%d keys left, want only the production quota and the session", n)
+	}
+}
+
+func TestServeAdminInspect(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Admin = AdminConfig{Path: "/_quota", Secret: "s3cret"}
+	})
+	serveAs(handler, "u1")
+
+	if rw := adminRequest(handler, http.MethodGet, "/_quota/inspect", "s3cret"); rw.Code != http.StatusBadRequest {
+		t.Fatalf("missing identifier: status %d, want 400", rw.Code)
+	}
+
+	rw := adminRequest(handler, http.MethodGet, "/_quota/inspect?identifier=u1", "s3cret")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("inspect: status %d, body %s", rw.Code, rw.Body.String())
+	}
+	var body struct {
+		Buckets map[string]*TokenBucket `json:"buckets"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Buckets) != 1 {
+		t.Fatalf("buckets %v, want the one rate limited identifier", body.Buckets)
+	}
+	for _, bucket := range body.Buckets {
+		if bucket == nil || bucket.Tokens != 1 || bucket.Rate != 2 {
+			t.Fatalf("bucket %+v, want 1 of 2 tokens left", bucket)
+		}
+	}
+}
+
+func TestServeAdminExportImport(t *testing.T) {
+	source, target := newTestRedisServer(t), newTestRedisServer(t)
+	admin := func(c *Config) {
+		c.Admin = AdminConfig{Path: "/_quota", Secret: "s3cret"}
+		c.Identifiers[0].RateLimit.Rate = 3
+	}
+	from := newTestPlugin(t, source, admin)
+	to := newTestPlugin(t, target, admin)
+	serveAs(from, "u1")
+	serveAs(from, "u1")
+
+	dump := adminRequest(from, http.MethodGet, "/_quota/export", "s3cret")
+	if dump.Code != http.StatusOK {
+		t.Fatalf("export: status %d, body %s", dump.Code, dump.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/_quota/import", strings.NewReader(dump.Body.String()))
+	req.Header.Set("X-Admin-Secret", "s3cret")
+	rw := httptest.NewRecorder()
+	to.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("import: status %d, body %s", rw.Code, rw.Body.String())
+	}
+
+	// The restored instance continues from the exported usage
+	if got := serveAs(to, "u1").Header().Get("X-Quota-Used"); got != "3" {
+		t.Fatalf("X-Quota-Used after the import %q, want 3", got)
+	}
+	if rw := serveAs(to, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d after the import, want the restored rate limit exhausted", rw.Code)
+	}
+}
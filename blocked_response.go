@@ -0,0 +1,69 @@
+package traefik_quota_plugin
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// structuredError is the machine-readable envelope of a blocked response
+type structuredError struct {
+	Error      string          `json:"error"`
+	Message    string          `json:"message,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	Limit      int64           `json:"limit"`
+	Remaining  int64           `json:"remaining"`
+	Reset      int64           `json:"reset"`
+	RetryAfter int64           `json:"retry_after"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+// structuredErrorBody builds the JSON envelope of a blocked response. Numeric
+// fields describe the limit that blocked the request; the configured body is
+// kept as details (JSON) or message (text). retry_after is capped to
+// maxRetryAfter when positive, while reset keeps the true reset time.
+func structuredErrorBody(response *QuotaResponse, maxRetryAfter time.Duration) string {
+	envelope := structuredError{Error: response.Reason, RequestID: response.RequestID}
+
+	if body := strings.TrimSpace(response.ResponseBody); body != "" {
+		if json.Valid([]byte(body)) {
+			envelope.Details = json.RawMessage(body)
+		} else {
+			envelope.Message = body
+		}
+	}
+
+	switch {
+	case response.Reason == "Quota exceeded" && response.Quota != nil:
+		envelope.Limit = response.Quota.Limit
+		envelope.Remaining = response.Quota.Remaining
+		if !response.Quota.ResetTime.IsZero() {
+			envelope.Reset = response.Quota.ResetTime.Unix()
+		}
+		envelope.RetryAfter = int64(math.Ceil(capRetryAfter(response.Quota.ResetIn, maxRetryAfter).Seconds()))
+	case response.RateLimit != nil:
+		envelope.Limit = int64(response.RateLimit.Limit)
+		envelope.Remaining = int64(response.RateLimit.Available)
+		envelope.Reset = response.RateLimit.ResetTime.Unix()
+		envelope.RetryAfter = int64(math.Ceil(capRetryAfter(response.RateLimit.RetryAfter, maxRetryAfter).Seconds()))
+	}
+
+	if response.RetryAfter > 0 {
+		envelope.RetryAfter = int64(math.Ceil(capRetryAfter(response.RetryAfter, maxRetryAfter).Seconds()))
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return `{"error":"` + response.Reason + `"}`
+	}
+	return string(data)
+}
+
+// capRetryAfter bounds a retry delay to max; a max of 0 leaves it unbounded
+func capRetryAfter(delay, max time.Duration) time.Duration {
+	if max > 0 && delay > max {
+		return max
+	}
+	return delay
+}
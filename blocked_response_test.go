@@ -0,0 +1,132 @@
+package traefik_quota_plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPStructuredErrors(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.StructuredErrors = true
+		c.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 1, Period: "1h", ResponseReachedLimitBody: "Slow down"}
+	})
+
+	serveAs(handler, "u1")
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, Content-Type %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("body %s: %v", rw.Body.String(), err)
+	}
+	if envelope["message"] != "Slow down" {
+		t.Errorf("message %v, want the configured body", envelope["message"])
+	}
+	for _, field := range []string{"limit", "remaining", "reset", "retry_after"} {
+		if _, ok := envelope[field].(float64); !ok {
+			t.Errorf("field %s = %v, want a number", field, envelope[field])
+		}
+	}
+	if envelope["limit"] != float64(1) || envelope["remaining"] != float64(0) {
+		t.Errorf("limit %v, remaining %v; want 1 and 0", envelope["limit"], envelope["remaining"])
+	}
+	if retryAfter := envelope["retry_after"].(float64); retryAfter < 3500 || retryAfter > 3600 {
+		t.Errorf("retry_after %v, want about an hour", retryAfter)
+	}
+}
+
+func TestStructuredErrorBodyQuota(t *testing.T) {
+	reset := time.Unix(1700000000, 0)
+	response := &QuotaResponse{
+		Reason:       "Quota exceeded",
+		ResponseBody: `{"code":"QUOTA"}`,
+		Quota:        &QuotaInfo{Limit: 5, Remaining: 0, ResetTime: reset, ResetIn: 2 * time.Hour},
+	}
+
+	var envelope structuredError
+	if err := json.Unmarshal([]byte(structuredErrorBody(response, 0)), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Limit != 5 || envelope.Remaining != 0 || envelope.Reset != reset.Unix() {
+		t.Fatalf("envelope %+v", envelope)
+	}
+	if envelope.RetryAfter != 7200 {
+		t.Fatalf("retry_after %d, want 7200", envelope.RetryAfter)
+	}
+	if string(envelope.Details) != `{"code":"QUOTA"}` || envelope.Message != "" {
+		t.Fatalf("JSON body kept as details %s, message %q", envelope.Details, envelope.Message)
+	}
+}
+
+func TestStructuredErrorBodyMaxRetryAfter(t *testing.T) {
+	reset := time.Unix(1700000000, 0)
+	response := &QuotaResponse{
+		Reason: "Quota exceeded",
+		Quota:  &QuotaInfo{Limit: 5, Remaining: 0, ResetTime: reset, ResetIn: 2 * time.Hour},
+	}
+
+	var envelope structuredError
+	if err := json.Unmarshal([]byte(structuredErrorBody(response, time.Hour)), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	// retry_after is capped while reset keeps the true time
+	if envelope.RetryAfter != 3600 || envelope.Reset != reset.Unix() {
+		t.Fatalf("retry_after %d, reset %d; want the 3600s cap and the true reset", envelope.RetryAfter, envelope.Reset)
+	}
+}
+
+func TestServeHTTPMaxRetryAfter(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.MaxRetryAfter = "1m"
+		c.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 1, Period: "48h"}
+	})
+
+	serveAs(handler, "u1")
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rw.Code)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("Retry-After %q, want the 60s cap", got)
+	}
+	// The reset header still advertises the true, days-away reset
+	reset, err := strconv.ParseInt(rw.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(time.Unix(reset, 0)); until < 47*time.Hour {
+		t.Fatalf("X-RateLimit-Reset %s away, want the uncapped reset", until)
+	}
+}
+
+func TestCapRetryAfter(t *testing.T) {
+	tests := []struct {
+		delay, max, want time.Duration
+	}{
+		{3 * 24 * time.Hour, time.Hour, time.Hour},
+		{time.Minute, time.Hour, time.Minute},
+		{3 * 24 * time.Hour, 0, 3 * 24 * time.Hour},
+	}
+	for _, tc := range tests {
+		if got := capRetryAfter(tc.delay, tc.max); got != tc.want {
+			t.Errorf("capRetryAfter(%s, %s) = %s, want %s", tc.delay, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestValidateMaxRetryAfter(t *testing.T) {
+	for value, valid := range map[string]bool{"": true, "1h": true, "0s": false, "-1m": false, "soon": false} {
+		config := validConfig()
+		config.MaxRetryAfter = value
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("MaxRetryAfter %q: error %v, want valid %v", value, err, valid)
+		}
+	}
+}
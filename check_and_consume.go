@@ -0,0 +1,198 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Orders the rate limit and quota of an identifier are checked in
+const (
+	CheckOrderRateLimit = "ratelimit" // Rate limit first, so rate limited requests never touch the quota (default)
+	CheckOrderQuota     = "quota"     // Quota first, so exhausted quotas report Quota exceeded even when also rate limited
+)
+
+// CheckAndConsume evaluates the rate limit and the quota of an identifier, in
+// CheckOrder, consuming cost units from each with atomic Redis operations, and
+// returns the unified decision of the first check that rejects the request.
+// Units are only taken when they fit, so concurrent requests never overshoot
+// either limit; a quota with ConsumeMode none is only checked.
+//
+// Redis errors fail open (the affected check allows the request) unless the
+// manager fails closed, in which case the error is returned.
+func (m *IdentifierManager) CheckAndConsume(req *http.Request, identifier string, cost int64) (*QuotaResponse, error) {
+	ctx := req.Context()
+	response := &QuotaResponse{
+		Identifier:     identifier,
+		IdentifierType: m.config.Type,
+		Cost:           cost,
+	}
+	if m.config.Quota.IsFractional() {
+		response.FractionalCost = requestFractionalCost(req, m.config, cost)
+	}
+
+	// IP identifiers may key each feature on a different address of the request
+	rateIdentifier := m.featureIdentifier(req, identifier, m.config.RateLimit.IPStrategy)
+	quotaIdentifier := m.featureIdentifier(req, identifier, m.config.Quota.IPStrategy)
+	response.quotaIdentifier = quotaIdentifier
+
+	// Either check may reject the request, and the first one to do so decides
+	// the reason and response
+	steps := []func() (bool, error){
+		func() (bool, error) { return m.checkRateLimitStep(req, rateIdentifier, cost, response) },
+		func() (bool, error) { return m.checkQuotaStep(ctx, quotaIdentifier, cost, response) },
+	}
+	if m.config.CheckOrder == CheckOrderQuota {
+		steps[0], steps[1] = steps[1], steps[0]
+	}
+
+	for _, step := range steps {
+		allowed, err := step()
+		if err == nil && allowed {
+			continue
+		}
+
+		// Give back a quota reserved before a later check rejected the request
+		if response.Consumed {
+			if refundErr := m.refundQuota(ctx, response); refundErr != nil {
+				m.log.errorf("Failed to refund quota of rejected request: %v", refundErr)
+			}
+			response.Consumed = false
+		}
+		if err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+
+	response.Allowed = true
+	response.Reason = "Request allowed"
+	return response, nil
+}
+
+// checkRateLimitStep checks the rate limit of an identifier, taking cost
+// tokens when allowed, and records the rate limit decision in response
+func (m *IdentifierManager) checkRateLimitStep(req *http.Request, identifier string, cost int64, response *QuotaResponse) (bool, error) {
+	if !m.config.RateLimit.Enabled || m.rateLimiter == nil {
+		return true, nil
+	}
+
+	allowed, info, err := m.checkRateLimit(req, identifier, cost)
+	if err != nil {
+		if m.failClosed {
+			return false, fmt.Errorf("rate limiter error: %w", err)
+		}
+		// In case of error, allow the request (fail open)
+		m.log.errorf("Rate limiter error: %v", err)
+		allowed = true
+	}
+	response.RateLimit = &info
+
+	if !allowed {
+		response.Reason = "Rate limit exceeded"
+		response.ResponseCode = m.config.RateLimit.ResponseReachedLimitCode
+		response.ResponseBody = m.config.RateLimit.ResponseReachedLimitBody
+	}
+	return allowed, nil
+}
+
+// checkQuotaStep checks, and reserves unless consumption is left to the
+// application, the quota of an identifier and records the decision in response
+func (m *IdentifierManager) checkQuotaStep(ctx context.Context, identifier string, cost int64, response *QuotaResponse) (bool, error) {
+	if !m.quotaManager.IsQuotaEnabled() {
+		return true, nil
+	}
+
+	var allowed bool
+	var info *QuotaInfo
+	var err error
+	fractional := m.config.Quota.IsFractional()
+	switch {
+	case m.config.Quota.CountsBytes():
+		// Response bytes are consumed as they are written; the request
+		// only needs some quota left
+		allowed, info, err = m.quotaManager.CheckQuotaN(ctx, identifier, 1)
+	case m.config.Quota.ConsumeMode == ConsumeModeNone && fractional:
+		allowed, info, err = m.quotaManager.CheckQuotaFloat(ctx, identifier, response.FractionalCost)
+	case m.config.Quota.ConsumeMode == ConsumeModeNone:
+		allowed, info, err = m.quotaManager.CheckQuotaN(ctx, identifier, cost)
+	case fractional:
+		allowed, info, err = m.quotaManager.ReserveQuotaFloat(ctx, identifier, response.FractionalCost)
+		response.Consumed = err == nil && allowed
+	default:
+		allowed, info, err = m.quotaManager.ReserveQuota(ctx, identifier, cost)
+		response.Consumed = err == nil && allowed
+	}
+	if err != nil {
+		if m.failClosed {
+			return false, fmt.Errorf("quota manager error: %w", err)
+		}
+		// In case of error, allow the request (fail open)
+		m.log.errorf("Quota manager error: %v", err)
+		allowed = true
+	}
+	response.Quota = info
+
+	if !allowed {
+		response.Reason = "Quota exceeded"
+		response.ResponseCode = m.config.Quota.ResponseReachedLimitCode
+		response.ResponseBody = m.config.Quota.ResponseReachedLimitBody
+	}
+	return allowed, nil
+}
+
+// consumeQuota consumes the quota units of an allowed request that were not reserved
+func (m *IdentifierManager) consumeQuota(ctx context.Context, response *QuotaResponse) (*QuotaInfo, error) {
+	if m.config.Quota.IsFractional() {
+		return m.quotaManager.ConsumeQuotaFloat(ctx, response.QuotaIdentifier(), response.FractionalCost)
+	}
+	return m.quotaManager.ConsumeQuota(ctx, response.QuotaIdentifier(), response.Cost)
+}
+
+// refundQuota gives back the quota units consumed by a request
+func (m *IdentifierManager) refundQuota(ctx context.Context, response *QuotaResponse) error {
+	if m.config.Quota.IsFractional() {
+		return m.quotaManager.RefundQuotaFloat(ctx, response.QuotaIdentifier(), response.FractionalCost)
+	}
+	return m.quotaManager.RefundQuota(ctx, response.QuotaIdentifier(), response.Cost)
+}
+
+// checkRateLimit takes cost tokens and returns the resulting rate limit state.
+// In throttle mode a denied request waits until its cost is available instead of being rejected.
+func (m *IdentifierManager) checkRateLimit(req *http.Request, identifier string, cost int64) (bool, RateLimitInfo, error) {
+	ctx := req.Context()
+
+	allowed, err := m.rateLimiter.AllowN(ctx, identifier, int(cost))
+	if err != nil {
+		return false, RateLimitInfo{}, err
+	}
+
+	// Get rate limit info
+	info, err := m.rateLimiter.GetLimitInfo(ctx, identifier)
+	if err != nil {
+		m.log.errorf("Failed to get rate limit info: %v", err)
+		info = RateLimitInfo{}
+	}
+
+	// In throttle mode, delay the request until its cost is available instead of rejecting it
+	if !allowed && m.config.RateLimit.ThrottleMode {
+		maxDelay, _ := m.config.RateLimit.ParseMaxThrottleDelay()
+		delay, possible, err := m.rateLimiter.WaitDelay(ctx, identifier, int(cost))
+		if err != nil {
+			m.log.errorf("Rate limiter throttle error: %v", err)
+		} else if possible && delay <= maxDelay {
+			tracef(req, "Throttling identifier %s for %v (cost %d)", identifier, delay, cost)
+			waited, err := m.rateLimiter.Wait(ctx, identifier, int(cost), delay)
+			if err != nil {
+				m.log.errorf("Rate limiter throttle error: %v", err)
+			} else if waited {
+				allowed = true
+				if refreshed, err := m.rateLimiter.GetLimitInfo(ctx, identifier); err == nil {
+					info = refreshed
+				}
+			}
+		}
+	}
+
+	return allowed, info, nil
+}
@@ -0,0 +1,128 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newTestManager returns an initialized manager of config backed by client
+func newTestManager(t *testing.T, client RedisClient, config IdentifierConfig) *IdentifierManager {
+	t.Helper()
+	config.Normalize()
+	manager := &IdentifierManager{
+		key:        "test",
+		config:     &config,
+		connection: newConnectedRedisConnection("primary", RedisConfig{}, client),
+	}
+	if err := manager.ensureInitialized(); err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func TestCheckAndConsumeNeverOvershoots(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    int
+		limit   int64
+		order   string
+		allowed int
+	}{
+		{"quota binds", 20, 10, "", 10},
+		{"rate limit binds", 5, 10, "", 5},
+		{"rate limit binds after the quota", 5, 10, CheckOrderQuota, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewMemoryRedisClient()
+			config := validConfig().Identifiers[0]
+			config.CheckOrder = tc.order
+			config.RateLimit = RateLimitConfig{Enabled: true, Rate: tc.rate, Period: "1h"}
+			config.Quota.Limit = tc.limit
+			manager := newTestManager(t, client, config)
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			allowed := 0
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					response, err := manager.CheckAndConsume(httptest.NewRequest("GET", "/", nil), "u1", 1)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if response.Allowed {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if allowed != tc.allowed {
+				t.Fatalf("%d requests allowed, want %d", allowed, tc.allowed)
+			}
+			// Rejected requests hold no quota
+			info, err := manager.quotaManager.GetQuotaInfo(context.Background(), "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Used != int64(tc.allowed) {
+				t.Fatalf("quota used %d, want %d", info.Used, tc.allowed)
+			}
+		})
+	}
+}
+
+func TestCheckAndConsumeOrder(t *testing.T) {
+	tests := []struct {
+		order  string
+		reason string
+	}{
+		{"", "Rate limit exceeded"},
+		{CheckOrderRateLimit, "Rate limit exceeded"},
+		{CheckOrderQuota, "Quota exceeded"},
+	}
+	for _, tc := range tests {
+		config := validConfig().Identifiers[0]
+		config.CheckOrder = tc.order
+		config.RateLimit = RateLimitConfig{Enabled: true, Rate: 1, Period: "1h"}
+		config.Quota.Limit = 1
+		manager := newTestManager(t, NewMemoryRedisClient(), config)
+
+		manager.CheckAndConsume(httptest.NewRequest("GET", "/", nil), "u1", 1)
+		// Violates both limits, so the first check decides
+		response, err := manager.CheckAndConsume(httptest.NewRequest("GET", "/", nil), "u1", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Allowed || response.Reason != tc.reason {
+			t.Errorf("order %q: allowed %v, reason %q; want %q", tc.order, response.Allowed, response.Reason, tc.reason)
+		}
+	}
+}
+
+func TestCheckAndConsumeOrderRefundsQuota(t *testing.T) {
+	config := validConfig().Identifiers[0]
+	config.CheckOrder = CheckOrderQuota
+	config.RateLimit = RateLimitConfig{Enabled: true, Rate: 1, Period: "1h"}
+	config.Quota.Limit = 5
+	manager := newTestManager(t, NewMemoryRedisClient(), config)
+
+	manager.CheckAndConsume(httptest.NewRequest("GET", "/", nil), "u1", 1)
+	response, err := manager.CheckAndConsume(httptest.NewRequest("GET", "/", nil), "u1", 1)
+	if err != nil || response.Allowed || response.Consumed {
+		t.Fatalf("rate limited request: allowed %v, consumed %v, %v", response.Allowed, response.Consumed, err)
+	}
+
+	// The quota reserved before the rate limit rejected the request is given back
+	info, err := manager.quotaManager.GetQuotaInfo(context.Background(), "u1")
+	if err != nil || info.Used != 1 {
+		t.Fatalf("quota used %v, %v; want only the allowed request", info, err)
+	}
+}
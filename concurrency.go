@@ -0,0 +1,155 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// concurrencyKeyTTL bounds how long an in-flight counter outlives its last
+// request, so counts leaked by a crashed instance eventually disappear
+const concurrencyKeyTTL = time.Hour
+
+// ConcurrencyInfo holds the in-flight and peak concurrent requests of an identifier
+type ConcurrencyInfo struct {
+	InFlight int64 `json:"in_flight"` // Requests currently being served
+	Peak     int64 `json:"peak"`      // Highest number of requests served at once
+}
+
+// ConcurrencyTracker counts concurrent requests per identifier in Redis
+type ConcurrencyTracker struct {
+	redisClient RedisClient
+	log         *pluginLogger // Logger of the owning plugin instance (nil for the default)
+}
+
+// NewConcurrencyTracker creates a new concurrency tracker
+func NewConcurrencyTracker(redisClient RedisClient) *ConcurrencyTracker {
+	return &ConcurrencyTracker{redisClient: redisClient}
+}
+
+// GetConcurrencyKey generates a Redis key for in-flight request counting
+func GetConcurrencyKey(identifier string) string {
+	return fmt.Sprintf("concurrency:%s", escapeKeyComponent(identifier))
+}
+
+// Enter records a request entering and returns the function that records it
+// leaving. Callers should defer the returned function so a panicking handler
+// doesn't leak the in-flight count.
+func (ct *ConcurrencyTracker) Enter(ctx context.Context, identifier string) (func(), error) {
+	key := GetConcurrencyKey(identifier)
+
+	inFlight, err := ct.redisClient.Incr(ctx, key)
+	if err != nil {
+		return func() {}, fmt.Errorf("failed to increment in-flight requests: %w", err)
+	}
+
+	if _, err := ct.redisClient.Expire(ctx, key, concurrencyKeyTTL); err != nil {
+		ct.log.errorf("Failed to set in-flight TTL for %s: %v", identifier, err)
+	}
+
+	if err := ct.recordPeak(ctx, identifier, inFlight); err != nil {
+		ct.log.errorf("Failed to record peak concurrency for %s: %v", identifier, err)
+	}
+
+	return func() {
+		// The request context may already be cancelled when the request ends
+		ct.leave(context.Background(), identifier)
+	}, nil
+}
+
+// recordPeak raises the peak concurrency of identifier to inFlight. The peak
+// is watched so a racing entry recording a higher count is never overwritten
+// with a lower one.
+func (ct *ConcurrencyTracker) recordPeak(ctx context.Context, identifier string, inFlight int64) error {
+	peakKey := GetConcurrencyKey(identifier) + ":peak"
+	value := strconv.FormatInt(inFlight, 10)
+
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		committed := true
+		err := ct.redisClient.Watch(ctx, func(tx RedisTx) error {
+			values, err := tx.MGet(ctx, peakKey)
+			if err != nil {
+				return err
+			}
+			if peak, ok := values[0].(string); ok {
+				if current, err := strconv.ParseInt(peak, 10, 64); err == nil && current >= inFlight {
+					return nil
+				}
+			}
+
+			committed, err = tx.Exec(ctx, []string{"SET", peakKey, value})
+			return err
+		}, peakKey)
+		if err != nil {
+			return err
+		}
+		if committed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("peak update conflicted %d times", maxWatchAttempts)
+}
+
+// leave records a request leaving, never letting the count drop below zero
+func (ct *ConcurrencyTracker) leave(ctx context.Context, identifier string) {
+	key := GetConcurrencyKey(identifier)
+
+	inFlight, err := ct.redisClient.IncrBy(ctx, key, -1)
+	if err != nil {
+		ct.log.errorf("Failed to decrement in-flight requests for %s: %v", identifier, err)
+		return
+	}
+
+	if inFlight < 0 {
+		if err := ct.redisClient.Set(ctx, key, 0, concurrencyKeyTTL); err != nil {
+			ct.log.errorf("Failed to reset in-flight requests for %s: %v", identifier, err)
+		}
+	}
+}
+
+// GetConcurrencyInfo returns the in-flight and peak requests of an identifier
+func (ct *ConcurrencyTracker) GetConcurrencyInfo(ctx context.Context, identifier string) (*ConcurrencyInfo, error) {
+	inFlight, err := ct.counter(ctx, GetConcurrencyKey(identifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get in-flight requests: %w", err)
+	}
+
+	peak, err := ct.peak(ctx, identifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peak concurrency: %w", err)
+	}
+
+	if inFlight < 0 {
+		inFlight = 0
+	}
+	return &ConcurrencyInfo{InFlight: inFlight, Peak: peak}, nil
+}
+
+// peak returns the recorded peak concurrency of an identifier
+func (ct *ConcurrencyTracker) peak(ctx context.Context, identifier string) (int64, error) {
+	return ct.counter(ctx, GetConcurrencyKey(identifier)+":peak")
+}
+
+// counter reads an integer key, treating a missing key as zero
+func (ct *ConcurrencyTracker) counter(ctx context.Context, key string) (int64, error) {
+	exists, err := ct.redisClient.Exists(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	value, err := ct.redisClient.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid counter value: %s", value)
+	}
+	return count, nil
+}
@@ -0,0 +1,116 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyTrackerCountsAndPeaks(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewConcurrencyTracker(NewMemoryRedisClient())
+
+	var leaves []func()
+	for i := 0; i < 3; i++ {
+		leave, err := tracker.Enter(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, leave)
+	}
+	if info, _ := tracker.GetConcurrencyInfo(ctx, "u1"); info.InFlight != 3 || info.Peak != 3 {
+		t.Fatalf("three in flight: %+v", info)
+	}
+
+	for _, leave := range leaves {
+		leave()
+	}
+	// A stray leave doesn't drive the count negative
+	tracker.leave(ctx, "u1")
+	if info, _ := tracker.GetConcurrencyInfo(ctx, "u1"); info.InFlight != 0 || info.Peak != 3 {
+		t.Fatalf("after completion: %+v", info)
+	}
+}
+
+func TestConcurrencyTrackerPeakRace(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	tracker := NewConcurrencyTracker(newTestRedisClient(t, server, RedisConfig{}))
+	peakKey := GetConcurrencyKey("u1") + ":peak"
+
+	// A racing entry records a higher peak just before this one writes
+	var once sync.Once
+	server.setHook(func(args []string) string {
+		if cmd := strings.ToUpper(args[0]); cmd == "EXEC" || (cmd == "SET" && args[1] == peakKey) {
+			once.Do(func() { server.mem.Set(ctx, peakKey, 9, 0) })
+		}
+		return ""
+	})
+
+	if _, err := tracker.Enter(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := tracker.GetConcurrencyInfo(ctx, "u1"); info.Peak != 9 {
+		t.Fatalf("peak %d, want the racing entry's 9 kept", info.Peak)
+	}
+}
+
+func TestServeHTTPTracksConcurrency(t *testing.T) {
+	server := newTestRedisServer(t)
+	entered, release := make(chan struct{}), make(chan struct{})
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Panic") != "" {
+			panic(http.ErrAbortHandler)
+		}
+		entered <- struct{}{}
+		<-release
+	})
+	handler := newTestPluginNext(t, server, next, func(c *Config) {
+		c.Admin = AdminConfig{Path: "/_quota", Secret: "s3cret"}
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.Limit = 100
+		c.Identifiers[0].TrackConcurrency = true
+	})
+	concurrency := func() (inFlight, peak float64) {
+		rw := adminRequest(handler, http.MethodGet, "/_quota/concurrency?identifier=u1", "s3cret")
+		var body map[string]interface{}
+		json.Unmarshal(rw.Body.Bytes(), &body)
+		inFlight, _ = body["in_flight"].(float64)
+		peak, _ = body["peak"].(float64)
+		return inFlight, peak
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveAs(handler, "u1")
+		}()
+		<-entered
+	}
+	if inFlight, peak := concurrency(); inFlight != 3 || peak != 3 {
+		t.Fatalf("three in flight: in_flight %v, peak %v", inFlight, peak)
+	}
+	close(release)
+	wg.Wait()
+	if inFlight, peak := concurrency(); inFlight != 0 || peak != 3 {
+		t.Fatalf("after completion: in_flight %v, peak %v", inFlight, peak)
+	}
+
+	// A panicking upstream still leaves
+	func() {
+		defer func() { recover() }()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		req.Header.Set("X-Panic", "1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	if inFlight, _ := concurrency(); inFlight != 0 {
+		t.Fatalf("in_flight %v after a panicking request", inFlight)
+	}
+}
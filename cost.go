@@ -0,0 +1,71 @@
+package traefik_quota_plugin
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxCost caps header-supplied costs when MaxCost is not configured
+const defaultMaxCost = 100
+
+// requestCost returns the units a request consumes from the rate limit and
+// quota: the CostHeader value when present and a positive integer, clamped to
+// MaxCost, and 1 otherwise
+func requestCost(req *http.Request, config *IdentifierConfig) int64 {
+	if config.CostHeader == "" {
+		return 1
+	}
+
+	value := strings.TrimSpace(req.Header.Get(config.CostHeader))
+	if value == "" {
+		return 1
+	}
+
+	cost, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || cost < 1 {
+		tracef(req, "Ignoring invalid cost header %s: '%s'", config.CostHeader, value)
+		return 1
+	}
+
+	maxCost := config.MaxCost
+	if maxCost <= 0 {
+		maxCost = defaultMaxCost
+	}
+	if cost > maxCost {
+		tracef(req, "Clamping cost %d to the maximum of %d", cost, maxCost)
+		return maxCost
+	}
+	return cost
+}
+
+// requestFractionalCost returns the units a request consumes from a fractional
+// quota: the CostHeader value when present and a positive number (e.g. 0.25),
+// clamped to MaxCost, and cost otherwise
+func requestFractionalCost(req *http.Request, config *IdentifierConfig, cost int64) float64 {
+	if config.CostHeader == "" {
+		return float64(cost)
+	}
+
+	value := strings.TrimSpace(req.Header.Get(config.CostHeader))
+	if value == "" {
+		return float64(cost)
+	}
+
+	fractionalCost, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(fractionalCost) || math.IsInf(fractionalCost, 0) || fractionalCost <= 0 {
+		tracef(req, "Ignoring invalid fractional cost header %s: '%s'", config.CostHeader, value)
+		return float64(cost)
+	}
+
+	maxCost := config.MaxCost
+	if maxCost <= 0 {
+		maxCost = defaultMaxCost
+	}
+	if fractionalCost > float64(maxCost) {
+		tracef(req, "Clamping cost %g to the maximum of %d", fractionalCost, maxCost)
+		return float64(maxCost)
+	}
+	return fractionalCost
+}
@@ -0,0 +1,39 @@
+package traefik_quota_plugin
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		max    int64
+		want   int64
+	}{
+		{"valid", "7", 0, 7},
+		{"clamped to the default maximum", "1000", 0, defaultMaxCost},
+		{"clamped to the configured maximum", "50", 20, 20},
+		{"missing", "", 0, 1},
+		{"not a number", "lots", 0, 1},
+		{"zero", "0", 0, 1},
+		{"negative", "-3", 0, 1},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.header != "" {
+			req.Header.Set("X-Request-Cost", tc.header)
+		}
+		config := &IdentifierConfig{CostHeader: "X-Request-Cost", MaxCost: tc.max}
+		if got := requestCost(req, config); got != tc.want {
+			t.Errorf("%s: cost %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Cost", "7")
+	if got := requestCost(req, &IdentifierConfig{}); got != 1 {
+		t.Errorf("cost %d without a CostHeader, want 1", got)
+	}
+}
@@ -0,0 +1,45 @@
+package traefik_quota_plugin
+
+import "net/http"
+
+// ReasonDenied is the block reason of denylisted identifier values
+const ReasonDenied = "Identifier denied"
+
+// isDenied reports whether the request's identifier value is denylisted. Header
+// identifiers also check the raw header, since aliases resolve to Value.
+func (ic *IdentifierConfig) isDenied(req *http.Request, identifier string) bool {
+	if len(ic.Denylist) == 0 {
+		return false
+	}
+
+	candidates := []string{identifier}
+	if ic.Type == "Header" {
+		candidates = append(candidates, req.Header.Get(ic.Name))
+	}
+
+	for _, denied := range ic.Denylist {
+		for _, candidate := range candidates {
+			if candidate != "" && candidate == denied {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deniedResponse builds the response blocking a denylisted identifier value
+func (m *IdentifierManager) deniedResponse(identifier string) *QuotaResponse {
+	statusCode := m.config.DenylistResponseCode
+	if statusCode == 0 {
+		statusCode = http.StatusForbidden
+	}
+
+	return &QuotaResponse{
+		Allowed:        false,
+		Identifier:     identifier,
+		IdentifierType: m.config.Type,
+		Reason:         ReasonDenied,
+		ResponseCode:   statusCode,
+		ResponseBody:   m.config.DenylistResponseBody,
+	}
+}
@@ -0,0 +1,51 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTPDenylist(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Type = "Query"
+		c.Identifiers[0].Name = "user"
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].Denylist = []string{"sk-revoked"}
+		c.Identifiers[0].DenylistResponseCode = http.StatusUnauthorized
+		c.Identifiers[0].DenylistResponseBody = `{"error":"revoked"}`
+	})
+
+	// Blocked with full quota and without touching Redis
+	before := len(server.commands())
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user=sk-revoked", nil))
+	if rw.Code != http.StatusUnauthorized || rw.Body.String() != `{"error":"revoked"}` {
+		t.Fatalf("denylisted value: status %d, body %s", rw.Code, rw.Body.String())
+	}
+	if n := len(server.commands()) - before; n != 0 {
+		t.Fatalf("denylisted value sent %d Redis commands, want none", n)
+	}
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user=sk-alice", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("other value: status %d, want 200", rw.Code)
+	}
+}
+
+func TestDenylistChecksAliasedHeader(t *testing.T) {
+	config := &IdentifierConfig{Type: "Header", Name: "X-User-ID", Value: "u1", Aliases: []string{"u1-old"}, Denylist: []string{"u1-old"}}
+
+	// The alias resolved to the canonical value, but the raw header is denylisted
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User-ID", "u1-old")
+	if !config.isDenied(req, "u1") {
+		t.Fatal("denylisted alias allowed")
+	}
+	req.Header.Set("X-User-ID", "u1")
+	if config.isDenied(req, "u1") {
+		t.Fatal("canonical value denied")
+	}
+}
@@ -0,0 +1,67 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DistinctCounter approximates the number of distinct identifier values seen
+// per fixed window using a Redis HyperLogLog, which stays around 12KB per
+// window however many values are added
+type DistinctCounter struct {
+	redisClient RedisClient
+	name        string
+	window      time.Duration
+}
+
+// NewDistinctCounter creates a distinct counter for the named identifier config
+func NewDistinctCounter(redisClient RedisClient, name string, window time.Duration) *DistinctCounter {
+	return &DistinctCounter{
+		redisClient: redisClient,
+		name:        name,
+		window:      window,
+	}
+}
+
+// GetDistinctKey generates a Redis key for the distinct values of a window
+func GetDistinctKey(name string, windowStart time.Time) string {
+	return fmt.Sprintf("distinct:%s:%d", name, windowStart.Unix())
+}
+
+// Add records an identifier value in the current window
+func (dc *DistinctCounter) Add(ctx context.Context, identifier string) error {
+	key := GetDistinctKey(dc.name, dc.windowStart(time.Now()))
+
+	changed, err := dc.redisClient.PFAdd(ctx, key, identifier)
+	if err != nil {
+		return fmt.Errorf("failed to add distinct value: %w", err)
+	}
+
+	// Set the expiration when the HLL changes (always true on creation); two
+	// windows keep the previous window readable for a while after it closes
+	if changed == 1 {
+		if _, err := dc.redisClient.Expire(ctx, key, dc.window*2); err != nil {
+			return fmt.Errorf("failed to set distinct expiration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Count returns the approximate distinct values of the current window and its start
+func (dc *DistinctCounter) Count(ctx context.Context) (int64, time.Time, error) {
+	windowStart := dc.windowStart(time.Now())
+
+	count, err := dc.redisClient.PFCount(ctx, GetDistinctKey(dc.name, windowStart))
+	if err != nil {
+		return 0, windowStart, fmt.Errorf("failed to count distinct values: %w", err)
+	}
+
+	return count, windowStart, nil
+}
+
+// windowStart returns the start of the window containing now
+func (dc *DistinctCounter) windowStart(now time.Time) time.Time {
+	return now.Truncate(dc.window)
+}
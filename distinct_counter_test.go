@@ -0,0 +1,68 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDistinctCounter(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+	counter := NewDistinctCounter(client, "keys", time.Hour)
+
+	for _, value := range []string{"k1", "k2", "k1", "k3", "k2"} {
+		if err := counter.Add(ctx, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, windowStart, err := counter.Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || !windowStart.Equal(time.Now().Truncate(time.Hour)) {
+		t.Fatalf("count %d in window %s, want 3 in the current hour", count, windowStart)
+	}
+
+	ttl, _ := client.TTL(ctx, GetDistinctKey("keys", windowStart))
+	if ttl <= time.Hour || ttl > 2*time.Hour {
+		t.Fatalf("window TTL %v, want two windows", ttl)
+	}
+}
+
+func TestServeAdminDistinct(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Admin = AdminConfig{Path: "/_quota", Secret: "s3cret"}
+		c.Identifiers[0].Type = "Query"
+		c.Identifiers[0].Name = "user"
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].DistinctWindow = "1h"
+	})
+
+	for _, user := range []string{"u1", "u2", "u1", "u3"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?user="+user, nil))
+	}
+
+	rw := adminRequest(handler, http.MethodGet, "/_quota/distinct", "s3cret")
+	var body struct {
+		Identifiers map[string]struct {
+			Count int64 `json:"count"`
+		} `json:"identifiers"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %s: %v", rw.Body.String(), err)
+	}
+	if len(body.Identifiers) != 1 {
+		t.Fatalf("identifiers %s, want one", rw.Body.String())
+	}
+	for key, distinct := range body.Identifiers {
+		if distinct.Count != 3 {
+			t.Fatalf("identifier %s counted %d distinct values, want 3", key, distinct.Count)
+		}
+	}
+}
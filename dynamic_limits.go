@@ -0,0 +1,169 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// defaultDynamicLimitsInterval is how often the dynamic limits hash is polled by default
+const defaultDynamicLimitsInterval = 30 * time.Second
+
+// DynamicLimitsConfig holds the settings of limits overridden from a Redis hash.
+// Each field of the hash is a manager key (Type:Name:Value) and its value a JSON
+// object of rate_limit and quota settings merged over the configured ones.
+type DynamicLimitsConfig struct {
+	Key      string `json:"key,omitempty" yaml:"Key,omitempty"`           // Redis hash of limit overrides (e.g. limits:config; disabled when empty)
+	Interval string `json:"interval,omitempty" yaml:"Interval,omitempty"` // How often the hash is polled (default 30s)
+}
+
+// Enabled reports whether limits are polled from Redis
+func (dlc DynamicLimitsConfig) Enabled() bool {
+	return dlc.Key != ""
+}
+
+// PollInterval returns the polling interval, defaulting to 30s
+func (dlc DynamicLimitsConfig) PollInterval() time.Duration {
+	if dlc.Interval == "" {
+		return defaultDynamicLimitsInterval
+	}
+	interval, _ := time.ParseDuration(dlc.Interval)
+	return interval
+}
+
+// Validate validates the dynamic limits settings
+func (dlc DynamicLimitsConfig) Validate() error {
+	if dlc.Interval == "" {
+		return nil
+	}
+	if interval, err := time.ParseDuration(dlc.Interval); err != nil || interval <= 0 {
+		return fmt.Errorf("invalid dynamic limits interval: %s", dlc.Interval)
+	}
+	return nil
+}
+
+// limitsOverride holds the settings a dynamic limits entry may override
+type limitsOverride struct {
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	Quota     QuotaSettings   `json:"quota"`
+}
+
+// applyLimitsOverride returns a normalized copy of base whose rate limit and
+// quota settings are overridden by the JSON object override
+func applyLimitsOverride(base IdentifierConfig, override string) (*IdentifierConfig, error) {
+	config := base
+	if override != "" {
+		// Round-trip the configured settings so the override never writes
+		// through pointers shared with base
+		encoded, err := json.Marshal(limitsOverride{RateLimit: base.RateLimit, Quota: base.Quota})
+		if err != nil {
+			return nil, err
+		}
+		var limits limitsOverride
+		if err := json.Unmarshal(encoded, &limits); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(override), &limits); err != nil {
+			return nil, fmt.Errorf("invalid limits override: %w", err)
+		}
+		config.RateLimit = limits.RateLimit
+		config.Quota = limits.Quota
+	}
+
+	config.Normalize()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config.prepareIPStrategies()
+	return &config, nil
+}
+
+// currentManagers returns the identifier managers requests are checked against
+func (q *quotaPlugin) currentManagers() map[string]*IdentifierManager {
+	q.managersMu.RLock()
+	defer q.managersMu.RUnlock()
+	return q.managers
+}
+
+// startDynamicLimits polls the dynamic limits hash for the lifetime of ctx
+func (q *quotaPlugin) startDynamicLimits(ctx context.Context) {
+	ticker := time.NewTicker(q.config.DynamicLimits.PollInterval())
+	go func() {
+		defer ticker.Stop()
+		q.pollDynamicLimits(ctx, ticker.C)
+	}()
+}
+
+// pollDynamicLimits refreshes the dynamic limits on every tick until ctx is done
+func (q *quotaPlugin) pollDynamicLimits(ctx context.Context, ticks <-chan time.Time) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			if err := q.refreshDynamicLimits(ctx); err != nil {
+				q.log.errorf("Failed to refresh dynamic limits: %v", err)
+			}
+		}
+	}
+}
+
+// refreshDynamicLimits reads the dynamic limits hash and swaps in managers for
+// the identifiers whose override changed. Invalid overrides are logged and the
+// identifier keeps its current limits.
+func (q *quotaPlugin) refreshDynamicLimits(ctx context.Context) error {
+	overrides, err := q.redisClient.HGetAll(ctx, q.config.DynamicLimits.Key)
+	if err != nil {
+		return err
+	}
+
+	current := q.currentManagers()
+	managers := make(map[string]*IdentifierManager, len(current))
+	changed := 0
+	for key, manager := range current {
+		override := overrides[key]
+		if override == manager.override {
+			managers[key] = manager
+			continue
+		}
+
+		config, err := applyLimitsOverride(q.baseIdentifiers[key], override)
+		if err == nil && manager.connection.config.DisableScripting && config.RateLimit.Enabled && config.RateLimit.Algorithm == AlgorithmSlidingWindow {
+			err = fmt.Errorf("the sliding window algorithm requires Redis scripting")
+		}
+		if err != nil {
+			q.log.errorf("Ignoring dynamic limits of identifier %s: %v", key, err)
+			managers[key] = manager
+			continue
+		}
+
+		managers[key] = manager.withConfig(config, override)
+		changed++
+	}
+	if changed == 0 {
+		return nil
+	}
+
+	q.managersMu.Lock()
+	q.managers = managers
+	q.managersMu.Unlock()
+
+	q.log.infof("Applied dynamic limits to %d identifiers", changed)
+	return nil
+}
+
+// withConfig returns a manager of the same identifier using config. Its
+// components are built on first use; counters stay in Redis and carry over.
+func (m *IdentifierManager) withConfig(config *IdentifierConfig, override string) *IdentifierManager {
+	return &IdentifierManager{
+		key:          m.key,
+		config:       config,
+		connection:   m.connection,
+		failClosed:   m.failClosed,
+		readCacheTTL: m.readCacheTTL,
+		log:          m.log,
+		async:        m.async,
+		override:     override,
+	}
+}
@@ -0,0 +1,122 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// managerKey returns the key of the plugin's only identifier manager
+func managerKey(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	for key := range handler.(*quotaPlugin).currentManagers() {
+		return key
+	}
+	t.Fatal("no managers")
+	return ""
+}
+
+func TestRefreshDynamicLimits(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.DynamicLimits = DynamicLimitsConfig{Key: "limits:config", Interval: "1h"}
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+	plugin := handler.(*quotaPlugin)
+	key := managerKey(t, handler)
+
+	serveAs(handler, "u1")
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d before the override, want 429", rw.Code)
+	}
+
+	server.mem.HSet(ctx, "limits:config", map[string]string{key: `{"rate_limit":{"rate":5,"burst":5}}`})
+	if err := plugin.refreshDynamicLimits(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rate := plugin.currentManagers()[key].config.RateLimit.Rate; rate != 5 {
+		t.Fatalf("rate %d after the refresh, want the override's 5", rate)
+	}
+	// Settings absent from the override keep their configured values
+	if limit := plugin.currentManagers()[key].config.Quota.Limit; limit != 5 {
+		t.Fatalf("quota limit %d, want the configured 5", limit)
+	}
+	if rw := serveAs(handler, "u1"); rw.Header().Get("X-RateLimit-Limit") != "5" {
+		t.Fatalf("X-RateLimit-Limit %q, want the overridden rate", rw.Header().Get("X-RateLimit-Limit"))
+	}
+
+	// An invalid override keeps the current limits
+	server.mem.HSet(ctx, "limits:config", map[string]string{key: `{"rate_limit":{"rate":-1}}`})
+	if err := plugin.refreshDynamicLimits(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rate := plugin.currentManagers()[key].config.RateLimit.Rate; rate != 5 {
+		t.Fatalf("rate %d after an invalid override, want 5 kept", rate)
+	}
+
+	// Removing the override restores the configured limits
+	server.mem.Del(ctx, "limits:config")
+	if err := plugin.refreshDynamicLimits(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rate := plugin.currentManagers()[key].config.RateLimit.Rate; rate != 1 {
+		t.Fatalf("rate %d after removing the override, want the configured 1", rate)
+	}
+}
+
+func TestDynamicLimitsPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.DynamicLimits = DynamicLimitsConfig{Key: "limits:config", Interval: "1h"}
+	})
+	plugin := handler.(*quotaPlugin)
+	key := managerKey(t, handler)
+
+	ticks := make(chan time.Time)
+	go plugin.pollDynamicLimits(ctx, ticks)
+
+	server.mem.HSet(ctx, "limits:config", map[string]string{key: `{"quota":{"limit":50}}`})
+	// The second tick is only received once the first refresh is done
+	ticks <- time.Now()
+	ticks <- time.Now()
+	if limit := plugin.currentManagers()[key].config.Quota.Limit; limit != 50 {
+		t.Fatalf("limit %d after a poll, want the override's 50", limit)
+	}
+}
+
+func TestApplyLimitsOverrideLeavesBaseUntouched(t *testing.T) {
+	base := validConfig().Identifiers[0]
+	base.Quota.ValueLimits = map[string]int64{"u1": 10}
+
+	config, err := applyLimitsOverride(base, `{"quota":{"value_limits":{"u1":20}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Quota.ValueLimits["u1"] != 20 || base.Quota.ValueLimits["u1"] != 10 {
+		t.Fatalf("override %d, base %d; want 20 and the base's 10 untouched", config.Quota.ValueLimits["u1"], base.Quota.ValueLimits["u1"])
+	}
+	if _, err := applyLimitsOverride(base, "not json"); err == nil || !strings.Contains(err.Error(), "invalid limits override") {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+}
+
+func TestApplyLimitsOverridePreparesIPStrategies(t *testing.T) {
+	base := IdentifierConfig{
+		Type:      "IP",
+		RateLimit: RateLimitConfig{Enabled: true, Rate: 10, Period: "1m", IPStrategy: &IPStrategy{Mode: IPStrategyTrusted, TrustedProxies: []string{"10.0.0.0/8"}}},
+	}
+
+	// The override round-trips the rate limit, and with it its IP strategy
+	config, err := applyLimitsOverride(base, `{"rate_limit":{"rate":20}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networks := config.RateLimit.IPStrategy.trustedNetworks; len(networks) != 1 {
+		t.Fatalf("trusted networks %v, want the parsed trusted proxy", networks)
+	}
+}
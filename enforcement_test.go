@@ -0,0 +1,119 @@
+package traefik_quota_plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPEnforceAfterCutover(t *testing.T) {
+	cutover := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now  time.Time
+		want int
+	}{
+		{cutover.Add(-time.Second), http.StatusOK},
+		{cutover, http.StatusTooManyRequests},
+		{cutover.Add(time.Second), http.StatusTooManyRequests},
+	}
+	for _, tc := range tests {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			c.EnforceAfter = cutover.Format(time.RFC3339)
+			c.Identifiers[0].RateLimit.Rate = 1
+		})
+		handler.(*quotaPlugin).now = func() time.Time { return tc.now }
+
+		serveAs(handler, "u1")
+		if rw := serveAs(handler, "u1"); rw.Code != tc.want {
+			t.Errorf("over-limit request at %s: status %d, want %d", tc.now, rw.Code, tc.want)
+		}
+	}
+}
+
+func TestValidateEnforceAfter(t *testing.T) {
+	config := validConfig()
+	config.EnforceAfter = "2024-06-01"
+	if err := config.Validate(); err == nil {
+		t.Fatal("enforce after without a time accepted")
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestServeHTTPEnforcePercent(t *testing.T) {
+	// Find one identifier in the lower and one in the upper half of the buckets
+	var low, high string
+	for i := 0; low == "" || high == ""; i++ {
+		user := "user" + strconv.Itoa(i)
+		if enforceBucket(user) < 50 {
+			low = user
+		} else {
+			high = user
+		}
+	}
+
+	tests := []struct {
+		name                    string
+		percent                 *int
+		lowBlocked, highBlocked bool
+	}{
+		{"unset", nil, true, true},
+		{"0", intPtr(0), false, false},
+		{"50", intPtr(50), true, false},
+		{"100", intPtr(100), true, true},
+	}
+	for _, tc := range tests {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			c.EnforcePercent = tc.percent
+			c.Identifiers[0].Type = "Query"
+			c.Identifiers[0].Name = "user"
+			c.Identifiers[0].Value = ""
+			c.Identifiers[0].RateLimit.Rate = 1
+		})
+
+		for user, wantBlocked := range map[string]bool{low: tc.lowBlocked, high: tc.highBlocked} {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?user="+user, nil))
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user="+user, nil))
+			if blocked := rw.Code == http.StatusTooManyRequests; blocked != wantBlocked {
+				t.Errorf("percent %s, bucket %d: blocked %v, want %v", tc.name, enforceBucket(user), blocked, wantBlocked)
+			}
+		}
+	}
+}
+
+func TestEffectiveEnforcePercent(t *testing.T) {
+	if percent := (&Config{}).EffectiveEnforcePercent(); percent != 100 {
+		t.Fatalf("enforce percent of a zero Config %d, want 100", percent)
+	}
+
+	// An explicit 0 survives a round trip and enforces nothing
+	var config Config
+	if err := json.Unmarshal([]byte(`{"enforce_percent": 0}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(config)
+	var decoded Config
+	json.Unmarshal(encoded, &decoded)
+	if decoded.EnforcePercent == nil || decoded.EffectiveEnforcePercent() != 0 {
+		t.Fatalf("explicit 0 enforce percent lost in %s", encoded)
+	}
+}
+
+func TestValidateEnforcePercent(t *testing.T) {
+	for _, tc := range []struct {
+		percent int
+		valid   bool
+	}{{-1, false}, {0, true}, {50, true}, {100, true}, {101, false}} {
+		config := validConfig()
+		config.EnforcePercent = &tc.percent
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("enforce percent %d: Validate() = %v", tc.percent, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package traefik_quota_plugin
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// globalRateLimitKey is the bucket shared by every request. Escaped identifiers
+// never contain a colon, so it can't collide with an identifier's bucket.
+const globalRateLimitKey = "ratelimit::global"
+
+// ReasonGlobalRateLimit is the block reason of the global rate limit
+const ReasonGlobalRateLimit = "Global rate limit exceeded"
+
+// defaultPriorityReserve is the percentage of the global burst kept for
+// higher-priority identifiers when GlobalPriorityReserve is not configured
+const defaultPriorityReserve = 20
+
+// newGlobalRateLimiter creates the limiter of the GlobalRateLimit ceiling
+func newGlobalRateLimiter(client RedisClient, config RateLimitConfig, redisConfig RedisConfig) *RateLimiter {
+	config.Normalize()
+	limiter := NewRateLimiter(client, config)
+	limiter.fixedKey = globalRateLimitKey
+	limiter.optimistic = redisConfig.DisableScripting
+	limiter.scriptingFallback = redisConfig.ScriptingFallback
+	return limiter
+}
+
+// allowGlobal takes a token from the global bucket and writes the blocked
+// response when none is left, or none beyond the share reserved for identifiers
+// of a higher priority. Redis errors fail open unless failing closed.
+// checkStart is when the limit checks began, for the Server-Timing header.
+func (q *quotaPlugin) allowGlobal(rw http.ResponseWriter, req *http.Request, checkStart time.Time) bool {
+	ctx := req.Context()
+
+	response := &QuotaResponse{
+		IdentifierType: "global",
+		Reason:         ReasonGlobalRateLimit,
+		ResponseCode:   q.config.GlobalRateLimit.ResponseReachedLimitCode,
+		ResponseBody:   q.config.GlobalRateLimit.ResponseReachedLimitBody,
+	}
+
+	// Lower-priority requests are shed while the bucket is down to the share
+	// reserved for higher priorities: the token is only taken, in the same
+	// atomic update, when the reserve stays untouched
+	reserve := q.priorityReserve(q.requestPriority(req))
+	allowed, err := q.globalLimiter.AllowNKeeping(ctx, "", 1, reserve)
+	if err == nil && !allowed && reserve > 0 {
+		tracef(req, "Shedding request: global tokens down to the %d reserved for higher priorities", reserve)
+	}
+	if err != nil {
+		if !q.failClosed() {
+			q.log.errorf("Global rate limiter error: %v", err)
+			return true
+		}
+		q.log.errorf("Global rate limiter error, failing closed: %v", err)
+		response = q.backendUnavailableResponse("global", "")
+	} else if allowed {
+		return true
+	} else if info, err := q.globalLimiter.GetLimitInfo(ctx, ""); err == nil {
+		response.RateLimit = &info
+	}
+
+	// During the grace period blocks are logged but the request is let through
+	if !q.enforceAfter.IsZero() && q.now().Before(q.enforceAfter) {
+		q.log.infof("Dry run: would block request: %s", response.Reason)
+		return true
+	}
+	if q.maintenance.active(ctx, q.now()) {
+		q.log.infof("Maintenance mode: serving request that would be blocked: %s", response.Reason)
+		return true
+	}
+
+	if q.metrics != nil {
+		q.metrics.ObserveDecision("global", nil, false)
+	}
+
+	q.writeServerTiming(rw, checkStart)
+	if q.shouldWriteQuotaHeaders(response) {
+		q.writeQuotaHeaders(rw, response)
+	}
+
+	statusCode := response.ResponseCode
+	if statusCode == 0 {
+		statusCode = http.StatusTooManyRequests
+	}
+	responseBody := response.ResponseBody
+	if responseBody == "" {
+		responseBody = response.Reason
+	}
+
+	response.RequestID = q.blockedRequestID(rw, req)
+	q.log.infof("Request blocked: %s%s", response.Reason, requestIDLogSuffix(response.RequestID))
+
+	contentType := responseContentType(responseBody, "")
+	if q.config.StructuredErrors {
+		responseBody = structuredErrorBody(response, q.maxRetryAfter)
+		contentType = "application/json"
+	}
+	rw.Header().Set("Content-Type", contentType)
+	rw.WriteHeader(statusCode)
+	rw.Write([]byte(responseBody))
+	return false
+}
+
+// requestPriority returns the highest Priority of the identifiers found in req,
+// or 0 when none is found or no identifier has a priority
+func (q *quotaPlugin) requestPriority(req *http.Request) int {
+	if q.maxPriority == 0 {
+		return 0
+	}
+
+	priority := 0
+	for _, manager := range q.currentManagers() {
+		if manager.config.Priority > priority && q.grantsPriority(req, manager.config) {
+			priority = manager.config.Priority
+		}
+	}
+	return priority
+}
+
+// grantsPriority reports whether req carries an identifier of config that the
+// identifier checks would accept, so a priority can't be claimed with any
+// value: unregistered types (whose static Value every request has), tampered
+// signed cookies and denylisted values grant none
+func (q *quotaPlugin) grantsPriority(req *http.Request, config *IdentifierConfig) bool {
+	if config.Type != "Sources" {
+		if _, ok := lookupIdentifierExtractor(config.Type); !ok {
+			return false
+		}
+	}
+	if config.hasTamperedCookie(req) {
+		return false
+	}
+
+	identifier := q.extractIdentifier(req, config)
+	return identifier != "" && !config.isDenied(req, identifier)
+}
+
+// priorityReserve returns how many global tokens are kept for requests of a
+// higher priority than priority: GlobalPriorityReserve percent of the burst
+// for priority 0, shrinking linearly to none for the highest priority
+func (q *quotaPlugin) priorityReserve(priority int) int {
+	if q.maxPriority == 0 || priority >= q.maxPriority {
+		return 0
+	}
+
+	percent := q.config.GlobalPriorityReserve
+	if percent == 0 {
+		percent = defaultPriorityReserve
+	}
+	share := float64(percent) / 100 * float64(q.maxPriority-priority) / float64(q.maxPriority)
+	return int(math.Ceil(share * float64(q.globalLimiter.config.Burst)))
+}
@@ -0,0 +1,145 @@
+package traefik_quota_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTPGlobalRateLimit(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Type = "Query"
+		c.Identifiers[0].Name = "user"
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].RateLimit.Rate = 100
+		c.Identifiers[0].Quota.Limit = 100
+		c.GlobalRateLimit = RateLimitConfig{Enabled: true, Rate: 3, Period: "1m", ResponseReachedLimitCode: http.StatusServiceUnavailable, ResponseReachedLimitBody: "busy"}
+	})
+	serve := func(user string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user="+user, nil))
+		return rw
+	}
+
+	// Each identifier is far below its own limits
+	for _, user := range []string{"u1", "u2", "u3"} {
+		if rw := serve(user); rw.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", user, rw.Code)
+		}
+	}
+	rw := serve("u4")
+	if rw.Code != http.StatusServiceUnavailable || rw.Body.String() != "busy" {
+		t.Fatalf("request past the global limit: status %d, body %q", rw.Code, rw.Body.String())
+	}
+
+	// The global block spends nothing of the identifier's own limits
+	for _, command := range server.commands() {
+		if strings.Contains(command, "u4") {
+			t.Fatalf("globally blocked request reached the identifier's keys: %s", command)
+		}
+	}
+}
+
+func TestServeHTTPGlobalPriorityShedding(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].ValuePrefix = "u"
+		priority := c.Identifiers[0]
+		priority.Name = "X-API-Key"
+		priority.ValuePrefix = "sk-"
+		priority.Priority = 1
+		priority.Denylist = []string{"sk-revoked"}
+		c.Identifiers = append(c.Identifiers, priority)
+		c.GlobalRateLimit = RateLimitConfig{Enabled: true, Rate: 5, Period: "1h"}
+		c.GlobalPriorityReserve = 40 // 2 of the 5 tokens
+	})
+	serveKey := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	for _, user := range []string{"u1", "u2", "u3"} {
+		if rw := serveAs(handler, user); rw.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", user, rw.Code)
+		}
+	}
+	if rw := serveAs(handler, "u4"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("low priority within the reserve: status %d, want it shed", rw.Code)
+	}
+
+	// A denylisted key matches the priority identifier's prefix but is not
+	// accepted by it, so it claims no priority and is shed as well
+	if code := serveKey("sk-revoked"); code != http.StatusTooManyRequests {
+		t.Fatalf("denylisted priority key: status %d, want it shed", code)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := serveKey(fmt.Sprintf("sk-%d", i)); code != want {
+			t.Fatalf("priority request %d: status %d, want %d", i+1, code, want)
+		}
+	}
+}
+
+func TestServeHTTPExtractsIdentifierOnce(t *testing.T) {
+	// The global priority and the identifier check both look at the tenant
+	extractions := 0
+	RegisterIdentifierExtractor("Tenant", func(req *http.Request, config *IdentifierConfig) string {
+		extractions++
+		return req.Header.Get("X-Tenant")
+	})
+	t.Cleanup(func() { RegisterIdentifierExtractor("Tenant", nil) })
+
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Type = "Tenant"
+		c.Identifiers[0].Name = "tenant"
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].Priority = 1
+		c.GlobalRateLimit = RateLimitConfig{Enabled: true, Rate: 5, Period: "1h"}
+		c.GlobalPriorityReserve = 40
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant", "acme")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rw.Code)
+	}
+	if extractions != 1 {
+		t.Fatalf("identifier extracted %d times, want once", extractions)
+	}
+}
+
+func TestGrantsPriority(t *testing.T) {
+	plugin := &quotaPlugin{config: validConfig()}
+	signed := &IdentifierConfig{Type: "Cookie", Name: "session", CookieSignature: CookieSignature{Secret: "s3cret", OnInvalid: CookieSignatureBlock}}
+	tests := []struct {
+		name   string
+		config *IdentifierConfig
+		cookie string
+		want   bool
+	}{
+		{"registered type", &IdentifierConfig{Type: "Header", Name: "X-User-ID", Value: "u1"}, "", true},
+		{"unregistered type", &IdentifierConfig{Type: "Custom", Value: "static"}, "", false},
+		{"denylisted value", &IdentifierConfig{Type: "Header", Name: "X-User-ID", ValuePrefix: "u", Denylist: []string{"u1"}}, "", false},
+		{"signed cookie", signed, signCookie("alice", "s3cret"), true},
+		{"tampered cookie", signed, signCookie("alice", "forged"), false},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: tc.cookie})
+		}
+		if got := plugin.grantsPriority(req, tc.config); got != tc.want {
+			t.Errorf("%s: grants priority %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package traefik_quota_plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// extractBearerIdentifier extracts the token from an "Authorization: Bearer <token>"
+// header. When Value is set only that exact token (or an alias) matches; with HashValue the
+// SHA-256 hex digest of the token is used so raw credentials never reach Redis.
+func extractBearerIdentifier(req *http.Request, config *IdentifierConfig) string {
+	authorization := strings.TrimSpace(req.Header.Get("Authorization"))
+	scheme, token, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ""
+	}
+	if config.Value != "" && !config.matchesValue(token) {
+		return ""
+	}
+	token = config.canonicalValue(token)
+
+	if config.HashValue {
+		return hashIdentifier(token)
+	}
+	return token
+}
+
+// hashIdentifier returns the SHA-256 hex digest of value
+func hashIdentifier(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,41 @@
+package traefik_quota_plugin
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractBearerIdentifier(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{"valid", "Bearer abc.def", "abc.def"},
+		{"lowercase scheme", "bearer abc.def", "abc.def"},
+		{"surrounding spaces", "  Bearer   abc.def  ", "abc.def"},
+		{"missing header", "", ""},
+		{"other scheme", "Basic dXNlcjpwYXNz", ""},
+		{"scheme only", "Bearer", ""},
+		{"empty token", "Bearer   ", ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		if got := extractBearerIdentifier(req, &IdentifierConfig{Type: "Bearer"}); got != tc.want {
+			t.Errorf("%s: identifier %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestExtractBearerIdentifierHashed(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer abc.def")
+
+	got := extractBearerIdentifier(req, &IdentifierConfig{Type: "Bearer", HashValue: true})
+	if got != hashIdentifier("abc.def") || len(got) != 64 {
+		t.Fatalf("hashed identifier %q", got)
+	}
+}
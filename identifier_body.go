@@ -0,0 +1,140 @@
+package traefik_quota_plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxBodyBytes is the body buffering cap used when MaxBodyBytes is not configured
+const defaultMaxBodyBytes int64 = 1 << 20
+
+// extractBodyIdentifier reads the request body, looks up the configured JSON path
+// and restores req.Body so the upstream still receives the full payload.
+// Requests without a body, e.g. GETs, use BodyFallback (or skip when empty)
+// and their body is left untouched.
+func extractBodyIdentifier(req *http.Request, config *IdentifierConfig) string {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return bodyFallback(req, config)
+	}
+
+	maxBytes := config.MaxBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBodyBytes
+	}
+
+	// Skip extraction early when the declared size is already beyond the cap
+	if req.ContentLength > maxBytes {
+		tracef(req, "Request body too large for identifier extraction (%d bytes)", req.ContentLength)
+		return ""
+	}
+
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		requestLogger(req).errorf("Failed to read request body: %v", err)
+		req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(data), req.Body), Closer: req.Body}
+		return ""
+	}
+
+	if int64(len(data)) > maxBytes {
+		// Hand the buffered prefix back in front of the unread remainder
+		req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(data), req.Body), Closer: req.Body}
+		tracef(req, "Request body exceeds %d bytes, skipping identifier extraction", maxBytes)
+		return ""
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	// Bodies of unknown length may still turn out empty
+	if len(data) == 0 {
+		return bodyFallback(req, config)
+	}
+
+	value, err := lookupJSONPath(data, config.Name)
+	if err != nil {
+		tracef(req, "Body identifier %s not found: %v", config.Name, err)
+		return ""
+	}
+
+	return value
+}
+
+// bodyFallback returns the value used for a request without a body
+func bodyFallback(req *http.Request, config *IdentifierConfig) string {
+	if config.BodyFallback != "" {
+		tracef(req, "Request has no body, using fallback '%s'", config.BodyFallback)
+	}
+	return config.BodyFallback
+}
+
+// readCloser combines a reader with the closer of the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// lookupJSONPath extracts a scalar value from a JSON document. The path is either
+// a JSON pointer (/data/tenant/id) or a dotted path (data.tenant.id).
+func lookupJSONPath(data []byte, path string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return "", fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	current := document
+	for _, token := range splitJSONPath(path) {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return "", fmt.Errorf("field %q not found", token)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("invalid array index %q", token)
+			}
+			current = node[index]
+		default:
+			return "", fmt.Errorf("cannot descend into %q", token)
+		}
+	}
+
+	switch value := current.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		return "", fmt.Errorf("value at %q is not a scalar", path)
+	}
+}
+
+// splitJSONPath splits a JSON pointer or dotted path into its reference tokens
+func splitJSONPath(path string) []string {
+	if path == "" || path == "/" {
+		return nil
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return strings.Split(path, ".")
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		// RFC 6901 escaping: ~1 is '/', ~0 is '~'
+		token = strings.ReplaceAll(token, "~1", "/")
+		tokens[i] = strings.ReplaceAll(token, "~0", "~")
+	}
+	return tokens
+}
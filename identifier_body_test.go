@@ -0,0 +1,108 @@
+package traefik_quota_plugin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	body := []byte(`{"data":{"tenant":{"id":"acme","n":42,"on":true},"items":[{"id":"first"}]},"a/b":{"~c":"escaped"}}`)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/data/tenant/id", "acme"},
+		{"data.tenant.id", "acme"},
+		{"/data/tenant/n", "42"},
+		{"/data/tenant/on", "true"},
+		{"/data/items/0/id", "first"},
+		{"/a~1b/~0c", "escaped"},
+	}
+	for _, tc := range tests {
+		got, err := lookupJSONPath(body, tc.path)
+		if err != nil || got != tc.want {
+			t.Errorf("lookupJSONPath(%q) = %q, %v; want %q", tc.path, got, err, tc.want)
+		}
+	}
+
+	for _, path := range []string{"/data/missing", "/data/items/3/id", "/data/tenant", "/data/tenant/id/deeper"} {
+		if got, err := lookupJSONPath(body, path); err == nil {
+			t.Errorf("lookupJSONPath(%q) = %q, want an error", path, got)
+		}
+	}
+	if _, err := lookupJSONPath([]byte("not json"), "/a"); err == nil {
+		t.Error("invalid JSON accepted")
+	}
+}
+
+func TestExtractBodyIdentifierRestoresBody(t *testing.T) {
+	payload := `{"query":"{ me }","variables":{"tenant":"acme"}}`
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(payload))
+	config := &IdentifierConfig{Type: "Body", Name: "/variables/tenant"}
+
+	if got := extractBodyIdentifier(req, config); got != "acme" {
+		t.Fatalf("identifier %q, want acme", got)
+	}
+	restored, _ := io.ReadAll(req.Body)
+	if string(restored) != payload {
+		t.Fatalf("upstream body %q, want %q", restored, payload)
+	}
+}
+
+func TestExtractBodyIdentifierSkipsLargeBodies(t *testing.T) {
+	payload := `{"tenant":"acme","padding":"` + strings.Repeat("x", 100) + `"}`
+	config := &IdentifierConfig{Type: "Body", Name: "tenant", MaxBodyBytes: 32}
+
+	// Declared length beyond the cap
+	req := httptest.NewRequest("POST", "/", strings.NewReader(payload))
+	if got := extractBodyIdentifier(req, config); got != "" {
+		t.Fatalf("identifier %q extracted from an oversized body", got)
+	}
+
+	// Unknown length discovered while reading
+	req = httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(payload)))
+	req.ContentLength = -1
+	if got := extractBodyIdentifier(req, config); got != "" {
+		t.Fatalf("identifier %q extracted from an oversized chunked body", got)
+	}
+	restored, _ := io.ReadAll(req.Body)
+	if string(restored) != payload {
+		t.Fatalf("upstream body %q, want the full payload", restored)
+	}
+}
+
+func TestExtractBodyIdentifierWithoutBody(t *testing.T) {
+	tests := []struct {
+		name string
+		req  func() *http.Request
+	}{
+		{"GET without body", func() *http.Request { return httptest.NewRequest("GET", "/", nil) }},
+		{"POST with empty body", func() *http.Request { return httptest.NewRequest("POST", "/", strings.NewReader("")) }},
+		{"empty body of unknown length", func() *http.Request {
+			req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader("")))
+			req.ContentLength = -1
+			return req
+		}},
+	}
+	for _, tc := range tests {
+		for _, fallback := range []string{"", "anonymous"} {
+			req := tc.req()
+			config := &IdentifierConfig{Type: "Body", Name: "/tenant", BodyFallback: fallback}
+			if got := extractBodyIdentifier(req, config); got != fallback {
+				t.Errorf("%s: identifier %q, want the fallback %q", tc.name, got, fallback)
+			}
+			if data, err := io.ReadAll(req.Body); err != nil || len(data) != 0 {
+				t.Errorf("%s: body %q, %v after extraction", tc.name, data, err)
+			}
+		}
+	}
+
+	// A body that is present still wins over the fallback
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"tenant":"acme"}`))
+	if got := extractBodyIdentifier(req, &IdentifierConfig{Type: "Body", Name: "/tenant", BodyFallback: "anonymous"}); got != "acme" {
+		t.Fatalf("identifier %q, want the body's acme", got)
+	}
+}
@@ -0,0 +1,38 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+)
+
+// extractClientCertIdentifier extracts a field of the verified client certificate.
+// Name selects the field: "CN" (default), "Serial" or "SAN".
+func extractClientCertIdentifier(req *http.Request, config *IdentifierConfig) string {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+	switch config.Name {
+	case "", "CN":
+		return cert.Subject.CommonName
+	case "Serial":
+		if cert.SerialNumber == nil {
+			return ""
+		}
+		return cert.SerialNumber.String()
+	case "SAN":
+		// Prefer DNS names, then email addresses, then URIs
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+		return ""
+	default:
+		return ""
+	}
+}
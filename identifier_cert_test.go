@@ -0,0 +1,58 @@
+package traefik_quota_plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestExtractClientCertIdentifier(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "billing-service"},
+		SerialNumber: big.NewInt(4711),
+		DNSNames:     []string{"billing.internal"},
+		URIs:         []*url.URL{spiffe},
+	}
+	uriOnly := &x509.Certificate{URIs: []*url.URL{spiffe}}
+
+	tests := []struct {
+		field string
+		cert  *x509.Certificate
+		want  string
+	}{
+		{"", cert, "billing-service"},
+		{"CN", cert, "billing-service"},
+		{"Serial", cert, "4711"},
+		{"SAN", cert, "billing.internal"},
+		{"SAN", uriOnly, "spiffe://example.org/billing"},
+		{"SAN", &x509.Certificate{}, ""},
+		{"Serial", &x509.Certificate{}, ""},
+		{"Fingerprint", cert, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tc.cert}}
+		if got := extractClientCertIdentifier(req, &IdentifierConfig{Type: "ClientCert", Name: tc.field}); got != tc.want {
+			t.Errorf("field %q: identifier %q, want %q", tc.field, got, tc.want)
+		}
+	}
+}
+
+func TestExtractClientCertIdentifierWithoutCert(t *testing.T) {
+	config := &IdentifierConfig{Type: "ClientCert", Name: "CN"}
+
+	// Plain HTTP, then TLS without a client certificate
+	req := httptest.NewRequest("GET", "/", nil)
+	if got := extractClientCertIdentifier(req, config); got != "" {
+		t.Fatalf("identifier %q without TLS", got)
+	}
+	req.TLS = &tls.ConnectionState{}
+	if got := extractClientCertIdentifier(req, config); got != "" {
+		t.Fatalf("identifier %q without a client certificate", got)
+	}
+}
@@ -0,0 +1,152 @@
+package traefik_quota_plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// HMAC algorithms of signed cookies
+const (
+	CookieSignatureSHA256 = "sha256" // HMAC-SHA256 (default)
+	CookieSignatureSHA1   = "sha1"   // HMAC-SHA1, for legacy applications
+	CookieSignatureSHA512 = "sha512" // HMAC-SHA512
+)
+
+// What happens to requests whose cookie fails verification
+const (
+	CookieSignatureSkip  = "skip"  // Treat the cookie as absent and try the next identifier (default)
+	CookieSignatureBlock = "block" // Block the request with 403
+)
+
+// ReasonInvalidCookieSignature is the block reason of tampered signed cookies
+const ReasonInvalidCookieSignature = "Invalid cookie signature"
+
+// CookieSignature verifies Cookie identifiers whose value is signed as
+// value.signature, the signature being the HMAC of value in hex or base64
+type CookieSignature struct {
+	Secret    string `json:"secret,omitempty" yaml:"Secret,omitempty"`        // HMAC key; verification is disabled when empty
+	Algorithm string `json:"algorithm,omitempty" yaml:"Algorithm,omitempty"`  // sha256 (default), sha1 or sha512
+	OnInvalid string `json:"on_invalid,omitempty" yaml:"OnInvalid,omitempty"` // skip (default) or block
+}
+
+// Validate validates the cookie signature settings
+func (s *CookieSignature) Validate() error {
+	switch s.Algorithm {
+	case "", CookieSignatureSHA256, CookieSignatureSHA1, CookieSignatureSHA512:
+	default:
+		return fmt.Errorf("unsupported cookie signature algorithm: %s", s.Algorithm)
+	}
+	switch s.OnInvalid {
+	case "", CookieSignatureSkip, CookieSignatureBlock:
+	default:
+		return fmt.Errorf("unsupported cookie signature failure action: %s", s.OnInvalid)
+	}
+	if s.Secret == "" && (s.Algorithm != "" || s.OnInvalid != "") {
+		return fmt.Errorf("cookie signature requires a secret")
+	}
+	return nil
+}
+
+// enabled reports whether cookie values must be verified
+func (s *CookieSignature) enabled() bool {
+	return s.Secret != ""
+}
+
+// verify checks a signed cookie value and returns the value without its
+// signature, or false when the signature is missing or doesn't match
+func (s *CookieSignature) verify(signed string) (string, bool) {
+	separator := strings.LastIndex(signed, ".")
+	if separator <= 0 {
+		return "", false
+	}
+	value, signature := signed[:separator], signed[separator+1:]
+
+	mac := hmac.New(s.hash(), []byte(s.Secret))
+	mac.Write([]byte(value))
+	expected := mac.Sum(nil)
+
+	for _, decoded := range decodeSignature(signature) {
+		if hmac.Equal(decoded, expected) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// hash returns the hash function of the configured algorithm
+func (s *CookieSignature) hash() func() hash.Hash {
+	switch s.Algorithm {
+	case CookieSignatureSHA1:
+		return sha1.New
+	case CookieSignatureSHA512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+// decodeSignature returns the candidate decodings of a signature: hex and
+// standard or URL-safe base64, padded or not
+func decodeSignature(signature string) [][]byte {
+	var candidates [][]byte
+	if decoded, err := hex.DecodeString(signature); err == nil {
+		candidates = append(candidates, decoded)
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(signature); err == nil {
+			candidates = append(candidates, decoded)
+		}
+	}
+	return candidates
+}
+
+// extractCookieIdentifier returns the value of the configured cookie, verified
+// when it is signed, falling back to Value when the cookie is absent
+func extractCookieIdentifier(req *http.Request, config *IdentifierConfig) string {
+	cookie, err := req.Cookie(config.Name)
+	if err != nil {
+		return config.Value
+	}
+	if !config.CookieSignature.enabled() {
+		return cookie.Value
+	}
+
+	value, ok := config.CookieSignature.verify(cookie.Value)
+	if !ok {
+		tracef(req, "Cookie %s has an invalid signature, skipping", config.Name)
+		return ""
+	}
+	return value
+}
+
+// hasTamperedCookie reports whether a Cookie identifier blocking invalid
+// signatures received a cookie that fails verification
+func (ic *IdentifierConfig) hasTamperedCookie(req *http.Request) bool {
+	if ic.Type != "Cookie" || !ic.CookieSignature.enabled() || ic.CookieSignature.OnInvalid != CookieSignatureBlock {
+		return false
+	}
+	cookie, err := req.Cookie(ic.Name)
+	if err != nil {
+		return false
+	}
+	_, ok := ic.CookieSignature.verify(cookie.Value)
+	return !ok
+}
+
+// tamperedCookieResponse builds the response blocking a tampered signed cookie
+func (m *IdentifierManager) tamperedCookieResponse() *QuotaResponse {
+	return &QuotaResponse{
+		Allowed:        false,
+		IdentifierType: m.config.Type,
+		Reason:         ReasonInvalidCookieSignature,
+		ResponseCode:   http.StatusForbidden,
+	}
+}
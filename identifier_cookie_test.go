@@ -0,0 +1,125 @@
+package traefik_quota_plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signCookie returns value signed with the HMAC-SHA256 of secret in hex
+func signCookie(value, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return value + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestExtractSignedCookieIdentifier(t *testing.T) {
+	config := &IdentifierConfig{Type: "Cookie", Name: "session", CookieSignature: CookieSignature{Secret: "s3cret"}}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("user.42"))
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"hex signature", signCookie("user.42", "s3cret"), "user.42"},
+		{"base64 signature", "user.42." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), "user.42"},
+		{"tampered value", strings.Replace(signCookie("user.42", "s3cret"), "42", "43", 1), ""},
+		{"other secret", signCookie("user.42", "other"), ""},
+		{"missing signature", "user42", ""},
+		{"empty value", signCookie("", "s3cret"), ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: tc.cookie})
+		if got := extractCookieIdentifier(req, config); got != tc.want {
+			t.Errorf("%s: identifier %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPSignedCookie(t *testing.T) {
+	for _, onInvalid := range []string{CookieSignatureSkip, CookieSignatureBlock} {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			cookie := c.Identifiers[0]
+			cookie.Type, cookie.Name, cookie.Value = "Cookie", "session", ""
+			cookie.CookieSignature = CookieSignature{Secret: "s3cret", OnInvalid: onInvalid}
+			c.Identifiers = append([]IdentifierConfig{cookie}, c.Identifiers...)
+		})
+
+		serve := func(cookie, user string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if user != "" {
+				req.Header.Set("X-User-ID", user)
+			}
+			req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			return rw
+		}
+
+		if rw := serve(signCookie("alice", "s3cret"), ""); rw.Code != http.StatusOK || !mentions(server, "alice") {
+			t.Fatalf("%s: valid cookie status %d, want 200 keyed on alice", onInvalid, rw.Code)
+		}
+
+		switch onInvalid {
+		case CookieSignatureSkip:
+			// The X-User-ID header identifier handles the request instead
+			if rw := serve(signCookie("alice", "forged"), "u1"); rw.Code != http.StatusOK || !mentions(server, "u1") {
+				t.Fatalf("skip: tampered cookie status %d, want 200 keyed on u1", rw.Code)
+			}
+		case CookieSignatureBlock:
+			if rw := serve(signCookie("alice", "forged"), ""); rw.Code != http.StatusForbidden || !strings.Contains(rw.Body.String(), ReasonInvalidCookieSignature) {
+				t.Fatalf("block: tampered cookie status %d, body %q", rw.Code, rw.Body.String())
+			}
+		}
+	}
+}
+
+func TestServeHTTPSignedCookieBlockAlwaysEnforced(t *testing.T) {
+	for name, configure := range map[string]func(*Config){
+		"before EnforceAfter":    func(c *Config) { c.EnforceAfter = time.Now().Add(time.Hour).Format(time.RFC3339) },
+		"outside EnforcePercent": func(c *Config) { c.EnforcePercent = intPtr(0) },
+	} {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			configure(c)
+			c.Identifiers[0].Type, c.Identifiers[0].Name, c.Identifiers[0].Value = "Cookie", "session", ""
+			c.Identifiers[0].CookieSignature = CookieSignature{Secret: "s3cret", OnInvalid: CookieSignatureBlock}
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: signCookie("alice", "forged")})
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != http.StatusForbidden {
+			t.Errorf("%s: tampered cookie status %d, want 403", name, rw.Code)
+		}
+	}
+}
+
+func TestCookieSignatureValidate(t *testing.T) {
+	tests := []struct {
+		signature CookieSignature
+		valid     bool
+	}{
+		{CookieSignature{}, true},
+		{CookieSignature{Secret: "s", Algorithm: CookieSignatureSHA512, OnInvalid: CookieSignatureBlock}, true},
+		{CookieSignature{Secret: "s", Algorithm: "md5"}, false},
+		{CookieSignature{Secret: "s", OnInvalid: "allow"}, false},
+		{CookieSignature{OnInvalid: CookieSignatureBlock}, false},
+	}
+	for _, tc := range tests {
+		if err := tc.signature.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%+v: error %v, want valid %v", tc.signature, err, tc.valid)
+		}
+	}
+}
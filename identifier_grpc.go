@@ -0,0 +1,39 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// grpcMetadataPrefix is the header prefix gRPC gateways use to carry metadata over HTTP
+const grpcMetadataPrefix = "grpc-metadata-"
+
+// extractGRPCMetadataIdentifier extracts a gRPC metadata value. Name is the
+// metadata key, with or without the grpc-metadata- prefix; both the prefixed
+// header (gRPC gateway convention) and the plain header (native gRPC over
+// HTTP/2) are checked. When Value is set only that exact value (or an alias) matches.
+func extractGRPCMetadataIdentifier(req *http.Request, config *IdentifierConfig) string {
+	value := grpcMetadataValue(req.Header, config.Name)
+	if value == "" {
+		return ""
+	}
+	if config.Value != "" && !config.matchesValue(value) {
+		return ""
+	}
+	return config.canonicalValue(value)
+}
+
+// grpcMetadataValue returns the value of a metadata key, preferring the
+// prefixed header; header names are matched case-insensitively
+func grpcMetadataValue(header http.Header, key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	key = strings.TrimPrefix(key, grpcMetadataPrefix)
+	if key == "" {
+		return ""
+	}
+
+	if value := strings.TrimSpace(header.Get(grpcMetadataPrefix + key)); value != "" {
+		return value
+	}
+	return strings.TrimSpace(header.Get(key))
+}
@@ -0,0 +1,44 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractGRPCMetadataIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		headers map[string]string
+		want    string
+	}{
+		{"prefixed header", "x-api-key", map[string]string{"Grpc-Metadata-X-Api-Key": "k1"}, "k1"},
+		{"prefixed key in config", "grpc-metadata-x-api-key", map[string]string{"grpc-metadata-x-api-key": "k1"}, "k1"},
+		{"mixed case key", "X-API-Key", map[string]string{"GRPC-METADATA-X-API-KEY": "k1"}, "k1"},
+		{"plain header", "x-api-key", map[string]string{"X-Api-Key": "k2"}, "k2"},
+		{"prefixed header wins", "x-api-key", map[string]string{"Grpc-Metadata-X-Api-Key": "k1", "X-Api-Key": "k2"}, "k1"},
+		{"missing", "x-api-key", map[string]string{"X-Other": "k3"}, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/svc.Api/Call", nil)
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		if got := extractGRPCMetadataIdentifier(req, &IdentifierConfig{Type: "GRPCMetadata", Name: tc.key}); got != tc.want {
+			t.Errorf("%s: identifier %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestExtractGRPCMetadataIdentifierMatchesValue(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/svc.Api/Call", nil)
+	req.Header.Set("Grpc-Metadata-X-Api-Key", "k1")
+
+	if got := extractGRPCMetadataIdentifier(req, &IdentifierConfig{Type: "GRPCMetadata", Name: "x-api-key", Value: "k1"}); got != "k1" {
+		t.Fatalf("matching value: identifier %q", got)
+	}
+	if got := extractGRPCMetadataIdentifier(req, &IdentifierConfig{Type: "GRPCMetadata", Name: "x-api-key", Value: "k2"}); got != "" {
+		t.Fatalf("other value: identifier %q", got)
+	}
+}
@@ -0,0 +1,198 @@
+package traefik_quota_plugin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IP strategies deciding which address of a request is its client IP
+const (
+	IPStrategyFirst   = "first"   // First address of the first client IP header (default)
+	IPStrategyTrusted = "trusted" // Rightmost X-Forwarded-For address not added by a trusted proxy
+	IPStrategyRemote  = "remote"  // Peer address, ignoring headers (e.g. the edge proxy)
+)
+
+// IPStrategy decides which address of a request is its client IP
+type IPStrategy struct {
+	Mode           string   `json:"mode,omitempty" yaml:"Mode,omitempty"`                      // first (default), trusted or remote
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"TrustedProxies,omitempty"` // Proxy addresses or CIDRs skipped by the trusted mode
+
+	trustedNetworks []*net.IPNet // TrustedProxies parsed by prepare
+}
+
+// Validate validates the IP strategy
+func (s *IPStrategy) Validate() error {
+	switch s.Mode {
+	case "", IPStrategyFirst, IPStrategyRemote:
+	case IPStrategyTrusted:
+		if len(s.TrustedProxies) == 0 {
+			return fmt.Errorf("the trusted IP strategy requires trusted proxies")
+		}
+	default:
+		return fmt.Errorf("unsupported IP strategy: %s", s.Mode)
+	}
+
+	for _, proxy := range s.TrustedProxies {
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare parses the validated trusted proxies once, when the plugin is built,
+// instead of on every request. Preparing a strategy twice is a no-op.
+func (s *IPStrategy) prepare() {
+	if s == nil || s.trustedNetworks != nil {
+		return
+	}
+	for _, proxy := range s.TrustedProxies {
+		// Validated beforehand
+		if network, err := parseTrustedProxy(proxy); err == nil {
+			s.trustedNetworks = append(s.trustedNetworks, network)
+		}
+	}
+}
+
+// clientIP returns the client IP of req according to the strategy
+func (s *IPStrategy) clientIP(req *http.Request, headers []string) string {
+	switch s.Mode {
+	case IPStrategyRemote:
+		return req.RemoteAddr
+	case IPStrategyTrusted:
+		return s.trustedClientIP(req)
+	default:
+		return clientIP(req, headers)
+	}
+}
+
+// trustedClientIP walks the X-Forwarded-For chain from the peer address
+// backwards and returns the first address that isn't a trusted proxy, or the
+// leftmost one when all are trusted
+func (s *IPStrategy) trustedClientIP(req *http.Request) string {
+	var chain []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	chain = append(chain, req.RemoteAddr)
+
+	for i := len(chain) - 1; i > 0; i-- {
+		if !s.isTrustedProxy(stripPort(chain[i])) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
+
+// isTrustedProxy reports whether ip is one of the trusted proxies
+func (s *IPStrategy) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return false
+	}
+	for _, network := range s.trustedNetworks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxy parses a trusted proxy given as a CIDR or a single address
+func parseTrustedProxy(proxy string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(proxy); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %s", proxy)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// extractIPIdentifier extracts the client IP, falling back to IPFallback (or skipping
+// the identifier) when the address cannot identify a client, e.g. behind a proxy
+// that leaves only a loopback or unix socket peer
+func extractIPIdentifier(req *http.Request, config *IdentifierConfig) string {
+	ip := stripPort(config.IPStrategy.clientIP(req, config.ClientIPHeaders))
+
+	if !isUsableClientIP(ip) {
+		tracef(req, "Client IP '%s' cannot identify the client, using fallback '%s'", ip, config.IPFallback)
+		return config.IPFallback
+	}
+
+	return ip
+}
+
+// defaultClientIPHeaders are consulted when no ClientIPHeaders are configured
+var defaultClientIPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+
+// clientIP returns the first entry of the first non-empty header in headers,
+// falling back to the peer address
+func clientIP(req *http.Request, headers []string) string {
+	if len(headers) == 0 {
+		headers = defaultClientIPHeaders
+	}
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		// Take first IP in case of multiple, e.g. X-Forwarded-For
+		first, _, _ := strings.Cut(value, ",")
+		if first = strings.TrimSpace(first); first != "" {
+			return first
+		}
+	}
+	return req.RemoteAddr
+}
+
+// stripPort removes the port from an address if present
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	if net.ParseIP(addr) != nil {
+		return addr // Bare IPv6 without port
+	}
+	if idx := strings.LastIndex(addr, ":"); idx != -1 {
+		return addr[:idx]
+	}
+	return addr
+}
+
+// isUsableClientIP reports whether ip is a routable client address. Empty values,
+// unix socket peers, loopback and unspecified addresses would bucket unrelated
+// clients together.
+func isUsableClientIP(ip string) bool {
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return false
+	}
+	return !parsed.IsLoopback() && !parsed.IsUnspecified()
+}
+
+// featureIdentifier returns the identifier a feature is keyed on: for IP
+// identifiers whose feature has its own IP strategy, the address that strategy
+// picks (identifier when it can't identify the client), otherwise identifier
+func (m *IdentifierManager) featureIdentifier(req *http.Request, identifier string, strategy *IPStrategy) string {
+	if strategy == nil || m.config.Type != "IP" {
+		return identifier
+	}
+
+	ip := stripPort(strategy.clientIP(req, m.config.ClientIPHeaders))
+	if !isUsableClientIP(ip) {
+		return identifier
+	}
+	return ip
+}
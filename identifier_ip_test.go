@@ -0,0 +1,134 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsUsableClientIP(t *testing.T) {
+	tests := map[string]bool{
+		"203.0.113.7":   true,
+		"2001:db8::1":   true,
+		"[2001:db8::1]": true,
+		"127.0.0.1":     false,
+		"::1":           false,
+		"0.0.0.0":       false,
+		"":              false,
+		"@":             false, // Unix socket peer
+		"/run/traefik":  false,
+	}
+	for ip, want := range tests {
+		if got := isUsableClientIP(ip); got != want {
+			t.Errorf("isUsableClientIP(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestExtractIPIdentifierUnusablePeer(t *testing.T) {
+	for _, remoteAddr := range []string{"127.0.0.1:34567", "[::1]:34567", "", "@"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+
+		if got := extractIPIdentifier(req, &IdentifierConfig{Type: "IP"}); got != "" {
+			t.Errorf("RemoteAddr %q: identifier %q, want the IP identifier skipped", remoteAddr, got)
+		}
+		if got := extractIPIdentifier(req, &IdentifierConfig{Type: "IP", IPFallback: "internal"}); got != "internal" {
+			t.Errorf("RemoteAddr %q: identifier %q, want the fallback", remoteAddr, got)
+		}
+	}
+}
+
+func TestExtractIPIdentifierUsablePeer(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.7:34567"
+	if got := extractIPIdentifier(req, &IdentifierConfig{Type: "IP", IPFallback: "internal"}); got != "203.0.113.7" {
+		t.Fatalf("identifier %q, want the peer address", got)
+	}
+
+	// A forwarded client address is used even behind a loopback peer
+	req.RemoteAddr = "127.0.0.1:34567"
+	req.Header.Set("X-Forwarded-For", "198.51.100.4, 10.0.0.1")
+	if got := extractIPIdentifier(req, &IdentifierConfig{Type: "IP"}); got != "198.51.100.4" {
+		t.Fatalf("identifier %q, want the forwarded address", got)
+	}
+}
+
+func TestExtractIPIdentifierClientIPHeaders(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "172.68.1.1:443"
+	req.Header.Set("X-Forwarded-For", "198.51.100.4, 172.68.1.1")
+	req.Header.Set("CF-Connecting-IP", "203.0.113.9")
+
+	cloudflare := &IdentifierConfig{Type: "IP", ClientIPHeaders: []string{"CF-Connecting-IP", "X-Forwarded-For"}}
+	if got := extractIPIdentifier(req, cloudflare); got != "203.0.113.9" {
+		t.Fatalf("identifier %q, want CF-Connecting-IP", got)
+	}
+	if got := extractIPIdentifier(req, &IdentifierConfig{Type: "IP"}); got != "198.51.100.4" {
+		t.Fatalf("default headers: identifier %q, want X-Forwarded-For", got)
+	}
+
+	// Later headers are consulted when earlier ones are absent
+	req.Header.Del("CF-Connecting-IP")
+	if got := extractIPIdentifier(req, cloudflare); got != "198.51.100.4" {
+		t.Fatalf("without CF-Connecting-IP: identifier %q, want X-Forwarded-For", got)
+	}
+
+	// Headers that aren't configured are ignored, leaving the peer address
+	onlyCloudflare := &IdentifierConfig{Type: "IP", ClientIPHeaders: []string{"CF-Connecting-IP"}}
+	if got := extractIPIdentifier(req, onlyCloudflare); got != "172.68.1.1" {
+		t.Fatalf("only CF-Connecting-IP: identifier %q, want the peer address", got)
+	}
+}
+
+func TestTrustedClientIP(t *testing.T) {
+	strategy := &IPStrategy{Mode: IPStrategyTrusted, TrustedProxies: []string{"10.0.0.0/8", "203.0.113.1"}}
+	strategy.prepare()
+	tests := []struct {
+		forwarded, remote, want string
+	}{
+		{"198.51.100.4, 10.0.0.2", "203.0.113.1:443", "198.51.100.4"},
+		// A spoofed leftmost entry is ignored
+		{"6.6.6.6, 198.51.100.4, 10.0.0.2", "203.0.113.1:443", "198.51.100.4"},
+		// An untrusted peer is the client itself
+		{"198.51.100.4", "192.0.2.9:443", "192.0.2.9:443"},
+		// All trusted: the leftmost address
+		{"10.0.0.3", "203.0.113.1:443", "10.0.0.3"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-For", tc.forwarded)
+		if got := strategy.trustedClientIP(req); got != tc.want {
+			t.Errorf("X-Forwarded-For %q via %s: %q, want %q", tc.forwarded, tc.remote, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPFeatureIPStrategies(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0] = IdentifierConfig{
+			Type:      "IP",
+			RateLimit: RateLimitConfig{Enabled: true, Rate: 10, Period: "1m", IPStrategy: &IPStrategy{Mode: IPStrategyTrusted, TrustedProxies: []string{"10.0.0.0/8", "203.0.113.1"}}},
+			Quota:     QuotaSettings{Enabled: true, Limit: 10, Period: "Daily", IPStrategy: &IPStrategy{Mode: IPStrategyRemote}},
+		}
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.1:443"
+	req.Header.Set("X-Forwarded-For", "198.51.100.4, 10.0.0.2")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rw.Code)
+	}
+
+	// The rate limit follows the real client, the quota bills the edge
+	if !mentions(server, "ratelimit:198.51.100.4") {
+		t.Errorf("no rate limit bucket of the client: %v", server.commands())
+	}
+	if !mentions(server, "quota:203.0.113.1") {
+		t.Errorf("no quota usage of the edge: %v", server.commands())
+	}
+}
@@ -0,0 +1,100 @@
+package traefik_quota_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// extractPathIdentifier extracts a URL path segment. Name is either a 1-based
+// segment index (e.g. 2 for the id in /tenants/{id}/users) or a pattern such
+// as /tenants/{id} whose {...} segment is captured, * matches any segment and
+// other segments must match literally. When Value is set only that exact value
+// (or an alias) matches.
+func extractPathIdentifier(req *http.Request, config *IdentifierConfig) string {
+	value := pathSegment(req.URL.EscapedPath(), config.Name)
+	if value == "" {
+		return ""
+	}
+	if config.Value != "" && !config.matchesValue(value) {
+		return ""
+	}
+	return config.canonicalValue(value)
+}
+
+// pathSegment returns the segment of the escaped path selected by an index or
+// pattern, or an empty string when the path is too short or doesn't match the
+// pattern. Segments are split before they are unescaped, so an encoded slash
+// stays part of its segment.
+func pathSegment(path, selector string) string {
+	segments := splitPath(path)
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[i] = unescaped
+		}
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 1 || index > len(segments) {
+			return ""
+		}
+		return segments[index-1]
+	}
+
+	pattern := splitPath(selector)
+	if len(segments) < len(pattern) {
+		return ""
+	}
+	captured := ""
+	for i, part := range pattern {
+		switch {
+		case isPathCapture(part):
+			captured = segments[i]
+		case part != "*" && part != segments[i]:
+			return ""
+		}
+	}
+	return captured
+}
+
+// splitPath splits a path into its non-empty segments
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// isPathCapture reports whether a pattern segment is a {name} capture
+func isPathCapture(part string) bool {
+	return len(part) > 2 && strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")
+}
+
+// validatePathSelector checks a Path identifier's segment index or pattern
+func validatePathSelector(selector string) error {
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 1 {
+			return fmt.Errorf("path segment index must be positive")
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(selector, "/") {
+		return fmt.Errorf("path identifier name must be a segment index or a pattern starting with /")
+	}
+	captures := 0
+	for _, part := range splitPath(selector) {
+		if isPathCapture(part) {
+			captures++
+		}
+	}
+	if captures != 1 {
+		return fmt.Errorf("path pattern must capture exactly one {segment}")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package traefik_quota_plugin
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractPathIdentifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		value    string
+		path     string
+		want     string
+	}{
+		{"index", "2", "", "/tenants/acme/users", "acme"},
+		{"index ignores empty segments", "2", "", "//tenants//acme/", "acme"},
+		{"index past the end", "4", "", "/tenants/acme/users", ""},
+		{"pattern capture", "/tenants/{id}", "", "/tenants/acme/users", "acme"},
+		{"pattern wildcard", "/*/{id}/users", "", "/orgs/acme/users", "acme"},
+		{"pattern literal mismatch", "/tenants/{id}", "", "/orgs/acme", ""},
+		{"path shorter than pattern", "/tenants/{id}", "", "/tenants", ""},
+		{"value matches", "2", "acme", "/tenants/acme", "acme"},
+		{"value differs", "2", "acme", "/tenants/globex", ""},
+		{"encoded slash stays in its segment", "3", "", "/files/a%2Fb/owner", "owner"},
+		{"segment is unescaped", "/tenants/{id}", "", "/tenants/a%2Fb", "a/b"},
+	}
+	for _, tc := range tests {
+		config := &IdentifierConfig{Type: "Path", Name: tc.selector, Value: tc.value}
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if got := extractPathIdentifier(req, config); got != tc.want {
+			t.Errorf("%s: identifier %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestValidatePathSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		valid    bool
+	}{
+		{"1", true},
+		{"0", false},
+		{"-1", false},
+		{"/tenants/{id}", true},
+		{"/tenants/{id}/users/{user}", false},
+		{"/tenants/*", false},
+		{"tenants/{id}", false},
+		{"/tenants/{}", false},
+	}
+	for _, tc := range tests {
+		if err := validatePathSelector(tc.selector); (err == nil) != tc.valid {
+			t.Errorf("%q: error %v, want valid %v", tc.selector, err, tc.valid)
+		}
+	}
+}
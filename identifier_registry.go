@@ -0,0 +1,109 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"sync"
+)
+
+// IdentifierExtractor returns the identifier of a request, or an empty string
+// when the request doesn't carry one for the given identifier config
+type IdentifierExtractor func(req *http.Request, config *IdentifierConfig) string
+
+var (
+	identifierExtractorsMu sync.RWMutex
+
+	// identifierExtractors maps an identifier Type to its extractor
+	identifierExtractors = map[string]IdentifierExtractor{
+		"Header":       extractHeaderIdentifier,
+		"IP":           extractIPIdentifier,
+		"Query":        extractQueryIdentifier,
+		"Cookie":       extractCookieIdentifier,
+		"Body":         extractBodyIdentifier,
+		"ClientCert":   extractClientCertIdentifier,
+		"Bearer":       extractBearerIdentifier,
+		"GRPCMetadata": extractGRPCMetadataIdentifier,
+		"Template":     extractTemplateIdentifier,
+		"Path":         extractPathIdentifier,
+	}
+)
+
+// RegisterIdentifierExtractor makes an extractor available as an identifier Type.
+// Registering an existing type, including a built-in one, replaces it. It is
+// meant for Go programs embedding the plugin and should be called before New.
+func RegisterIdentifierExtractor(typeName string, extractor IdentifierExtractor) {
+	identifierExtractorsMu.Lock()
+	defer identifierExtractorsMu.Unlock()
+
+	if extractor == nil {
+		delete(identifierExtractors, typeName)
+		return
+	}
+	identifierExtractors[typeName] = extractor
+}
+
+// lookupIdentifierExtractor returns the extractor registered for an identifier Type
+func lookupIdentifierExtractor(typeName string) (IdentifierExtractor, bool) {
+	identifierExtractorsMu.RLock()
+	defer identifierExtractorsMu.RUnlock()
+
+	extractor, ok := identifierExtractors[typeName]
+	return extractor, ok
+}
+
+// extractHeaderIdentifier returns the configured value when the header exactly matches it or one of its
+// aliases, or the header value itself when it matches ValuePrefix/ValueSuffix
+func extractHeaderIdentifier(req *http.Request, config *IdentifierConfig) string {
+	tracef(req, "Extracting identifier from header: %s (expected value: %s)", config.Name, config.Value)
+	value := req.Header.Get(config.Name)
+	tracef(req, "Header value from request: '%s'", value)
+
+	// Prefix/suffix identifiers key each matching value separately
+	if config.hasValuePattern() {
+		if value != "" && config.matchesValuePattern(value) {
+			tracef(req, "Header matches prefix '%s' and suffix '%s'", config.ValuePrefix, config.ValueSuffix)
+			return value
+		}
+		return ""
+	}
+
+	if value != "" {
+		// If header exists, check if it matches this identifier's expected value
+		tracef(req, "Comparing header value '%s' with config value '%s': %v", value, config.Value, config.matchesValue(value))
+		if config.matchesValue(value) {
+			tracef(req, "Header matches! Returning: %s", config.Value)
+			return config.Value
+		}
+		// If header exists but doesn't match, return empty (no match)
+		tracef(req, "Header doesn't match config value, returning empty")
+		return ""
+	}
+
+	// For specific identifiers, return empty when header is missing
+	tracef(req, "No header found and not a fallback identifier, returning empty")
+	return ""
+}
+
+// extractQueryIdentifier returns the query parameter, falling back to the configured value
+func extractQueryIdentifier(req *http.Request, config *IdentifierConfig) string {
+	value := req.URL.Query().Get(config.Name)
+	if value != "" {
+		return value
+	}
+	return config.Value
+}
+
+// extractTemplateIdentifier renders the configured template against the request
+func extractTemplateIdentifier(req *http.Request, config *IdentifierConfig) string {
+	// Build template data from request
+	templateData := buildTemplateData(req)
+
+	// Execute template with the data
+	result, err := executeTemplate(config.Value, templateData)
+	if err != nil {
+		requestLogger(req).errorf("Template execution failed: %v", err)
+		return ""
+	}
+
+	tracef(req, "Template result: '%s' from template: '%s'", result, config.Value)
+	return result
+}
@@ -0,0 +1,125 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterIdentifierExtractor(t *testing.T) {
+	// Tenants are the first label of the host name
+	RegisterIdentifierExtractor("Subdomain", func(req *http.Request, config *IdentifierConfig) string {
+		tenant, _, _ := strings.Cut(req.Host, ".")
+		if tenant != config.Value {
+			return ""
+		}
+		return tenant
+	})
+	t.Cleanup(func() { RegisterIdentifierExtractor("Subdomain", nil) })
+
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Type = "Subdomain"
+		c.Identifiers[0].Name = "host"
+		c.Identifiers[0].Value = "acme"
+	})
+
+	serve := func(host string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := serve("acme.example.com"); got != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, got, want)
+		}
+	}
+	if got := serve("other.example.com"); got != http.StatusForbidden {
+		t.Fatalf("unmatched tenant: status %d, want 403", got)
+	}
+}
+
+func TestUnregisteredIdentifierTypeUsesValue(t *testing.T) {
+	RegisterIdentifierExtractor("Subdomain", func(*http.Request, *IdentifierConfig) string { return "extracted" })
+	RegisterIdentifierExtractor("Subdomain", nil)
+
+	q := &quotaPlugin{config: validConfig()}
+	config := &IdentifierConfig{Type: "Subdomain", Value: "static"}
+	if got := q.extractIdentifier(httptest.NewRequest(http.MethodGet, "/", nil), config); got != "static" {
+		t.Fatalf("identifier %q of an unregistered type, want the configured value", got)
+	}
+	if _, ok := lookupIdentifierExtractor("Header"); !ok {
+		t.Fatal("built-in Header extractor not registered")
+	}
+}
+
+func TestExtractHeaderIdentifierValuePattern(t *testing.T) {
+	tests := []struct {
+		name           string
+		prefix, suffix string
+		header         string
+		want           string
+	}{
+		{"prefix match", "sk-prod-", "", "sk-prod-abc", "sk-prod-abc"},
+		{"prefix mismatch", "sk-prod-", "", "sk-test-abc", ""},
+		{"suffix match", "", "@example.com", "alice@example.com", "alice@example.com"},
+		{"suffix mismatch", "", "@example.com", "alice@example.org", ""},
+		{"prefix and suffix", "sk-", "-v2", "sk-abc-v2", "sk-abc-v2"},
+		{"overlapping prefix and suffix", "sk-", "-v2", "sk-v2", ""},
+		{"missing header", "sk-", "", "", ""},
+	}
+	for _, tc := range tests {
+		config := &IdentifierConfig{Type: "Header", Name: "X-API-Key", ValuePrefix: tc.prefix, ValueSuffix: tc.suffix}
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.header != "" {
+			req.Header.Set("X-API-Key", tc.header)
+		}
+		if got := extractHeaderIdentifier(req, config); got != tc.want {
+			t.Errorf("%s: identifier %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPValuePrefixKeysValuesSeparately(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].ValuePrefix = "sk-prod-"
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+
+	for _, user := range []string{"sk-prod-a", "sk-prod-b"} {
+		if rw := serveAs(handler, user); rw.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want its own bucket", user, rw.Code)
+		}
+	}
+	if rw := serveAs(handler, "sk-prod-a"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request of sk-prod-a: status %d, want 429", rw.Code)
+	}
+	if rw := serveAs(handler, "sk-test-a"); rw.Code != http.StatusForbidden {
+		t.Fatalf("non-matching key: status %d, want 403", rw.Code)
+	}
+}
+
+func TestValidateValuePattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*IdentifierConfig)
+		valid  bool
+	}{
+		{"prefix", func(ic *IdentifierConfig) { ic.Value = "" }, true},
+		{"with a value", func(ic *IdentifierConfig) {}, false},
+		{"with aliases", func(ic *IdentifierConfig) { ic.Value = ""; ic.Aliases = []string{"a"} }, false},
+		{"not a header", func(ic *IdentifierConfig) { ic.Value = ""; ic.Type = "Query" }, false},
+	}
+	for _, tc := range tests {
+		config := validConfig()
+		config.Identifiers[0].ValuePrefix = "sk-"
+		tc.mutate(&config.Identifiers[0])
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package traefik_quota_plugin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// SourceSpec is one place a Sources identifier may read its value from
+type SourceSpec struct {
+	Type string `json:"type,omitempty" yaml:"Type,omitempty"` // Header, Cookie, Query, IP or another identifier type
+	Name string `json:"name,omitempty" yaml:"Name,omitempty"` // Header, cookie or query parameter name
+}
+
+// extractSourcesIdentifier walks the configured sources in priority order and
+// returns the first value found, prefixed with its source so equal values of
+// different sources don't share limits. It falls back to the configured value
+// (or skips the identifier) when every source is empty.
+func extractSourcesIdentifier(req *http.Request, config *IdentifierConfig) string {
+	for _, source := range config.Sources {
+		if value := extractSourceValue(req, config, source); value != "" {
+			tracef(req, "Identifier found in source %s %s", source.Type, source.Name)
+			return source.prefix() + value
+		}
+	}
+
+	tracef(req, "No source carried an identifier, using fallback '%s'", config.Value)
+	return config.Value
+}
+
+// extractSourceValue reads the raw value of a single source. Headers yield
+// their value rather than an exact match, and per-type fallbacks are disabled
+// so an empty source moves on to the next one.
+func extractSourceValue(req *http.Request, config *IdentifierConfig, source SourceSpec) string {
+	if source.Type == "Header" {
+		return req.Header.Get(source.Name)
+	}
+
+	extractor, ok := lookupIdentifierExtractor(source.Type)
+	if !ok {
+		return ""
+	}
+
+	sourceConfig := *config
+	sourceConfig.Type = source.Type
+	sourceConfig.Name = source.Name
+	sourceConfig.Value = ""
+	sourceConfig.IPFallback = ""
+	sourceConfig.BodyFallback = ""
+	sourceConfig.Aliases = nil
+	sourceConfig.Sources = nil
+	return extractor(req, &sourceConfig)
+}
+
+// prefix returns the prefix of the values read from the source, e.g.
+// "Header:X-API-Key:" or "IP:"
+func (source SourceSpec) prefix() string {
+	if source.Name == "" {
+		return source.Type + ":"
+	}
+	return source.Type + ":" + source.Name + ":"
+}
+
+// validateSources checks the sources of a Sources identifier
+func validateSources(sources []SourceSpec) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("at least one source is required for source-based identification")
+	}
+	for i, source := range sources {
+		switch source.Type {
+		case "Header", "Cookie", "Query", "Body", "GRPCMetadata":
+			if source.Name == "" {
+				return fmt.Errorf("source %d: name is required for %s sources", i, source.Type)
+			}
+		case "Path":
+			if err := validatePathSelector(source.Name); err != nil {
+				return fmt.Errorf("source %d: %w", i, err)
+			}
+		case "Sources", "Template":
+			return fmt.Errorf("source %d: %s cannot be used as a source", i, source.Type)
+		default:
+			if _, ok := lookupIdentifierExtractor(source.Type); !ok {
+				return fmt.Errorf("source %d: unsupported source type: %s", i, source.Type)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractSourcesIdentifierPriority(t *testing.T) {
+	config := &IdentifierConfig{
+		Type: "Sources",
+		Sources: []SourceSpec{
+			{Type: "Header", Name: "X-API-Key"},
+			{Type: "Cookie", Name: "session"},
+			{Type: "Query", Name: "api_key"},
+			{Type: "IP"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		query  string
+		want   string
+	}{
+		{"header first", "k-header", "k-cookie", "k-query", "Header:X-API-Key:k-header"},
+		{"cookie before query", "", "k-cookie", "k-query", "Cookie:session:k-cookie"},
+		{"query", "", "", "k-query", "Query:api_key:k-query"},
+		{"IP last", "", "", "", "IP:203.0.113.7"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/?api_key="+tc.query, nil)
+		req.RemoteAddr = "203.0.113.7:443"
+		if tc.header != "" {
+			req.Header.Set("X-API-Key", tc.header)
+		}
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: tc.cookie})
+		}
+		if got := extractSourcesIdentifier(req, config); got != tc.want {
+			t.Errorf("%s: identifier %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestExtractSourcesIdentifierAllEmpty(t *testing.T) {
+	config := &IdentifierConfig{
+		Type:    "Sources",
+		Sources: []SourceSpec{{Type: "Header", Name: "X-API-Key"}, {Type: "IP"}},
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:443" // Unusable, so the IP source is empty too
+
+	if got := extractSourcesIdentifier(req, config); got != "" {
+		t.Fatalf("identifier %q, want the identifier skipped", got)
+	}
+	config.Value = "anonymous"
+	if got := extractSourcesIdentifier(req, config); got != "anonymous" {
+		t.Fatalf("identifier %q, want the configured fallback", got)
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	for name, sources := range map[string][]SourceSpec{
+		"none":           nil,
+		"nameless":       {{Type: "Header"}},
+		"nested":         {{Type: "Sources"}},
+		"unknown source": {{Type: "Carrier pigeon"}},
+	} {
+		if err := validateSources(sources); err == nil {
+			t.Errorf("%s: sources accepted", name)
+		}
+	}
+	if err := validateSources([]SourceSpec{{Type: "Header", Name: "X-API-Key"}, {Type: "IP"}}); err != nil {
+		t.Fatal(err)
+	}
+}
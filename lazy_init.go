@@ -0,0 +1,206 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Cooldown before a failed named connection is dialed again; it doubles with
+// every failed dial up to redisRedialMaxDelay
+const (
+	redisRedialBaseDelay = time.Second
+	redisRedialMaxDelay  = time.Minute
+)
+
+// redisConnection is a named Redis connection dialed on first use, so New
+// doesn't wait on every connection of a large configuration
+type redisConnection struct {
+	name    string
+	config  RedisConfig
+	ctx     context.Context
+	metrics *Metrics
+	log     *pluginLogger
+	dial    func(RedisConfig, *pluginLogger) (RedisClient, error)
+
+	mu       sync.Mutex
+	client   RedisClient
+	dialing  chan struct{} // Closed when the running dial finishes; nil when none runs
+	err      error         // Error of the last failed dial, returned until retryAt
+	retryAt  time.Time     // Earliest time of the next dial after a failure
+	cooldown time.Duration // Current delay between failed dials
+}
+
+// newRedisConnection creates a named connection that is dialed on first use
+func newRedisConnection(ctx context.Context, name string, config RedisConfig, metrics *Metrics, log *pluginLogger) *redisConnection {
+	return &redisConnection{
+		name:    name,
+		config:  config,
+		ctx:     ctx,
+		metrics: metrics,
+		log:     log,
+		dial:    newRedisClient,
+	}
+}
+
+// newConnectedRedisConnection wraps an already dialed client
+func newConnectedRedisConnection(name string, config RedisConfig, client RedisClient) *redisConnection {
+	return &redisConnection{name: name, config: config, client: client}
+}
+
+// get returns the connection's client, dialing it on first use. Only a
+// successful dial is kept; after a failure the error is returned until a
+// cooldown passes, and the next call dials again. One call dials at a time,
+// without holding the lock: concurrent calls wait for the first dial, and
+// return the last error while a redial runs.
+func (c *redisConnection) get() (RedisClient, error) {
+	c.mu.Lock()
+	for c.client == nil && c.dialing != nil && c.err == nil {
+		dialing := c.dialing
+		c.mu.Unlock()
+		<-dialing
+		c.mu.Lock()
+	}
+	if c.client != nil {
+		defer c.mu.Unlock()
+		return c.client, nil
+	}
+	if c.err != nil && (c.dialing != nil || time.Now().Before(c.retryAt)) {
+		defer c.mu.Unlock()
+		return nil, c.err
+	}
+	dialing := make(chan struct{})
+	c.dialing = dialing
+	c.mu.Unlock()
+
+	client, err := connectWithRetry(c.ctx, c.config, c.dial, c.log)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(dialing)
+	c.dialing = nil
+
+	if err != nil {
+		c.cooldown *= 2
+		if c.cooldown < redisRedialBaseDelay {
+			c.cooldown = redisRedialBaseDelay
+		}
+		if c.cooldown > redisRedialMaxDelay {
+			c.cooldown = redisRedialMaxDelay
+		}
+		c.retryAt = time.Now().Add(c.cooldown)
+		c.log.errorf("Failed to connect to Redis connection '%s', retrying in %v - %v", c.name, c.cooldown, err)
+		c.err = fmt.Errorf("redis connection %s unavailable: %w", c.name, err)
+		return nil, c.err
+	}
+
+	startRedisReaper(c.ctx, client)
+	if c.metrics != nil {
+		client = NewInstrumentedRedisClient(client, c.metrics)
+	}
+	c.client, c.err = client, nil
+	return client, nil
+}
+
+// ensureInitialized builds the manager's Redis-backed components and compiled
+// template on first use; concurrent first requests initialize exactly once.
+// A failed initialization, e.g. an unreachable connection, is retried by the
+// next request. The connection is dialed without holding the manager's lock.
+func (m *IdentifierManager) ensureInitialized() error {
+	m.initMu.Lock()
+	initialized := m.initialized
+	m.initMu.Unlock()
+	if initialized {
+		return nil
+	}
+
+	client, err := m.connection.get()
+	if err != nil {
+		return err
+	}
+
+	m.initMu.Lock()
+	defer m.initMu.Unlock()
+
+	if m.initialized {
+		return nil
+	}
+	if err := m.initialize(client); err != nil {
+		return err
+	}
+	m.initialized = true
+	return nil
+}
+
+// initialize builds the manager's components on client. It must only run
+// through ensureInitialized.
+func (m *IdentifierManager) initialize(client RedisClient) error {
+	// Compile the identifier template once instead of on every request
+	if m.config.Type == "Template" {
+		if _, err := compileTemplate(m.config.Value); err != nil {
+			return err
+		}
+	}
+
+	// Quota and bucket reads share one cache; a nil cache disables caching
+	var cache *readCache
+	if m.readCacheTTL > 0 {
+		cache = newReadCache(m.readCacheTTL)
+	}
+
+	m.quotaManager = NewQuotaManager(client, m.config.Quota)
+	m.quotaManager.cache = cache
+	m.quotaManager.log = m.log
+	if m.config.Quota.AsyncConsume {
+		m.quotaManager.async = m.async.attach(m.quotaManager)
+	}
+
+	// Only create rate limiter if rate limiting is enabled
+	if m.config.RateLimit.Enabled {
+		m.rateLimiter = NewRateLimiter(client, m.config.RateLimit)
+		m.rateLimiter.cache = cache
+		m.rateLimiter.log = m.log
+		m.rateLimiter.optimistic = m.connection.config.DisableScripting
+		m.rateLimiter.scriptingFallback = m.connection.config.ScriptingFallback
+	}
+
+	if m.config.WebSocket.LimitsConnections() {
+		m.webSockets = NewWebSocketLimiter(client, m.config.WebSocket.MaxConnections)
+		m.webSockets.log = m.log
+	}
+
+	if m.config.TrackConcurrency {
+		m.concurrency = NewConcurrencyTracker(client)
+		m.concurrency.log = m.log
+	}
+
+	m.webhook = newWebhookNotifier(m.config.Webhook, m.log)
+
+	if m.config.DistinctWindow != "" {
+		window, _ := time.ParseDuration(m.config.DistinctWindow)
+		m.distinct = NewDistinctCounter(client, m.key, window)
+	}
+
+	return nil
+}
+
+// templateCache holds compiled identifier templates keyed by their source
+var templateCache sync.Map
+
+// compileTemplate returns the compiled template for templateStr, parsing it on first use
+func compileTemplate(templateStr string) (*template.Template, error) {
+	if cached, ok := templateCache.Load(templateStr); ok {
+		return cached.(*template.Template), nil
+	}
+
+	// Create a new template with custom delimiters
+	tmpl, err := template.New("identifier").Delims("[[", "]]").Parse(templateStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	cached, _ := templateCache.LoadOrStore(templateStr, tmpl)
+	return cached.(*template.Template), nil
+}
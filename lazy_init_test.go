@@ -0,0 +1,140 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingDial returns a dial function failing while fail is true, counting its calls
+func countingDial(mu *sync.Mutex, dials *int, fail *bool) func(RedisConfig, *pluginLogger) (RedisClient, error) {
+	return func(RedisConfig, *pluginLogger) (RedisClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		*dials++
+		if *fail {
+			return nil, errors.New("connection refused")
+		}
+		return NewMemoryRedisClient(), nil
+	}
+}
+
+func TestEnsureInitializedOnceUnderConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	dials, fail := 0, false
+	connection := newRedisConnection(context.Background(), "tenants", RedisConfig{}, nil, nil)
+	connection.dial = countingDial(&mu, &dials, &fail)
+
+	config := validConfig().Identifiers[0]
+	config.Normalize()
+	manager := &IdentifierManager{key: "k", config: &config, connection: connection}
+
+	var wg sync.WaitGroup
+	quotaManagers := make([]*QuotaManager, 20)
+	for i := range quotaManagers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := manager.ensureInitialized(); err != nil {
+				t.Error(err)
+				return
+			}
+			quotaManagers[i] = manager.quotaManager
+		}(i)
+	}
+	wg.Wait()
+
+	if dials != 1 {
+		t.Fatalf("dialed %d times, want once", dials)
+	}
+	for _, qm := range quotaManagers {
+		if qm == nil || qm != quotaManagers[0] {
+			t.Fatal("concurrent first requests saw different components")
+		}
+	}
+}
+
+func TestRedisConnectionRetriesFailedDial(t *testing.T) {
+	var mu sync.Mutex
+	dials, fail := 0, true
+	connection := newRedisConnection(context.Background(), "tenants", RedisConfig{ConnectAttempts: 1}, nil, nil)
+	connection.dial = countingDial(&mu, &dials, &fail)
+
+	if _, err := connection.get(); err == nil {
+		t.Fatal("failed dial returned no error")
+	}
+	// Within the cooldown the error is returned without dialing
+	if _, err := connection.get(); err == nil || dials != 1 {
+		t.Fatalf("get during the cooldown: %v after %d dials", err, dials)
+	}
+
+	// The cooldown doubles with every failed dial
+	connection.retryAt = time.Time{}
+	connection.get()
+	if connection.cooldown != 2*redisRedialBaseDelay {
+		t.Fatalf("cooldown after two failures %v, want %v", connection.cooldown, 2*redisRedialBaseDelay)
+	}
+
+	// Once the cooldown passed, Redis is dialed again and success is kept
+	fail = false
+	connection.retryAt = time.Time{}
+	client, err := connection.get()
+	if err != nil || client == nil {
+		t.Fatalf("get after Redis recovered = %v, %v", client, err)
+	}
+	if again, _ := connection.get(); again != client || dials != 3 {
+		t.Fatalf("connected client not reused, %d dials", dials)
+	}
+}
+
+func TestRedisConnectionRedialsWithoutBlocking(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	connection := newRedisConnection(context.Background(), "tenants", RedisConfig{ConnectAttempts: 1}, nil, nil)
+	connection.dial = func(RedisConfig, *pluginLogger) (RedisClient, error) {
+		close(dialing)
+		<-release
+		return NewMemoryRedisClient(), nil
+	}
+	connection.err = errors.New("connection refused")
+
+	redialed := make(chan RedisClient)
+	go func() {
+		client, _ := connection.get()
+		redialed <- client
+	}()
+	<-dialing
+
+	// While one call redials, the others fail fast with the last error
+	if _, err := connection.get(); err == nil {
+		t.Fatal("get during a redial returned no error")
+	}
+	close(release)
+	if client := <-redialed; client == nil {
+		t.Fatal("redial returned no client")
+	}
+	if client, err := connection.get(); client == nil || err != nil {
+		t.Fatalf("get after the redial = %v, %v", client, err)
+	}
+}
+
+func TestEnsureInitializedRetriesAfterFailure(t *testing.T) {
+	var mu sync.Mutex
+	dials, fail := 0, true
+	connection := newRedisConnection(context.Background(), "tenants", RedisConfig{ConnectAttempts: 1}, nil, nil)
+	connection.dial = countingDial(&mu, &dials, &fail)
+
+	config := validConfig().Identifiers[0]
+	config.Normalize()
+	manager := &IdentifierManager{key: "k", config: &config, connection: connection}
+
+	if err := manager.ensureInitialized(); err == nil {
+		t.Fatal("initialization without Redis succeeded")
+	}
+	fail = false
+	connection.retryAt = time.Time{}
+	if err := manager.ensureInitialized(); err != nil || manager.quotaManager == nil {
+		t.Fatalf("initialization after Redis recovered: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Log levels, from most to least verbose
+const (
+	LogLevelInfo  = "info"  // Decisions, lifecycle events and sampled request traces (default)
+	LogLevelError = "error" // Only failures
+	LogLevelOff   = "off"   // Nothing
+)
+
+// Log outputs selectable from the configuration
+const (
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+)
+
+// pluginLogger writes the plugin's log lines to its own destination, leaving
+// the standard logger of the host process untouched. Each plugin instance has
+// its own, so instances live at the same time, e.g. across a configuration
+// reload, keep their own log settings.
+type pluginLogger struct {
+	out   *log.Logger
+	level string
+}
+
+// stdoutLog writes the lines of instances logging to stdout and of nil
+// loggers, e.g. of components built outside a plugin instance
+var stdoutLog = log.New(os.Stdout, "", log.LstdFlags)
+
+// newPluginLogger builds the logger of a plugin instance from its configuration
+func newPluginLogger(config LogConfig) (*pluginLogger, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	l := &pluginLogger{out: stdoutLog, level: config.Level}
+	if l.level == "" {
+		l.level = LogLevelInfo
+	}
+	if config.Output == LogOutputStderr {
+		l.out = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return l, nil
+}
+
+// Validate checks the log output and level
+func (lc LogConfig) Validate() error {
+	switch lc.Output {
+	case "", LogOutputStdout, LogOutputStderr:
+	default:
+		return fmt.Errorf("unsupported log output: %s", lc.Output)
+	}
+	switch lc.Level {
+	case "", LogLevelInfo, LogLevelError, LogLevelOff:
+	default:
+		return fmt.Errorf("unsupported log level: %s", lc.Level)
+	}
+	return nil
+}
+
+// loggerContextKey carries the logger of the plugin instance serving a request
+type loggerContextKey struct{}
+
+// withLogger stores the plugin instance's logger in the request context, so
+// extractors and other request-scoped helpers log with its settings
+func withLogger(req *http.Request, l *pluginLogger) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), loggerContextKey{}, l))
+}
+
+// requestLogger returns the logger of the plugin instance serving req, or nil
+// (logging to stdout) outside one
+func requestLogger(req *http.Request) *pluginLogger {
+	l, _ := req.Context().Value(loggerContextKey{}).(*pluginLogger)
+	return l
+}
+
+// infof logs a decision or lifecycle event
+func (l *pluginLogger) infof(format string, args ...interface{}) {
+	l.printf(LogLevelInfo, format, args...)
+}
+
+// errorf logs a failure
+func (l *pluginLogger) errorf(format string, args ...interface{}) {
+	l.printf(LogLevelError, format, args...)
+}
+
+// printf writes the line when level is enabled. A nil logger, e.g. of a
+// component built outside a plugin instance, writes every level to stdout.
+func (l *pluginLogger) printf(level, format string, args ...interface{}) {
+	if l == nil {
+		stdoutLog.Printf(format, args...)
+		return
+	}
+	if l.level == LogLevelOff || (l.level == LogLevelError && level != LogLevelError) {
+		return
+	}
+	l.out.Printf(format, args...)
+}
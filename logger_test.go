@@ -0,0 +1,157 @@
+package traefik_quota_plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// bufferLogger returns an info logger writing to the returned buffer
+func bufferLogger() (*pluginLogger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return &pluginLogger{out: log.New(&buf, "", log.LstdFlags), level: LogLevelInfo}, &buf
+}
+
+// captureLogs sends the log lines of the plugin instances to one buffer
+func captureLogs(handlers ...http.Handler) *bytes.Buffer {
+	var buf bytes.Buffer
+	for _, handler := range handlers {
+		handler.(*quotaPlugin).log.out = log.New(&buf, "", log.LstdFlags)
+	}
+	return &buf
+}
+
+func TestPluginLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	for _, tc := range []struct {
+		level      string
+		info, errs bool
+	}{
+		{"", true, true},
+		{LogLevelInfo, true, true},
+		{LogLevelError, false, true},
+		{LogLevelOff, false, false},
+	} {
+		buf.Reset()
+		l, err := newPluginLogger(LogConfig{Level: tc.level})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.out = log.New(&buf, "", log.LstdFlags)
+		l.infof("info line")
+		l.errorf("error line")
+
+		if got := strings.Contains(buf.String(), "info line"); got != tc.info {
+			t.Errorf("level %q: info logged %v, want %v", tc.level, got, tc.info)
+		}
+		if got := strings.Contains(buf.String(), "error line"); got != tc.errs {
+			t.Errorf("level %q: error logged %v, want %v", tc.level, got, tc.errs)
+		}
+	}
+
+	// A nil logger writes to stdout instead of failing
+	var nilLogger *pluginLogger
+	nilLogger.errorf("nil logger line")
+}
+
+func TestPluginLoggerOutputs(t *testing.T) {
+	for output, want := range map[string]*log.Logger{"": stdoutLog, LogOutputStdout: stdoutLog} {
+		if l, _ := newPluginLogger(LogConfig{Output: output}); l.out != want {
+			t.Errorf("output %q doesn't write to stdout", output)
+		}
+	}
+	if l, _ := newPluginLogger(LogConfig{Output: LogOutputStderr}); l.out.Writer() != os.Stderr {
+		t.Error("stderr output doesn't write to stderr")
+	}
+}
+
+func TestServeHTTPLogsWithoutTouchingStandardLogger(t *testing.T) {
+	standard := log.Writer()
+
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, nil)
+	buf := captureLogs(handler)
+	serveAs(handler, "")
+
+	if !strings.Contains(buf.String(), "Access denied") {
+		t.Fatalf("plugin log output %q, want the denied request", buf.String())
+	}
+	if log.Writer() != standard {
+		t.Fatal("the plugin redirected the standard logger")
+	}
+}
+
+func TestConcurrentInstancesDoNotInterfere(t *testing.T) {
+	servers := []*testRedisServer{newTestRedisServer(t), newTestRedisServer(t)}
+	levels := []string{LogLevelInfo, LogLevelOff}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handlers := make([]http.Handler, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range handlers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			config := validConfig()
+			config.Persistence.Redis.Address = servers[i].addr
+			config.Log.Level = levels[i]
+			config.Identifiers[0].RateLimit.Rate = i + 1
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handlers[i], errs[i] = New(ctx, next, config, fmt.Sprintf("instance-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each instance keeps its own rate and Redis: instance 0 allows one request
+	// and instance 1 two, regardless of the other's traffic
+	for i, handler := range handlers {
+		for n := 0; n <= i; n++ {
+			if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+				t.Fatalf("instance %d request %d: status %d", i, n, rw.Code)
+			}
+		}
+		if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+			t.Fatalf("instance %d: status %d past its rate, want 429", i, rw.Code)
+		}
+	}
+	for i, server := range servers {
+		if !mentions(server, "u1") {
+			t.Errorf("instance %d didn't use its own Redis", i)
+		}
+	}
+
+	// The instance logging nothing doesn't silence the other
+	buf := captureLogs(handlers...)
+	serveAs(handlers[1], "")
+	if buf.Len() != 0 {
+		t.Fatalf("silenced instance logged %q", buf.String())
+	}
+	serveAs(handlers[0], "")
+	if !strings.Contains(buf.String(), "Access denied") {
+		t.Fatalf("log output %q, want the denied request of the logging instance", buf.String())
+	}
+}
+
+func TestLogConfigValidate(t *testing.T) {
+	for _, config := range []LogConfig{{Output: "file"}, {Level: "debug"}} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+	if err := (LogConfig{Output: LogOutputStderr, Level: LogLevelError}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
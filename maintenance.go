@@ -0,0 +1,83 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maintenanceFlagTTL is how long a read of the maintenance flag key is reused
+const maintenanceFlagTTL = time.Second
+
+// MaintenanceConfig holds the settings of maintenance mode, which lets every
+// request past the limits while headers and logs are still produced
+type MaintenanceConfig struct {
+	Enabled bool   `json:"enabled,omitempty" yaml:"Enabled,omitempty"` // Lift the limits for everyone
+	Key     string `json:"key,omitempty" yaml:"Key,omitempty"`         // Redis key turning maintenance mode on while it holds true or 1 (e.g. quota:maintenance)
+}
+
+// maintenanceFlag reports whether maintenance mode is on, reading the flag
+// key at most once per maintenanceFlagTTL
+type maintenanceFlag struct {
+	config      MaintenanceConfig
+	redisClient RedisClient
+	log         *pluginLogger
+
+	mu        sync.Mutex
+	on        bool
+	checkedAt time.Time
+}
+
+// newMaintenanceFlag returns the maintenance flag of config, or nil when
+// maintenance mode can't be turned on
+func newMaintenanceFlag(config MaintenanceConfig, redisClient RedisClient, log *pluginLogger) *maintenanceFlag {
+	if !config.Enabled && config.Key == "" {
+		return nil
+	}
+	return &maintenanceFlag{config: config, redisClient: redisClient, log: log}
+}
+
+// active reports whether maintenance mode is on. A flag key that can't be read
+// keeps its last known state.
+func (f *maintenanceFlag) active(ctx context.Context, now time.Time) bool {
+	if f == nil {
+		return false
+	}
+	if f.config.Enabled {
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.checkedAt.IsZero() && now.Sub(f.checkedAt) < maintenanceFlagTTL {
+		return f.on
+	}
+
+	on := f.on
+	value, err := f.redisClient.Get(ctx, f.config.Key)
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		on = false
+	case err != nil:
+		f.log.errorf("Failed to read maintenance flag %s: %v", f.config.Key, err)
+	default:
+		on, _ = strconv.ParseBool(value)
+	}
+	if on != f.on {
+		f.log.infof("Maintenance mode turned %s by %s", onOff(on), f.config.Key)
+	}
+	f.on = on
+	f.checkedAt = now
+	return f.on
+}
+
+// onOff formats a toggle state for log lines
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,110 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPMaintenanceModeEnabled(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.MaintenanceMode.Enabled = true
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].ValuePrefix = "u"
+		c.Identifiers[0].RateLimit.Rate = 1
+		c.Identifiers[0].Denylist = []string{"u-revoked"}
+	})
+
+	serveAs(handler, "u1")
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("over-limit request in maintenance: status %d, want 200", rw.Code)
+	}
+	// Headers still report the exhausted limit
+	if rw.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("X-RateLimit-Remaining %q, want 0", rw.Header().Get("X-RateLimit-Remaining"))
+	}
+	if rw := serveAs(handler, "u-revoked"); rw.Code != http.StatusForbidden {
+		t.Fatalf("denylisted value in maintenance: status %d, want 403", rw.Code)
+	}
+}
+
+func TestServeHTTPMaintenanceModeKeepsRejections(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.MaintenanceMode.Enabled = true
+		c.Identifiers[0].MaxRequestBytes = 100
+		cookie := c.Identifiers[0]
+		cookie.Type, cookie.Name, cookie.Value = "Cookie", "session", ""
+		cookie.CookieSignature = CookieSignature{Secret: "s3cret", OnInvalid: CookieSignatureBlock}
+		c.Identifiers = append(c.Identifiers, cookie)
+	})
+
+	if rw := postAs(handler, "u1", 101); rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized request in maintenance: status %d, want 413", rw.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: signCookie("alice", "forged")})
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("tampered cookie in maintenance: status %d, want 403", rw.Code)
+	}
+}
+
+func TestServeHTTPMaintenanceModeFlagKey(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.MaintenanceMode.Key = "quota:maintenance"
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+	now := time.Now()
+	handler.(*quotaPlugin).now = func() time.Time { return now }
+
+	serveAs(handler, "u1")
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("flag unset: status %d, want 429", rw.Code)
+	}
+
+	server.mem.Set(ctx, "quota:maintenance", "true", 0)
+	// The last read is reused until it is maintenanceFlagTTL old
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("flag set within the TTL: status %d, want 429", rw.Code)
+	}
+	now = now.Add(maintenanceFlagTTL)
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("flag set: status %d, want 200", rw.Code)
+	}
+
+	server.mem.Set(ctx, "quota:maintenance", "0", 0)
+	now = now.Add(maintenanceFlagTTL)
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("flag cleared: status %d, want 429", rw.Code)
+	}
+}
+
+func TestMaintenanceFlagKeepsStateOnErrors(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{Address: server.addr})
+	flag := newMaintenanceFlag(MaintenanceConfig{Key: "quota:maintenance"}, client, nil)
+	now := time.Now()
+
+	server.mem.Set(ctx, "quota:maintenance", "1", 0)
+	if !flag.active(ctx, now) {
+		t.Fatal("flag set to 1, want maintenance on")
+	}
+	server.setHook(func(args []string) string { return "-ERR unavailable\r\n" })
+	if !flag.active(ctx, now.Add(maintenanceFlagTTL)) {
+		t.Fatal("unreadable flag turned maintenance off, want the last state kept")
+	}
+
+	if newMaintenanceFlag(MaintenanceConfig{}, client, nil) != nil {
+		t.Fatal("flag created without Enabled or Key")
+	}
+}
@@ -0,0 +1,653 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ensure the in-memory client satisfies the full RedisClient interface
+var _ RedisClient = (*MemoryRedisClient)(nil)
+
+// MemoryRedisClient implements RedisClient with an in-memory map.
+// It is intended for tests and benchmarks that should not depend on a real Redis.
+type MemoryRedisClient struct {
+	mu      sync.Mutex
+	values  map[string]string
+	zsets   map[string]map[string]float64
+	sets    map[string]map[string]struct{}
+	hashes  map[string]map[string]string
+	expires map[string]time.Time
+	now     func() time.Time
+}
+
+// NewMemoryRedisClient creates a new in-memory Redis client
+func NewMemoryRedisClient() *MemoryRedisClient {
+	return &MemoryRedisClient{
+		values:  make(map[string]string),
+		zsets:   make(map[string]map[string]float64),
+		sets:    make(map[string]map[string]struct{}),
+		hashes:  make(map[string]map[string]string),
+		expires: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// SetClock replaces the clock used to evaluate key expiration
+func (m *MemoryRedisClient) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+// Ping always succeeds for the in-memory client
+func (m *MemoryRedisClient) Ping(ctx context.Context) (string, error) {
+	return "PONG", nil
+}
+
+// Get retrieves a value
+func (m *MemoryRedisClient) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.lookup(key)
+	if !ok {
+		return "", errKeyNotFound
+	}
+	return value, nil
+}
+
+// GetEx retrieves a value and refreshes its expiration when positive
+func (m *MemoryRedisClient) GetEx(ctx context.Context, key string, expiration time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.lookup(key)
+	if !ok {
+		return "", errKeyNotFound
+	}
+	if expiration > 0 {
+		m.expires[key] = m.now().Add(expiration)
+	}
+	return value, nil
+}
+
+// Set stores a value with an optional expiration
+func (m *MemoryRedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = fmt.Sprintf("%v", value)
+	if expiration > 0 {
+		m.expires[key] = m.now().Add(expiration)
+	} else {
+		delete(m.expires, key)
+	}
+	return nil
+}
+
+// Incr increments a key's value by 1
+func (m *MemoryRedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	return m.IncrBy(ctx, key, 1)
+}
+
+// IncrBy increments a key's value by a specified amount
+func (m *MemoryRedisClient) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current int64
+	if existing, ok := m.lookup(key); ok {
+		parsed, err := strconv.ParseInt(existing, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("redis error: ERR value is not an integer or out of range")
+		}
+		current = parsed
+	}
+
+	// Like Redis, refuse to wrap around
+	if (value > 0 && current > math.MaxInt64-value) || (value < 0 && current < math.MinInt64-value) {
+		return 0, &RedisError{Message: "ERR increment or decrement would overflow"}
+	}
+
+	current += value
+	m.values[key] = strconv.FormatInt(current, 10)
+	return current, nil
+}
+
+// IncrByFloat increments a key's value by a fractional amount
+func (m *MemoryRedisClient) IncrByFloat(ctx context.Context, key string, value float64) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current float64
+	if existing, ok := m.lookup(key); ok {
+		parsed, err := strconv.ParseFloat(existing, 64)
+		if err != nil {
+			return 0, fmt.Errorf("redis error: ERR value is not a valid float")
+		}
+		current = parsed
+	}
+
+	current += value
+	m.values[key] = strconv.FormatFloat(current, 'f', -1, 64)
+	return current, nil
+}
+
+// Expire sets an expiration time for a key, rounded up to whole seconds
+func (m *MemoryRedisClient) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	seconds := time.Duration(math.Ceil(expiration.Seconds())) * time.Second
+	return m.PExpire(ctx, key, seconds)
+}
+
+// PExpire sets an expiration time for a key and reports whether the key existed
+func (m *MemoryRedisClient) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.exists(key) {
+		return false, nil
+	}
+	m.expires[key] = m.now().Add(expiration)
+	return true, nil
+}
+
+// TTL returns the remaining time to live for a key
+func (m *MemoryRedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.exists(key) {
+		return 0, errKeyNotFound
+	}
+
+	expiresAt, ok := m.expires[key]
+	if !ok {
+		return -1, nil // No expiration
+	}
+
+	// Redis reports TTL with second granularity
+	return expiresAt.Sub(m.now()).Truncate(time.Second), nil
+}
+
+// Exists checks if keys exist
+func (m *MemoryRedisClient) Exists(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for _, key := range keys {
+		if m.exists(key) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Eval emulates the Lua scripts used by the plugin; other scripts are rejected
+func (m *MemoryRedisClient) Eval(ctx context.Context, script string, keys []string, args ...string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch script {
+	case slidingWindowScript:
+		return m.evalSlidingWindow(keys, args)
+	case tokenBucketScript:
+		return m.evalTokenBucket(keys, args)
+	default:
+		return nil, &RedisError{Message: "NOSCRIPT script not supported by the in-memory client"}
+	}
+}
+
+// evalSlidingWindow mirrors slidingWindowScript. Callers must hold m.mu.
+func (m *MemoryRedisClient) evalSlidingWindow(keys []string, args []string) (interface{}, error) {
+	if len(keys) != 1 || len(args) != 6 {
+		return nil, &RedisError{Message: "ERR wrong number of arguments"}
+	}
+
+	now, _ := strconv.ParseFloat(args[0], 64)
+	window, _ := strconv.ParseFloat(args[1], 64)
+	limit, _ := strconv.ParseInt(args[2], 10, 64)
+	cost, _ := strconv.ParseInt(args[3], 10, 64)
+	reserve, _ := strconv.ParseInt(args[5], 10, 64)
+
+	key := keys[0]
+	if expiresAt, ok := m.expires[key]; ok && !m.now().Before(expiresAt) {
+		delete(m.zsets, key)
+		delete(m.expires, key)
+	}
+
+	set := m.zsets[key]
+	if set == nil {
+		set = make(map[string]float64)
+	}
+	for member, score := range set {
+		if score <= now-window {
+			delete(set, member)
+		}
+	}
+
+	count := int64(len(set))
+	var allowed int64
+	if cost > 0 && count+cost+reserve <= limit {
+		for i := int64(1); i <= cost; i++ {
+			set[args[4]+":"+strconv.FormatInt(i, 10)] = now
+		}
+		count += cost
+		allowed = 1
+	}
+
+	oldest := int64(-1)
+	for _, score := range set {
+		if oldest == -1 || int64(score) < oldest {
+			oldest = int64(score)
+		}
+	}
+
+	if len(set) > 0 {
+		m.zsets[key] = set
+		m.expires[key] = m.now().Add(time.Duration(window) * time.Microsecond)
+	} else {
+		delete(m.zsets, key)
+		delete(m.expires, key)
+	}
+
+	return []interface{}{allowed, count, oldest}, nil
+}
+
+// evalTokenBucket mirrors tokenBucketScript. Callers must hold m.mu.
+func (m *MemoryRedisClient) evalTokenBucket(keys []string, args []string) (interface{}, error) {
+	if len(keys) != 2 || len(args) != 11 {
+		return nil, &RedisError{Message: "ERR wrong number of arguments"}
+	}
+
+	now, _ := strconv.ParseFloat(args[0], 64)
+	rate, _ := strconv.ParseFloat(args[1], 64)
+	burst, _ := strconv.ParseFloat(args[2], 64)
+	period, _ := strconv.ParseFloat(args[3], 64)
+	refillRate, _ := strconv.ParseFloat(args[4], 64)
+	refillInterval, _ := strconv.ParseFloat(args[5], 64)
+	cost, _ := strconv.ParseFloat(args[7], 64)
+	ttl, _ := strconv.ParseInt(args[8], 10, 64)
+	windowStart, _ := strconv.ParseFloat(args[9], 64)
+	reserve, _ := strconv.ParseFloat(args[10], 64)
+
+	lastRefill := args[0]
+	tokensValue, hasTokens := m.lookup(keys[0])
+	lastValue, hasLast := m.lookup(keys[1])
+	tokens, tokensErr := strconv.ParseFloat(tokensValue, 64)
+	last, lastErr := strconv.ParseFloat(lastValue, 64)
+	if !hasTokens || !hasLast || tokensErr != nil || lastErr != nil {
+		tokens, _ = strconv.ParseFloat(args[6], 64)
+		if windowStart > 0 {
+			lastRefill = args[9]
+		}
+	} else if windowStart > 0 {
+		if last < windowStart {
+			tokens = burst
+			lastRefill = args[9]
+		} else {
+			lastRefill = strconv.FormatFloat(last, 'f', 0, 64)
+		}
+	} else {
+		elapsed := math.Max(now-last, 0)
+		if refillInterval > 0 && refillRate > 0 {
+			intervals := math.Floor(elapsed / refillInterval)
+			tokens = math.Min(tokens+intervals*refillRate, burst)
+			if tokens < burst {
+				lastRefill = strconv.FormatFloat(last+intervals*refillInterval, 'f', 0, 64)
+			}
+		} else {
+			tokens = math.Min(tokens+rate*elapsed/period, burst)
+		}
+	}
+
+	var allowed int64
+	if tokens >= cost+reserve {
+		tokens -= cost
+		allowed = 1
+	}
+
+	expiresAt := m.now().Add(time.Duration(ttl) * time.Millisecond)
+	tokensString := strconv.FormatFloat(tokens, 'g', 14, 64)
+	m.values[keys[0]] = tokensString
+	m.values[keys[1]] = lastRefill
+	m.expires[keys[0]] = expiresAt
+	m.expires[keys[1]] = expiresAt
+
+	return []interface{}{allowed, tokensString}, nil
+}
+
+// Scan returns all live keys matching the glob pattern in a single page
+func (m *MemoryRedisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for key := range m.values {
+		if _, ok := m.lookup(key); ok && globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.zsets {
+		if m.exists(key) && globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.sets {
+		if m.exists(key) && globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.hashes {
+		if m.exists(key) && globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, 0, nil
+}
+
+// Del deletes keys and returns how many existed
+func (m *MemoryRedisClient) Del(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for _, key := range keys {
+		if m.exists(key) {
+			count++
+		}
+		delete(m.values, key)
+		delete(m.zsets, key)
+		delete(m.sets, key)
+		delete(m.hashes, key)
+		delete(m.expires, key)
+	}
+	return count, nil
+}
+
+// MGet retrieves the values of keys in key order; missing keys yield nil
+func (m *MemoryRedisClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := m.lookup(key); ok {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+// PFAdd adds elements to an exact set standing in for a HyperLogLog
+func (m *MemoryRedisClient) PFAdd(ctx context.Context, key string, elements ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exists(key) // evict the key if it expired
+	set := m.sets[key]
+	var changed int64
+	if set == nil {
+		set = make(map[string]struct{})
+		m.sets[key] = set
+		changed = 1
+	}
+	for _, element := range elements {
+		if _, ok := set[element]; !ok {
+			set[element] = struct{}{}
+			changed = 1
+		}
+	}
+	return changed, nil
+}
+
+// PFCount returns the exact number of distinct elements across the sets
+func (m *MemoryRedisClient) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	union := make(map[string]struct{})
+	for _, key := range keys {
+		if !m.exists(key) {
+			continue
+		}
+		for element := range m.sets[key] {
+			union[element] = struct{}{}
+		}
+	}
+	return int64(len(union)), nil
+}
+
+// HSet sets fields of a hash, returning how many were added
+func (m *MemoryRedisClient) HSet(ctx context.Context, key string, fields map[string]string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exists(key) // evict the key if it expired
+	hash := m.hashes[key]
+	if hash == nil {
+		hash = make(map[string]string)
+		m.hashes[key] = hash
+	}
+	var added int64
+	for field, value := range fields {
+		if _, ok := hash[field]; !ok {
+			added++
+		}
+		hash[field] = value
+	}
+	return added, nil
+}
+
+// HGetAll returns a copy of the fields and values of a hash
+func (m *MemoryRedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fields := make(map[string]string)
+	if !m.exists(key) {
+		return fields, nil
+	}
+	for field, value := range m.hashes[key] {
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+// SAdd adds members to a set, returning how many were not already members
+func (m *MemoryRedisClient) SAdd(ctx context.Context, key string, members ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exists(key) // evict the key if it expired
+	set := m.sets[key]
+	if set == nil {
+		set = make(map[string]struct{})
+		m.sets[key] = set
+	}
+	var added int64
+	for _, member := range members {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			added++
+		}
+	}
+	return added, nil
+}
+
+// SMembers returns the members of a set in sorted order
+func (m *MemoryRedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := []string{}
+	if !m.exists(key) {
+		return members, nil
+	}
+	for member := range m.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// Close is a no-op for the in-memory client
+func (m *MemoryRedisClient) Close() error {
+	return nil
+}
+
+// lookup returns the live value for key, evicting it if expired. Callers must hold m.mu.
+func (m *MemoryRedisClient) lookup(key string) (string, bool) {
+	value, ok := m.values[key]
+	if !ok {
+		return "", false
+	}
+
+	if expiresAt, ok := m.expires[key]; ok && !m.now().Before(expiresAt) {
+		delete(m.values, key)
+		delete(m.expires, key)
+		return "", false
+	}
+
+	return value, true
+}
+
+// exists reports whether key holds a live value of any kind, evicting it if
+// expired. Callers must hold m.mu.
+func (m *MemoryRedisClient) exists(key string) bool {
+	if expiresAt, ok := m.expires[key]; ok && !m.now().Before(expiresAt) {
+		delete(m.values, key)
+		delete(m.zsets, key)
+		delete(m.sets, key)
+		delete(m.hashes, key)
+		delete(m.expires, key)
+		return false
+	}
+
+	_, isValue := m.values[key]
+	_, isZSet := m.zsets[key]
+	_, isSet := m.sets[key]
+	_, isHash := m.hashes[key]
+	return isValue || isZSet || isSet || isHash
+}
+
+// globMatch reports whether s matches a Redis glob pattern supporting *, ? and \ escapes
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+// Watch emulates WATCH/MULTI/EXEC: Exec applies its commands only while the
+// watched keys still hold the values they had when watched
+func (m *MemoryRedisClient) Watch(ctx context.Context, fn func(tx RedisTx) error, keys ...string) error {
+	m.mu.Lock()
+	tx := &memoryTx{client: m, watched: make(map[string]memoryWatch, len(keys))}
+	for _, key := range keys {
+		value, ok := m.lookup(key)
+		tx.watched[key] = memoryWatch{value: value, exists: ok}
+	}
+	m.mu.Unlock()
+
+	return fn(tx)
+}
+
+// memoryWatch is the state of a watched key when it was watched
+type memoryWatch struct {
+	value  string
+	exists bool
+}
+
+// memoryTx is the RedisTx of the in-memory client
+type memoryTx struct {
+	client  *MemoryRedisClient
+	watched map[string]memoryWatch
+}
+
+// MGet reads keys; missing keys yield nil
+func (tx *memoryTx) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	return tx.client.MGet(ctx, keys...)
+}
+
+// Exec applies SET (with optional PX) and DEL commands atomically unless a
+// watched key changed
+func (tx *memoryTx) Exec(ctx context.Context, commands ...[]string) (bool, error) {
+	m := tx.client
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	watched := tx.watched
+	tx.watched = nil
+	for key, state := range watched {
+		if value, ok := m.lookup(key); ok != state.exists || value != state.value {
+			return false, nil
+		}
+	}
+
+	for _, command := range commands {
+		if err := m.execLocked(command); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Unwatch forgets the watched keys
+func (tx *memoryTx) Unwatch(ctx context.Context) error {
+	tx.watched = nil
+	return nil
+}
+
+// execLocked applies a transaction command. Callers must hold m.mu.
+func (m *MemoryRedisClient) execLocked(command []string) error {
+	switch {
+	case len(command) == 3 && command[0] == "SET":
+		m.values[command[1]] = command[2]
+		delete(m.expires, command[1])
+	case len(command) == 5 && command[0] == "SET" && command[3] == "PX":
+		ms, err := strconv.ParseInt(command[4], 10, 64)
+		if err != nil {
+			return &RedisError{Message: "ERR value is not an integer or out of range"}
+		}
+		m.values[command[1]] = command[2]
+		m.expires[command[1]] = m.now().Add(time.Duration(ms) * time.Millisecond)
+	case len(command) >= 2 && command[0] == "DEL":
+		for _, key := range command[1:] {
+			delete(m.values, key)
+			delete(m.expires, key)
+		}
+	default:
+		return &RedisError{Message: fmt.Sprintf("ERR unsupported transaction command '%v'", command)}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for key expiration
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryRedisClientExpiration(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	client := NewMemoryRedisClient()
+	client.SetClock(clock.Now)
+
+	if err := client.Set(ctx, "k", "v", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := client.TTL(ctx, "k"); err != nil || ttl != 10*time.Second {
+		t.Fatalf("TTL = %v, %v; want 10s", ttl, err)
+	}
+
+	clock.Advance(9 * time.Second)
+	if value, err := client.Get(ctx, "k"); err != nil || value != "v" {
+		t.Fatalf("Get before expiry = %q, %v", value, err)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := client.Get(ctx, "k"); !errors.Is(err, errKeyNotFound) {
+		t.Fatalf("Get after expiry error = %v, want errKeyNotFound", err)
+	}
+	if n, _ := client.Exists(ctx, "k"); n != 0 {
+		t.Fatalf("Exists after expiry = %d", n)
+	}
+}
+
+func TestMemoryRedisClientGetExRefreshesTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	client := NewMemoryRedisClient()
+	client.SetClock(clock.Now)
+
+	client.Set(ctx, "k", "v", 10*time.Second)
+	clock.Advance(8 * time.Second)
+	if _, err := client.GetEx(ctx, "k", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(8 * time.Second)
+	if value, err := client.Get(ctx, "k"); err != nil || value != "v" {
+		t.Fatalf("Get after refreshed TTL = %q, %v", value, err)
+	}
+}
+
+func TestMemoryRedisClientIncrBy(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+
+	if v, err := client.IncrBy(ctx, "n", 5); err != nil || v != 5 {
+		t.Fatalf("IncrBy = %d, %v", v, err)
+	}
+	if v, err := client.Incr(ctx, "n"); err != nil || v != 6 {
+		t.Fatalf("Incr = %d, %v", v, err)
+	}
+
+	client.Set(ctx, "text", "abc", 0)
+	if _, err := client.Incr(ctx, "text"); err == nil {
+		t.Fatal("Incr of a non-integer succeeded")
+	}
+
+	client.Set(ctx, "max", fmt.Sprint(int64(1<<63-1)), 0)
+	if _, err := client.Incr(ctx, "max"); err == nil {
+		t.Fatal("Incr past MaxInt64 succeeded")
+	}
+}
+
+func TestMemoryRedisClientConcurrentIncr(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				client.Incr(ctx, "n")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := client.Get(ctx, "n"); value != "5000" {
+		t.Fatalf("value after concurrent increments = %s, want 5000", value)
+	}
+}
+
+func TestMemoryRedisClientScanAndDel(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+
+	client.Set(ctx, "quota:a:2024-01-01", "1", 0)
+	client.Set(ctx, "quota:b:2024-01-01", "2", 0)
+	client.Set(ctx, "ratelimit:a:tokens", "3", 0)
+	client.SAdd(ctx, "quota:set", "x")
+
+	keys, cursor, err := client.Scan(ctx, 0, "quota:*", 100)
+	if err != nil || cursor != 0 {
+		t.Fatalf("Scan = %v, %d, %v", keys, cursor, err)
+	}
+	want := []string{"quota:a:2024-01-01", "quota:b:2024-01-01", "quota:set"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Scan keys = %v, want %v", keys, want)
+	}
+
+	if n, _ := client.Del(ctx, keys...); n != 3 {
+		t.Fatalf("Del = %d, want 3", n)
+	}
+	values, _ := client.MGet(ctx, "quota:a:2024-01-01", "ratelimit:a:tokens")
+	if values[0] != nil || values[1] != "3" {
+		t.Fatalf("MGet after Del = %v", values)
+	}
+}
+
+func TestMemoryRedisClientWatchConflict(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+	client.Set(ctx, "k", "1", 0)
+
+	err := client.Watch(ctx, func(tx RedisTx) error {
+		client.Set(ctx, "k", "2", 0) // Concurrent writer
+		ok, err := tx.Exec(ctx, []string{"SET", "k", "3"})
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.Error("Exec succeeded although the watched key changed")
+		}
+		return nil
+	}, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Watch(ctx, func(tx RedisTx) error {
+		ok, err := tx.Exec(ctx, []string{"SET", "k", "4", "PX", "1000"})
+		if !ok || err != nil {
+			t.Errorf("Exec on unchanged key = %v, %v", ok, err)
+		}
+		return nil
+	}, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := client.Get(ctx, "k"); value != "4" {
+		t.Fatalf("value after Exec = %s, want 4", value)
+	}
+}
+
+func TestMemoryRedisClientEvalRejectsUnknownScripts(t *testing.T) {
+	client := NewMemoryRedisClient()
+	_, err := client.Eval(context.Background(), "return 1", nil)
+	if !errors.Is(err, ErrNoScript) {
+		t.Fatalf("Eval of an unknown script error = %v, want ErrNoScript", err)
+	}
+}
+
+func BenchmarkMemoryRedisClientIncrBy(b *testing.B) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.IncrBy(ctx, "quota:bench", 1)
+	}
+}
+
+func BenchmarkMemoryRedisClientRateLimiter(b *testing.B) {
+	ctx := context.Background()
+	limiter := NewRateLimiter(NewMemoryRedisClient(), RateLimitConfig{Enabled: true, Rate: 1000000, Period: "1s"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := limiter.Allow(ctx, "bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryRedisClientQuota(b *testing.B) {
+	ctx := context.Background()
+	quota := NewQuotaManager(NewMemoryRedisClient(), QuotaSettings{Enabled: true, Limit: 1 << 40, Period: "Daily"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := quota.ReserveQuota(ctx, "bench", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryRedisClientParallel(b *testing.B) {
+	ctx := context.Background()
+	limiter := NewRateLimiter(NewMemoryRedisClient(), RateLimitConfig{Enabled: true, Rate: 1000000, Period: "1s"})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := limiter.Allow(ctx, "bench"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
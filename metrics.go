@@ -0,0 +1,338 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsPath is where metrics are served when MetricsConfig.Path is empty
+const defaultMetricsPath = "/_quota/metrics"
+
+// redisLatencyBuckets are the histogram upper bounds in seconds
+var redisLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// histogram accumulates observations into cumulative buckets
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// metricLabelName is the Prometheus label name syntax
+var metricLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedMetricLabels are set by the plugin and can't be configured
+var reservedMetricLabels = map[string]bool{"type": true, "decision": true, "command": true, "le": true}
+
+// metricLabelEscaper escapes label values for the text exposition format
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics collects plugin metrics and renders them in Prometheus text format
+type Metrics struct {
+	mu    sync.Mutex
+	redis map[string]*histogram
+	// decisions counts requests by their rendered label set
+	decisions map[string]uint64
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		redis:     make(map[string]*histogram),
+		decisions: make(map[string]uint64),
+	}
+}
+
+// ObserveDecision counts a request decided by an identifier of identifierType,
+// tagged with the identifier's configured labels
+func (m *Metrics) ObserveDecision(identifierType string, labels map[string]string, allowed bool) {
+	decision := "blocked"
+	if allowed {
+		decision = "allowed"
+	}
+
+	all := map[string]string{"type": identifierType, "decision": decision}
+	for name, value := range labels {
+		all[name] = value
+	}
+	key := formatMetricLabels(all)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decisions[key]++
+}
+
+// formatMetricLabels renders labels sorted by name, e.g. {decision="allowed",plan="pro"}
+func formatMetricLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, metricLabelEscaper.Replace(labels[name]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// validateMetricLabels checks that label names are valid Prometheus label
+// names not used by the plugin itself
+func validateMetricLabels(labels map[string]string) error {
+	for name := range labels {
+		if !metricLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid metrics label name: %q", name)
+		}
+		if reservedMetricLabels[name] {
+			return fmt.Errorf("metrics label name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// ObserveRedis records the latency of a Redis round trip for a command
+func (m *Metrics) ObserveRedis(command string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.redis[command]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(redisLatencyBuckets))}
+		m.redis[command] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range redisLatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteTo renders all metrics in Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var written int64
+	write := func(format string, args ...interface{}) error {
+		n, err := fmt.Fprintf(w, format, args...)
+		written += int64(n)
+		return err
+	}
+
+	commands := make([]string, 0, len(m.redis))
+	for command := range m.redis {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	if err := write("# HELP quota_redis_command_duration_seconds Latency of Redis round trips.\n# TYPE quota_redis_command_duration_seconds histogram\n"); err != nil {
+		return written, err
+	}
+	for _, command := range commands {
+		h := m.redis[command]
+		for i, bound := range redisLatencyBuckets {
+			if err := write("quota_redis_command_duration_seconds_bucket{command=%q,le=%q} %d\n",
+				command, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i]); err != nil {
+				return written, err
+			}
+		}
+		if err := write("quota_redis_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", command, h.count); err != nil {
+			return written, err
+		}
+		if err := write("quota_redis_command_duration_seconds_sum{command=%q} %g\n", command, h.sum); err != nil {
+			return written, err
+		}
+		if err := write("quota_redis_command_duration_seconds_count{command=%q} %d\n", command, h.count); err != nil {
+			return written, err
+		}
+	}
+
+	if len(m.decisions) > 0 {
+		keys := make([]string, 0, len(m.decisions))
+		for key := range m.decisions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if err := write("# HELP quota_requests_total Requests decided by the plugin.\n# TYPE quota_requests_total counter\n"); err != nil {
+			return written, err
+		}
+		for _, key := range keys {
+			if err := write("quota_requests_total%s %d\n", key, m.decisions[key]); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// ServeHTTP exposes the metrics endpoint
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rw.WriteHeader(http.StatusOK)
+	m.WriteTo(rw)
+}
+
+// instrumentedRedisClient decorates a RedisClient, timing every round trip
+type instrumentedRedisClient struct {
+	next    RedisClient
+	metrics *Metrics
+}
+
+// NewInstrumentedRedisClient wraps client so each command latency is recorded in metrics
+func NewInstrumentedRedisClient(client RedisClient, metrics *Metrics) RedisClient {
+	return &instrumentedRedisClient{
+		next:    client,
+		metrics: metrics,
+	}
+}
+
+// observe records the time elapsed since start for command
+func (c *instrumentedRedisClient) observe(command string, start time.Time) {
+	c.metrics.ObserveRedis(command, time.Since(start))
+}
+
+// Ping sends a PING command to Redis
+func (c *instrumentedRedisClient) Ping(ctx context.Context) (string, error) {
+	defer c.observe("PING", time.Now())
+	return c.next.Ping(ctx)
+}
+
+// Get retrieves a value from Redis
+func (c *instrumentedRedisClient) Get(ctx context.Context, key string) (string, error) {
+	defer c.observe("GET", time.Now())
+	return c.next.Get(ctx, key)
+}
+
+// GetEx retrieves a value and refreshes its expiration
+func (c *instrumentedRedisClient) GetEx(ctx context.Context, key string, expiration time.Duration) (string, error) {
+	defer c.observe("GETEX", time.Now())
+	return c.next.GetEx(ctx, key, expiration)
+}
+
+// Set stores a value in Redis
+func (c *instrumentedRedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	defer c.observe("SET", time.Now())
+	return c.next.Set(ctx, key, value, expiration)
+}
+
+// Incr increments a key's value by 1
+func (c *instrumentedRedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	defer c.observe("INCR", time.Now())
+	return c.next.Incr(ctx, key)
+}
+
+// IncrBy increments a key's value by a specified amount
+func (c *instrumentedRedisClient) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	defer c.observe("INCRBY", time.Now())
+	return c.next.IncrBy(ctx, key, value)
+}
+
+// IncrByFloat increments a key's value by a fractional amount
+func (c *instrumentedRedisClient) IncrByFloat(ctx context.Context, key string, value float64) (float64, error) {
+	defer c.observe("INCRBYFLOAT", time.Now())
+	return c.next.IncrByFloat(ctx, key, value)
+}
+
+// Expire sets an expiration time for a key
+func (c *instrumentedRedisClient) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	defer c.observe("EXPIRE", time.Now())
+	return c.next.Expire(ctx, key, expiration)
+}
+
+// PExpire sets an expiration time for a key in milliseconds
+func (c *instrumentedRedisClient) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	defer c.observe("PEXPIRE", time.Now())
+	return c.next.PExpire(ctx, key, expiration)
+}
+
+// TTL returns the remaining time to live for a key
+func (c *instrumentedRedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	defer c.observe("TTL", time.Now())
+	return c.next.TTL(ctx, key)
+}
+
+// Exists checks if keys exist
+func (c *instrumentedRedisClient) Exists(ctx context.Context, keys ...string) (int64, error) {
+	defer c.observe("EXISTS", time.Now())
+	return c.next.Exists(ctx, keys...)
+}
+
+// Eval runs a Lua script atomically
+func (c *instrumentedRedisClient) Eval(ctx context.Context, script string, keys []string, args ...string) (interface{}, error) {
+	defer c.observe("EVAL", time.Now())
+	return c.next.Eval(ctx, script, keys, args...)
+}
+
+// Scan iterates keys matching a glob pattern
+func (c *instrumentedRedisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	defer c.observe("SCAN", time.Now())
+	return c.next.Scan(ctx, cursor, match, count)
+}
+
+// Del deletes keys
+func (c *instrumentedRedisClient) Del(ctx context.Context, keys ...string) (int64, error) {
+	defer c.observe("DEL", time.Now())
+	return c.next.Del(ctx, keys...)
+}
+
+// MGet retrieves the values of several keys in one round trip
+func (c *instrumentedRedisClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	defer c.observe("MGET", time.Now())
+	return c.next.MGet(ctx, keys...)
+}
+
+// PFAdd adds elements to a HyperLogLog
+func (c *instrumentedRedisClient) PFAdd(ctx context.Context, key string, elements ...string) (int64, error) {
+	defer c.observe("PFADD", time.Now())
+	return c.next.PFAdd(ctx, key, elements...)
+}
+
+// PFCount returns the approximate distinct count of HyperLogLogs
+func (c *instrumentedRedisClient) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	defer c.observe("PFCOUNT", time.Now())
+	return c.next.PFCount(ctx, keys...)
+}
+
+// HGetAll returns the fields and values of a hash
+func (c *instrumentedRedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	defer c.observe("HGETALL", time.Now())
+	return c.next.HGetAll(ctx, key)
+}
+
+// SAdd adds members to a set
+func (c *instrumentedRedisClient) SAdd(ctx context.Context, key string, members ...string) (int64, error) {
+	defer c.observe("SADD", time.Now())
+	return c.next.SAdd(ctx, key, members...)
+}
+
+// SMembers returns the members of a set
+func (c *instrumentedRedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	defer c.observe("SMEMBERS", time.Now())
+	return c.next.SMembers(ctx, key)
+}
+
+// Watch runs an optimistic transaction on watched keys
+func (c *instrumentedRedisClient) Watch(ctx context.Context, fn func(tx RedisTx) error, keys ...string) error {
+	defer c.observe("WATCH", time.Now())
+	return c.next.Watch(ctx, fn, keys...)
+}
+
+// Close closes the underlying client
+func (c *instrumentedRedisClient) Close() error {
+	return c.next.Close()
+}
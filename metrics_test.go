@@ -0,0 +1,111 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstrumentedRedisClientForwardsAndObserves(t *testing.T) {
+	ctx := context.Background()
+	metrics := NewMetrics()
+	memory := NewMemoryRedisClient()
+	client := NewInstrumentedRedisClient(memory, metrics)
+
+	if err := client.Set(ctx, "k", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := client.IncrBy(ctx, "k", 2); err != nil || v != 3 {
+		t.Fatalf("IncrBy = %d, %v", v, err)
+	}
+	if v, err := client.Get(ctx, "k"); err != nil || v != "3" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	// The wrapped client sees the writes
+	if v, _ := memory.Get(ctx, "k"); v != "3" {
+		t.Fatalf("wrapped client holds %q", v)
+	}
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	for _, want := range []string{
+		`quota_redis_command_duration_seconds_count{command="SET"} 1`,
+		`quota_redis_command_duration_seconds_count{command="INCRBY"} 1`,
+		`quota_redis_command_duration_seconds_count{command="GET"} 1`,
+		`quota_redis_command_duration_seconds_bucket{command="GET",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestObserveRedisBuckets(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.ObserveRedis("GET", 3*time.Millisecond)
+	metrics.ObserveRedis("GET", 2*time.Second)
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	for _, want := range []string{
+		`quota_redis_command_duration_seconds_bucket{command="GET",le="0.0025"} 0`,
+		`quota_redis_command_duration_seconds_bucket{command="GET",le="0.005"} 1`,
+		`quota_redis_command_duration_seconds_bucket{command="GET",le="1"} 1`,
+		`quota_redis_command_duration_seconds_bucket{command="GET",le="+Inf"} 2`,
+		`quota_redis_command_duration_seconds_count{command="GET"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Metrics.Enabled = true
+	})
+	serveAs(handler, "u1")
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, defaultMetricsPath, nil))
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "quota_redis_command_duration_seconds_count") {
+		t.Fatalf("metrics endpoint: status %d, body:\n%s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestMetricsDecisionLabels(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Metrics.Enabled = true
+		c.Identifiers[0].RateLimit.Rate = 1
+		c.Identifiers[0].Labels = map[string]string{"plan": "pro", "team": `se"arch`}
+	})
+	serveAs(handler, "u1")
+	serveAs(handler, "u1")
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, defaultMetricsPath, nil))
+	for _, want := range []string{
+		`quota_requests_total{decision="allowed",plan="pro",team="se\"arch",type="Header"} 1`,
+		`quota_requests_total{decision="blocked",plan="pro",team="se\"arch",type="Header"} 1`,
+	} {
+		if !strings.Contains(rw.Body.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, rw.Body.String())
+		}
+	}
+}
+
+func TestValidateMetricLabels(t *testing.T) {
+	for _, name := range []string{"", "1plan", "plan-tier", "__internal", "type", "decision"} {
+		if err := validateMetricLabels(map[string]string{name: "x"}); err == nil {
+			t.Errorf("label name %q accepted", name)
+		}
+	}
+	if err := validateMetricLabels(map[string]string{"plan": "pro", "_team": "search"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// isProbeRequest reports whether req only asks for its current limits
+func (q *quotaPlugin) isProbeRequest(req *http.Request) bool {
+	if q.config.ProbeHeader == "" {
+		return false
+	}
+	probe, err := strconv.ParseBool(req.Header.Get(q.config.ProbeHeader))
+	return err == nil && probe
+}
+
+// serveProbe answers a probe with the limit headers of the first matching
+// identifier and an empty 200, without consuming anything or proxying.
+// Denylisted values and, when failing closed, Redis failures are blocked as
+// their requests would be.
+func (q *quotaPlugin) serveProbe(rw http.ResponseWriter, req *http.Request) {
+	for key, manager := range q.currentManagers() {
+		identifier := q.extractIdentifier(req, manager.config)
+		if identifier == "" {
+			continue
+		}
+
+		// Denylisted values are blocked like any of their requests
+		if manager.config.isDenied(req, identifier) {
+			response := manager.deniedResponse(identifier)
+			response.IdentifierType = key
+			q.blockProbe(rw, req, manager, response)
+			return
+		}
+
+		var response *QuotaResponse
+		err := manager.ensureInitialized()
+		if err != nil {
+			q.log.errorf("Error initializing identifier %s: %v", key, err)
+		} else if response, err = manager.Probe(req.Context(), identifier); err != nil {
+			q.log.errorf("Error probing identifier %s: %v", key, err)
+		}
+		if err != nil {
+			// Redis failures are handled per FailureMode, as for the requests probed
+			if manager.failClosed && q.enforcing(identifier) && !q.maintenance.active(req.Context(), q.now()) {
+				response = q.backendUnavailableResponse(key, identifier)
+				q.blockProbe(rw, req, manager, response)
+				return
+			}
+			rw.Header().Set("X-Quota-Probe-Allowed", "true")
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+
+		tracef(req, "Probe for identifier: %s (type: %s)", identifier, key)
+
+		// The probe exists to read the headers, so HeadersOn doesn't apply
+		q.writeQuotaHeaders(rw, response)
+		rw.Header().Set("X-Quota-Probe-Allowed", strconv.FormatBool(response.Allowed))
+		suppressHeaders(rw.Header(), manager.config.SuppressHeaders)
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	rw.Write([]byte(`{"error":"Access denied","message":"No valid identifier found in request"}`))
+}
+
+// blockProbe answers a probe with the rejection response would get as a
+// regular request
+func (q *quotaPlugin) blockProbe(rw http.ResponseWriter, req *http.Request, manager *IdentifierManager, response *QuotaResponse) {
+	responseBody := response.ResponseBody
+	if responseBody == "" {
+		responseBody = response.Reason
+	}
+
+	response.RequestID = q.blockedRequestID(rw, req)
+	q.log.infof("Probe blocked: %s (identifier: %s, type: %s)%s",
+		response.Reason, response.Identifier, response.IdentifierType, requestIDLogSuffix(response.RequestID))
+
+	contentType := responseContentType(responseBody, manager.config.ResponseContentType)
+	if q.config.StructuredErrors {
+		responseBody = structuredErrorBody(response, q.maxRetryAfter)
+		contentType = "application/json"
+	}
+	q.writeQuotaHeaders(rw, response)
+	suppressHeaders(rw.Header(), manager.config.SuppressHeaders)
+	rw.Header().Set("Content-Type", contentType)
+	rw.WriteHeader(response.ResponseCode)
+	rw.Write([]byte(responseBody))
+}
+
+// Probe reports the current rate limit and quota state of identifier without
+// consuming anything; Allowed tells whether a single-unit request would pass
+func (m *IdentifierManager) Probe(ctx context.Context, identifier string) (*QuotaResponse, error) {
+	response := &QuotaResponse{
+		Allowed:        true,
+		Identifier:     identifier,
+		IdentifierType: m.config.Type,
+	}
+
+	if m.config.RateLimit.Enabled && m.rateLimiter != nil {
+		info, err := m.rateLimiter.GetLimitInfo(ctx, identifier)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rate limit info: %w", err)
+		}
+		response.RateLimit = &info
+		if info.Available < 1 {
+			response.Allowed = false
+			response.Reason = "Rate limit exceeded"
+		}
+	}
+
+	if m.quotaManager.IsQuotaEnabled() {
+		allowed, info, err := m.quotaManager.CheckQuotaN(ctx, identifier, 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get quota info: %w", err)
+		}
+		// Report whether the usage so far is beyond the limit, not whether the
+		// hypothetical request would be
+		if info.Fractional {
+			info.Overage = info.ExactUsed > info.ExactLimit+fractionalEpsilon
+		} else {
+			info.Overage = info.Used > info.Limit
+		}
+		response.Quota = info
+		if !allowed && response.Allowed {
+			response.Allowed = false
+			response.Reason = "Quota exceeded"
+		}
+	}
+
+	return response, nil
+}
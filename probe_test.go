@@ -0,0 +1,121 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTPProbeNeverConsumes(t *testing.T) {
+	server := newTestRedisServer(t)
+	proxied := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { proxied++ })
+	handler := newTestPluginNext(t, server, next, func(c *Config) {
+		c.ProbeHeader = "X-Quota-Probe"
+		c.Identifiers[0].RateLimit.Rate = 10
+		c.Identifiers[0].Quota.Limit = 2
+	})
+
+	probe := func(value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		req.Header.Set("X-Quota-Probe", value)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	serveAs(handler, "u1")
+	for i := 0; i < 5; i++ {
+		rw := probe("true")
+		if rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Used") != "1" || rw.Header().Get("X-RateLimit-Remaining") != "9" || rw.Header().Get("X-Quota-Probe-Allowed") != "true" {
+			t.Fatalf("probe %d: status %d, headers %v", i+1, rw.Code, rw.Header())
+		}
+	}
+	if proxied != 1 {
+		t.Fatalf("%d requests proxied, want probes answered by the plugin", proxied)
+	}
+
+	// A value other than true is a regular request
+	if rw := probe("false"); rw.Header().Get("X-Quota-Used") != "2" || proxied != 2 {
+		t.Fatalf("non-probe request: X-Quota-Used %q, %d proxied", rw.Header().Get("X-Quota-Used"), proxied)
+	}
+
+	// An exhausted quota is reported, not enforced
+	if rw := probe("1"); rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Probe-Allowed") != "false" {
+		t.Fatalf("probe of an exhausted quota: status %d, allowed %q", rw.Code, rw.Header().Get("X-Quota-Probe-Allowed"))
+	}
+}
+
+func TestServeHTTPProbeWithoutIdentifier(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.ProbeHeader = "X-Quota-Probe"
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Quota-Probe", "true")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("probe without an identifier: status %d, want 403", rw.Code)
+	}
+}
+
+// probeAs sends a probe of user to handler
+func probeAs(handler http.Handler, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", user)
+	req.Header.Set("X-Quota-Probe", "true")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestServeHTTPProbeDenylisted(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.ProbeHeader = "X-Quota-Probe"
+		c.Identifiers[0].Denylist = []string{"u1"}
+	})
+
+	if rw := probeAs(handler, "u1"); rw.Code != http.StatusForbidden || rw.Body.String() != ReasonDenied {
+		t.Fatalf("probe of a denylisted value: status %d, body %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestServeHTTPProbeGlobalRateLimit(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.ProbeHeader = "X-Quota-Probe"
+		c.GlobalRateLimit = RateLimitConfig{Enabled: true, Rate: 1, Period: "1h"}
+	})
+
+	if rw := probeAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("first probe: status %d, want 200", rw.Code)
+	}
+	if rw := probeAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("probe past the global rate limit: status %d, want 429", rw.Code)
+	}
+}
+
+func TestServeHTTPProbeFailureMode(t *testing.T) {
+	for mode, want := range map[string]int{"open": http.StatusOK, "closed": http.StatusServiceUnavailable} {
+		t.Run(mode, func(t *testing.T) {
+			server := newTestRedisServer(t)
+			handler := newTestPlugin(t, server, func(c *Config) {
+				c.ProbeHeader = "X-Quota-Probe"
+				c.FailureMode = mode
+			})
+			failRedis(server)
+
+			rw := probeAs(handler, "u1")
+			if rw.Code != want {
+				t.Fatalf("probe with Redis failing: status %d, want %d", rw.Code, want)
+			}
+			if mode == "open" && rw.Header().Get("X-Quota-Probe-Allowed") != "true" {
+				t.Fatalf("X-Quota-Probe-Allowed %q failing open, want true", rw.Header().Get("X-Quota-Probe-Allowed"))
+			}
+		})
+	}
+}
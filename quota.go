@@ -3,19 +3,17 @@ package traefik_quota_plugin
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"math"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
+	"time"
 )
 
-func init() {
-	log.SetOutput(os.Stdout)
-}
-
 // CreateConfig creates and initializes the plugin configuration
 func CreateConfig() *Config {
 	return &Config{}
@@ -27,7 +25,28 @@ type quotaPlugin struct {
 	next        http.Handler
 	config      *Config
 	redisClient RedisClient
-	managers    map[string]*IdentifierManager
+	// connections holds the primary ("") and named Redis connections
+	connections map[string]*redisConnection
+	// managersMu guards managers, which dynamic limits swap while serving
+	managersMu sync.RWMutex
+	managers   map[string]*IdentifierManager
+	// baseIdentifiers holds the configured identifiers by manager key, before dynamic limits
+	baseIdentifiers map[string]IdentifierConfig
+	sampler         *sampler
+	metrics         *Metrics
+	// maxRetryAfter caps advertised retry delays (0 for no cap)
+	maxRetryAfter time.Duration
+	// globalLimiter enforces GlobalRateLimit across all identifiers (nil when disabled)
+	globalLimiter *RateLimiter
+	// maxPriority is the highest identifier Priority; 0 disables priority shedding
+	maxPriority int
+	// enforceAfter is the instant blocking starts; before it blocks are only logged
+	enforceAfter time.Time
+	// maintenance lifts the limits while maintenance mode is on (nil when it can't be)
+	maintenance *maintenanceFlag
+	// log writes this instance's log lines with its own Log settings
+	log *pluginLogger
+	now func() time.Time
 }
 
 // passthroughPlugin is used when quota plugin is disabled (no Redis config)
@@ -42,9 +61,27 @@ func (p *passthroughPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 
 // IdentifierManager manages rate limiting and quota for a specific identifier
 type IdentifierManager struct {
-	config       *IdentifierConfig
+	key        string
+	config     *IdentifierConfig
+	connection *redisConnection
+	failClosed bool // return Redis errors instead of failing open
+	// readCacheTTL enables the in-process cache of quota and bucket reads when positive
+	readCacheTTL time.Duration
+	log          *pluginLogger // the plugin instance's logger
+	// async queues quota usage when AsyncConsume is set; it is shared with the
+	// managers dynamic limits swap in, so its flusher runs once per identifier
+	async    *asyncConsumer
+	override string // dynamic limits applied over the configured ones ("" for none)
+
+	// Built on first use by ensureInitialized
+	initMu       sync.Mutex
+	initialized  bool
 	rateLimiter  *RateLimiter
 	quotaManager *QuotaManager
+	concurrency  *ConcurrencyTracker
+	distinct     *DistinctCounter
+	webSockets   *WebSocketLimiter
+	webhook      *webhookNotifier
 }
 
 // QuotaResponse contains the result of quota checking
@@ -57,8 +94,69 @@ type QuotaResponse struct {
 	Reason         string         `json:"reason,omitempty"`
 	ResponseCode   int            `json:"response_code,omitempty"`
 	ResponseBody   string         `json:"response_body,omitempty"`
+	RetryAfter     time.Duration  `json:"retry_after,omitempty"`
+	Cost           int64          `json:"cost,omitempty"`
+	FractionalCost float64        `json:"fractional_cost,omitempty"` // Quota units of a fractional quota
+	Consumed       bool           `json:"consumed,omitempty"`
+	WebSocket      bool           `json:"websocket,omitempty"`  // Holds one of the identifier's open WebSocket connections
+	RequestID      string         `json:"request_id,omitempty"` // Correlates a blocked response with its log line
+
+	// quotaIdentifier is the identifier the quota is keyed on when it differs
+	quotaIdentifier string
 }
 
+// QuotaIdentifier returns the identifier the request's quota usage is keyed on
+func (r *QuotaResponse) QuotaIdentifier() string {
+	if r.quotaIdentifier != "" {
+		return r.quotaIdentifier
+	}
+	return r.Identifier
+}
+
+// alwaysEnforced reports whether the decision blocks even where limits are
+// not enforced. Such decisions are made before the identifier's manager is
+// initialized, so they can't be served.
+func (r *QuotaResponse) alwaysEnforced() bool {
+	switch r.Reason {
+	case ReasonDenied, ReasonRequestTooLarge, ReasonInvalidCookieSignature, ReasonIdentifierTooLong:
+		return true
+	}
+	return false
+}
+
+// SoftBlockWarningHeader carries the exceeded limit of soft-blocked requests
+const SoftBlockWarningHeader = "X-Quota-Warning"
+
+// isReadRequest reports whether req uses a read-only method (GET or HEAD)
+func isReadRequest(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// ReasonBackendUnavailable is the block reason used when failing closed on Redis errors
+const ReasonBackendUnavailable = "backend unavailable"
+
+// defaultFailClosedRetryAfter is the back-off advertised when failing closed
+const defaultFailClosedRetryAfter = 5 * time.Second
+
+// IdentifierOverflow modes for identifiers longer than MaxIdentifierLength
+const (
+	IdentifierOverflowReject = "reject"
+	IdentifierOverflowHash   = "hash"
+)
+
+// HeadersOn modes controlling when limit headers are written
+const (
+	HeadersOnAlways  = "always"
+	HeadersOnBlocked = "blocked"
+	HeadersOnNever   = "never"
+)
+
+// Retry-After header formats
+const (
+	RetryAfterFormatSeconds  = "seconds"
+	RetryAfterFormatHTTPDate = "http-date"
+)
+
 // TemplateData holds data available for template evaluation
 type TemplateData struct {
 	Headers map[string]string `json:"headers"`
@@ -71,52 +169,96 @@ type TemplateData struct {
 
 // New creates and returns a new quota plugin instance
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	// Build the instance's logger first so every message below honours its
+	// settings; other live instances keep their own
+	pluginLog, err := newPluginLogger(config.Log)
+	if err != nil {
+		return nil, err
+	}
+
 	// If Redis address is empty, disable the plugin (pass-through mode)
 	if config.Persistence.Redis.Address == "" {
-		log.Printf("Quota plugin '%s' disabled: Redis address not configured", name)
+		pluginLog.errorf("Quota plugin '%s' disabled: Redis address not configured", name)
 		return &passthroughPlugin{next: next}, nil
 	}
 
 	if len(config.Identifiers) == 0 {
-		log.Printf("Quota plugin '%s' disabled: No identifiers configured", name)
+		pluginLog.errorf("Quota plugin '%s' disabled: No identifiers configured", name)
 		return &passthroughPlugin{next: next}, nil
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Initialize Redis client
-	redisClient, err := NewRedisClient(config.Persistence.Redis)
+	redisClient, err := connectWithRetry(ctx, config.Persistence.Redis, newRedisClient, pluginLog)
 	if err != nil {
-		log.Printf("Quota plugin '%s' disabled: Failed to connect to Redis - %v", name, err)
+		pluginLog.errorf("Quota plugin '%s' disabled: Failed to connect to Redis - %v", name, err)
 		return &passthroughPlugin{next: next}, nil
 	}
 
-	// Initialize managers for each identifier
+	startRedisReaper(ctx, redisClient)
+
+	// Time every Redis round trip when metrics are enabled
+	var metrics *Metrics
+	if config.Metrics.Enabled {
+		metrics = NewMetrics()
+		redisClient = NewInstrumentedRedisClient(redisClient, metrics)
+	}
+
+	// Named Redis connections used to shard identifiers across instances are
+	// dialed on first use
+	connections := map[string]*redisConnection{"": newConnectedRedisConnection("primary", config.Persistence.Redis, redisClient)}
+	for connectionName, redisConfig := range config.Persistence.Connections {
+		connections[connectionName] = newRedisConnection(ctx, connectionName, redisConfig, metrics, pluginLog)
+	}
+
+	// Hot reads are optionally cached in process (validated by config.Validate)
+	var readCacheTTL time.Duration
+	if config.ReadCacheTTL != "" {
+		readCacheTTL, _ = time.ParseDuration(config.ReadCacheTTL)
+	}
+
+	// Register managers for each identifier; their Redis-backed components are
+	// built on first use
 	managers := make(map[string]*IdentifierManager)
+	baseIdentifiers := make(map[string]IdentifierConfig)
 	for i, identifierConfig := range config.Identifiers {
-		log.Printf("load identifier %s", identifierConfig.Name)
-		// Validate identifier config
-		if err := identifierConfig.Validate(); err != nil {
-			return nil, fmt.Errorf("identifier %d validation failed: %w", i, err)
-		}
+		pluginLog.infof("load identifier %s", identifierConfig.Name)
+		// Apply defaults; the identifier was validated by config.Validate
+		identifierConfig.Normalize()
 
 		// Create a copy of the config to avoid pointer issues
 		configCopy := identifierConfig
+		configCopy.prepareIPStrategies()
 
-		// Create manager for this identifier
-		manager := &IdentifierManager{
-			config:       &configCopy,
-			quotaManager: NewQuotaManager(redisClient, configCopy.Quota),
+		// Use the identifier's named Redis connection, defaulting to the primary
+		connection, ok := connections[configCopy.RedisConnection]
+		if !ok {
+			return nil, fmt.Errorf("identifier %d references unknown Redis connection: %s", i, configCopy.RedisConnection)
 		}
 
-		// Only create rate limiter if rate limiting is enabled
-		if configCopy.RateLimit.Enabled {
-			manager.rateLimiter = NewRateLimiter(redisClient, configCopy.RateLimit)
+		// Sliding windows need sorted sets, which are only updated by Lua
+		if connection.config.DisableScripting && configCopy.RateLimit.Enabled && configCopy.RateLimit.Algorithm == AlgorithmSlidingWindow {
+			return nil, fmt.Errorf("identifier %d: the sliding window algorithm requires Redis scripting", i)
 		}
 
 		// Use a combination of type, name, and value as key to avoid conflicts
-		key := fmt.Sprintf("%s:%s:%s", configCopy.Type, configCopy.Name, configCopy.Value)
-		managers[key] = manager
+		key := fmt.Sprintf("%s:%s:%s", escapeKeyComponent(configCopy.Type), escapeKeyComponent(configCopy.Name), escapeKeyComponent(configCopy.keyValue()))
+
+		baseIdentifiers[key] = config.Identifiers[i]
+		managers[key] = &IdentifierManager{
+			key:          key,
+			config:       &configCopy,
+			connection:   connection,
+			failClosed:   config.FailureMode == "closed",
+			readCacheTTL: readCacheTTL,
+			log:          pluginLog,
+			async:        newAsyncConsumer(ctx),
+		}
 
-		// Log manager initialization
+		// Log manager registration
 		rateLimitStatus := "disabled"
 		if configCopy.RateLimit.Enabled {
 			rateLimitStatus = fmt.Sprintf("%d/%s", configCopy.RateLimit.Rate, configCopy.RateLimit.Period)
@@ -125,10 +267,13 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		quotaStatus := "disabled"
 		if configCopy.Quota.Enabled {
 			quotaStatus = fmt.Sprintf("%d/%s", configCopy.Quota.Limit, configCopy.Quota.Period)
+			if configCopy.Quota.IsFractional() {
+				quotaStatus = fmt.Sprintf("%g/%s", configCopy.Quota.FractionalLimit, configCopy.Quota.Period)
+			}
 		}
 
-		log.Printf("Initialized manager for identifier %s:%s:%s (rate: %s, quota: %s)",
-			configCopy.Type, configCopy.Name, configCopy.Value, rateLimitStatus, quotaStatus)
+		pluginLog.infof("Registered manager for identifier %s:%s:%s (rate: %s, quota: %s)",
+			configCopy.Type, configCopy.Name, configCopy.keyValue(), rateLimitStatus, quotaStatus)
 	}
 
 	plugin := &quotaPlugin{
@@ -136,48 +281,210 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		next:        next,
 		config:      config,
 		redisClient: redisClient,
+		connections: connections,
 		managers:    managers,
+		sampler:     newSampler(config.EffectiveSampleRate(), time.Now().UnixNano()),
+		metrics:     metrics,
+		log:         pluginLog,
+		now:         time.Now,
+
+		baseIdentifiers: baseIdentifiers,
+	}
+	if config.MaxRetryAfter != "" {
+		// Already validated in Config.Validate
+		plugin.maxRetryAfter, _ = time.ParseDuration(config.MaxRetryAfter)
+	}
+	if config.GlobalRateLimit.Enabled {
+		plugin.globalLimiter = newGlobalRateLimiter(redisClient, config.GlobalRateLimit, config.Persistence.Redis)
+		plugin.globalLimiter.log = pluginLog
+		for _, identifierConfig := range config.Identifiers {
+			if identifierConfig.Priority > plugin.maxPriority {
+				plugin.maxPriority = identifierConfig.Priority
+			}
+		}
+	}
+	plugin.maintenance = newMaintenanceFlag(config.MaintenanceMode, redisClient, pluginLog)
+	if config.EnforceAfter != "" {
+		plugin.enforceAfter, _ = time.Parse(time.RFC3339, config.EnforceAfter)
+		pluginLog.infof("Quota plugin '%s' runs in dry-run mode until %s", name, plugin.enforceAfter.Format(time.RFC3339))
 	}
 
-	log.Printf("Quota plugin '%s' initialized with %d identifiers", name, len(managers))
+	if config.DynamicLimits.Enabled() {
+		// Apply the current overrides before serving, then keep polling
+		if err := plugin.refreshDynamicLimits(ctx); err != nil {
+			pluginLog.errorf("Failed to load dynamic limits: %v", err)
+		}
+		plugin.startDynamicLimits(ctx)
+	}
+
+	pluginLog.infof("Quota plugin '%s' initialized with %d identifiers", name, len(managers))
 	return plugin, nil
 }
 
 // ServeHTTP processes the HTTP request with quota and rate limiting
 func (q *quotaPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// Log request-scoped events with this instance's settings
+	req = withLogger(req, q.log)
+
+	// Serve the metrics endpoint before any identifier checks
+	if q.metrics != nil && req.URL.Path == q.metricsPath() {
+		q.metrics.ServeHTTP(rw, req)
+		return
+	}
+
+	// Serve admin operations before any identifier checks
+	if q.isAdminRequest(req) {
+		q.serveAdmin(rw, req)
+		return
+	}
+
+	// Time the limit checks for the Server-Timing header
+	checkStart := time.Now()
+
+	// Extract each identifier once, however many checks look at it
+	req = withIdentifierCache(req)
+
+	// The global rate limit protects the backend before any identifier is
+	// checked, and before probes so they can't bypass it
+	if q.globalLimiter != nil && !q.allowGlobal(rw, req, checkStart) {
+		return
+	}
+
+	// Probes read their current limits without consuming or being proxied
+	if q.isProbeRequest(req) {
+		q.serveProbe(rw, req)
+		return
+	}
+
+	// Decide once per request whether allowed-request events are logged
+	if q.config.EffectiveSampleRate() < 1 {
+		req = withSampling(req, q.sampler.Sample())
+	}
+
 	// Check all identifiers and find the first match
 	var response *QuotaResponse
 	var matchedManager *IdentifierManager
+	unknownKey := false
+	uninitialized := false // the matched manager's components could not be built
 
-	for key, manager := range q.managers {
-		log.Printf("Checking identifier: %s", key)
-		log.Printf("Manager config - Type: %s, Name: %s, Value: %s",
+	for key, manager := range q.currentManagers() {
+		tracef(req, "Checking identifier: %s", key)
+		tracef(req, "Manager config - Type: %s, Name: %s, Value: %s",
 			manager.config.Type, manager.config.Name, manager.config.Value)
-		identifier := q.extractIdentifier(req, manager.config)
+		raw := cachedRawIdentifier(req, manager.config)
+		identifier := q.limitIdentifierLength(req, raw)
+
+		// Identifiers rejected as too long block the request rather than
+		// letting a later identifier match it
+		if identifier == "" && raw != "" {
+			response = manager.identifierTooLongResponse()
+			response.IdentifierType = key
+			matchedManager = manager
+			tracef(req, "Identifier matched: %s (identifier of %d bytes too long)", key, len(raw))
+			break
+		}
+
+		// Signed cookies failing verification block instead of skipping when configured
+		if identifier == "" && manager.config.hasTamperedCookie(req) {
+			response = manager.tamperedCookieResponse()
+			response.IdentifierType = key
+			matchedManager = manager
+			tracef(req, "Identifier matched: %s (invalid cookie signature)", key)
+			break
+		}
 
 		// Skip empty identifiers
 		if identifier == "" {
-			log.Printf("Identifier %s not found in request, skipping", key)
+			tracef(req, "Identifier %s not found in request, skipping", key)
+			// A key header carrying a value no identifier expects is an unknown key
+			if manager.config.Type == "Header" && req.Header.Get(manager.config.Name) != "" {
+				unknownKey = true
+			}
 			continue
 		}
 
+		// Denylisted values are blocked before any Redis access
+		if manager.config.isDenied(req, identifier) {
+			response = manager.deniedResponse(identifier)
+			response.IdentifierType = key
+			matchedManager = manager
+			tracef(req, "Identifier matched: %s (denylisted)", key)
+			break
+		}
+
+		// Oversized requests are rejected before any Redis access
+		if manager.config.isOversized(req) && manager.config.rejectsOversized() {
+			response = manager.oversizedResponse(identifier)
+			response.IdentifierType = key
+			matchedManager = manager
+			tracef(req, "Identifier matched: %s (request of %d bytes too large)", key, req.ContentLength)
+			break
+		}
+
+		// Build the manager's components on its first matching request. An
+		// unreachable connection is a Redis failure like any other: it blocks
+		// when failing closed and lets the request through otherwise.
+		if err := manager.ensureInitialized(); err != nil {
+			q.log.errorf("Error initializing identifier %s: %v", key, err)
+			if manager.failClosed {
+				response = q.backendUnavailableResponse(manager.config.Type, identifier)
+			} else {
+				response = &QuotaResponse{Allowed: true, Identifier: identifier, Reason: "Request allowed"}
+			}
+			response.IdentifierType = key
+			matchedManager = manager
+			uninitialized = true
+			tracef(req, "Identifier matched: %s (backend unavailable, allowed: %v)", key, response.Allowed)
+			break
+		}
+
+		// Record the value for distinct counting, whatever the decision
+		if manager.distinct != nil {
+			if err := manager.distinct.Add(req.Context(), identifier); err != nil {
+				q.log.errorf("Failed to count distinct identifier %s: %v", key, err)
+			}
+		}
+
 		// Check this identifier
 		resp, err := q.checkIdentifier(req, manager, identifier)
 		if err != nil {
-			log.Printf("Error checking identifier %s: %v", key, err)
+			q.log.errorf("Error checking identifier %s: %v", key, err)
 			continue
 		}
 
 		resp.IdentifierType = key
 		response = resp
 		matchedManager = manager
-		log.Printf("Identifier matched: %s (allowed: %v)", key, response.Allowed)
+		tracef(req, "Identifier matched: %s (allowed: %v)", key, response.Allowed)
 		break // Use first matching identifier
 	}
 
+	q.writeServerTiming(rw, checkStart)
+
+	// Reject a present but unrecognized key explicitly instead of as a missing identifier
+	if response == nil && unknownKey && q.config.RejectUnknownKeys {
+		requestID := q.blockedRequestID(rw, req)
+		q.log.infof("Access denied: Unknown key in request%s", requestIDLogSuffix(requestID))
+
+		statusCode := q.config.UnknownKeyResponseCode
+		if statusCode == 0 {
+			statusCode = http.StatusUnauthorized
+		}
+		responseBody := q.config.UnknownKeyResponseBody
+		if responseBody == "" {
+			responseBody = `{"error":"Invalid key","message":"The provided key is not recognized"}`
+		}
+
+		rw.Header().Set("Content-Type", responseContentType(responseBody, ""))
+		rw.WriteHeader(statusCode)
+		rw.Write([]byte(responseBody))
+		return
+	}
+
 	// If no identifier matched, block the request with 403
 	if response == nil {
-		log.Printf("Access denied: No valid identifier found for request")
+		requestID := q.blockedRequestID(rw, req)
+		q.log.infof("Access denied: No valid identifier found for request%s", requestIDLogSuffix(requestID))
 
 		// Set content type for JSON response
 		rw.Header().Set("Content-Type", "application/json")
@@ -194,8 +501,50 @@ func (q *quotaPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Write quota headers to response
-	q.writeQuotaHeaders(rw, response)
+	// Soft-blocked identifiers exceeding a limit, and reads past a quota that
+	// allows them, are served with a warning header
+	softBlocked := matchedManager.config.SoftBlock && !response.alwaysEnforced() && response.Reason != ReasonBackendUnavailable
+	readPastQuota := matchedManager.config.Quota.AllowReadsPastQuota && response.Reason == "Quota exceeded" && isReadRequest(req)
+	if !response.Allowed && (softBlocked || readPastQuota) {
+		q.log.infof("Soft block: serving over-limit request: %s (identifier: %s, type: %s)",
+			response.Reason, response.Identifier, response.IdentifierType)
+		rw.Header().Set(SoftBlockWarningHeader, response.Reason)
+		response.Allowed = true
+	}
+
+	// During the grace period blocks are logged but the request is let through;
+	// the denylist is a kill switch and, like oversized requests and tampered
+	// signed cookies, always enforced
+	if !response.Allowed && !response.alwaysEnforced() && !q.enforcing(response.Identifier) {
+		q.log.infof("Dry run: would block request: %s (identifier: %s, type: %s)",
+			response.Reason, response.Identifier, response.IdentifierType)
+		response.Allowed = true
+	}
+
+	// Maintenance mode lifts every limit; the denylist, oversized requests and
+	// tampered signed cookies stay blocked
+	if !response.Allowed && !response.alwaysEnforced() && q.maintenance.active(req.Context(), q.now()) {
+		q.log.infof("Maintenance mode: serving request that would be blocked: %s (identifier: %s, type: %s)",
+			response.Reason, response.Identifier, response.IdentifierType)
+		response.Allowed = true
+	}
+
+	if q.metrics != nil {
+		q.metrics.ObserveDecision(matchedManager.config.Type, matchedManager.config.Labels, response.Allowed)
+	}
+
+	// Write quota headers to response unless suppressed for this decision
+	if q.shouldWriteQuotaHeaders(response) {
+		q.writeQuotaHeaders(rw, response)
+	}
+
+	// Add the matched identifier's static response headers
+	for name, value := range matchedManager.config.ResponseHeaders {
+		rw.Header().Set(name, value)
+	}
+
+	// Remove headers this identifier must not reveal, e.g. its limits
+	suppressHeaders(rw.Header(), matchedManager.config.SuppressHeaders)
 
 	// If request is not allowed, return appropriate error
 	if !response.Allowed {
@@ -212,15 +561,20 @@ func (q *quotaPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			responseBody = response.Reason
 		}
 
-		log.Printf("Request blocked: %s (identifier: %s, type: %s)",
-			response.Reason, response.Identifier, response.IdentifierType)
+		response.RequestID = q.blockedRequestID(rw, req)
+		q.log.infof("Request blocked: %s (identifier: %s, type: %s)%s",
+			response.Reason, response.Identifier, response.IdentifierType, requestIDLogSuffix(response.RequestID))
+		matchedManager.webhook.notify(response, q.now())
 
-		// Set content type based on response body format
-		if strings.Contains(responseBody, "{") && strings.Contains(responseBody, "}") {
-			rw.Header().Set("Content-Type", "application/json")
-		} else {
-			rw.Header().Set("Content-Type", "text/plain")
+		// Set content type from configuration or the response body format
+		contentType := responseContentType(responseBody, matchedManager.config.ResponseContentType)
+
+		// Replace the configured body with a machine-readable envelope
+		if q.config.StructuredErrors {
+			responseBody = structuredErrorBody(response, q.maxRetryAfter)
+			contentType = "application/json"
 		}
+		rw.Header().Set("Content-Type", contentType)
 
 		// Write status code and body manually instead of using http.Error
 		rw.WriteHeader(statusCode)
@@ -228,109 +582,235 @@ func (q *quotaPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Request is allowed, consume quota if enabled
-	if matchedManager.quotaManager.IsQuotaEnabled() {
+	// Without its components nothing of the identifier can be counted
+	if uninitialized {
+		q.injectUpstreamHeaders(req, response)
+		q.next.ServeHTTP(rw, req)
+		return
+	}
+
+	// Quota is normally reserved by CheckAndConsume; requests let through without
+	// a reservation (dry run, failing open) are counted here unless consumption
+	// is left to the application
+	consumed := response.Consumed
+	if !consumed && !matchedManager.limitsWebSocket(req) && matchedManager.quotaManager.IsQuotaEnabled() && matchedManager.config.Quota.ConsumeMode != ConsumeModeNone && !matchedManager.config.Quota.CountsBytes() {
 		ctx := req.Context()
-		_, err := matchedManager.quotaManager.ConsumeQuota(ctx, response.Identifier, 1)
+		info, err := matchedManager.consumeQuota(ctx, response)
 		if err != nil {
-			log.Printf("Failed to consume quota: %v", err)
+			q.log.errorf("Failed to consume quota: %v", err)
+		} else if info != nil {
+			response.Quota = info
+			consumed = true
 		}
 	}
 
-	log.Printf("Request allowed for identifier: %s (type: %s)", response.Identifier, response.IdentifierType)
-	q.next.ServeHTTP(rw, req)
-}
+	q.injectUpstreamHeaders(req, response)
 
-// checkIdentifier checks if a request is allowed for a specific identifier
-func (q *quotaPlugin) checkIdentifier(req *http.Request, manager *IdentifierManager, identifier string) (*QuotaResponse, error) {
-	ctx := req.Context()
+	tracef(req, "Request allowed for identifier: %s (type: %s)", response.Identifier, response.IdentifierType)
 
-	var rateLimitAllowed = true
-	var rateLimitInfo RateLimitInfo
+	// An upgraded connection stays open until the upstream handler returns
+	if response.WebSocket {
+		defer matchedManager.webSockets.Close(context.Background(), response.Identifier)
+	}
 
-	// Check rate limiting only if enabled and rateLimiter exists
-	if manager.config.RateLimit.Enabled && manager.rateLimiter != nil {
-		var err error
-		rateLimitAllowed, err = manager.rateLimiter.Allow(ctx, identifier)
+	// Count the request as in flight until the upstream handler returns or panics
+	if matchedManager.concurrency != nil {
+		leave, err := matchedManager.concurrency.Enter(req.Context(), response.Identifier)
 		if err != nil {
-			log.Printf("Rate limiter error: %v", err)
-			// In case of error, allow the request (fail open)
-			rateLimitAllowed = true
+			q.log.errorf("Failed to track concurrency: %v", err)
+		} else {
+			defer leave()
 		}
+	}
 
-		// Get rate limit info
-		rateLimitInfo, err = manager.rateLimiter.GetLimitInfo(ctx, identifier)
-		if err != nil {
-			log.Printf("Failed to get rate limit info: %v", err)
-			rateLimitInfo = RateLimitInfo{}
+	// Capture the upstream status when consumed quota may have to be refunded
+	quotaSettings := matchedManager.config.Quota
+	if consumed && (len(quotaSettings.AuthFailureStatuses) > 0 || quotaSettings.RefundOnCancel) {
+		recorder := newResponseRecorder(rw)
+		q.next.ServeHTTP(recorder, req)
+
+		refund := false
+		if quotaSettings.IsAuthFailureStatus(recorder.status) {
+			tracef(req, "Upstream rejected identifier %s with %d, refunding quota", response.Identifier, recorder.status)
+			refund = true
+		} else if quotaSettings.RefundOnCancel && req.Context().Err() == context.Canceled {
+			tracef(req, "Client cancelled request for identifier %s, refunding quota", response.Identifier)
+			refund = true
 		}
 
-		// If rate limited, return immediately
-		if !rateLimitAllowed {
-			return &QuotaResponse{
-				Allowed:        false,
-				RateLimit:      &rateLimitInfo,
-				Identifier:     identifier,
-				IdentifierType: manager.config.Type,
-				Reason:         "Rate limit exceeded",
-				ResponseCode:   manager.config.RateLimit.ResponseReachedLimitCode,
-				ResponseBody:   manager.config.RateLimit.ResponseReachedLimitBody,
-			}, nil
+		// The request context may already be cancelled, so refund on a fresh one
+		if refund {
+			if err := matchedManager.refundQuota(context.Background(), response); err != nil {
+				q.log.errorf("Failed to refund quota: %v", err)
+			}
 		}
+		return
 	}
 
-	// Check quota if enabled
-	var quotaInfo *QuotaInfo
-	quotaAllowed := true
+	// Bytes quotas count the response as it is written
+	if quotaSettings.CountsBytes() && !matchedManager.limitsWebSocket(req) && matchedManager.quotaManager.IsQuotaEnabled() {
+		matchedManager.serveCountingBytes(q.next, rw, req, response)
+		return
+	}
 
-	if manager.quotaManager.IsQuotaEnabled() {
-		var err error
-		quotaAllowed, quotaInfo, err = manager.quotaManager.CheckQuota(ctx, identifier)
-		if err != nil {
-			log.Printf("Quota manager error: %v", err)
-			// In case of error, allow the request (fail open)
-			quotaAllowed = true
-		}
+	q.next.ServeHTTP(rw, req)
+}
+
+// startRedisReaper evicts idle pooled connections, and pings them when
+// configured, for the lifetime of ctx
+func startRedisReaper(ctx context.Context, client RedisClient) {
+	if simpleClient, ok := client.(*SimpleRedisClient); ok {
+		simpleClient.StartReaper(ctx)
+		simpleClient.StartKeepAlive(ctx)
 	}
+}
 
-	// If quota exceeded, return
-	if !quotaAllowed {
-		response := &QuotaResponse{
-			Allowed:        false,
-			Quota:          quotaInfo,
-			Identifier:     identifier,
-			IdentifierType: manager.config.Type,
-			Reason:         "Quota exceeded",
-			ResponseCode:   manager.config.Quota.ResponseReachedLimitCode,
-			ResponseBody:   manager.config.Quota.ResponseReachedLimitBody,
-		}
+// shouldWriteQuotaHeaders reports whether limit headers are written for the decision
+func (q *quotaPlugin) shouldWriteQuotaHeaders(response *QuotaResponse) bool {
+	switch q.config.HeadersOn {
+	case HeadersOnBlocked:
+		return !response.Allowed
+	case HeadersOnNever:
+		return false
+	default:
+		return true
+	}
+}
 
-		// Only include rate limit info if rate limiting is enabled and rateLimiter exists
-		if manager.config.RateLimit.Enabled && manager.rateLimiter != nil {
-			response.RateLimit = &rateLimitInfo
+// suppressHeaders deletes the named headers; a trailing * (e.g. X-RateLimit-*)
+// matches every header with that prefix
+func suppressHeaders(header http.Header, names []string) {
+	for _, name := range names {
+		prefix, wildcard := strings.CutSuffix(name, "*")
+		if !wildcard {
+			header.Del(name)
+			continue
 		}
+		prefix = strings.ToLower(prefix)
+		for key := range header {
+			if strings.HasPrefix(strings.ToLower(key), prefix) {
+				delete(header, key)
+			}
+		}
+	}
+}
 
-		return response, nil
+// enforcing reports whether blocks are enforced for identifier, i.e. EnforceAfter
+// is unset or has passed and the identifier falls within EnforcePercent
+func (q *quotaPlugin) enforcing(identifier string) bool {
+	if !q.enforceAfter.IsZero() && q.now().Before(q.enforceAfter) {
+		return false
 	}
+	return enforceBucket(identifier) < q.config.EffectiveEnforcePercent()
+}
 
-	response := &QuotaResponse{
-		Allowed:        true,
-		Quota:          quotaInfo,
-		Identifier:     identifier,
-		IdentifierType: manager.config.Type,
-		Reason:         "Request allowed",
+// enforceBucket deterministically assigns identifier to a bucket in [0, 100)
+// so an identifier stays in the same rollout group across requests and instances
+func enforceBucket(identifier string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(identifier))
+	return int(hash.Sum32() % 100)
+}
+
+// formatRetryAfter formats a Retry-After delay as delta-seconds or, with the
+// http-date format, as the RFC 7231 date the delay ends at relative to now
+func formatRetryAfter(format string, delay time.Duration, now time.Time) string {
+	seconds := int64(math.Ceil(delay.Seconds()))
+	if format == RetryAfterFormatHTTPDate {
+		return now.Add(time.Duration(seconds) * time.Second).UTC().Format(http.TimeFormat)
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// writeServerTiming reports how long the limit checks since start took in a
+// Server-Timing header when enabled
+func (q *quotaPlugin) writeServerTiming(rw http.ResponseWriter, start time.Time) {
+	if !q.config.ServerTiming {
+		return
+	}
+	duration := float64(time.Since(start).Microseconds()) / 1000
+	rw.Header().Add("Server-Timing", fmt.Sprintf(`quota;dur=%.3f;desc="Quota check"`, duration))
+}
+
+// metricsPath returns the configured metrics endpoint path
+func (q *quotaPlugin) metricsPath() string {
+	if q.config.Metrics.Path == "" {
+		return defaultMetricsPath
+	}
+	return q.config.Metrics.Path
+}
+
+// checkIdentifier checks if a request is allowed for a specific identifier,
+// consuming its cost when it is
+func (q *quotaPlugin) checkIdentifier(req *http.Request, manager *IdentifierManager, identifier string) (*QuotaResponse, error) {
+	// Upgrades may be limited by open connections instead of rate and quota
+	if manager.limitsWebSocket(req) {
+		response, err := manager.CheckWebSocket(req.Context(), identifier)
+		if err != nil {
+			// Only returned when failing closed
+			q.log.errorf("%v", err)
+			response = q.backendUnavailableResponse(manager.config.Type, identifier)
+		}
+		return response, nil
 	}
 
-	// Only include rate limit info if rate limiting is enabled and rateLimiter exists
-	if manager.config.RateLimit.Enabled && manager.rateLimiter != nil {
-		response.RateLimit = &rateLimitInfo
+	cost := manager.config.oversizeCost(req, requestCost(req, manager.config))
+
+	response, err := manager.CheckAndConsume(req, identifier, cost)
+	if err != nil {
+		// Only returned when failing closed
+		q.log.errorf("%v", err)
+		response = q.backendUnavailableResponse(manager.config.Type, identifier)
+		response.Cost = cost
 	}
 
 	return response, nil
 }
 
+// responseContentType returns the configured content type, or detects JSON bodies
+func responseContentType(body, configured string) string {
+	if configured != "" {
+		return configured
+	}
+
+	trimmed := strings.TrimSpace(body)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "application/json"
+	}
+	return "text/plain"
+}
+
+// failClosed reports whether Redis errors should block requests
+func (q *quotaPlugin) failClosed() bool {
+	return q.config.FailureMode == "closed"
+}
+
+// backendUnavailableResponse builds the response for a request blocked because
+// the limits could not be evaluated
+func (q *quotaPlugin) backendUnavailableResponse(identifierType, identifier string) *QuotaResponse {
+	statusCode := q.config.FailClosedResponseCode
+	if statusCode == 0 {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	retryAfter := defaultFailClosedRetryAfter
+	if q.config.FailClosedRetryAfter != "" {
+		// Already validated in New
+		retryAfter, _ = time.ParseDuration(q.config.FailClosedRetryAfter)
+	}
+
+	return &QuotaResponse{
+		Allowed:        false,
+		Identifier:     identifier,
+		IdentifierType: identifierType,
+		Reason:         ReasonBackendUnavailable,
+		ResponseCode:   statusCode,
+		RetryAfter:     retryAfter,
+	}
+}
+
 // buildTemplateData creates template data from HTTP request
-func (q *quotaPlugin) buildTemplateData(req *http.Request) *TemplateData {
+func buildTemplateData(req *http.Request) *TemplateData {
 	// Build headers map
 	headers := make(map[string]string)
 	for key, values := range req.Header {
@@ -377,11 +857,10 @@ func (q *quotaPlugin) buildTemplateData(req *http.Request) *TemplateData {
 }
 
 // executeTemplate executes a Go text template with the provided data
-func (q *quotaPlugin) executeTemplate(templateStr string, data *TemplateData) (string, error) {
-	// Create a new template with custom delimiters
-	tmpl, err := template.New("identifier").Delims("[[", "]]").Parse(templateStr)
+func executeTemplate(templateStr string, data *TemplateData) (string, error) {
+	tmpl, err := compileTemplate(templateStr)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		return "", err
 	}
 
 	// Execute template
@@ -394,73 +873,87 @@ func (q *quotaPlugin) executeTemplate(templateStr string, data *TemplateData) (s
 	return strings.TrimSpace(buf.String()), nil
 }
 
-// extractIdentifier extracts the identifier from the request based on configuration
+// extractIdentifier extracts the identifier from the request using the extractor
+// registered for its type, or the first of its Sources yielding a value; aliases
+// resolve to the canonical Value
 func (q *quotaPlugin) extractIdentifier(req *http.Request, config *IdentifierConfig) string {
-	switch config.Type {
-	case "Header":
-		log.Printf("Extracting identifier from header: %s (expected value: %s)", config.Name, config.Value)
-		value := req.Header.Get(config.Name)
-		log.Printf("Header value from request: '%s'", value)
-
-		if value != "" {
-			// If header exists, check if it matches this identifier's expected value
-			log.Printf("Comparing header value '%s' with config value '%s': %v", value, config.Value, value == config.Value)
-			if value == config.Value {
-				log.Printf("Header matches! Returning: %s", value)
-				return value
-			}
-			// If header exists but doesn't match, return empty (no match)
-			log.Printf("Header doesn't match config value, returning empty")
-			return ""
-		}
-
-		// For specific identifiers, return empty when header is missing
-		log.Printf("No header found and not a fallback identifier, returning empty")
-		return ""
-	case "IP":
-		// Extract IP from request
-		ip := req.RemoteAddr
-		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
-			// Take first IP in case of multiple
-			ips := strings.Split(forwarded, ",")
-			ip = strings.TrimSpace(ips[0])
-		}
-		if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
-			ip = realIP
-		}
-		// Remove port if present
-		if idx := strings.LastIndex(ip, ":"); idx != -1 {
-			ip = ip[:idx]
-		}
-		return ip
-	case "Query":
-		value := req.URL.Query().Get(config.Name)
-		if value != "" {
-			return value
-		}
-		return config.Value
-	case "Cookie":
-		cookie, err := req.Cookie(config.Name)
-		if err == nil {
-			return cookie.Value
-		}
-		return config.Value
-	case "Template":
-		// Build template data from request
-		templateData := q.buildTemplateData(req)
+	return q.limitIdentifierLength(req, cachedRawIdentifier(req, config))
+}
 
-		// Execute template with the data
-		result, err := q.executeTemplate(config.Value, templateData)
-		if err != nil {
-			log.Printf("Template execution failed: %v", err)
-			return ""
-		}
+// identifierCacheContextKey carries the raw identifiers extracted for a request
+type identifierCacheContextKey struct{}
 
-		log.Printf("Template result: '%s' from template: '%s'", result, config.Value)
-		return result
-	default:
+// withIdentifierCache gives the request a cache of its raw identifiers, so an
+// identifier looked at by both the global limit and the identifier checks is
+// extracted, e.g. its body read, only once
+func withIdentifierCache(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), identifierCacheContextKey{}, map[*IdentifierConfig]string{}))
+}
+
+// cachedRawIdentifier returns the raw identifier of config, served from the
+// request's identifier cache when it has one
+func cachedRawIdentifier(req *http.Request, config *IdentifierConfig) string {
+	cache, ok := req.Context().Value(identifierCacheContextKey{}).(map[*IdentifierConfig]string)
+	if !ok {
+		return rawIdentifier(req, config)
+	}
+	if identifier, ok := cache[config]; ok {
+		return identifier
+	}
+	identifier := rawIdentifier(req, config)
+	cache[config] = identifier
+	return identifier
+}
+
+// rawIdentifier extracts the identifier of config from the request before
+// MaxIdentifierLength is applied
+func rawIdentifier(req *http.Request, config *IdentifierConfig) string {
+	if config.Type == "Sources" {
+		return config.canonicalValue(extractSourcesIdentifier(req, config))
+	}
+
+	extractor, ok := lookupIdentifierExtractor(config.Type)
+	if !ok {
 		return config.Value
 	}
+	return config.canonicalValue(extractor(req, config))
+}
+
+// ReasonIdentifierTooLong is the block reason of identifiers longer than
+// MaxIdentifierLength with the reject overflow mode
+const ReasonIdentifierTooLong = "Identifier too long"
+
+// identifierTooLongResponse returns the rejection of an identifier value longer
+// than MaxIdentifierLength. The value itself is left out of the response.
+func (m *IdentifierManager) identifierTooLongResponse() *QuotaResponse {
+	return &QuotaResponse{
+		Allowed:        false,
+		IdentifierType: m.config.Type,
+		Reason:         ReasonIdentifierTooLong,
+		ResponseCode:   http.StatusForbidden,
+	}
+}
+
+// limitIdentifierLength enforces MaxIdentifierLength before the identifier is
+// used in Redis keys: oversized values are rejected (returned empty) or
+// replaced by their SHA-256 digest
+func (q *quotaPlugin) limitIdentifierLength(req *http.Request, identifier string) string {
+	maxLength := q.config.MaxIdentifierLength
+	if maxLength <= 0 || len(identifier) <= maxLength {
+		return identifier
+	}
+
+	if q.config.IdentifierOverflow == IdentifierOverflowHash {
+		hashed := hashIdentifier(identifier)
+		if len(hashed) > maxLength {
+			hashed = hashed[:maxLength]
+		}
+		tracef(req, "Identifier of %d bytes exceeds %d, using its hash", len(identifier), maxLength)
+		return hashed
+	}
+
+	q.log.infof("Identifier rejected: %d bytes exceeds the maximum of %d", len(identifier), maxLength)
+	return ""
 }
 
 // writeQuotaHeaders writes quota headers to HTTP response
@@ -469,17 +962,41 @@ func (q *quotaPlugin) writeQuotaHeaders(w http.ResponseWriter, response *QuotaRe
 	if response.RateLimit != nil {
 		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(response.RateLimit.Limit))
 		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(response.RateLimit.Available))
+		w.Header().Set("X-RateLimit-Used", strconv.Itoa(response.RateLimit.Used))
 		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(response.RateLimit.ResetTime.Unix(), 10))
 		if response.RateLimit.RetryAfter > 0 {
-			w.Header().Set("Retry-After", strconv.FormatInt(int64(response.RateLimit.RetryAfter.Seconds()), 10))
+			delay := capRetryAfter(response.RateLimit.RetryAfter.Truncate(time.Second), q.maxRetryAfter)
+			w.Header().Set("Retry-After", formatRetryAfter(q.config.RetryAfterFormat, delay, time.Now()))
 		}
 	}
 
+	// Back-off advertised when the limits could not be evaluated
+	if response.RetryAfter > 0 {
+		w.Header().Set("Retry-After", formatRetryAfter(q.config.RetryAfterFormat, capRetryAfter(response.RetryAfter, q.maxRetryAfter), time.Now()))
+	}
+
 	// Add quota headers
 	if response.Quota != nil {
-		w.Header().Set("X-Quota-Limit", strconv.FormatInt(response.Quota.Limit, 10))
-		w.Header().Set("X-Quota-Used", strconv.FormatInt(response.Quota.Used, 10))
-		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(response.Quota.Remaining, 10))
-		w.Header().Set("X-Quota-Reset", strconv.FormatInt(response.Quota.ResetTime.Unix(), 10))
+		limit, used, remaining := response.Quota.headerValues()
+		if limit != "" {
+			w.Header().Set("X-Quota-Limit", limit)
+			w.Header().Set("X-Quota-Remaining", remaining)
+		}
+		w.Header().Set("X-Quota-Used", used)
+		if response.Quota.ResetTime.IsZero() {
+			// Lifetime quotas never reset
+			w.Header().Set("X-Quota-Reset", "never")
+			if q.config.QuotaResetDateHeader {
+				w.Header().Set("X-Quota-Reset-Date", "never")
+			}
+		} else {
+			w.Header().Set("X-Quota-Reset", strconv.FormatInt(response.Quota.ResetTime.Unix(), 10))
+			if q.config.QuotaResetDateHeader {
+				w.Header().Set("X-Quota-Reset-Date", response.Quota.ResetTime.Format(time.RFC3339))
+			}
+		}
+		if response.Quota.Overage {
+			w.Header().Set("X-Quota-Overage", "true")
+		}
 	}
 }
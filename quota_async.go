@@ -0,0 +1,204 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// asyncConsumeFlushInterval is how often queued quota increments are written to Redis
+const asyncConsumeFlushInterval = 100 * time.Millisecond
+
+// asyncUsage identifies a queued increment: the identifier and the quota key
+// of the period it was consumed in
+type asyncUsage struct {
+	identifier string
+	key        string
+}
+
+// asyncConsumer queues quota increments off the request path and writes them
+// to Redis in batches, coalescing the increments of each identifier. Queued
+// increments are lost if the process crashes before a flush. Managers swapped
+// in by dynamic limits share the consumer of the manager they replace, so one
+// flusher runs per identifier for the plugin's lifetime.
+type asyncConsumer struct {
+	ctx   context.Context
+	start sync.Once
+
+	mu       sync.Mutex
+	qm       *QuotaManager        // Manager whose settings the increments are written with
+	pending  map[asyncUsage]int64 // Queued increments not yet taken by a flush
+	inflight map[asyncUsage]int64 // Increments of the running flush not yet written
+	flushes  uint64               // Completed flushes, to spot one landing during a read
+}
+
+// newAsyncConsumer creates a consumer whose flusher, started by attach, runs
+// every asyncConsumeFlushInterval and once more when ctx is done
+func newAsyncConsumer(ctx context.Context) *asyncConsumer {
+	return &asyncConsumer{
+		ctx:     ctx,
+		pending: make(map[asyncUsage]int64),
+	}
+}
+
+// attach writes the queued increments with the settings of qm from now on and
+// starts the flusher on first use
+func (c *asyncConsumer) attach(qm *QuotaManager) *asyncConsumer {
+	c.mu.Lock()
+	c.qm = qm
+	c.mu.Unlock()
+
+	c.start.Do(func() { go c.run() })
+	return c
+}
+
+// run flushes every asyncConsumeFlushInterval until ctx is done
+func (c *asyncConsumer) run() {
+	ticker := time.NewTicker(asyncConsumeFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			// The plugin's context is gone, so the last flush uses a fresh one
+			c.flush(context.Background())
+			return
+		case <-ticker.C:
+			c.flush(c.ctx)
+		}
+	}
+}
+
+// add queues amount units (negative for refunds) of usage
+func (c *asyncConsumer) add(usage asyncUsage, amount int64) {
+	c.mu.Lock()
+	c.pending[usage] += amount
+	c.mu.Unlock()
+}
+
+// pendingFor returns the units of usage queued or being written
+func (c *asyncConsumer) pendingFor(usage asyncUsage) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[usage] + c.inflight[usage]
+}
+
+// completedFlushes returns how many flushes have finished writing
+func (c *asyncConsumer) completedFlushes() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushes
+}
+
+// tryAdd queues amount units of usage unless exceeds reports that the stored
+// usage plus every queued and in-flight unit leaves no room for them, and
+// returns the usage the decision was made on. The stored usage must have been
+// read after flushes flushes completed; if another one completed since, the
+// read may or may not include its writes, so nothing is queued and fresh is
+// false.
+func (c *asyncConsumer) tryAdd(usage asyncUsage, amount, stored int64, flushes uint64, exceeds func(used int64) bool) (used int64, added, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.flushes != flushes {
+		return 0, false, false
+	}
+
+	used = clampCount(stored + c.pending[usage] + c.inflight[usage])
+	if exceeds(used) {
+		return used, false, true
+	}
+	c.pending[usage] += amount
+	return used, true, true
+}
+
+// flush writes the queued increments to Redis. They count as in flight until
+// written, and failed writes are queued again for the next flush.
+func (c *asyncConsumer) flush(ctx context.Context) {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	batch := c.pending
+	c.pending = make(map[asyncUsage]int64)
+	c.inflight = batch
+	qm := c.qm
+	c.mu.Unlock()
+
+	failed := make(map[asyncUsage]int64)
+	for usage, amount := range batch {
+		var err error
+		switch {
+		case amount > 0:
+			_, err = qm.incrementUsage(ctx, usage.identifier, usage.key, amount)
+		case amount < 0:
+			err = qm.refundUsage(ctx, usage.key, -amount)
+		}
+		if err != nil {
+			qm.log.errorf("Failed to apply queued quota usage of %s, retrying with the next flush: %v", usage.identifier, err)
+			failed[usage] = amount
+		}
+	}
+
+	c.mu.Lock()
+	for usage, amount := range failed {
+		c.pending[usage] += amount
+	}
+	c.inflight = nil
+	c.flushes++
+	c.mu.Unlock()
+}
+
+// queuedUsage returns the queued usage of identifier in the current period
+func (qm *QuotaManager) queuedUsage(identifier string) asyncUsage {
+	return asyncUsage{identifier: identifier, key: GetQuotaKey(identifier, qm.periodKey())}
+}
+
+// reserveAsync checks amount against the stored usage plus the queued
+// increments and, when it fits, queues it instead of writing it to Redis. The
+// check and the queueing are atomic, so concurrent requests never overshoot.
+func (qm *QuotaManager) reserveAsync(ctx context.Context, identifier string, amount int64) (bool, *QuotaInfo, error) {
+	usage := qm.queuedUsage(identifier)
+	exceeds := func(used int64) bool {
+		return qm.config.IsEnforced() && qm.exceedsLimit(identifier, used, amount)
+	}
+
+	for {
+		flushes := qm.async.completedFlushes()
+		info, err := qm.GetQuotaInfo(ctx, identifier)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to get quota info: %w", err)
+		}
+
+		used, added, fresh := qm.async.tryAdd(usage, amount, info.Used, flushes, exceeds)
+		if !fresh {
+			// A flush landed during the read; read again
+			continue
+		}
+		if !added {
+			return false, qm.withInfoReset(info, qm.quotaInfo(identifier, used)), nil
+		}
+		return true, qm.withInfoReset(info, qm.quotaInfo(identifier, used+amount)), nil
+	}
+}
+
+// consumeAsync queues amount units and returns the usage including every queued increment
+func (qm *QuotaManager) consumeAsync(ctx context.Context, identifier string, amount int64) (*QuotaInfo, error) {
+	usage := qm.queuedUsage(identifier)
+	qm.async.add(usage, amount)
+
+	info, err := qm.GetQuotaInfo(ctx, identifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get updated quota info: %w", err)
+	}
+	return qm.withInfoReset(info, qm.quotaInfo(identifier, clampCount(info.Used+qm.async.pendingFor(usage)))), nil
+}
+
+// withInfoReset copies the reset of stored onto computed
+func (qm *QuotaManager) withInfoReset(stored, computed *QuotaInfo) *QuotaInfo {
+	computed.ResetTime = stored.ResetTime
+	computed.ResetIn = stored.ResetIn
+	return computed
+}
@@ -0,0 +1,229 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// asyncQuotaManager returns a Daily quota manager of limit with AsyncConsume
+// on a test server, flushing until ctx is done
+func asyncQuotaManager(t *testing.T, ctx context.Context, server *testRedisServer, limit int64) *QuotaManager {
+	qm := NewQuotaManager(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), QuotaSettings{Enabled: true, Limit: limit, Period: "Daily", AsyncConsume: true})
+	qm.async = newAsyncConsumer(ctx).attach(qm)
+	return qm
+}
+
+// incrBys counts the INCRBY commands server received
+func incrBys(server *testRedisServer) int {
+	n := 0
+	for _, command := range server.commands() {
+		if strings.HasPrefix(command, "INCRBY ") {
+			n++
+		}
+	}
+	return n
+}
+
+// storedUsage returns the usage of identifier written to server
+func storedUsage(t *testing.T, server *testRedisServer, qm *QuotaManager, identifier string) string {
+	value, _ := server.mem.Get(context.Background(), GetQuotaKey(identifier, qm.periodKey()))
+	return value
+}
+
+func TestAsyncConsumeQueuesReservations(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	qm := NewQuotaManager(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), QuotaSettings{Enabled: true, Limit: 3, Period: "Daily", AsyncConsume: true})
+	// Without the background flusher, so only the explicit flush writes
+	qm.async = &asyncConsumer{qm: qm, pending: make(map[asyncUsage]int64)}
+
+	// Queued reservations count against the limit before they are written
+	for i, want := range []bool{true, true, true, false} {
+		allowed, info, err := qm.ReserveQuota(ctx, "u1", 1)
+		if err != nil || allowed != want {
+			t.Fatalf("reservation %d: allowed %v, %v; want %v", i+1, allowed, err, want)
+		}
+		if want && info.Used != int64(i+1) {
+			t.Fatalf("reservation %d: used %d, want the queued usage", i+1, info.Used)
+		}
+	}
+	if n := incrBys(server); n != 0 {
+		t.Fatalf("%d INCRBYs on the request path, want none", n)
+	}
+
+	// The flush coalesces the increments into one write
+	qm.async.flush(ctx)
+	if used := storedUsage(t, server, qm, "u1"); used != "3" {
+		t.Fatalf("stored usage %q after the flush, want 3", used)
+	}
+	if n := incrBys(server); n != 1 {
+		t.Fatalf("%d INCRBYs, want the increments coalesced into one", n)
+	}
+}
+
+func TestAsyncConsumeAppliesIncrements(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := newTestRedisServer(t)
+	qm := asyncQuotaManager(t, ctx, server, 10)
+
+	qm.ReserveQuota(ctx, "u1", 2)
+	qm.ConsumeQuota(ctx, "u1", 3)
+	qm.RefundQuota(ctx, "u1", 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for storedUsage(t, server, qm, "u1") != "4" {
+		if time.Now().After(deadline) {
+			t.Fatalf("stored usage %q, want 4 eventually", storedUsage(t, server, qm, "u1"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAsyncConsumeFlushesOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := newTestRedisServer(t)
+	qm := asyncQuotaManager(t, ctx, server, 10)
+
+	qm.ReserveQuota(ctx, "u1", 5)
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for storedUsage(t, server, qm, "u1") != "5" {
+		if time.Now().After(deadline) {
+			t.Fatal("queued usage not flushed on shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAsyncConsumeNeverOvershootsConcurrently(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	qm := NewQuotaManager(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), QuotaSettings{Enabled: true, Limit: 10, Period: "Daily", AsyncConsume: true})
+	qm.async = &asyncConsumer{qm: qm, pending: make(map[asyncUsage]int64)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := qm.ReserveQuota(ctx, "u1", 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 10 {
+		t.Fatalf("%d concurrent reservations allowed, want the limit of 10", allowed)
+	}
+}
+
+func TestAsyncConsumeCountsUnwrittenFlushes(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	qm := NewQuotaManager(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), QuotaSettings{Enabled: true, Limit: 3, Period: "Daily", AsyncConsume: true})
+	qm.async = &asyncConsumer{qm: qm, pending: make(map[asyncUsage]int64)}
+	for i := 0; i < 3; i++ {
+		qm.ReserveQuota(ctx, "u1", 1)
+	}
+
+	// While the flush writes, its increments still count
+	writing, release := make(chan struct{}), make(chan struct{})
+	server.setHook(func(args []string) string {
+		if strings.EqualFold(args[0], "INCRBY") {
+			close(writing)
+			<-release
+		}
+		return ""
+	})
+	done := make(chan struct{})
+	go func() {
+		qm.async.flush(ctx)
+		close(done)
+	}()
+	<-writing
+	if allowed, _, _ := qm.ReserveQuota(ctx, "u1", 1); allowed {
+		t.Fatal("reservation allowed while the queued usage was being written")
+	}
+	close(release)
+	<-done
+
+	// A failed write is queued again instead of dropped
+	qm.ReserveQuota(ctx, "u2", 2)
+	server.setHook(func(args []string) string {
+		if strings.EqualFold(args[0], "INCRBY") {
+			return "-ERR unavailable\r\n"
+		}
+		return ""
+	})
+	qm.async.flush(ctx)
+	if pending := qm.async.pendingFor(qm.queuedUsage("u2")); pending != 2 {
+		t.Fatalf("%d units queued after a failed flush, want the 2 kept", pending)
+	}
+	server.setHook(nil)
+	qm.async.flush(ctx)
+	if used := storedUsage(t, server, qm, "u2"); used != "2" {
+		t.Fatalf("stored usage %q after the retried flush, want 2", used)
+	}
+}
+
+func TestAsyncConsumerSharedAcrossDynamicLimits(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.DynamicLimits = DynamicLimitsConfig{Key: "limits:config", Interval: "1h"}
+		c.Identifiers[0].Quota.AsyncConsume = true
+	})
+	plugin := handler.(*quotaPlugin)
+	key := managerKey(t, handler)
+	serveAs(handler, "u1")
+	before := plugin.currentManagers()[key]
+
+	server.mem.HSet(ctx, "limits:config", map[string]string{key: `{"quota":{"limit":50}}`})
+	if err := plugin.refreshDynamicLimits(ctx); err != nil {
+		t.Fatal(err)
+	}
+	serveAs(handler, "u1")
+	after := plugin.currentManagers()[key]
+
+	if after == before || after.quotaManager.async != before.quotaManager.async {
+		t.Fatal("the swapped in manager started its own async consumer")
+	}
+	if qm := after.async.qm; qm != after.quotaManager {
+		t.Fatal("queued usage is not written with the current limits")
+	}
+}
+
+func TestValidateAsyncConsume(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*QuotaSettings)
+		valid  bool
+	}{
+		{"requests", func(q *QuotaSettings) {}, true},
+		{"fractional", func(q *QuotaSettings) { q.FractionalLimit = 1.5 }, false},
+		{"bytes", func(q *QuotaSettings) { q.Unit = QuotaUnitBytes }, false},
+	}
+	for _, tc := range tests {
+		config := validConfig()
+		config.Identifiers[0].Quota.AsyncConsume = true
+		tc.mutate(&config.Identifiers[0].Quota)
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
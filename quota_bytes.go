@@ -0,0 +1,32 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+)
+
+// serveCountingBytes calls the upstream handler and consumes the response
+// bytes it writes from the identifier's quota. Streamed responses are counted
+// as they are written and consumed with one write once the response ends.
+func (m *IdentifierManager) serveCountingBytes(next http.Handler, rw http.ResponseWriter, req *http.Request, response *QuotaResponse) {
+	recorder := newResponseRecorder(rw)
+
+	// Count what was written even when the upstream handler panics
+	defer func() {
+		m.consumeBytes(response.QuotaIdentifier(), recorder.written)
+	}()
+
+	next.ServeHTTP(recorder, req)
+}
+
+// consumeBytes consumes the bytes written in a response from identifier's quota
+func (m *IdentifierManager) consumeBytes(identifier string, written int64) {
+	if written <= 0 {
+		return
+	}
+
+	// The request context may already be cancelled, so consume on a fresh one
+	if _, err := m.quotaManager.ConsumeQuota(context.Background(), identifier, written); err != nil {
+		m.log.errorf("Failed to consume quota bytes: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// bytesQuota configures a bytes quota of limit for u1
+func bytesQuota(limit int64) func(*Config) {
+	return func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota = QuotaSettings{Enabled: true, Limit: limit, Period: "Daily", Unit: QuotaUnitBytes}
+	}
+}
+
+// quotaUsed returns u1's quota usage of the plugin's only manager
+func quotaUsed(t *testing.T, handler http.Handler) int64 {
+	t.Helper()
+	for _, manager := range handler.(*quotaPlugin).currentManagers() {
+		info, err := manager.quotaManager.GetQuotaInfo(context.Background(), "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Used
+	}
+	t.Fatal("no managers")
+	return 0
+}
+
+func TestServeHTTPBytesQuota(t *testing.T) {
+	server := newTestRedisServer(t)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(strings.Repeat("x", 1000)))
+	})
+	handler := newTestPluginNext(t, server, next, bytesQuota(1500))
+
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK || rw.Body.Len() != 1000 {
+		t.Fatalf("first response: status %d, %d bytes", rw.Code, rw.Body.Len())
+	}
+	if used := quotaUsed(t, handler); used != 1000 {
+		t.Fatalf("used %d after a 1000 byte response, want 1000", used)
+	}
+
+	// Some quota is left, so the request is let through and may overrun it
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("second response: status %d, want 200", rw.Code)
+	}
+	if used := quotaUsed(t, handler); used != 2000 {
+		t.Fatalf("used %d, want 2000", used)
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusForbidden {
+		t.Fatalf("exhausted quota: status %d, want 403", rw.Code)
+	}
+}
+
+func TestServeHTTPBytesQuotaCountsStreams(t *testing.T) {
+	server := newTestRedisServer(t)
+	var handler http.Handler
+	var usedMidStream int64
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(strings.Repeat("x", 100)))
+		rw.(http.Flusher).Flush()
+		usedMidStream = quotaUsed(t, handler)
+		rw.Write([]byte(strings.Repeat("x", 50)))
+	})
+	handler = newTestPluginNext(t, server, next, bytesQuota(1000))
+
+	serveAs(handler, "u1")
+	if usedMidStream != 0 {
+		t.Fatalf("used %d after the first flush, want nothing written before the response ends", usedMidStream)
+	}
+	if used := quotaUsed(t, handler); used != 150 {
+		t.Fatalf("used %d after the stream, want all 150 bytes", used)
+	}
+	if n := incrBys(server); n != 1 {
+		t.Fatalf("%d quota writes for the stream, want one", n)
+	}
+}
+
+func TestValidateBytesQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*QuotaSettings)
+		valid  bool
+	}{
+		{"bytes", func(q *QuotaSettings) {}, true},
+		{"unknown unit", func(q *QuotaSettings) { q.Unit = "tokens" }, false},
+		{"fractional", func(q *QuotaSettings) { q.FractionalLimit = 1.5 }, false},
+		{"consume mode none", func(q *QuotaSettings) { q.ConsumeMode = ConsumeModeNone }, false},
+		{"refund on cancel", func(q *QuotaSettings) { q.RefundOnCancel = true }, false},
+		{"auth failure refunds", func(q *QuotaSettings) { q.AuthFailureStatuses = []int{401} }, false},
+	}
+	for _, tc := range tests {
+		config := validConfig()
+		config.Identifiers[0].Quota.Unit = QuotaUnitBytes
+		tc.mutate(&config.Identifiers[0].Quota)
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
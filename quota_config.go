@@ -2,6 +2,9 @@ package traefik_quota_plugin
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -9,9 +12,74 @@ import (
 type Config struct {
 	Persistence PersistenceConfig  `json:"persistence,omitempty" yaml:"Persistence,omitempty"`
 	Identifiers []IdentifierConfig `json:"identifiers,omitempty" yaml:"Identifiers,omitempty"`
+	SampleRate  *float64           `json:"sample_rate,omitempty" yaml:"SampleRate,omitempty"` // Fraction (0.0-1.0) of allowed requests that are logged (default 1)
+	// ForwardHeaders lists decision headers (X-Quota-Identifier, X-Quota-Remaining, ...) injected into the proxied request
+	ForwardHeaders []string      `json:"forward_headers,omitempty" yaml:"ForwardHeaders,omitempty"`
+	Metrics        MetricsConfig `json:"metrics,omitempty" yaml:"Metrics,omitempty"`
+	// FailureMode decides what happens on Redis errors: "open" (default) allows, "closed" blocks
+	FailureMode            string      `json:"failure_mode,omitempty" yaml:"FailureMode,omitempty"`
+	FailClosedResponseCode int         `json:"fail_closed_response_code,omitempty" yaml:"FailClosedResponseCode,omitempty"` // HTTP status code when failing closed (default 503)
+	FailClosedRetryAfter   string      `json:"fail_closed_retry_after,omitempty" yaml:"FailClosedRetryAfter,omitempty"`     // Retry-After when failing closed (default 5s)
+	Admin                  AdminConfig `json:"admin,omitempty" yaml:"Admin,omitempty"`
+	Log                    LogConfig   `json:"log,omitempty" yaml:"Log,omitempty"`
+	QuotaResetDateHeader   bool        `json:"quota_reset_date_header,omitempty" yaml:"QuotaResetDateHeader,omitempty"`     // Also send X-Quota-Reset-Date in RFC3339
+	StructuredErrors       bool        `json:"structured_errors,omitempty" yaml:"StructuredErrors,omitempty"`               // Send blocked responses as a JSON envelope with limit fields
+	RetryAfterFormat       string      `json:"retry_after_format,omitempty" yaml:"RetryAfterFormat,omitempty"`              // "seconds" (default) or "http-date"
+	MaxRetryAfter          string      `json:"max_retry_after,omitempty" yaml:"MaxRetryAfter,omitempty"`                    // Cap on advertised retry delays (e.g. 1h; default uncapped)
+	HeadersOn              string      `json:"headers_on,omitempty" yaml:"HeadersOn,omitempty"`                             // When limit headers are sent: always (default), blocked or never
+	EnforceAfter           string      `json:"enforce_after,omitempty" yaml:"EnforceAfter,omitempty"`                       // RFC3339 time before which blocks are only logged
+	EnforcePercent         *int        `json:"enforce_percent,omitempty" yaml:"EnforcePercent,omitempty"`                   // Percentage (0-100) of identifiers blocks are enforced for (default 100)
+	MaxIdentifierLength    int         `json:"max_identifier_length,omitempty" yaml:"MaxIdentifierLength,omitempty"`        // Longest identifier used in Redis keys (0 for unlimited)
+	IdentifierOverflow     string      `json:"identifier_overflow,omitempty" yaml:"IdentifierOverflow,omitempty"`           // reject (default) or hash identifiers over the maximum
+	ReadCacheTTL           string      `json:"read_cache_ttl,omitempty" yaml:"ReadCacheTTL,omitempty"`                      // Cache quota and bucket reads in process for this long (e.g. 100ms; default off)
+	RejectUnknownKeys      bool        `json:"reject_unknown_keys,omitempty" yaml:"RejectUnknownKeys,omitempty"`            // Answer a Header identifier value no identifier expects with UnknownKeyResponseCode
+	UnknownKeyResponseCode int         `json:"unknown_key_response_code,omitempty" yaml:"UnknownKeyResponseCode,omitempty"` // HTTP status code for unknown keys (default 401)
+	UnknownKeyResponseBody string      `json:"unknown_key_response_body,omitempty" yaml:"UnknownKeyResponseBody,omitempty"` // Response body for unknown keys
+	ProbeHeader            string      `json:"probe_header,omitempty" yaml:"ProbeHeader,omitempty"`                         // Request header (e.g. X-Quota-Probe) whose value true answers with the current limits without consuming or proxying
+	// GlobalRateLimit is a ceiling on all requests, checked before any identifier
+	GlobalRateLimit RateLimitConfig `json:"global_rate_limit,omitempty" yaml:"GlobalRateLimit,omitempty"`
+	// GlobalPriorityReserve is the percentage (0-100) of the global burst kept for higher-priority identifiers (default 20)
+	GlobalPriorityReserve int `json:"global_priority_reserve,omitempty" yaml:"GlobalPriorityReserve,omitempty"`
+	// ServerTiming adds a Server-Timing header with the duration of the limit checks, for debugging
+	ServerTiming bool `json:"server_timing,omitempty" yaml:"ServerTiming,omitempty"`
+	// RequestIDHeader (e.g. X-Request-Id) carries the id of blocked responses, echoing the request's own id when present
+	RequestIDHeader string `json:"request_id_header,omitempty" yaml:"RequestIDHeader,omitempty"`
+	// DynamicLimits polls a Redis hash of per-identifier limit overrides, applied without a redeploy
+	DynamicLimits DynamicLimitsConfig `json:"dynamic_limits,omitempty" yaml:"DynamicLimits,omitempty"`
+	// MaintenanceMode lets every request past the limits, e.g. during an incident
+	MaintenanceMode MaintenanceConfig `json:"maintenance_mode,omitempty" yaml:"MaintenanceMode,omitempty"`
+}
+
+// LogConfig holds the plugin's log settings
+type LogConfig struct {
+	Output string `json:"output,omitempty" yaml:"Output,omitempty"` // stdout (default) or stderr
+	Level  string `json:"level,omitempty" yaml:"Level,omitempty"`   // info (default), error or off
+}
+
+// AdminConfig holds admin endpoint settings
+type AdminConfig struct {
+	Path   string `json:"path,omitempty" yaml:"Path,omitempty"`     // Admin endpoint path prefix (disabled when empty)
+	Secret string `json:"secret,omitempty" yaml:"Secret,omitempty"` // Required in the X-Admin-Secret request header
+}
+
+// MetricsConfig holds metrics endpoint settings
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled,omitempty" yaml:"Enabled,omitempty"` // Record Redis latency and serve metrics
+	Path    string `json:"path,omitempty" yaml:"Path,omitempty"`       // Metrics endpoint path (default /_quota/metrics)
+}
+
+// EffectiveSampleRate returns SampleRate, or 1 (every request) when it is unset
+func (c *Config) EffectiveSampleRate() float64 {
+	if c.SampleRate == nil {
+		return 1
+	}
+	return *c.SampleRate
 }
 
 // QuotaConfig holds the complete quota configuration (for backward compatibility)
+//
+// Deprecated: Use Config instead; existing values can be converted with
+// Config.FromQuotaConfig.
 type QuotaConfig struct {
 	Persistence PersistenceConfig  `json:"persistence,omitempty" yaml:"Persistence,omitempty"`
 	Identifiers []IdentifierConfig `json:"identifiers,omitempty" yaml:"Identifiers,omitempty"`
@@ -20,6 +88,8 @@ type QuotaConfig struct {
 // PersistenceConfig holds Redis configuration
 type PersistenceConfig struct {
 	Redis RedisConfig `json:"redis,omitempty" yaml:"Redis,omitempty"`
+	// Connections defines additional named Redis instances identifiers can use
+	Connections map[string]RedisConfig `json:"connections,omitempty" yaml:"Connections,omitempty"`
 }
 
 // RedisConfig holds Redis connection settings
@@ -27,34 +97,155 @@ type RedisConfig struct {
 	Address  string `json:"address,omitempty" yaml:"Address,omitempty"`
 	Password string `json:"password,omitempty" yaml:"Password,omitempty"`
 	DB       int    `json:"db,omitempty" yaml:"DB,omitempty"`
+	// ConnectionName is announced via CLIENT SETNAME (default "traefik-quota-plugin")
+	ConnectionName    string `json:"connection_name,omitempty" yaml:"ConnectionName,omitempty"`
+	MaxIdle           int    `json:"max_idle,omitempty" yaml:"MaxIdle,omitempty"`                      // Idle connections kept in the pool (default 4)
+	MaxActive         int    `json:"max_active,omitempty" yaml:"MaxActive,omitempty"`                  // Maximum open connections, 0 for unlimited
+	IdleTimeout       string `json:"idle_timeout,omitempty" yaml:"IdleTimeout,omitempty"`              // Close connections idle longer than this (e.g. 5m)
+	KeepAliveInterval string `json:"keep_alive_interval,omitempty" yaml:"KeepAliveInterval,omitempty"` // Ping idle connections this often, closing those that fail (e.g. 30s; default off)
+	ConnectAttempts   int    `json:"connect_attempts,omitempty" yaml:"ConnectAttempts,omitempty"`      // Initial connection attempts (default 3)
+	ConnectMaxDelay   string `json:"connect_max_delay,omitempty" yaml:"ConnectMaxDelay,omitempty"`     // Maximum backoff between attempts (default 2s)
+	DisableScripting  bool   `json:"disable_scripting,omitempty" yaml:"DisableScripting,omitempty"`    // Update token buckets with WATCH/MULTI/EXEC instead of EVAL
+	ScriptingFallback string `json:"scripting_fallback,omitempty" yaml:"ScriptingFallback,omitempty"`  // When EVAL is unsupported: nonatomic (default), optimistic or fail
+	LoadingRetries    int    `json:"loading_retries,omitempty" yaml:"LoadingRetries,omitempty"`        // Retries of commands refused while Redis loads its dataset, backing off from 50ms (default 3, -1 to disable)
 }
 
 // IdentifierConfig holds identifier configuration with its own rate limit and quota
 type IdentifierConfig struct {
-	Type      string          `json:"type,omitempty" yaml:"Type,omitempty"`   // Header, IP, etc.
-	Name      string          `json:"name,omitempty" yaml:"Name,omitempty"`   // Header name
-	Value     string          `json:"value,omitempty" yaml:"Value,omitempty"` // Default value
-	RateLimit RateLimitConfig `json:"rate_limit,omitempty" yaml:"RateLimit,omitempty"`
-	Quota     QuotaSettings   `json:"quota,omitempty" yaml:"Quota,omitempty"`
+	Type                   string          `json:"type,omitempty" yaml:"Type,omitempty"`                                       // Header, IP, etc.
+	Name                   string          `json:"name,omitempty" yaml:"Name,omitempty"`                                       // Header name
+	Value                  string          `json:"value,omitempty" yaml:"Value,omitempty"`                                     // Default value
+	Aliases                []string        `json:"aliases,omitempty" yaml:"Aliases,omitempty"`                                 // Values sharing the Value's counters, e.g. rotated API keys
+	ValuePrefix            string          `json:"value_prefix,omitempty" yaml:"ValuePrefix,omitempty"`                        // Header identifiers match any value starting with this, each value keyed separately
+	ValueSuffix            string          `json:"value_suffix,omitempty" yaml:"ValueSuffix,omitempty"`                        // Header identifiers match any value ending with this, each value keyed separately
+	Sources                []SourceSpec    `json:"sources,omitempty" yaml:"Sources,omitempty"`                                 // Places a Sources identifier reads its value from, in priority order
+	Denylist               []string        `json:"denylist,omitempty" yaml:"Denylist,omitempty"`                               // Values that are always blocked, e.g. revoked API keys
+	DenylistResponseCode   int             `json:"denylist_response_code,omitempty" yaml:"DenylistResponseCode,omitempty"`     // HTTP status code for denylisted values (default 403)
+	DenylistResponseBody   string          `json:"denylist_response_body,omitempty" yaml:"DenylistResponseBody,omitempty"`     // Response body for denylisted values
+	HashValue              bool            `json:"hash_value,omitempty" yaml:"HashValue,omitempty"`                            // Use the SHA-256 digest of Bearer tokens
+	MaxBodyBytes           int64           `json:"max_body_bytes,omitempty" yaml:"MaxBodyBytes,omitempty"`                     // Body buffering cap for Body identifiers
+	BodyFallback           string          `json:"body_fallback,omitempty" yaml:"BodyFallback,omitempty"`                      // Value used when a Body identifier's request has no body (empty skips)
+	IPFallback             string          `json:"ip_fallback,omitempty" yaml:"IPFallback,omitempty"`                          // Value used when the client IP is loopback/empty (empty skips)
+	ClientIPHeaders        []string        `json:"client_ip_headers,omitempty" yaml:"ClientIPHeaders,omitempty"`               // Ordered headers carrying the client IP (default X-Real-IP, X-Forwarded-For)
+	IPStrategy             IPStrategy      `json:"ip_strategy,omitempty" yaml:"IPStrategy,omitempty"`                          // Which address of the request is the client IP (default first)
+	CookieSignature        CookieSignature `json:"cookie_signature,omitempty" yaml:"CookieSignature,omitempty"`                // HMAC verification of signed Cookie identifier values
+	RedisConnection        string          `json:"redis_connection,omitempty" yaml:"RedisConnection,omitempty"`                // Named Redis connection (default primary)
+	TrackConcurrency       bool            `json:"track_concurrency,omitempty" yaml:"TrackConcurrency,omitempty"`              // Record in-flight and peak concurrent requests
+	DistinctWindow         string          `json:"distinct_window,omitempty" yaml:"DistinctWindow,omitempty"`                  // Count distinct identifier values per window (e.g. 1h)
+	CostHeader             string          `json:"cost_header,omitempty" yaml:"CostHeader,omitempty"`                          // Request header carrying the units a request consumes
+	MaxCost                int64           `json:"max_cost,omitempty" yaml:"MaxCost,omitempty"`                                // Upper bound for CostHeader values (default 100)
+	MaxRequestBytes        int64           `json:"max_request_bytes,omitempty" yaml:"MaxRequestBytes,omitempty"`               // Largest Content-Length handled per OversizeAction (0 for unlimited)
+	OversizeAction         string          `json:"oversize_action,omitempty" yaml:"OversizeAction,omitempty"`                  // reject (default, 413) or cost: charge OversizeCostMultiplier times the cost
+	OversizeCostMultiplier int64           `json:"oversize_cost_multiplier,omitempty" yaml:"OversizeCostMultiplier,omitempty"` // Cost multiplier of oversized requests with the cost action
+	// ResponseHeaders are static headers (e.g. X-Plan: pro) added to responses when this identifier matches
+	ResponseHeaders map[string]string `json:"response_headers,omitempty" yaml:"ResponseHeaders,omitempty"`
+	// SuppressHeaders are response headers the plugin must not send for this identifier (e.g. X-RateLimit-*)
+	SuppressHeaders []string `json:"suppress_headers,omitempty" yaml:"SuppressHeaders,omitempty"`
+	// SoftBlock serves over-limit requests with an X-Quota-Warning header instead of blocking them
+	SoftBlock bool `json:"soft_block,omitempty" yaml:"SoftBlock,omitempty"`
+	// ResponseContentType overrides the detected Content-Type of blocked responses
+	ResponseContentType string          `json:"response_content_type,omitempty" yaml:"ResponseContentType,omitempty"`
+	RateLimit           RateLimitConfig `json:"rate_limit,omitempty" yaml:"RateLimit,omitempty"`
+	Quota               QuotaSettings   `json:"quota,omitempty" yaml:"Quota,omitempty"`
+	// Priority ranks the identifier under global pressure: requests of lower priorities are shed first (default 0, the lowest)
+	Priority int `json:"priority,omitempty" yaml:"Priority,omitempty"`
+	// CheckOrder is the order the rate limit and quota are checked in: ratelimit (default) or quota
+	CheckOrder string `json:"check_order,omitempty" yaml:"CheckOrder,omitempty"`
+	// Labels are attached to the metrics of requests decided by this identifier (e.g. plan: pro)
+	Labels map[string]string `json:"labels,omitempty" yaml:"Labels,omitempty"`
+	// WebSocket decides how WebSocket upgrade requests are limited
+	WebSocket WebSocketPolicy `json:"websocket,omitempty" yaml:"WebSocket,omitempty"`
+	// Webhook is notified in the background when a request of this identifier is blocked
+	Webhook WebhookConfig `json:"webhook,omitempty" yaml:"Webhook,omitempty"`
 }
 
 // RateLimitConfig holds rate limiting configuration
 type RateLimitConfig struct {
-	Enabled                  bool   `json:"enabled,omitempty" yaml:"Enabled,omitempty"`                                      // Enable/disable rate limiting
-	Rate                     int    `json:"rate,omitempty" yaml:"Rate,omitempty"`                                            // Requests per period
-	Burst                    int    `json:"burst,omitempty" yaml:"Burst,omitempty"`                                          // Burst capacity
-	Period                   string `json:"period,omitempty" yaml:"Period,omitempty"`                                        // Time period (1m, 1h, etc.)
-	ResponseReachedLimitCode int    `json:"response_reached_limit_code,omitempty" yaml:"ResponseReachedLimitCode,omitempty"` // HTTP status code when limit reached
-	ResponseReachedLimitBody string `json:"response_reached_limit_body,omitempty" yaml:"ResponseReachedLimitBody,omitempty"` // Response body when limit reached
+	Enabled                  bool        `json:"enabled,omitempty" yaml:"Enabled,omitempty"`                                      // Enable/disable rate limiting
+	Rate                     int         `json:"rate,omitempty" yaml:"Rate,omitempty"`                                            // Requests per period
+	Burst                    int         `json:"burst,omitempty" yaml:"Burst,omitempty"`                                          // Burst capacity
+	Period                   string      `json:"period,omitempty" yaml:"Period,omitempty"`                                        // Time period (1m, 1h, etc.)
+	Algorithm                string      `json:"algorithm,omitempty" yaml:"Algorithm,omitempty"`                                  // TokenBucket (default) or SlidingWindow
+	RefillRate               int         `json:"refill_rate,omitempty" yaml:"RefillRate,omitempty"`                               // Tokens added every RefillInterval
+	RefillInterval           string      `json:"refill_interval,omitempty" yaml:"RefillInterval,omitempty"`                       // Explicit refill cadence (e.g. 6s)
+	ResponseReachedLimitCode int         `json:"response_reached_limit_code,omitempty" yaml:"ResponseReachedLimitCode,omitempty"` // HTTP status code when limit reached
+	ResponseReachedLimitBody string      `json:"response_reached_limit_body,omitempty" yaml:"ResponseReachedLimitBody,omitempty"` // Response body when limit reached
+	ThrottleMode             bool        `json:"throttle_mode,omitempty" yaml:"ThrottleMode,omitempty"`                           // Delay over-limit requests instead of rejecting
+	MaxThrottleDelay         string      `json:"max_throttle_delay,omitempty" yaml:"MaxThrottleDelay,omitempty"`                  // Longest throttle delay (default 5s)
+	InitialTokens            string      `json:"initial_tokens,omitempty" yaml:"InitialTokens,omitempty"`                         // Tokens of a new bucket: full (default), zero or a number
+	AlignWindow              bool        `json:"align_window,omitempty" yaml:"AlignWindow,omitempty"`                             // Refill the bucket fully at wall-clock Period boundaries (e.g. top of the minute)
+	BucketTTL                string      `json:"bucket_ttl,omitempty" yaml:"BucketTTL,omitempty"`                                 // How long idle buckets persist (e.g. 24h; default 2x Period, at least Period)
+	AvailableRounding        string      `json:"available_rounding,omitempty" yaml:"AvailableRounding,omitempty"`                 // How fractional tokens are reported as available: floor (default), round or ceil
+	IPStrategy               *IPStrategy `json:"ip_strategy,omitempty" yaml:"IPStrategy,omitempty"`                               // Client IP the bucket of an IP identifier is keyed on (default the identifier's)
 }
 
 // QuotaSettings holds quota configuration
 type QuotaSettings struct {
-	Enabled                  bool   `json:"enabled,omitempty" yaml:"Enabled,omitempty"`
-	Limit                    int64  `json:"limit,omitempty" yaml:"Limit,omitempty"`                                          // Total quota limit
-	Period                   string `json:"period,omitempty" yaml:"Period,omitempty"`                                        // Daily, Weekly, Monthly
-	ResponseReachedLimitCode int    `json:"response_reached_limit_code,omitempty" yaml:"ResponseReachedLimitCode,omitempty"` // HTTP status code when limit reached
-	ResponseReachedLimitBody string `json:"response_reached_limit_body,omitempty" yaml:"ResponseReachedLimitBody,omitempty"` // Response body when limit reached
+	Enabled                  bool             `json:"enabled,omitempty" yaml:"Enabled,omitempty"`
+	Limit                    int64            `json:"limit,omitempty" yaml:"Limit,omitempty"`                                          // Total quota limit
+	ValueLimits              map[string]int64 `json:"value_limits,omitempty" yaml:"ValueLimits,omitempty"`                             // Limits of specific identifier values (e.g. sk-alice: 100000); other values use Limit
+	FractionalLimit          float64          `json:"fractional_limit,omitempty" yaml:"FractionalLimit,omitempty"`                     // Fractional quota limit tracked with INCRBYFLOAT instead of Limit (e.g. 2.5)
+	Period                   string           `json:"period,omitempty" yaml:"Period,omitempty"`                                        // Daily, Weekly, Monthly, Lifetime (never resets) or a rolling duration (e.g. 6h)
+	ResetDay                 int              `json:"reset_day,omitempty" yaml:"ResetDay,omitempty"`                                   // Day of month a Monthly quota resets on (default 1)
+	Timezone                 string           `json:"timezone,omitempty" yaml:"Timezone,omitempty"`                                    // IANA timezone periods roll over in (default local)
+	OverageAllowance         int64            `json:"overage_allowance,omitempty" yaml:"OverageAllowance,omitempty"`                   // Requests allowed beyond Limit before blocking
+	CarryOverageDebt         bool             `json:"carry_overage_debt,omitempty" yaml:"CarryOverageDebt,omitempty"`                  // Start the next period with the usage that went beyond Limit
+	SignedRemaining          bool             `json:"signed_remaining,omitempty" yaml:"SignedRemaining,omitempty"`                     // Report negative remaining when over the limit (default clamps at 0)
+	Enforce                  *bool            `json:"enforce,omitempty" yaml:"Enforce,omitempty"`                                      // false only counts usage and never blocks (default true)
+	ConsumeMode              string           `json:"consume_mode,omitempty" yaml:"ConsumeMode,omitempty"`                             // pre (default) or none
+	Unit                     string           `json:"unit,omitempty" yaml:"Unit,omitempty"`                                            // requests (default) or bytes: count response bytes written by the upstream
+	AuthFailureStatuses      []int            `json:"auth_failure_statuses,omitempty" yaml:"AuthFailureStatuses,omitempty"`            // Upstream statuses refunded as non-counting (e.g. 401, 403)
+	RefundOnCancel           bool             `json:"refund_on_cancel,omitempty" yaml:"RefundOnCancel,omitempty"`                      // Refund quota when the client cancels before the upstream responds
+	AsyncConsume             bool             `json:"async_consume,omitempty" yaml:"AsyncConsume,omitempty"`                           // Queue usage increments and write them to Redis in the background, off the request path
+	AllowReadsPastQuota      bool             `json:"allow_reads_past_quota,omitempty" yaml:"AllowReadsPastQuota,omitempty"`           // Serve GET and HEAD requests past the quota with X-Quota-Warning, still blocking writes
+	ResponseReachedLimitCode int              `json:"response_reached_limit_code,omitempty" yaml:"ResponseReachedLimitCode,omitempty"` // HTTP status code when limit reached
+	ResponseReachedLimitBody string           `json:"response_reached_limit_body,omitempty" yaml:"ResponseReachedLimitBody,omitempty"` // Response body when limit reached
+	IPStrategy               *IPStrategy      `json:"ip_strategy,omitempty" yaml:"IPStrategy,omitempty"`                               // Client IP the usage of an IP identifier is keyed on (default the identifier's)
+}
+
+// Validate validates an enabled rate limit configuration
+func (rlc *RateLimitConfig) Validate() error {
+	if !rlc.Enabled {
+		return nil
+	}
+
+	if rlc.Rate <= 0 {
+		return fmt.Errorf("rate limit rate must be positive when rate limiting is enabled")
+	}
+	if rlc.Algorithm != "" && rlc.Algorithm != AlgorithmTokenBucket && rlc.Algorithm != AlgorithmSlidingWindow {
+		return fmt.Errorf("unsupported rate limit algorithm: %s", rlc.Algorithm)
+	}
+	if rlc.Burst <= 0 {
+		return fmt.Errorf("rate limit burst must be positive when rate limiting is enabled")
+	}
+	if _, err := rlc.ParseRateLimitPeriod(); err != nil {
+		return fmt.Errorf("invalid rate limit period: %w", err)
+	}
+	if interval, err := rlc.ParseRefillInterval(); err != nil {
+		return fmt.Errorf("invalid refill interval: %w", err)
+	} else if (interval > 0) != (rlc.RefillRate > 0) {
+		return fmt.Errorf("refill rate and refill interval must be set together")
+	}
+	if rlc.AlignWindow && rlc.Algorithm == AlgorithmSlidingWindow {
+		return fmt.Errorf("align window is only supported by the token bucket algorithm")
+	}
+	if rlc.AlignWindow && rlc.RefillInterval != "" {
+		return fmt.Errorf("align window and refill interval are mutually exclusive")
+	}
+	if rlc.AvailableRounding != "" && rlc.AvailableRounding != RoundingFloor && rlc.AvailableRounding != RoundingRound && rlc.AvailableRounding != RoundingCeil {
+		return fmt.Errorf("unsupported available rounding: %s", rlc.AvailableRounding)
+	}
+	if err := rlc.validateBucketTTL(); err != nil {
+		return err
+	}
+	if _, err := rlc.ParseMaxThrottleDelay(); err != nil {
+		return fmt.Errorf("invalid max throttle delay: %w", err)
+	}
+	if _, err := rlc.ParseInitialTokens(); err != nil {
+		return fmt.Errorf("invalid initial tokens: %w", err)
+	}
+
+	return nil
 }
 
 // ParseRateLimitPeriod parses rate limit period string to duration
@@ -66,6 +257,75 @@ func (rlc *RateLimitConfig) ParseRateLimitPeriod() (time.Duration, error) {
 	return time.ParseDuration(rlc.Period)
 }
 
+// validateBucketTTL checks that BucketTTL keeps a bucket for at least its
+// refill period, so it can't expire before refilling
+func (rlc *RateLimitConfig) validateBucketTTL() error {
+	if rlc.BucketTTL == "" {
+		return nil
+	}
+	if rlc.Algorithm == AlgorithmSlidingWindow {
+		return fmt.Errorf("bucket TTL is only supported by the token bucket algorithm")
+	}
+	ttl, err := time.ParseDuration(rlc.BucketTTL)
+	if err != nil {
+		return fmt.Errorf("invalid bucket TTL: %w", err)
+	}
+	period, err := rlc.ParseRateLimitPeriod()
+	if err == nil && ttl < period {
+		return fmt.Errorf("bucket TTL must be at least the rate limit period (%s)", rlc.Period)
+	}
+	return nil
+}
+
+// bucketTTL returns how long an idle token bucket persists: BucketTTL when
+// set (already validated), and twice the refill period otherwise
+func (rlc *RateLimitConfig) bucketTTL(period time.Duration) time.Duration {
+	if rlc.BucketTTL != "" {
+		if ttl, err := time.ParseDuration(rlc.BucketTTL); err == nil {
+			return ttl
+		}
+	}
+	return period * 2
+}
+
+// ParseRefillInterval parses the explicit refill interval (0 when refill derives from Period)
+func (rlc *RateLimitConfig) ParseRefillInterval() (time.Duration, error) {
+	if rlc.RefillInterval == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(rlc.RefillInterval)
+}
+
+// ParseMaxThrottleDelay parses the longest delay applied in throttle mode
+func (rlc *RateLimitConfig) ParseMaxThrottleDelay() (time.Duration, error) {
+	if rlc.MaxThrottleDelay == "" {
+		return 5 * time.Second, nil // default to 5 seconds
+	}
+
+	return time.ParseDuration(rlc.MaxThrottleDelay)
+}
+
+// ParseInitialTokens returns the number of tokens a new bucket starts with:
+// "full" (default) for Burst, "zero", or an explicit number capped at Burst
+func (rlc *RateLimitConfig) ParseInitialTokens() (float64, error) {
+	switch rlc.InitialTokens {
+	case "", "full":
+		return float64(rlc.Burst), nil
+	case "zero":
+		return 0, nil
+	}
+
+	tokens, err := strconv.ParseFloat(rlc.InitialTokens, 64)
+	if err != nil {
+		return 0, fmt.Errorf("must be full, zero or a number: %s", rlc.InitialTokens)
+	}
+	if tokens < 0 {
+		return 0, fmt.Errorf("must not be negative: %s", rlc.InitialTokens)
+	}
+	return math.Min(tokens, float64(rlc.Burst)), nil
+}
+
 // ParseQuotaPeriod parses quota period string to duration
 func (qs *QuotaSettings) ParseQuotaPeriod() (time.Duration, error) {
 	switch qs.Period {
@@ -75,32 +335,271 @@ func (qs *QuotaSettings) ParseQuotaPeriod() (time.Duration, error) {
 		return 7 * 24 * time.Hour, nil
 	case "Monthly":
 		return 30 * 24 * time.Hour, nil // Approximation
+	case "Lifetime":
+		return 0, nil // Never resets
 	default:
+		// Rolling windows starting at the first request, e.g. 6h
+		if period, err := time.ParseDuration(qs.Period); err == nil && period >= time.Second {
+			return period, nil
+		}
 		return 0, fmt.Errorf("unsupported quota period: %s", qs.Period)
 	}
 }
 
+// IsRolling reports whether Period is a duration whose window starts at the
+// identifier's first request instead of a calendar boundary
+func (qs *QuotaSettings) IsRolling() bool {
+	switch qs.Period {
+	case "Daily", "Weekly", "Monthly", "Lifetime":
+		return false
+	}
+	_, err := qs.ParseQuotaPeriod()
+	return err == nil
+}
+
+// IsLifetime reports whether usage accumulates forever instead of resetting
+func (qs *QuotaSettings) IsLifetime() bool {
+	return qs.Period == "Lifetime"
+}
+
+// IsEnforced reports whether exceeding the quota blocks requests
+func (qs *QuotaSettings) IsEnforced() bool {
+	return qs.Enforce == nil || *qs.Enforce
+}
+
+// IsFractional reports whether usage is tracked as a float against FractionalLimit
+func (qs *QuotaSettings) IsFractional() bool {
+	return qs.FractionalLimit > 0
+}
+
+// CountsBytes reports whether usage is counted in response bytes rather than requests
+func (qs *QuotaSettings) CountsBytes() bool {
+	return qs.Unit == QuotaUnitBytes
+}
+
+// LimitFor returns the quota limit of an identifier value, its ValueLimits
+// entry when it has one and Limit otherwise
+func (qs *QuotaSettings) LimitFor(identifier string) int64 {
+	if limit, ok := qs.ValueLimits[identifier]; ok {
+		return limit
+	}
+	return qs.Limit
+}
+
+// IsAuthFailureStatus reports whether an upstream status should not count against the quota
+func (qs *QuotaSettings) IsAuthFailureStatus(status int) bool {
+	for _, candidate := range qs.AuthFailureStatuses {
+		if candidate == status {
+			return true
+		}
+	}
+	return false
+}
+
 // Validate validates the quota configuration
+//
+// Deprecated: Use Config.Validate instead.
 func (qc *QuotaConfig) Validate() error {
+	config := CreateConfig()
+	config.FromQuotaConfig(*qc)
+	return config.Validate()
+}
+
+// FromQuotaConfig copies the settings of a legacy QuotaConfig into the config.
+// Plugin-level options that QuotaConfig doesn't carry are left unchanged.
+func (c *Config) FromQuotaConfig(qc QuotaConfig) {
+	c.Persistence = qc.Persistence
+	c.Identifiers = append([]IdentifierConfig(nil), qc.Identifiers...)
+}
+
+// EffectiveEnforcePercent returns EnforcePercent, or 100 (every identifier) when it is unset
+func (c *Config) EffectiveEnforcePercent() int {
+	if c.EnforcePercent == nil {
+		return 100
+	}
+	return *c.EnforcePercent
+}
+
+// Validate validates the plugin configuration
+func (c *Config) Validate() error {
 	// Validate Redis config
-	if qc.Persistence.Redis.Address == "" {
+	if c.Persistence.Redis.Address == "" {
 		return fmt.Errorf("redis address is required")
 	}
 
+	if err := c.Persistence.Redis.validateScriptingFallback(); err != nil {
+		return err
+	}
+	if err := c.Persistence.Redis.validateConnect(); err != nil {
+		return err
+	}
+	if c.Persistence.Redis.LoadingRetries < -1 {
+		return fmt.Errorf("redis loading retries must be -1 or more")
+	}
+	for name, connection := range c.Persistence.Connections {
+		if err := connection.validateScriptingFallback(); err != nil {
+			return fmt.Errorf("redis connection %s: %w", name, err)
+		}
+		if err := connection.validateConnect(); err != nil {
+			return fmt.Errorf("redis connection %s: %w", name, err)
+		}
+		if connection.LoadingRetries < -1 {
+			return fmt.Errorf("redis connection %s: loading retries must be -1 or more", name)
+		}
+	}
+
 	// Validate identifiers
-	if len(qc.Identifiers) == 0 {
+	if len(c.Identifiers) == 0 {
 		return fmt.Errorf("at least one identifier is required")
 	}
 
-	for i, identifier := range qc.Identifiers {
+	if c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1) {
+		return fmt.Errorf("sample rate must be between 0 and 1")
+	}
+	if err := validateForwardHeaders(c.ForwardHeaders); err != nil {
+		return err
+	}
+	if c.FailureMode != "" && c.FailureMode != "open" && c.FailureMode != "closed" {
+		return fmt.Errorf("unsupported failure mode: %s", c.FailureMode)
+	}
+	if c.Admin.Path != "" && c.Admin.Secret == "" {
+		return fmt.Errorf("admin secret is required when the admin endpoint is enabled")
+	}
+	if c.MaxIdentifierLength < 0 {
+		return fmt.Errorf("max identifier length must not be negative")
+	}
+	if c.IdentifierOverflow != "" && c.IdentifierOverflow != IdentifierOverflowReject && c.IdentifierOverflow != IdentifierOverflowHash {
+		return fmt.Errorf("unsupported identifier overflow mode: %s", c.IdentifierOverflow)
+	}
+	if c.HeadersOn != "" && c.HeadersOn != HeadersOnAlways && c.HeadersOn != HeadersOnBlocked && c.HeadersOn != HeadersOnNever {
+		return fmt.Errorf("unsupported headers on mode: %s", c.HeadersOn)
+	}
+	if c.RetryAfterFormat != "" && c.RetryAfterFormat != RetryAfterFormatSeconds && c.RetryAfterFormat != RetryAfterFormatHTTPDate {
+		return fmt.Errorf("unsupported retry after format: %s", c.RetryAfterFormat)
+	}
+	if err := c.Log.Validate(); err != nil {
+		return err
+	}
+	if err := c.DynamicLimits.Validate(); err != nil {
+		return err
+	}
+	if c.GlobalPriorityReserve < 0 || c.GlobalPriorityReserve > 100 {
+		return fmt.Errorf("global priority reserve must be between 0 and 100")
+	}
+	if c.EnforcePercent != nil && (*c.EnforcePercent < 0 || *c.EnforcePercent > 100) {
+		return fmt.Errorf("enforce percent must be between 0 and 100")
+	}
+	if c.EnforceAfter != "" {
+		if _, err := time.Parse(time.RFC3339, c.EnforceAfter); err != nil {
+			return fmt.Errorf("invalid enforce after time: %w", err)
+		}
+	}
+	if c.ReadCacheTTL != "" {
+		if ttl, err := time.ParseDuration(c.ReadCacheTTL); err != nil || ttl < 0 {
+			return fmt.Errorf("invalid read cache TTL: %s", c.ReadCacheTTL)
+		}
+	}
+	if c.MaxRetryAfter != "" {
+		if maxRetryAfter, err := time.ParseDuration(c.MaxRetryAfter); err != nil || maxRetryAfter <= 0 {
+			return fmt.Errorf("invalid max retry after: %s", c.MaxRetryAfter)
+		}
+	}
+	if c.FailClosedRetryAfter != "" {
+		if _, err := time.ParseDuration(c.FailClosedRetryAfter); err != nil {
+			return fmt.Errorf("invalid fail closed retry after: %w", err)
+		}
+	}
+
+	globalRateLimit := c.GlobalRateLimit
+	globalRateLimit.Normalize()
+	if err := globalRateLimit.Validate(); err != nil {
+		return fmt.Errorf("global rate limit: %w", err)
+	}
+	if globalRateLimit.Enabled && (globalRateLimit.ThrottleMode || globalRateLimit.IPStrategy != nil) {
+		return fmt.Errorf("global rate limit: neither throttle mode nor an IP strategy is supported")
+	}
+	if globalRateLimit.Enabled && globalRateLimit.Algorithm == AlgorithmSlidingWindow && c.Persistence.Redis.DisableScripting {
+		return fmt.Errorf("global rate limit: the sliding window algorithm requires Redis scripting")
+	}
+
+	for i, identifier := range c.Identifiers {
+		identifier.Normalize()
 		if err := identifier.Validate(); err != nil {
 			return fmt.Errorf("identifier %d validation failed: %w", i, err)
 		}
+		if _, ok := c.Persistence.Connections[identifier.RedisConnection]; identifier.RedisConnection != "" && !ok {
+			return fmt.Errorf("identifier %d references unknown Redis connection: %s", i, identifier.RedisConnection)
+		}
 	}
 
 	return nil
 }
 
+// Normalize fills in defaults for omitted identifier settings
+func (ic *IdentifierConfig) Normalize() {
+	ic.RateLimit.Normalize()
+}
+
+// prepareIPStrategies parses the trusted proxies of the identifier's IP
+// strategies once it has been validated
+func (ic *IdentifierConfig) prepareIPStrategies() {
+	ic.IPStrategy.prepare()
+	ic.RateLimit.IPStrategy.prepare()
+	ic.Quota.IPStrategy.prepare()
+}
+
+// Normalize fills in defaults for omitted rate limit settings
+func (rlc *RateLimitConfig) Normalize() {
+	// Default burst capacity to the rate when omitted
+	if rlc.Burst == 0 {
+		rlc.Burst = rlc.Rate
+	}
+}
+
+// matchesValue reports whether value is the configured Value or one of its aliases
+func (ic *IdentifierConfig) matchesValue(value string) bool {
+	return value == ic.Value || ic.isAlias(value)
+}
+
+// canonicalValue maps an alias to the configured Value so both share one bucket
+func (ic *IdentifierConfig) canonicalValue(value string) string {
+	if ic.isAlias(value) {
+		return ic.Value
+	}
+	return value
+}
+
+// hasValuePattern reports whether values are matched by ValuePrefix/ValueSuffix instead of Value
+func (ic *IdentifierConfig) hasValuePattern() bool {
+	return ic.ValuePrefix != "" || ic.ValueSuffix != ""
+}
+
+// matchesValuePattern reports whether value starts with ValuePrefix and ends
+// with ValueSuffix, without the two overlapping
+func (ic *IdentifierConfig) matchesValuePattern(value string) bool {
+	return len(value) >= len(ic.ValuePrefix)+len(ic.ValueSuffix) &&
+		strings.HasPrefix(value, ic.ValuePrefix) && strings.HasSuffix(value, ic.ValueSuffix)
+}
+
+// keyValue returns the value part of the identifier's manager key: Value, or
+// prefix*suffix for identifiers matching a value pattern
+func (ic *IdentifierConfig) keyValue() string {
+	if ic.hasValuePattern() {
+		return ic.ValuePrefix + "*" + ic.ValueSuffix
+	}
+	return ic.Value
+}
+
+// isAlias reports whether value is one of the configured aliases
+func (ic *IdentifierConfig) isAlias(value string) bool {
+	for _, alias := range ic.Aliases {
+		if value != "" && value == alias {
+			return true
+		}
+	}
+	return false
+}
+
 // Validate validates the identifier configuration
 func (ic *IdentifierConfig) Validate() error {
 	// Validate identifier config
@@ -110,33 +609,167 @@ func (ic *IdentifierConfig) Validate() error {
 	if ic.Type == "Header" && ic.Name == "" {
 		return fmt.Errorf("header name is required for header-based identification")
 	}
+	if ic.Type == "Body" && ic.Name == "" {
+		return fmt.Errorf("JSON path is required for body-based identification")
+	}
+	if ic.Type == "GRPCMetadata" && ic.Name == "" {
+		return fmt.Errorf("metadata key is required for gRPC metadata identification")
+	}
+	if ic.Type == "Path" {
+		if err := validatePathSelector(ic.Name); err != nil {
+			return err
+		}
+	}
+	if ic.Type == "Sources" {
+		if err := validateSources(ic.Sources); err != nil {
+			return err
+		}
+	} else if len(ic.Sources) > 0 {
+		return fmt.Errorf("sources require the Sources identifier type")
+	}
+	if ic.Type == "ClientCert" && ic.Name != "" && ic.Name != "CN" && ic.Name != "Serial" && ic.Name != "SAN" {
+		return fmt.Errorf("client certificate field must be CN, Serial or SAN")
+	}
+	if len(ic.Aliases) > 0 && (ic.Value == "" || ic.Type == "Template") {
+		return fmt.Errorf("aliases require a canonical value")
+	}
+	if ic.hasValuePattern() {
+		if ic.Type != "Header" {
+			return fmt.Errorf("value prefix and suffix require the Header identifier type")
+		}
+		if ic.Value != "" || len(ic.Aliases) > 0 {
+			return fmt.Errorf("value prefix and suffix cannot be combined with a value or aliases")
+		}
+	}
+	if ic.MaxCost < 0 {
+		return fmt.Errorf("max cost must not be negative")
+	}
+	if ic.MaxCost > maxSafeCount {
+		return fmt.Errorf("max cost must not exceed %d", int64(maxSafeCount))
+	}
+	if ic.DistinctWindow != "" {
+		if window, err := time.ParseDuration(ic.DistinctWindow); err != nil || window <= 0 {
+			return fmt.Errorf("invalid distinct window: %s", ic.DistinctWindow)
+		}
+	}
+
+	if err := validateMetricLabels(ic.Labels); err != nil {
+		return err
+	}
+	if ic.Priority < 0 {
+		return fmt.Errorf("priority must not be negative")
+	}
+	if ic.CheckOrder != "" && ic.CheckOrder != CheckOrderRateLimit && ic.CheckOrder != CheckOrderQuota {
+		return fmt.Errorf("unsupported check order: %s", ic.CheckOrder)
+	}
+	if err := ic.validateRequestSize(); err != nil {
+		return err
+	}
+	if err := ic.WebSocket.Validate(); err != nil {
+		return err
+	}
+	if err := ic.Webhook.Validate(); err != nil {
+		return err
+	}
 
 	// Check that at least one feature is enabled
-	if !ic.RateLimit.Enabled && !ic.Quota.Enabled {
-		return fmt.Errorf("at least one feature (rate limit or quota) must be enabled")
+	if !ic.RateLimit.Enabled && !ic.Quota.Enabled && !ic.WebSocket.LimitsConnections() {
+		return fmt.Errorf("at least one feature (rate limit, quota or WebSocket connections) must be enabled")
 	}
 
-	// Validate rate limit config if enabled
-	if ic.RateLimit.Enabled {
-		if ic.RateLimit.Rate <= 0 {
-			return fmt.Errorf("rate limit rate must be positive when rate limiting is enabled")
+	if err := ic.CookieSignature.Validate(); err != nil {
+		return err
+	}
+	if err := ic.IPStrategy.Validate(); err != nil {
+		return err
+	}
+	for feature, strategy := range map[string]*IPStrategy{"rate limit": ic.RateLimit.IPStrategy, "quota": ic.Quota.IPStrategy} {
+		if strategy == nil {
+			continue
 		}
-		if ic.RateLimit.Burst <= 0 {
-			return fmt.Errorf("rate limit burst must be positive when rate limiting is enabled")
+		if ic.Type != "IP" {
+			return fmt.Errorf("%s IP strategy is only supported by IP identifiers", feature)
 		}
-		if _, err := ic.RateLimit.ParseRateLimitPeriod(); err != nil {
-			return fmt.Errorf("invalid rate limit period: %w", err)
+		if err := strategy.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", feature, err)
 		}
 	}
 
+	// Validate rate limit config if enabled
+	if err := ic.RateLimit.Validate(); err != nil {
+		return err
+	}
+
 	// Validate quota config if enabled
 	if ic.Quota.Enabled {
-		if ic.Quota.Limit <= 0 {
-			return fmt.Errorf("quota limit must be positive when quota is enabled")
+		// Counting-only quotas may omit the limit
+		if ic.Quota.Limit <= 0 && ic.Quota.IsEnforced() && !ic.Quota.IsFractional() {
+			return fmt.Errorf("quota limit must be positive when quota is enforced")
+		}
+		if ic.Quota.Limit < 0 {
+			return fmt.Errorf("quota limit must not be negative")
+		}
+		if ic.Quota.FractionalLimit < 0 || math.IsNaN(ic.Quota.FractionalLimit) || math.IsInf(ic.Quota.FractionalLimit, 0) {
+			return fmt.Errorf("quota fractional limit must be a positive number")
+		}
+		if ic.Quota.IsFractional() && ic.Quota.Limit > 0 {
+			return fmt.Errorf("quota limit and fractional limit are mutually exclusive")
+		}
+		if ic.Quota.IsFractional() && ic.Quota.CarryOverageDebt {
+			return fmt.Errorf("carrying overage debt is not supported for fractional quotas")
+		}
+		if ic.Quota.IsRolling() && (ic.Quota.IsFractional() || ic.Quota.CarryOverageDebt) {
+			return fmt.Errorf("rolling quota periods support neither fractional limits nor overage debt")
+		}
+		if ic.Quota.IsLifetime() && ic.Quota.CarryOverageDebt {
+			return fmt.Errorf("carrying overage debt is not supported for lifetime quotas")
 		}
 		if _, err := ic.Quota.ParseQuotaPeriod(); err != nil {
 			return fmt.Errorf("invalid quota period: %w", err)
 		}
+		if ic.Quota.ConsumeMode != "" && ic.Quota.ConsumeMode != ConsumeModePre && ic.Quota.ConsumeMode != ConsumeModeNone {
+			return fmt.Errorf("unsupported quota consume mode: %s", ic.Quota.ConsumeMode)
+		}
+		if ic.Quota.Unit != "" && ic.Quota.Unit != QuotaUnitRequests && ic.Quota.Unit != QuotaUnitBytes {
+			return fmt.Errorf("unsupported quota unit: %s", ic.Quota.Unit)
+		}
+		if ic.Quota.CountsBytes() && (ic.Quota.IsFractional() || ic.Quota.ConsumeMode == ConsumeModeNone) {
+			return fmt.Errorf("bytes quotas support neither fractional limits nor consume mode none")
+		}
+		if ic.Quota.CountsBytes() && (len(ic.Quota.AuthFailureStatuses) > 0 || ic.Quota.RefundOnCancel) {
+			return fmt.Errorf("bytes quotas are consumed after the response and can't be refunded")
+		}
+		if ic.Quota.AsyncConsume && (ic.Quota.IsFractional() || ic.Quota.CountsBytes()) {
+			return fmt.Errorf("async consume supports neither fractional limits nor bytes quotas")
+		}
+		if ic.Quota.Timezone != "" {
+			if _, err := time.LoadLocation(ic.Quota.Timezone); err != nil {
+				return fmt.Errorf("invalid quota timezone: %w", err)
+			}
+		}
+		if ic.Quota.OverageAllowance < 0 {
+			return fmt.Errorf("quota overage allowance must not be negative")
+		}
+		if ic.Quota.Limit > maxSafeCount || ic.Quota.OverageAllowance > maxSafeCount-ic.Quota.Limit {
+			return fmt.Errorf("quota limit plus overage allowance must not exceed %d", int64(maxSafeCount))
+		}
+		for value, limit := range ic.Quota.ValueLimits {
+			if limit <= 0 {
+				return fmt.Errorf("quota limit of value %q must be positive", value)
+			}
+			if limit > maxSafeCount || ic.Quota.OverageAllowance > maxSafeCount-limit {
+				return fmt.Errorf("quota limit of value %q plus overage allowance must not exceed %d", value, int64(maxSafeCount))
+			}
+		}
+		if len(ic.Quota.ValueLimits) > 0 && ic.Quota.IsFractional() {
+			return fmt.Errorf("value limits are not supported for fractional quotas")
+		}
+		if ic.Quota.ResetDay < 0 || ic.Quota.ResetDay > 31 {
+			return fmt.Errorf("quota reset day must be between 1 and 31")
+		}
+		if ic.Quota.ResetDay > 1 && ic.Quota.Period != "Monthly" {
+			return fmt.Errorf("quota reset day is only supported for Monthly quotas")
+		}
 	}
 
 	return nil
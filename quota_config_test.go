@@ -0,0 +1,83 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRateLimitBurstDefaultsToRate(t *testing.T) {
+	config := validConfig()
+	config.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 7, Period: "1m"}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("omitted burst rejected: %v", err)
+	}
+	identifier := config.Identifiers[0]
+	identifier.Normalize()
+	if burst := identifier.RateLimit.Burst; burst != 7 {
+		t.Fatalf("burst %d, want the rate 7", burst)
+	}
+
+	// An explicit burst is kept
+	explicit := IdentifierConfig{Type: "IP", RateLimit: RateLimitConfig{Enabled: true, Rate: 7, Burst: 20, Period: "1m"}}
+	explicit.Normalize()
+	if explicit.RateLimit.Burst != 20 {
+		t.Fatalf("explicit burst overwritten with %d", explicit.RateLimit.Burst)
+	}
+}
+
+func TestRateLimitZeroRateRejected(t *testing.T) {
+	config := validConfig()
+	config.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Period: "1m"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("zero rate accepted")
+	}
+
+	negative := IdentifierConfig{Type: "IP", RateLimit: RateLimitConfig{Enabled: true, Rate: 5, Burst: -1, Period: "1m"}}
+	negative.Normalize()
+	if err := negative.Validate(); err == nil {
+		t.Fatal("negative burst accepted")
+	}
+}
+
+func TestFromQuotaConfigMatchesConfig(t *testing.T) {
+	server := newTestRedisServer(t)
+	direct := validConfig()
+	direct.Persistence.Redis.Address = server.addr
+
+	legacy := QuotaConfig{Persistence: direct.Persistence, Identifiers: validConfig().Identifiers}
+	if err := legacy.Validate(); err != nil {
+		t.Fatalf("legacy shape rejected: %v", err)
+	}
+	migrated := CreateConfig()
+	migrated.FromQuotaConfig(legacy)
+	if err := migrated.Validate(); err != nil {
+		t.Fatalf("migrated config rejected: %v", err)
+	}
+
+	// Identifiers are copied, not shared
+	legacy.Identifiers[0].Value = "changed"
+	if migrated.Identifiers[0].Value != "u1" {
+		t.Fatal("migrated config shares the legacy identifiers")
+	}
+
+	// Both shapes build plugins enforcing the same limits
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for name, config := range map[string]*Config{"direct": direct, "migrated": migrated} {
+		handler, err := New(ctx, http.NotFoundHandler(), config, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if rw := serveAs(handler, "u1"); rw.Header().Get("X-Quota-Limit") != "5" {
+			t.Fatalf("%s: X-Quota-Limit %q, want 5", name, rw.Header().Get("X-Quota-Limit"))
+		}
+	}
+}
+
+func TestQuotaConfigValidateUsesConfigRules(t *testing.T) {
+	legacy := QuotaConfig{Identifiers: validConfig().Identifiers}
+	if err := legacy.Validate(); err == nil {
+		t.Fatal("legacy config without a Redis address accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// fractionalEpsilon absorbs float rounding (e.g. 0.1+0.2) when comparing
+// fractional usage against its limit
+const fractionalEpsilon = 1e-9
+
+// CheckQuotaFloat checks if a request costing a fractional amount is allowed
+// under a fractional quota
+func (qm *QuotaManager) CheckQuotaFloat(ctx context.Context, identifier string, amount float64) (bool, *QuotaInfo, error) {
+	if !qm.config.Enabled {
+		return true, nil, nil
+	}
+
+	info := qm.getFractionalQuotaInfo(ctx, identifier)
+
+	// Counting-only quotas track usage without ever blocking
+	if qm.config.IsEnforced() && qm.exceedsFractionalLimit(info.ExactUsed+amount) {
+		return false, info, nil
+	}
+
+	info.Overage = info.ExactUsed+amount > qm.config.FractionalLimit+fractionalEpsilon
+	return true, info, nil
+}
+
+// ReserveQuotaFloat atomically consumes a fractional amount when it fits in the
+// quota (including the overage allowance), rolling the increment back when over
+func (qm *QuotaManager) ReserveQuotaFloat(ctx context.Context, identifier string, amount float64) (bool, *QuotaInfo, error) {
+	if !qm.config.Enabled {
+		return true, nil, nil
+	}
+
+	key := GetQuotaKey(identifier, qm.periodKey())
+
+	newUsage, err := qm.incrementFractionalUsage(ctx, key, amount)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if qm.config.IsEnforced() && qm.exceedsFractionalLimit(newUsage) {
+		used, err := qm.redisClient.IncrByFloat(ctx, key, -amount)
+		qm.cache.invalidate(key)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to roll back quota reservation: %w", err)
+		}
+		return false, qm.fractionalQuotaInfo(used), nil
+	}
+
+	return true, qm.fractionalQuotaInfo(newUsage), nil
+}
+
+// ConsumeQuotaFloat consumes a fractional amount of quota for a request
+func (qm *QuotaManager) ConsumeQuotaFloat(ctx context.Context, identifier string, amount float64) (*QuotaInfo, error) {
+	if !qm.config.Enabled {
+		return nil, nil
+	}
+
+	key := GetQuotaKey(identifier, qm.periodKey())
+
+	newUsage, err := qm.incrementFractionalUsage(ctx, key, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return qm.fractionalQuotaInfo(newUsage), nil
+}
+
+// RefundQuotaFloat gives back a previously consumed fractional amount
+func (qm *QuotaManager) RefundQuotaFloat(ctx context.Context, identifier string, amount float64) error {
+	if !qm.config.Enabled || amount <= 0 {
+		return nil
+	}
+
+	key := GetQuotaKey(identifier, qm.periodKey())
+
+	newUsage, err := qm.redisClient.IncrByFloat(ctx, key, -amount)
+	qm.cache.invalidate(key)
+	if err != nil {
+		return fmt.Errorf("failed to refund quota: %w", err)
+	}
+
+	// Never let refunds push usage below zero, e.g. after a period rollover
+	if newUsage < 0 {
+		if _, err := qm.redisClient.IncrByFloat(ctx, key, -newUsage); err != nil {
+			return fmt.Errorf("failed to correct refunded quota: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// incrementFractionalUsage increments the fractional usage stored at key and
+// makes sure the key expires at the end of the current period
+func (qm *QuotaManager) incrementFractionalUsage(ctx context.Context, key string, amount float64) (float64, error) {
+	newUsage, err := qm.redisClient.IncrByFloat(ctx, key, amount)
+	qm.cache.invalidate(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment quota: %w", err)
+	}
+
+	if err := qm.ensureExpiry(ctx, key, newUsage == amount); err != nil {
+		return 0, err
+	}
+
+	return newUsage, nil
+}
+
+// getFractionalQuotaInfo reads the fractional usage of identifier; unreadable
+// usage counts as zero, as for integer quotas
+func (qm *QuotaManager) getFractionalQuotaInfo(ctx context.Context, identifier string) *QuotaInfo {
+	key := GetQuotaKey(identifier, qm.periodKey())
+
+	if cached, ok := qm.cache.get(key); ok {
+		return qm.fractionalQuotaInfo(cached.(float64))
+	}
+
+	var used float64
+	if usageStr, err := qm.redisClient.Get(ctx, key); err == nil {
+		if parsedUsage, parseErr := strconv.ParseFloat(usageStr, 64); parseErr == nil {
+			used = parsedUsage
+		}
+	}
+	qm.cache.set(key, used)
+
+	return qm.fractionalQuotaInfo(used)
+}
+
+// exceedsFractionalLimit reports whether usage is beyond the fractional limit
+// and the overage allowance
+func (qm *QuotaManager) exceedsFractionalLimit(usage float64) bool {
+	return usage > qm.config.FractionalLimit+float64(qm.config.OverageAllowance)+fractionalEpsilon
+}
+
+// fractionalQuotaInfo builds the quota information for a fractional usage
+func (qm *QuotaManager) fractionalQuotaInfo(used float64) *QuotaInfo {
+	limit := qm.config.FractionalLimit
+	remaining := limit - used
+	if remaining < 0 && !qm.config.SignedRemaining {
+		remaining = 0
+	}
+
+	// Fractional quotas have no value limits; the limit is replaced below
+	info := qm.quotaInfo("", int64(math.Ceil(used-fractionalEpsilon)))
+	info.Limit = int64(math.Floor(limit))
+	info.Remaining = int64(math.Floor(remaining + fractionalEpsilon))
+	info.Overage = used > limit+fractionalEpsilon
+	info.Fractional = true
+	info.ExactLimit = limit
+	info.ExactUsed = used
+	info.ExactRemaining = remaining
+	return info
+}
+
+// headerValues formats the limit, usage and remaining quota for response
+// headers, with exact decimals for fractional quotas. The limit and remaining
+// quota are empty for counting-only quotas without a limit.
+func (qi *QuotaInfo) headerValues() (limit, used, remaining string) {
+	if qi.Unlimited {
+		return "", strconv.FormatInt(qi.Used, 10), ""
+	}
+	if qi.Fractional {
+		return formatQuotaFloat(qi.ExactLimit), formatQuotaFloat(qi.ExactUsed), formatQuotaFloat(qi.ExactRemaining)
+	}
+	return strconv.FormatInt(qi.Limit, 10), strconv.FormatInt(qi.Used, 10), strconv.FormatInt(qi.Remaining, 10)
+}
+
+// formatQuotaFloat formats a fractional quota value without float noise
+func formatQuotaFloat(value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', 9, 64)
+	formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+	if formatted == "-0" {
+		return "0"
+	}
+	return formatted
+}
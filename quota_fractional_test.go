@@ -0,0 +1,93 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReserveQuotaFloatAccumulates(t *testing.T) {
+	ctx := context.Background()
+	qm := NewQuotaManager(NewMemoryRedisClient(), QuotaSettings{Enabled: true, FractionalLimit: 1, Period: "Daily"})
+
+	// Ten 0.1 steps sum to 0.9999999999999999 and must still fit exactly
+	for i := 1; i <= 10; i++ {
+		allowed, info, err := qm.ReserveQuotaFloat(ctx, "u1", 0.1)
+		if err != nil || !allowed {
+			t.Fatalf("reservation %d: allowed %v, %v", i, allowed, err)
+		}
+		if i == 10 && (info.ExactRemaining > fractionalEpsilon || info.Overage) {
+			t.Fatalf("after the limit: remaining %v, overage %v", info.ExactRemaining, info.Overage)
+		}
+	}
+
+	allowed, info, err := qm.ReserveQuotaFloat(ctx, "u1", 0.001)
+	if err != nil || allowed {
+		t.Fatalf("reservation over the limit: allowed %v, %v", allowed, err)
+	}
+	if got := formatQuotaFloat(info.ExactUsed); got != "1" {
+		t.Fatalf("usage after the rejected reservation %s, want it rolled back to 1", got)
+	}
+}
+
+func TestFormatQuotaFloat(t *testing.T) {
+	tests := map[float64]string{
+		0:                  "0",
+		2.5:                "2.5",
+		0.1 + 0.2:          "0.3",
+		0.001:              "0.001",
+		-0.0000000000001:   "0",
+		1234.000000000001:  "1234",
+		-1.250000000000001: "-1.25",
+	}
+	for value, want := range tests {
+		if got := formatQuotaFloat(value); got != want {
+			t.Errorf("formatQuotaFloat(%v) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestSimpleRedisClientIncrByFloat(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{Address: server.addr})
+	ctx := context.Background()
+
+	client.IncrByFloat(ctx, "f", 0.25)
+	if value, err := client.IncrByFloat(ctx, "f", 0.5); err != nil || value != 0.75 {
+		t.Fatalf("IncrByFloat = %v, %v; want 0.75", value, err)
+	}
+	client.Set(ctx, "text", "abc", 0)
+	if _, err := client.IncrByFloat(ctx, "text", 1); err == nil {
+		t.Fatal("IncrByFloat of a non-number succeeded")
+	}
+}
+
+func TestServeHTTPFractionalCost(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].CostHeader = "X-Request-Cost"
+		c.Identifiers[0].Quota = QuotaSettings{Enabled: true, FractionalLimit: 0.5, Period: "Daily"}
+	})
+
+	serve := func(cost string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		req.Header.Set("X-Request-Cost", cost)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve("0.2")
+	if rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Limit") != "0.5" || rw.Header().Get("X-Quota-Used") != "0.2" || rw.Header().Get("X-Quota-Remaining") != "0.3" {
+		t.Fatalf("status %d, headers %v", rw.Code, rw.Header())
+	}
+	if rw := serve("0.3"); rw.Code != http.StatusOK {
+		t.Fatalf("request filling the limit: status %d, want 200", rw.Code)
+	}
+	if rw := serve("0.001"); rw.Code != http.StatusForbidden {
+		t.Fatalf("request over the limit: status %d, want 403", rw.Code)
+	}
+}
@@ -2,40 +2,88 @@ package traefik_quota_plugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// maxSafeCount bounds limits, allowances and costs, leaving enough headroom
+// that adding any two of them can never overflow an int64
+const maxSafeCount = 1 << 62
+
+// Quota consumption modes
+const (
+	ConsumeModePre  = "pre"  // Consume when the request is allowed (default)
+	ConsumeModeNone = "none" // Only check; consumption is done explicitly via ConsumeQuota
+)
+
+// Units quota usage is counted in
+const (
+	QuotaUnitRequests = "requests" // The request cost, reserved before the upstream is called (default)
+	QuotaUnitBytes    = "bytes"    // Response bytes, consumed as the upstream writes them
+)
+
 // QuotaManager manages quota tracking and enforcement
 type QuotaManager struct {
 	redisClient RedisClient
 	config      QuotaSettings
+	location    *time.Location
+	cache       *readCache       // Optional cache of usage reads (nil when disabled)
+	log         *pluginLogger    // Logger of the owning plugin instance (nil for the default)
+	async       *asyncConsumer   // Queues usage increments when AsyncConsume is set (nil otherwise)
+	clock       func() time.Time // Source of the current time (nil for time.Now)
 }
 
 // QuotaInfo contains information about quota usage
 type QuotaInfo struct {
-	Limit     int64         `json:"limit"`      // Total quota limit
-	Used      int64         `json:"used"`       // Currently used quota
-	Remaining int64         `json:"remaining"`  // Remaining quota
-	Period    string        `json:"period"`     // Quota period (Daily/Weekly/Monthly)
-	ResetTime time.Time     `json:"reset_time"` // When quota resets
-	ResetIn   time.Duration `json:"reset_in"`   // Time until reset
+	Limit     int64         `json:"limit"`               // Total quota limit
+	Used      int64         `json:"used"`                // Currently used quota
+	Remaining int64         `json:"remaining"`           // Remaining quota
+	Period    string        `json:"period"`              // Quota period (Daily/Weekly/Monthly)
+	ResetTime time.Time     `json:"reset_time"`          // When quota resets
+	ResetIn   time.Duration `json:"reset_in"`            // Time until reset
+	Overage   bool          `json:"overage"`             // Usage is beyond Limit but within the overage allowance
+	Unlimited bool          `json:"unlimited,omitempty"` // Counting-only quota without a Limit, so Limit, Remaining and Overage don't apply
+
+	// Fractional quotas report exact values here; Limit and Remaining are rounded down and Used up
+	Fractional     bool    `json:"fractional,omitempty"`
+	ExactLimit     float64 `json:"exact_limit,omitempty"`
+	ExactUsed      float64 `json:"exact_used,omitempty"`
+	ExactRemaining float64 `json:"exact_remaining,omitempty"`
 }
 
 // NewQuotaManager creates a new quota manager
 func NewQuotaManager(redisClient RedisClient, config QuotaSettings) *QuotaManager {
+	// Periods roll over in the configured timezone (validated beforehand)
+	location := time.Local
+	if config.Timezone != "" {
+		if loaded, err := time.LoadLocation(config.Timezone); err == nil {
+			location = loaded
+		}
+	}
+
 	return &QuotaManager{
 		redisClient: redisClient,
 		config:      config,
+		location:    location,
 	}
 }
 
 // CheckQuota checks if a request is allowed under the quota
 func (qm *QuotaManager) CheckQuota(ctx context.Context, identifier string) (bool, *QuotaInfo, error) {
+	return qm.CheckQuotaN(ctx, identifier, 1)
+}
+
+// CheckQuotaN checks if a request costing amount units is allowed under the quota
+func (qm *QuotaManager) CheckQuotaN(ctx context.Context, identifier string, amount int64) (bool, *QuotaInfo, error) {
 	if !qm.config.Enabled {
 		return true, nil, nil
 	}
+	if qm.config.IsFractional() {
+		return qm.CheckQuotaFloat(ctx, identifier, float64(amount))
+	}
 
 	// Get current quota usage
 	info, err := qm.GetQuotaInfo(ctx, identifier)
@@ -43,45 +91,258 @@ func (qm *QuotaManager) CheckQuota(ctx context.Context, identifier string) (bool
 		return false, nil, fmt.Errorf("failed to get quota info: %w", err)
 	}
 
-	// Check if quota is exceeded
-	if info.Used >= info.Limit {
+	// Comparisons subtract from the bounded limit so a huge usage or amount
+	// can't overflow into a negative sum
+	amount = clampCount(amount)
+
+	// Counting-only quotas track usage without ever blocking
+	if !qm.config.IsEnforced() {
+		info.Overage = !info.Unlimited && info.Used > info.Limit-amount
+		return true, info, nil
+	}
+
+	// Check if quota including the overage allowance would be exceeded
+	if qm.exceedsLimit(identifier, info.Used, amount) {
 		return false, info, nil
 	}
 
+	// This request is allowed but lands beyond the regular limit
+	info.Overage = info.Used > info.Limit-amount
+
 	return true, info, nil
 }
 
-// ConsumeQuota consumes quota for a request
-func (qm *QuotaManager) ConsumeQuota(ctx context.Context, identifier string, amount int64) (*QuotaInfo, error) {
+// ReserveQuota atomically consumes amount units when they fit in the quota
+// (including the overage allowance). The usage is incremented first and rolled
+// back when over, so concurrent requests can never overshoot the quota; the
+// returned info reflects usage after the reservation.
+func (qm *QuotaManager) ReserveQuota(ctx context.Context, identifier string, amount int64) (bool, *QuotaInfo, error) {
 	if !qm.config.Enabled {
-		return nil, nil
+		return true, nil, nil
 	}
 
 	if amount <= 0 {
 		amount = 1
 	}
+	if qm.config.IsFractional() {
+		return qm.ReserveQuotaFloat(ctx, identifier, float64(amount))
+	}
 
-	// Generate quota key
-	periodKey := GetQuotaPeriodKey(qm.config.Period)
-	key := GetQuotaKey(identifier, periodKey)
+	amount = clampCount(amount)
+
+	if qm.async != nil {
+		return qm.reserveAsync(ctx, identifier, amount)
+	}
 
-	// Increment usage
+	// An amount that could never fit is refused before touching the counter
+	if qm.config.IsEnforced() && qm.exceedsLimit(identifier, 0, amount) {
+		info, err := qm.GetQuotaInfo(ctx, identifier)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to get quota info: %w", err)
+		}
+		return false, info, nil
+	}
+
+	key := GetQuotaKey(identifier, qm.periodKey())
+
+	newUsage, err := qm.incrementUsage(ctx, identifier, key, amount)
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Counting-only quotas track usage without ever blocking
+	if qm.config.IsEnforced() && newUsage > qm.config.LimitFor(identifier)+qm.config.OverageAllowance {
+		used, err := qm.redisClient.IncrBy(ctx, key, -amount)
+		qm.cache.invalidate(key)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to roll back quota reservation: %w", err)
+		}
+		return false, qm.withStoredReset(ctx, key, qm.quotaInfo(identifier, used)), nil
+	}
+
+	return true, qm.withStoredReset(ctx, key, qm.quotaInfo(identifier, newUsage)), nil
+}
+
+// incrementUsage increments the usage of identifier stored at key and makes
+// sure the key expires at the end of the current period. With CarryOverageDebt
+// the first increment of a period also adds the previous period's overage.
+func (qm *QuotaManager) incrementUsage(ctx context.Context, identifier, key string, amount int64) (int64, error) {
 	newUsage, err := qm.redisClient.IncrBy(ctx, key, amount)
+	qm.cache.invalidate(key)
+	if isOverflowError(err) || (err == nil && amount > 0 && newUsage < 0) {
+		// Redis refuses to overflow and other backends may wrap; either way
+		// the counter is saturated so it blocks instead of granting access
+		return qm.saturateUsage(ctx, key)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment quota: %w", err)
+	}
+
+	created := newUsage == amount
+	if created && qm.config.CarryOverageDebt {
+		if newUsage, err = qm.carryOverageDebt(ctx, identifier, key, newUsage); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := qm.ensureExpiry(ctx, key, created); err != nil {
+		return 0, err
+	}
+
+	return newUsage, nil
+}
+
+// saturateUsage pins the usage stored at key to maxSafeCount after an overflow
+func (qm *QuotaManager) saturateUsage(ctx context.Context, key string) (int64, error) {
+	qm.log.errorf("Quota usage at %s overflowed, clamping it to %d", key, int64(maxSafeCount))
+
+	err := qm.redisClient.Set(ctx, key, int64(maxSafeCount), 0)
+	qm.cache.invalidate(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to increment quota: %w", err)
+		return 0, fmt.Errorf("failed to clamp overflowed quota: %w", err)
 	}
 
-	// Set expiration if this is a new key
-	if newUsage == amount {
-		// Set expiration to the end of the current period
+	// Set dropped the expiration
+	if err := qm.ensureExpiry(ctx, key, false); err != nil {
+		return 0, err
+	}
+
+	return maxSafeCount, nil
+}
+
+// exceedsLimit reports whether used plus amount is beyond the limit of
+// identifier and the overage allowance, without computing the possibly
+// overflowing sum
+func (qm *QuotaManager) exceedsLimit(identifier string, used, amount int64) bool {
+	return used > qm.config.LimitFor(identifier)+qm.config.OverageAllowance-amount
+}
+
+// clampCount bounds an amount to maxSafeCount
+func clampCount(amount int64) int64 {
+	if amount > maxSafeCount {
+		return maxSafeCount
+	}
+	return amount
+}
+
+// isOverflowError reports whether err is Redis refusing an increment that
+// would overflow
+func isOverflowError(err error) bool {
+	var redisErr *RedisError
+	return errors.As(err, &redisErr) && strings.Contains(redisErr.Message, "overflow")
+}
+
+// carryOverageDebt adds the previous period's overage of identifier to the
+// usage stored at key. The debt is carried once per period: a marker key next
+// to the usage remembers it, so usage reset by an admin doesn't bring it back.
+func (qm *QuotaManager) carryOverageDebt(ctx context.Context, identifier, key string, usage int64) (int64, error) {
+	debt := qm.previousOverage(ctx, identifier)
+	if debt <= 0 {
+		return usage, nil
+	}
+
+	marker := key + ":carried"
+	carried, err := qm.redisClient.Incr(ctx, marker)
+	if err != nil {
+		return 0, fmt.Errorf("failed to carry overage debt: %w", err)
+	}
+	if carried > 1 {
+		return usage, nil
+	}
+	if err := qm.ensureExpiry(ctx, marker, true); err != nil {
+		return 0, err
+	}
+
+	usage, err = qm.redisClient.IncrBy(ctx, key, debt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to carry overage debt: %w", err)
+	}
+	return usage, nil
+}
+
+// previousOverage returns how far the previous period's usage of identifier
+// went beyond the limit, or 0 when it stayed within it or is gone
+func (qm *QuotaManager) previousOverage(ctx context.Context, identifier string) int64 {
+	previous := qm.periodKeyAt(qm.periodStart().Add(-time.Nanosecond))
+	usageStr, err := qm.redisClient.Get(ctx, GetQuotaKey(identifier, previous))
+	if err != nil {
+		return 0
+	}
+	used, err := strconv.ParseInt(usageStr, 10, 64)
+	limit := qm.config.LimitFor(identifier)
+	if err != nil || used <= limit {
+		return 0
+	}
+	return used - limit
+}
+
+// ensureExpiry makes sure a usage key expires at the end of the current period.
+// A created key always gets its expiration; an existing one only when it lost
+// its TTL (e.g. the first Expire failed or the usage was overwritten with Set).
+func (qm *QuotaManager) ensureExpiry(ctx context.Context, key string, created bool) error {
+	// Lifetime usage never expires
+	if qm.config.IsLifetime() {
+		return nil
+	}
+
+	needsExpire := created
+	if !needsExpire {
+		if ttl, err := qm.redisClient.TTL(ctx, key); err == nil && ttl == -1 {
+			needsExpire = true
+		}
+	}
+
+	if needsExpire {
+		// Set expiration to the end of the current period; carried debt is read
+		// during the next period, so the usage then lives until that one ends
 		resetTime := qm.getNextResetTime()
-		timeUntilReset := time.Until(resetTime)
+		if qm.config.CarryOverageDebt {
+			resetTime = qm.nextResetTimeAt(resetTime)
+		}
+		timeUntilReset := resetTime.Sub(qm.now())
+		if qm.config.IsRolling() {
+			// The window starts with the key
+			timeUntilReset, _ = qm.config.ParseQuotaPeriod()
+		}
 
-		if err := qm.redisClient.Expire(ctx, key, timeUntilReset); err != nil {
-			return nil, fmt.Errorf("failed to set quota expiration: %w", err)
+		existed, err := qm.redisClient.PExpire(ctx, key, timeUntilReset)
+		if err != nil {
+			return fmt.Errorf("failed to set quota expiration: %w", err)
+		}
+		if !existed {
+			return fmt.Errorf("failed to set quota expiration: key %s does not exist", key)
 		}
 	}
 
+	return nil
+}
+
+// ConsumeQuota consumes quota for a request
+func (qm *QuotaManager) ConsumeQuota(ctx context.Context, identifier string, amount int64) (*QuotaInfo, error) {
+	if !qm.config.Enabled {
+		return nil, nil
+	}
+
+	if amount <= 0 {
+		amount = 1
+	}
+	if qm.config.IsFractional() {
+		return qm.ConsumeQuotaFloat(ctx, identifier, float64(amount))
+	}
+	amount = clampCount(amount)
+
+	if qm.async != nil {
+		return qm.consumeAsync(ctx, identifier, amount)
+	}
+
+	// Generate quota key
+	periodKey := qm.periodKey()
+	key := GetQuotaKey(identifier, periodKey)
+
+	if _, err := qm.incrementUsage(ctx, identifier, key, amount); err != nil {
+		return nil, err
+	}
+
 	// Get updated quota info
 	info, err := qm.GetQuotaInfo(ctx, identifier)
 	if err != nil {
@@ -91,6 +352,41 @@ func (qm *QuotaManager) ConsumeQuota(ctx context.Context, identifier string, amo
 	return info, nil
 }
 
+// RefundQuota gives back previously consumed quota for a request that should not count
+func (qm *QuotaManager) RefundQuota(ctx context.Context, identifier string, amount int64) error {
+	if !qm.config.Enabled || amount <= 0 {
+		return nil
+	}
+	if qm.config.IsFractional() {
+		return qm.RefundQuotaFloat(ctx, identifier, float64(amount))
+	}
+
+	if qm.async != nil {
+		qm.async.add(qm.queuedUsage(identifier), -amount)
+		return nil
+	}
+
+	return qm.refundUsage(ctx, GetQuotaKey(identifier, qm.periodKey()), amount)
+}
+
+// refundUsage decrements the usage stored at key by amount, never below zero
+func (qm *QuotaManager) refundUsage(ctx context.Context, key string, amount int64) error {
+	newUsage, err := qm.redisClient.IncrBy(ctx, key, -amount)
+	qm.cache.invalidate(key)
+	if err != nil {
+		return fmt.Errorf("failed to refund quota: %w", err)
+	}
+
+	// Never let refunds push usage below zero, e.g. after a period rollover
+	if newUsage < 0 {
+		if _, err := qm.redisClient.IncrBy(ctx, key, -newUsage); err != nil {
+			return fmt.Errorf("failed to correct refunded quota: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // GetQuotaInfo retrieves current quota information
 func (qm *QuotaManager) GetQuotaInfo(ctx context.Context, identifier string) (*QuotaInfo, error) {
 	if !qm.config.Enabled {
@@ -104,10 +400,19 @@ func (qm *QuotaManager) GetQuotaInfo(ctx context.Context, identifier string) (*Q
 		}, nil
 	}
 
+	if qm.config.IsFractional() {
+		return qm.getFractionalQuotaInfo(ctx, identifier), nil
+	}
+
 	// Generate quota key
-	periodKey := GetQuotaPeriodKey(qm.config.Period)
+	periodKey := qm.periodKey()
 	key := GetQuotaKey(identifier, periodKey)
 
+	// Serve hot identifiers from the read cache when enabled
+	if cached, ok := qm.cache.get(key); ok {
+		return qm.withStoredReset(ctx, key, qm.quotaInfo(identifier, cached.(int64))), nil
+	}
+
 	// Get current usage
 	usageStr, err := qm.redisClient.Get(ctx, key)
 	var used int64 = 0
@@ -116,25 +421,100 @@ func (qm *QuotaManager) GetQuotaInfo(ctx context.Context, identifier string) (*Q
 			used = parsedUsage
 		}
 	}
+	qm.cache.set(key, used)
+
+	return qm.withStoredReset(ctx, key, qm.quotaInfo(identifier, used)), nil
+}
+
+// GetQuotaInfoMulti retrieves the quota information of several identifiers,
+// fetching every usage with a single MGET round trip
+func (qm *QuotaManager) GetQuotaInfoMulti(ctx context.Context, identifiers []string) (map[string]*QuotaInfo, error) {
+	infos := make(map[string]*QuotaInfo, len(identifiers))
+	if len(identifiers) == 0 {
+		return infos, nil
+	}
+
+	if !qm.config.Enabled {
+		for _, identifier := range identifiers {
+			infos[identifier], _ = qm.GetQuotaInfo(ctx, identifier)
+		}
+		return infos, nil
+	}
+
+	// All keys are built for the same period so the snapshot is consistent
+	periodKey := qm.periodKey()
+	keys := make([]string, len(identifiers))
+	for i, identifier := range identifiers {
+		keys[i] = GetQuotaKey(identifier, periodKey)
+	}
+
+	values, err := qm.redisClient.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota usages: %w", err)
+	}
+
+	// Missing or unparsable usage counts as zero, as in GetQuotaInfo
+	for i, identifier := range identifiers {
+		usageStr, _ := values[i].(string)
+		if qm.config.IsFractional() {
+			used, _ := strconv.ParseFloat(usageStr, 64)
+			infos[identifier] = qm.fractionalQuotaInfo(used)
+			continue
+		}
+		used, _ := strconv.ParseInt(usageStr, 10, 64)
+		infos[identifier] = qm.withStoredReset(ctx, keys[i], qm.quotaInfo(identifier, used))
+	}
+
+	return infos, nil
+}
+
+// quotaInfo builds the quota information of identifier for the given usage
+func (qm *QuotaManager) quotaInfo(identifier string, used int64) *QuotaInfo {
+	limit := qm.config.LimitFor(identifier)
 
-	// Calculate remaining quota
-	remaining := qm.config.Limit - used
-	if remaining < 0 {
+	// Calculate remaining quota, negative by the overage when signed
+	remaining := limit - used
+	if remaining < 0 && !qm.config.SignedRemaining {
 		remaining = 0
 	}
 
-	// Calculate reset time
+	// Calculate reset time; lifetime quotas never reset
 	resetTime := qm.getNextResetTime()
-	resetIn := time.Until(resetTime)
+	var resetIn time.Duration
+	if !resetTime.IsZero() {
+		resetIn = resetTime.Sub(qm.now())
+	}
+
+	// Counting-only quotas may have no limit to be over
+	unlimited := limit <= 0 && !qm.config.IsEnforced()
 
 	return &QuotaInfo{
-		Limit:     qm.config.Limit,
+		Limit:     limit,
 		Used:      used,
 		Remaining: remaining,
 		Period:    qm.config.Period,
 		ResetTime: resetTime,
 		ResetIn:   resetIn,
-	}, nil
+		Overage:   !unlimited && used > limit,
+		Unlimited: unlimited,
+	}
+}
+
+// withStoredReset sets the reset of a rolling quota from the remaining TTL of
+// its usage key, so the advertised reset matches the actual expiry. Before the
+// window starts the reset is a full period from now.
+func (qm *QuotaManager) withStoredReset(ctx context.Context, key string, info *QuotaInfo) *QuotaInfo {
+	if !qm.config.IsRolling() {
+		return info
+	}
+
+	ttl, err := qm.redisClient.TTL(ctx, key)
+	if err != nil || ttl <= 0 {
+		return info
+	}
+	info.ResetTime = qm.now().Add(ttl)
+	info.ResetIn = ttl
+	return info
 }
 
 // ResetQuota resets the quota for a specific identifier
@@ -144,11 +524,42 @@ func (qm *QuotaManager) ResetQuota(ctx context.Context, identifier string) error
 	}
 
 	// Generate quota key
-	periodKey := GetQuotaPeriodKey(qm.config.Period)
+	periodKey := qm.periodKey()
 	key := GetQuotaKey(identifier, periodKey)
 
 	// Reset to 0
-	return qm.redisClient.Set(ctx, key, 0, 0)
+	err := qm.redisClient.Set(ctx, key, 0, 0)
+	qm.cache.invalidate(key)
+	return err
+}
+
+// ResetAllPeriods deletes the usage of identifier in the current period and
+// the lookback periods before it (e.g. the last 7 days of a Daily quota) with
+// a single DEL
+func (qm *QuotaManager) ResetAllPeriods(ctx context.Context, identifier string, lookback int) error {
+	if !qm.config.Enabled {
+		return nil
+	}
+	if lookback < 0 {
+		return fmt.Errorf("lookback must not be negative")
+	}
+
+	now := qm.now()
+	keys := []string{GetQuotaKey(identifier, qm.periodKeyAt(now))}
+	// Rolling and lifetime quotas have a single key
+	for i := 0; i < lookback && !qm.config.IsRolling() && !qm.config.IsLifetime(); i++ {
+		now = qm.periodStartAt(now).Add(-time.Nanosecond)
+		keys = append(keys, GetQuotaKey(identifier, qm.periodKeyAt(now)))
+	}
+
+	_, err := qm.redisClient.Del(ctx, keys...)
+	for _, key := range keys {
+		qm.cache.invalidate(key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset quota periods: %w", err)
+	}
+	return nil
 }
 
 // GetUsageHistory returns usage history for different periods
@@ -179,15 +590,25 @@ func (qm *QuotaManager) GetUsageHistory(ctx context.Context, identifier string,
 	return history, nil
 }
 
-// getNextResetTime calculates when the quota will reset next
+// getNextResetTime calculates when the quota will reset next, or returns the
+// zero time for lifetime quotas, which never reset
 func (qm *QuotaManager) getNextResetTime() time.Time {
-	now := time.Now()
+	return qm.nextResetTimeAt(qm.now())
+}
 
+// nextResetTimeAt returns when the quota period containing now ends
+func (qm *QuotaManager) nextResetTimeAt(now time.Time) time.Time {
 	switch qm.config.Period {
 	case "Daily":
 		// Reset at midnight
 		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
 	case "Weekly":
+		if qm.config.CarryOverageDebt {
+			// Carried debt is read from the previous ISO week's key, so the
+			// period must end at Monday midnight, when the key changes
+			start := qm.periodStartAt(now)
+			return time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, now.Location())
+		}
 		// Reset at Sunday midnight
 		daysUntilSunday := (7 - int(now.Weekday())) % 7
 		if daysUntilSunday == 0 {
@@ -195,14 +616,98 @@ func (qm *QuotaManager) getNextResetTime() time.Time {
 		}
 		return time.Date(now.Year(), now.Month(), now.Day()+daysUntilSunday, 0, 0, 0, 0, now.Location())
 	case "Monthly":
+		if qm.config.ResetDay > 1 {
+			// Reset at the next occurrence of the billing day
+			next := monthlyResetDate(now.Year(), now.Month(), qm.config.ResetDay, now.Location())
+			if !now.Before(next) {
+				next = monthlyResetDate(now.Year(), now.Month()+1, qm.config.ResetDay, now.Location())
+			}
+			return next
+		}
 		// Reset at the first day of next month
 		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	case "Lifetime":
+		return time.Time{}
 	default:
+		// Rolling windows not started yet last a full period from now
+		if period, err := qm.config.ParseQuotaPeriod(); err == nil {
+			return now.Add(period)
+		}
 		// Default to daily
 		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
 	}
 }
 
+// periodKey returns the key suffix identifying the current quota period
+func (qm *QuotaManager) periodKey() string {
+	return qm.periodKeyAt(qm.now())
+}
+
+// periodKeyAt returns the key suffix identifying the quota period containing now
+func (qm *QuotaManager) periodKeyAt(now time.Time) string {
+	if qm.config.Period == "Monthly" && qm.config.ResetDay > 1 {
+		// Key billing cycles by their start date rather than the calendar month
+		start := monthlyResetDate(now.Year(), now.Month(), qm.config.ResetDay, now.Location())
+		if now.Before(start) {
+			start = monthlyResetDate(now.Year(), now.Month()-1, qm.config.ResetDay, now.Location())
+		}
+		return fmt.Sprintf("%s-C%02d", start.Format("2006-01"), qm.config.ResetDay)
+	}
+	if qm.config.IsRolling() {
+		// One key per window, which ends when the key expires
+		return "R" + qm.config.Period
+	}
+
+	return quotaPeriodKey(qm.config.Period, now)
+}
+
+// periodStart returns when the current quota period began
+func (qm *QuotaManager) periodStart() time.Time {
+	return qm.periodStartAt(qm.now())
+}
+
+// periodStartAt returns when the quota period containing now began
+func (qm *QuotaManager) periodStartAt(now time.Time) time.Time {
+	switch qm.config.Period {
+	case "Weekly":
+		// Periods start at Monday midnight (the reset after Sunday)
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		return time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, now.Location())
+	case "Monthly":
+		if qm.config.ResetDay > 1 {
+			start := monthlyResetDate(now.Year(), now.Month(), qm.config.ResetDay, now.Location())
+			if now.Before(start) {
+				start = monthlyResetDate(now.Year(), now.Month()-1, qm.config.ResetDay, now.Location())
+			}
+			return start
+		}
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	case "Lifetime":
+		return time.Time{}
+	default:
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+}
+
+// now returns the current time in the quota timezone
+func (qm *QuotaManager) now() time.Time {
+	if qm.clock != nil {
+		return qm.clock().In(qm.location)
+	}
+	return time.Now().In(qm.location)
+}
+
+// monthlyResetDate returns midnight of the given day in the month, clamped to the
+// last day for months shorter than day
+func monthlyResetDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, loc)
+}
+
 // IsQuotaEnabled checks if quota is enabled
 func (qm *QuotaManager) IsQuotaEnabled() bool {
 	return qm.config.Enabled
@@ -223,13 +728,18 @@ func (qm *QuotaManager) SetQuotaUsage(ctx context.Context, identifier string, us
 	if !qm.config.Enabled {
 		return nil
 	}
+	if usage < 0 {
+		return fmt.Errorf("quota usage must not be negative")
+	}
 
 	// Generate quota key
-	periodKey := GetQuotaPeriodKey(qm.config.Period)
+	periodKey := qm.periodKey()
 	key := GetQuotaKey(identifier, periodKey)
 
 	// Set usage
-	return qm.redisClient.Set(ctx, key, usage, 0)
+	err := qm.redisClient.Set(ctx, key, usage, 0)
+	qm.cache.invalidate(key)
+	return err
 }
 
 // GetActiveQuotaKeys returns all active quota keys (for monitoring/admin purposes)
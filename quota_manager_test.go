@@ -0,0 +1,550 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newClockedQuotaManager returns a quota manager of config whose clock reads now
+func newClockedQuotaManager(config QuotaSettings, now time.Time) *QuotaManager {
+	config.Enabled = true
+	if config.Limit == 0 && config.FractionalLimit == 0 {
+		config.Limit = 10
+	}
+	if config.Timezone == "" {
+		config.Timezone = "UTC"
+	}
+	qm := NewQuotaManager(NewMemoryRedisClient(), config)
+	qm.clock = func() time.Time { return now }
+	return qm
+}
+
+func date(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestMonthlyResetDay(t *testing.T) {
+	tests := []struct {
+		name      string
+		resetDay  int
+		now       time.Time
+		wantReset time.Time
+		wantKey   string
+	}{
+		{"before the billing day", 15, date(2024, 1, 10, 12), date(2024, 1, 15, 0), "2023-12-C15"},
+		{"on the billing day", 15, date(2024, 1, 15, 0), date(2024, 2, 15, 0), "2024-01-C15"},
+		{"day 31 clamps to a leap February", 31, date(2024, 2, 20, 12), date(2024, 2, 29, 0), "2024-01-C31"},
+		{"day 31 clamps to February", 31, date(2023, 2, 20, 12), date(2023, 2, 28, 0), "2023-01-C31"},
+		{"clamped cycle starts at month end", 31, date(2024, 2, 29, 1), date(2024, 3, 31, 0), "2024-02-C31"},
+		{"day 30 in April", 30, date(2024, 4, 30, 0), date(2024, 5, 30, 0), "2024-04-C30"},
+		{"calendar month by default", 0, date(2024, 2, 20, 12), date(2024, 3, 1, 0), "2024-02"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			qm := newClockedQuotaManager(QuotaSettings{Period: "Monthly", ResetDay: tc.resetDay}, tc.now)
+			if got := qm.getNextResetTime(); !got.Equal(tc.wantReset) {
+				t.Errorf("next reset %v, want %v", got, tc.wantReset)
+			}
+			if got := qm.periodKey(); got != tc.wantKey {
+				t.Errorf("period key %q, want %q", got, tc.wantKey)
+			}
+			if start := qm.periodStart(); !start.Before(qm.getNextResetTime()) || start.After(tc.now) {
+				t.Errorf("period start %v outside the cycle containing %v", start, tc.now)
+			}
+		})
+	}
+}
+
+func TestValidateResetDay(t *testing.T) {
+	for _, tc := range []struct {
+		period   string
+		resetDay int
+		valid    bool
+	}{
+		{"Monthly", 15, true},
+		{"Monthly", 31, true},
+		{"Monthly", 32, false},
+		{"Monthly", -1, false},
+		{"Daily", 15, false},
+	} {
+		config := validConfig()
+		config.Identifiers[0].Quota.Period = tc.period
+		config.Identifiers[0].Quota.ResetDay = tc.resetDay
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s ResetDay %d: Validate() = %v", tc.period, tc.resetDay, err)
+		}
+	}
+}
+
+func TestOverageAllowance(t *testing.T) {
+	ctx := context.Background()
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Daily", Limit: 3, OverageAllowance: 2}, date(2024, 1, 10, 12))
+
+	for i := int64(1); i <= 6; i++ {
+		// CheckQuota predicts the decision ReserveQuota takes
+		checked, _, err := qm.CheckQuota(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed, info, err := qm.ReserveQuota(ctx, "u1", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checked != allowed {
+			t.Fatalf("request %d: CheckQuota %v but ReserveQuota %v", i, checked, allowed)
+		}
+
+		switch {
+		case i <= 3: // Up to the limit
+			if !allowed || info.Overage {
+				t.Fatalf("request %d: allowed %v, overage %v; want allowed without overage", i, allowed, info.Overage)
+			}
+		case i <= 5: // Within the allowance
+			if !allowed || !info.Overage || info.Remaining != 0 {
+				t.Fatalf("request %d: allowed %v, overage %v, remaining %d; want allowed overage", i, allowed, info.Overage, info.Remaining)
+			}
+		default: // Beyond the allowance
+			if allowed || info.Used != 5 {
+				t.Fatalf("request %d: allowed %v with %d used; want blocked at 5", i, allowed, info.Used)
+			}
+		}
+	}
+}
+
+func TestCountingOnlyQuotaNeverBlocks(t *testing.T) {
+	ctx := context.Background()
+	enforce := false
+
+	for _, limit := range []int64{0, 3} {
+		qm := NewQuotaManager(NewMemoryRedisClient(), QuotaSettings{Enabled: true, Limit: limit, Period: "Daily", Enforce: &enforce})
+		for i := int64(1); i <= 5; i++ {
+			if checked, _, err := qm.CheckQuota(ctx, "u1"); !checked || err != nil {
+				t.Fatalf("limit %d, request %d: CheckQuota %v, %v", limit, i, checked, err)
+			}
+			allowed, info, err := qm.ReserveQuota(ctx, "u1", 1)
+			if !allowed || err != nil {
+				t.Fatalf("limit %d, request %d: blocked (%v)", limit, i, err)
+			}
+			if info.Used != i {
+				t.Fatalf("limit %d, request %d: used %d", limit, i, info.Used)
+			}
+			if wantOverage := limit > 0 && i > limit; info.Overage != wantOverage {
+				t.Fatalf("limit %d, request %d: overage %v, want %v", limit, i, info.Overage, wantOverage)
+			}
+			if info.Unlimited != (limit == 0) {
+				t.Fatalf("limit %d: unlimited %v", limit, info.Unlimited)
+			}
+		}
+	}
+}
+
+func TestConsumeQuotaRestoresMissingTTL(t *testing.T) {
+	ctx := context.Background()
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Daily"}, date(2024, 1, 10, 12))
+	key := GetQuotaKey("u1", qm.periodKey())
+
+	// Usage written without an expiry, as after a failed first Expire
+	if err := qm.SetQuotaUsage(ctx, "u1", 3); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := qm.redisClient.TTL(ctx, key); ttl != -1 {
+		t.Fatalf("TTL before consuming %v, want none", ttl)
+	}
+
+	info, err := qm.ConsumeQuota(ctx, "u1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Used != 4 {
+		t.Fatalf("used %d, want 4", info.Used)
+	}
+	ttl, err := qm.redisClient.TTL(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 11*time.Hour || ttl > 12*time.Hour {
+		t.Fatalf("TTL after consuming %v, want the 12h left in the day", ttl)
+	}
+}
+
+func TestSignedRemaining(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		signed bool
+		want   int64
+	}{{false, 0}, {true, -15}} {
+		settings := QuotaSettings{Period: "Daily", Limit: 10, OverageAllowance: 20, SignedRemaining: tc.signed}
+		qm := newClockedQuotaManager(settings, date(2024, 1, 10, 12))
+		if err := qm.SetQuotaUsage(ctx, "u1", 25); err != nil {
+			t.Fatal(err)
+		}
+		info, err := qm.GetQuotaInfo(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Remaining != tc.want || !info.Overage {
+			t.Errorf("signed %v: remaining %d, overage %v; want %d", tc.signed, info.Remaining, info.Overage, tc.want)
+		}
+	}
+}
+
+func TestWeeklyResetMatchesPeriodKey(t *testing.T) {
+	tests := []struct {
+		now       time.Time
+		wantStart time.Time
+		wantKey   string
+	}{
+		{date(2024, 1, 1, 0), date(2024, 1, 1, 0), "2024-W01"}, // Monday
+		{date(2024, 1, 3, 12), date(2024, 1, 1, 0), "2024-W01"},
+		{date(2024, 1, 7, 23), date(2024, 1, 1, 0), "2024-W01"}, // Sunday
+		{date(2024, 12, 30, 1), date(2024, 12, 30, 0), "2025-W01"},
+		{date(2021, 1, 3, 12), date(2020, 12, 28, 0), "2020-W53"},
+	}
+	for _, tc := range tests {
+		// Carrying debt aligns the reset with the ISO week of the key
+		qm := newClockedQuotaManager(QuotaSettings{Period: "Weekly", CarryOverageDebt: true}, tc.now)
+		start, reset := qm.periodStart(), qm.getNextResetTime()
+
+		if !start.Equal(tc.wantStart) || !reset.Equal(tc.wantStart.AddDate(0, 0, 7)) {
+			t.Errorf("%v: period %v to %v, want the week from %v", tc.now, start, reset, tc.wantStart)
+		}
+		if reset.Weekday() != time.Monday {
+			t.Errorf("%v: reset on %v, want Monday", tc.now, reset.Weekday())
+		}
+		// The key changes exactly at the reset
+		if key := qm.periodKey(); key != tc.wantKey || qm.periodKeyAt(start) != key || qm.periodKeyAt(reset.Add(-time.Nanosecond)) != key {
+			t.Errorf("%v: key %q does not span the period, want %q", tc.now, key, tc.wantKey)
+		}
+		if qm.periodKeyAt(reset) == tc.wantKey {
+			t.Errorf("%v: key %q still used after the reset", tc.now, tc.wantKey)
+		}
+	}
+}
+
+func TestWeeklyResetWithoutDebtOnSunday(t *testing.T) {
+	for now, want := range map[time.Time]time.Time{
+		date(2024, 1, 3, 12): date(2024, 1, 7, 0),
+		date(2024, 1, 7, 12): date(2024, 1, 14, 0),
+	} {
+		qm := newClockedQuotaManager(QuotaSettings{Period: "Weekly"}, now)
+		if reset := qm.getNextResetTime(); !reset.Equal(want) {
+			t.Errorf("%v: reset %v, want %v", now, reset, want)
+		}
+	}
+}
+
+func TestCarryOverageDebt(t *testing.T) {
+	ctx := context.Background()
+	now := date(2024, 1, 3, 12) // Wednesday
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Weekly", Limit: 10, OverageAllowance: 20, CarryOverageDebt: true}, now)
+	qm.clock = func() time.Time { return now }
+
+	if _, err := qm.ConsumeQuota(ctx, "u1", 20); err != nil {
+		t.Fatal(err)
+	}
+	// The usage outlives its week by one week so the next one can read the debt
+	ttl, _ := qm.redisClient.TTL(ctx, GetQuotaKey("u1", qm.periodKey()))
+	if want := (4*24 + 12 + 7*24) * time.Hour; ttl <= want-time.Minute || ttl > want {
+		t.Fatalf("TTL of usage with debt %v, want %v", ttl, want)
+	}
+
+	// A client that went 10 over starts the next week at 10 used
+	now = date(2024, 1, 8, 0)
+	info, err := qm.ConsumeQuota(ctx, "u1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Used != 11 {
+		t.Fatalf("used %d after the reset, want the 10 carried plus 1", info.Used)
+	}
+
+	// The next week carries only the 1 this week went over
+	now = date(2024, 1, 15, 0)
+	if info, _ := qm.ConsumeQuota(ctx, "u1", 1); info.Used != 2 {
+		t.Fatalf("used %d two weeks later, want 2", info.Used)
+	}
+}
+
+func TestCarryOverageDebtExpiresAfterNextPeriod(t *testing.T) {
+	ctx := context.Background()
+	// January's usage must last through February, which is shorter
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Monthly", Limit: 10, CarryOverageDebt: true}, date(2024, 1, 15, 12))
+	if _, err := qm.ConsumeQuota(ctx, "u1", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ttl, _ := qm.redisClient.TTL(ctx, GetQuotaKey("u1", qm.periodKey()))
+	if want := date(2024, 3, 1, 0).Sub(date(2024, 1, 15, 12)); ttl <= want-time.Minute || ttl > want {
+		t.Fatalf("TTL of usage with debt %v, want %v until the end of February", ttl, want)
+	}
+}
+
+func TestCarryOverageDebtAfterReset(t *testing.T) {
+	ctx := context.Background()
+	for name, reset := range map[string]func(*QuotaManager) error{
+		"ResetQuota":      func(qm *QuotaManager) error { return qm.ResetQuota(ctx, "u1") },
+		"ResetAllPeriods": func(qm *QuotaManager) error { return qm.ResetAllPeriods(ctx, "u1", 0) },
+	} {
+		now := date(2024, 1, 3, 12)
+		qm := newClockedQuotaManager(QuotaSettings{Period: "Weekly", Limit: 10, OverageAllowance: 20, CarryOverageDebt: true}, now)
+		qm.clock = func() time.Time { return now }
+		if _, err := qm.ConsumeQuota(ctx, "u1", 20); err != nil {
+			t.Fatal(err)
+		}
+
+		// The next week starts with the debt, which a reset clears for good
+		now = date(2024, 1, 8, 0)
+		if info, _ := qm.ConsumeQuota(ctx, "u1", 1); info.Used != 11 {
+			t.Fatalf("%s: used %d before the reset, want the 10 carried plus 1", name, info.Used)
+		}
+		if err := reset(qm); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := qm.ConsumeQuota(ctx, "u1", 1); info.Used != 1 {
+			t.Fatalf("%s: used %d after the reset, want 1 without the debt", name, info.Used)
+		}
+	}
+}
+
+func TestGetQuotaInfoMulti(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{Address: server.addr})
+	qm := NewQuotaManager(client, QuotaSettings{Enabled: true, Limit: 10, Period: "Daily"})
+
+	qm.ConsumeQuota(ctx, "u1", 3)
+	qm.ConsumeQuota(ctx, "u2", 10)
+	before := len(server.commands())
+
+	infos, err := qm.GetQuotaInfoMulti(ctx, []string{"u1", "u2", "u3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for identifier, want := range map[string]int64{"u1": 3, "u2": 10, "u3": 0} {
+		info := infos[identifier]
+		if info == nil || info.Used != want || info.Remaining != 10-want {
+			t.Errorf("%s: %+v, want %d used", identifier, info, want)
+		}
+	}
+
+	gets := 0
+	for _, command := range server.commands()[before:] {
+		if strings.HasPrefix(command, "GET ") || strings.HasPrefix(command, "MGET ") {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Fatalf("%d reads for three identifiers, want one MGET", gets)
+	}
+
+	if infos, err := qm.GetQuotaInfoMulti(ctx, nil); err != nil || len(infos) != 0 {
+		t.Fatalf("no identifiers = %v, %v", infos, err)
+	}
+}
+
+func TestValueLimits(t *testing.T) {
+	ctx := context.Background()
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Daily", Limit: 2, ValueLimits: map[string]int64{"sk-alice": 4}}, date(2024, 1, 10, 12))
+
+	for identifier, limit := range map[string]int64{"sk-alice": 4, "sk-bob": 2} {
+		for i := int64(1); i <= limit+1; i++ {
+			allowed, info, err := qm.ReserveQuota(ctx, identifier, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != (i <= limit) || info.Limit != limit {
+				t.Fatalf("%s request %d: allowed %v with limit %d, want the limit of %d", identifier, i, allowed, info.Limit, limit)
+			}
+		}
+		if info, _ := qm.GetQuotaInfo(ctx, identifier); info.Limit != limit || info.Remaining != 0 {
+			t.Fatalf("%s: GetQuotaInfo limit %d, remaining %d", identifier, info.Limit, info.Remaining)
+		}
+	}
+}
+
+func TestServeHTTPValueLimits(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Type = "Query"
+		c.Identifiers[0].Name = "user"
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.ValueLimits = map[string]int64{"sk-alice": 100}
+	})
+	serve := func(user string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user="+user, nil))
+		return rw
+	}
+
+	if got := serve("sk-alice").Header().Get("X-Quota-Limit"); got != "100" {
+		t.Fatalf("overridden value: X-Quota-Limit %q, want 100", got)
+	}
+	if got := serve("sk-bob").Header().Get("X-Quota-Limit"); got != "5" {
+		t.Fatalf("other value: X-Quota-Limit %q, want the default 5", got)
+	}
+}
+
+func TestRollingPeriodResetMatchesTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	client := NewMemoryRedisClient()
+	client.SetClock(clock.Now)
+	qm := NewQuotaManager(client, QuotaSettings{Enabled: true, Limit: 10, Period: "6h"})
+	qm.clock = clock.Now
+
+	// Before the window starts it would last a full period
+	if info, _ := qm.GetQuotaInfo(ctx, "u1"); !info.ResetTime.Equal(clock.Now().Add(6 * time.Hour)) {
+		t.Fatalf("reset before the window %v, want 6h from now", info.ResetTime)
+	}
+
+	qm.ConsumeQuota(ctx, "u1", 1)
+	clock.Advance(2 * time.Hour)
+	qm.ConsumeQuota(ctx, "u1", 1)
+
+	// The window started with the first request, not the wall clock
+	info, err := qm.GetQuotaInfo(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, _ := client.TTL(ctx, GetQuotaKey("u1", qm.periodKey()))
+	if ttl != 4*time.Hour || !info.ResetTime.Equal(clock.Now().Add(ttl)) {
+		t.Fatalf("reset %v with TTL %v, want the key's expiry 4h from now", info.ResetTime, ttl)
+	}
+	if info.Used != 2 {
+		t.Fatalf("used %d, want 2", info.Used)
+	}
+
+	clock.Advance(4 * time.Hour)
+	if info, _ := qm.GetQuotaInfo(ctx, "u1"); info.Used != 0 {
+		t.Fatalf("used %d after the window, want 0", info.Used)
+	}
+}
+
+func TestValidateRollingPeriod(t *testing.T) {
+	for name, quota := range map[string]QuotaSettings{
+		"too short":  {Enabled: true, Limit: 10, Period: "500ms"},
+		"fractional": {Enabled: true, FractionalLimit: 1.5, Period: "6h"},
+		"debt":       {Enabled: true, Limit: 10, Period: "6h", CarryOverageDebt: true, OverageAllowance: 1},
+	} {
+		config := validConfig()
+		config.Identifiers[0].Quota = quota
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: rolling quota accepted", name)
+		}
+	}
+}
+
+func TestResetAllPeriods(t *testing.T) {
+	ctx := context.Background()
+	now := date(2024, 3, 2, 12)
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Daily"}, now)
+
+	// Usage of the last five days, including across the month boundary
+	for day := 0; day < 5; day++ {
+		qm.redisClient.Set(ctx, GetQuotaKey("u1", quotaPeriodKey("Daily", now.AddDate(0, 0, -day))), "3", 0)
+	}
+	qm.redisClient.Set(ctx, GetQuotaKey("u2", qm.periodKey()), "3", 0)
+
+	if err := qm.ResetAllPeriods(ctx, "u1", 3); err != nil {
+		t.Fatal(err)
+	}
+	for day, want := range []int64{0, 0, 0, 0, 1} {
+		key := GetQuotaKey("u1", quotaPeriodKey("Daily", now.AddDate(0, 0, -day)))
+		if n, _ := qm.redisClient.Exists(ctx, key); n != want {
+			t.Errorf("%s exists %d, want %d", key, n, want)
+		}
+	}
+	if n, _ := qm.redisClient.Exists(ctx, GetQuotaKey("u2", qm.periodKey())); n != 1 {
+		t.Fatal("another identifier's usage was reset")
+	}
+
+	if err := qm.ResetAllPeriods(ctx, "u1", -1); err == nil {
+		t.Fatal("negative lookback accepted")
+	}
+}
+
+func TestResetAllPeriodsSingleKeyPeriods(t *testing.T) {
+	ctx := context.Background()
+	for _, period := range []string{"6h", "Lifetime"} {
+		qm := newClockedQuotaManager(QuotaSettings{Period: period}, date(2024, 3, 2, 12))
+		qm.ConsumeQuota(ctx, "u1", 4)
+		if err := qm.ResetAllPeriods(ctx, "u1", 5); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := qm.GetQuotaInfo(ctx, "u1"); info.Used != 0 {
+			t.Errorf("%s: used %d after the reset, want 0", period, info.Used)
+		}
+	}
+}
+
+func TestLifetimeQuotaNeverResets(t *testing.T) {
+	ctx := context.Background()
+	now := date(2024, time.March, 1, 12)
+	qm := newClockedQuotaManager(QuotaSettings{Period: "Lifetime", Limit: 5}, now)
+
+	// Usage accumulates across days, months and years
+	for i, at := range []time.Time{now, now.AddDate(0, 0, 1), now.AddDate(0, 2, 0), now.AddDate(3, 0, 0)} {
+		qm.clock = func() time.Time { return at }
+		allowed, info, err := qm.ReserveQuota(ctx, "trial", 1)
+		if err != nil || !allowed || info.Used != int64(i+1) {
+			t.Fatalf("request %d on %s: allowed %v, used %v, %v", i+1, at.Format("2006-01-02"), allowed, info, err)
+		}
+		if !info.ResetTime.IsZero() || info.ResetIn != 0 {
+			t.Fatalf("reset %s in %s, want never", info.ResetTime, info.ResetIn)
+		}
+	}
+
+	key := GetQuotaKey("trial", qm.periodKey())
+	if ttl, err := qm.redisClient.TTL(ctx, key); err != nil || ttl != -1 {
+		t.Fatalf("lifetime key TTL %s, %v; want none", ttl, err)
+	}
+
+	qm.ReserveQuota(ctx, "trial", 1)
+	qm.clock = func() time.Time { return now.AddDate(10, 0, 0) }
+	if allowed, _, err := qm.ReserveQuota(ctx, "trial", 1); err != nil || allowed {
+		t.Fatalf("exhausted lifetime quota ten years on: allowed %v, %v", allowed, err)
+	}
+}
+
+func TestServeHTTPLifetimeQuotaHeaders(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.QuotaResetDateHeader = true
+		c.StructuredErrors = true
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.Period = "Lifetime"
+		c.Identifiers[0].Quota.Limit = 1
+	})
+
+	rw := serveAs(handler, "u1")
+	if rw.Header().Get("X-Quota-Reset") != "never" || rw.Header().Get("X-Quota-Reset-Date") != "never" {
+		t.Fatalf("X-Quota-Reset %q, X-Quota-Reset-Date %q; want never", rw.Header().Get("X-Quota-Reset"), rw.Header().Get("X-Quota-Reset-Date"))
+	}
+
+	rw = serveAs(handler, "u1")
+	var envelope structuredError
+	if err := json.Unmarshal(rw.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if rw.Code != http.StatusForbidden || envelope.Reset != 0 || envelope.RetryAfter != 0 {
+		t.Fatalf("status %d, reset %d, retry_after %d; want 403 without a reset", rw.Code, envelope.Reset, envelope.RetryAfter)
+	}
+}
+
+func TestValidateLifetimeQuota(t *testing.T) {
+	config := validConfig()
+	config.Identifiers[0].Quota.Period = "Lifetime"
+	if err := config.Validate(); err != nil {
+		t.Fatalf("lifetime quota rejected: %v", err)
+	}
+	config.Identifiers[0].Quota.CarryOverageDebt = true
+	if err := config.Validate(); err == nil {
+		t.Fatal("lifetime quota carrying overage debt accepted")
+	}
+}
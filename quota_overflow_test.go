@@ -0,0 +1,69 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestReserveQuotaSaturatesInsteadOfWrapping(t *testing.T) {
+	ctx := context.Background()
+	enforce := false
+	qm := NewQuotaManager(NewMemoryRedisClient(), QuotaSettings{Enabled: true, Period: "Daily", Enforce: &enforce})
+	key := GetQuotaKey("u1", qm.periodKey())
+	qm.redisClient.Set(ctx, key, strconv.FormatInt(math.MaxInt64-5, 10), 0)
+
+	allowed, info, err := qm.ReserveQuota(ctx, "u1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed || info.Used != maxSafeCount {
+		t.Fatalf("allowed %v with %d used, want the counter clamped to %d", allowed, info.Used, int64(maxSafeCount))
+	}
+	if ttl, _ := qm.redisClient.TTL(ctx, key); ttl <= 0 {
+		t.Fatalf("TTL of the clamped counter %v, want the period's expiry", ttl)
+	}
+}
+
+func TestReserveQuotaNearMaxInt64Blocks(t *testing.T) {
+	ctx := context.Background()
+	qm := NewQuotaManager(NewMemoryRedisClient(), QuotaSettings{Enabled: true, Limit: 10, Period: "Daily"})
+	key := GetQuotaKey("u1", qm.periodKey())
+
+	// A cost that could never fit leaves the counter untouched
+	allowed, _, err := qm.ReserveQuota(ctx, "u1", math.MaxInt64)
+	if err != nil || allowed {
+		t.Fatalf("huge cost: allowed %v, %v", allowed, err)
+	}
+	if n, _ := qm.redisClient.Exists(ctx, key); n != 0 {
+		t.Fatal("a refused cost touched the counter")
+	}
+
+	// A counter pushed near the boundary blocks instead of wrapping negative
+	qm.redisClient.Set(ctx, key, strconv.FormatInt(math.MaxInt64-1, 10), 0)
+	allowed, info, err := qm.ReserveQuota(ctx, "u1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed || info.Used < 0 || info.Remaining != 0 {
+		t.Fatalf("allowed %v with %d used and %d remaining, want blocked", allowed, info.Used, info.Remaining)
+	}
+}
+
+func TestValidateSafeCounts(t *testing.T) {
+	for name, mutate := range map[string]func(*IdentifierConfig){
+		"limit":     func(ic *IdentifierConfig) { ic.Quota.Limit = maxSafeCount + 1 },
+		"allowance": func(ic *IdentifierConfig) { ic.Quota.Limit, ic.Quota.OverageAllowance = maxSafeCount, 1 },
+		"value limit": func(ic *IdentifierConfig) {
+			ic.Quota.ValueLimits = map[string]int64{"u1": maxSafeCount + 1}
+		},
+		"max cost": func(ic *IdentifierConfig) { ic.MaxCost = maxSafeCount + 1 },
+	} {
+		config := validConfig()
+		mutate(&config.Identifiers[0])
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s beyond %d accepted", name, int64(maxSafeCount))
+		}
+	}
+}
@@ -0,0 +1,669 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration passing Validate, with one Header
+// identifier X-User-ID=u1 allowing 2 requests per minute and 5 per day
+func validConfig() *Config {
+	config := CreateConfig()
+	config.Persistence.Redis.Address = "localhost:6379"
+	config.Identifiers = []IdentifierConfig{{
+		Type:      "Header",
+		Name:      "X-User-ID",
+		Value:     "u1",
+		RateLimit: RateLimitConfig{Enabled: true, Rate: 2, Period: "1m"},
+		Quota:     QuotaSettings{Enabled: true, Limit: 5, Period: "Daily"},
+	}}
+	return config
+}
+
+// newTestPlugin creates a plugin of validConfig backed by server, proxying to
+// a backend answering 200; mutate adjusts the configuration first
+func newTestPlugin(t testing.TB, server *testRedisServer, mutate func(*Config)) http.Handler {
+	t.Helper()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	return newTestPluginNext(t, server, next, mutate)
+}
+
+// newTestPluginNext is newTestPlugin proxying to next
+func newTestPluginNext(t testing.TB, server *testRedisServer, next http.Handler, mutate func(*Config)) http.Handler {
+	t.Helper()
+	config := validConfig()
+	config.Persistence.Redis.Address = server.addr
+	if mutate != nil {
+		mutate(config)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	handler, err := New(ctx, next, config, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+// serveAs sends a GET / with X-User-ID set to user
+func serveAs(handler http.Handler, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if user != "" {
+		req.Header.Set("X-User-ID", user)
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestServeHTTPRateLimit(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, nil)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rw := serveAs(handler, "u1"); rw.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, rw.Code, want)
+		}
+	}
+}
+
+func TestServeHTTPQuota(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+	})
+
+	for i := 0; i < 5; i++ {
+		if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, rw.Code)
+		}
+	}
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("request over quota: status %d, want 403", rw.Code)
+	}
+	if got := rw.Header().Get("X-Quota-Remaining"); got != "0" {
+		t.Fatalf("X-Quota-Remaining = %q, want 0", got)
+	}
+}
+
+func TestServeHTTPNoIdentifier(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, nil)
+
+	if rw := serveAs(handler, ""); rw.Code != http.StatusForbidden {
+		t.Fatalf("status without identifier %d, want 403", rw.Code)
+	}
+}
+
+func TestServeHTTPRateLimitUsedHeader(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 10, Period: "1h"}
+	})
+
+	for i := 1; i <= 3; i++ {
+		rw := serveAs(handler, "u1")
+		used, _ := strconv.Atoi(rw.Header().Get("X-RateLimit-Used"))
+		remaining, _ := strconv.Atoi(rw.Header().Get("X-RateLimit-Remaining"))
+		if used != i || used+remaining != 10 {
+			t.Fatalf("request %d: X-RateLimit-Used %d, X-RateLimit-Remaining %d", i, used, remaining)
+		}
+	}
+}
+
+func TestServeHTTPOverageHeader(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota = QuotaSettings{Enabled: true, Limit: 1, OverageAllowance: 1, Period: "Daily"}
+	})
+
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Overage") != "" {
+		t.Fatalf("within the limit: status %d, X-Quota-Overage %q", rw.Code, rw.Header().Get("X-Quota-Overage"))
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Overage") != "true" {
+		t.Fatalf("within the allowance: status %d, X-Quota-Overage %q", rw.Code, rw.Header().Get("X-Quota-Overage"))
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusForbidden {
+		t.Fatalf("beyond the allowance: status %d, want 403", rw.Code)
+	}
+}
+
+func TestServeHTTPCountingOnly(t *testing.T) {
+	server := newTestRedisServer(t)
+	enforce := false
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota = QuotaSettings{Enabled: true, Period: "Daily", Enforce: &enforce}
+	})
+
+	for i := 1; i <= 3; i++ {
+		rw := serveAs(handler, "u1")
+		if rw.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, rw.Code)
+		}
+		if got := rw.Header().Get("X-Quota-Used"); got != strconv.Itoa(i) {
+			t.Fatalf("request %d: X-Quota-Used %q", i, got)
+		}
+		for _, name := range []string{"X-Quota-Limit", "X-Quota-Remaining", "X-Quota-Overage"} {
+			if got := rw.Header().Get(name); got != "" {
+				t.Fatalf("request %d: %s %q sent without a limit", i, name, got)
+			}
+		}
+	}
+}
+
+// failRedis makes every data command fail while keeping connections alive
+func failRedis(server *testRedisServer) {
+	server.setHook(func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "PING", "AUTH", "SELECT", "CLIENT":
+			return ""
+		}
+		return "-LOADING Redis is loading the dataset in memory\r\n"
+	})
+}
+
+func TestServeHTTPFailClosed(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       int
+		retryAfter string
+		wantCode   int
+		wantRetry  string
+	}{
+		{"defaults", 0, "", http.StatusServiceUnavailable, "5"},
+		{"configured", http.StatusTooManyRequests, "1500ms", http.StatusTooManyRequests, "2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestRedisServer(t)
+			handler := newTestPlugin(t, server, func(c *Config) {
+				c.FailureMode = "closed"
+				c.FailClosedResponseCode = tc.code
+				c.FailClosedRetryAfter = tc.retryAfter
+			})
+			failRedis(server)
+
+			rw := serveAs(handler, "u1")
+			if rw.Code != tc.wantCode {
+				t.Fatalf("status %d, want %d", rw.Code, tc.wantCode)
+			}
+			if got := rw.Header().Get("Retry-After"); got != tc.wantRetry {
+				t.Fatalf("Retry-After %q, want %q", got, tc.wantRetry)
+			}
+			if body := rw.Body.String(); body != ReasonBackendUnavailable {
+				t.Fatalf("body %q, want %q", body, ReasonBackendUnavailable)
+			}
+		})
+	}
+}
+
+func TestServeHTTPFailOpen(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, nil)
+	failRedis(server)
+
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusOK || rw.Header().Get("Retry-After") != "" {
+		t.Fatalf("failing open: status %d, Retry-After %q", rw.Code, rw.Header().Get("Retry-After"))
+	}
+}
+
+func TestNewRejectsInvalidFailureMode(t *testing.T) {
+	server := newTestRedisServer(t)
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.FailureMode = "sometimes" },
+		func(c *Config) { c.FailureMode = "closed"; c.FailClosedRetryAfter = "soon" },
+	} {
+		config := validConfig()
+		config.Persistence.Redis.Address = server.addr
+		mutate(config)
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "test"); err == nil {
+			t.Errorf("failure mode %q, retry after %q accepted", config.FailureMode, config.FailClosedRetryAfter)
+		}
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	tests := []struct {
+		body, configured, want string
+	}{
+		{`{"error":"slow down"}`, "", "application/json"},
+		{` [1, 2] `, "", "application/json"},
+		{"Limit {rate} reached, retry later", "", "text/plain"},
+		{"{not json", "", "text/plain"},
+		{"", "", "text/plain"},
+		{"Slow down", "text/html", "text/html"},
+	}
+	for _, tc := range tests {
+		if got := responseContentType(tc.body, tc.configured); got != tc.want {
+			t.Errorf("responseContentType(%q, %q) = %q, want %q", tc.body, tc.configured, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPBlockedContentType(t *testing.T) {
+	for _, tc := range []struct {
+		body, want string
+	}{
+		{`{"error":"rate limited"}`, "application/json"},
+		{"Rate {limit} reached", "text/plain"},
+		{"", "text/plain"},
+	} {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			c.Identifiers[0].RateLimit.Rate = 1
+			c.Identifiers[0].RateLimit.ResponseReachedLimitBody = tc.body
+		})
+		serveAs(handler, "u1")
+
+		rw := serveAs(handler, "u1")
+		if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Content-Type") != tc.want {
+			t.Errorf("body %q: status %d, Content-Type %q, want %q", tc.body, rw.Code, rw.Header().Get("Content-Type"), tc.want)
+		}
+	}
+}
+
+func TestServeHTTPZeroInitialTokensBlocksFirstRequest(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.InitialTokens = "zero"
+	})
+
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("first request of a new identifier: status %d, want 429", rw.Code)
+	}
+}
+
+func TestServeHTTPQuotaResetDateHeader(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.QuotaResetDateHeader = true
+		c.Identifiers[0].Quota.Timezone = "Asia/Jakarta"
+	})
+
+	rw := serveAs(handler, "u1")
+	resetDate, err := time.Parse(time.RFC3339, rw.Header().Get("X-Quota-Reset-Date"))
+	if err != nil {
+		t.Fatalf("X-Quota-Reset-Date: %v", err)
+	}
+	if _, offset := resetDate.Zone(); offset != 7*60*60 {
+		t.Fatalf("X-Quota-Reset-Date %s not in the quota timezone", resetDate)
+	}
+	if resetDate.Hour() != 0 || resetDate.Minute() != 0 {
+		t.Fatalf("daily quota resets at %s, want local midnight", resetDate)
+	}
+	if reset := rw.Header().Get("X-Quota-Reset"); reset != strconv.FormatInt(resetDate.Unix(), 10) {
+		t.Fatalf("X-Quota-Reset %s differs from X-Quota-Reset-Date %s", reset, resetDate)
+	}
+}
+
+func TestServeHTTPQuotaResetDateHeaderOptIn(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, nil)
+
+	rw := serveAs(handler, "u1")
+	if rw.Header().Get("X-Quota-Reset") == "" || rw.Header().Get("X-Quota-Reset-Date") != "" {
+		t.Fatalf("X-Quota-Reset %q, X-Quota-Reset-Date %q", rw.Header().Get("X-Quota-Reset"), rw.Header().Get("X-Quota-Reset-Date"))
+	}
+}
+
+func TestServeHTTPConsumeModeNone(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.ConsumeMode = ConsumeModeNone
+	})
+
+	// Limits are checked but usage is left to the application
+	for i := 1; i <= 7; i++ {
+		rw := serveAs(handler, "u1")
+		if rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Used") != "0" {
+			t.Fatalf("request %d: status %d, X-Quota-Used %q", i, rw.Code, rw.Header().Get("X-Quota-Used"))
+		}
+	}
+
+	// Usage consumed explicitly still blocks
+	settings := validConfig().Identifiers[0].Quota
+	if err := NewQuotaManager(server.mem, settings).SetQuotaUsage(context.Background(), "u1", 5); err != nil {
+		t.Fatal(err)
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusForbidden {
+		t.Fatalf("request past the explicitly consumed quota: status %d, want 403", rw.Code)
+	}
+}
+
+func TestFormatRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("WIB", 7*60*60))
+	tests := []struct {
+		format string
+		delay  time.Duration
+		want   string
+	}{
+		{"", 90 * time.Second, "90"},
+		{RetryAfterFormatSeconds, 1500 * time.Millisecond, "2"},
+		{RetryAfterFormatHTTPDate, 90 * time.Second, "Fri, 01 Mar 2024 05:01:30 GMT"},
+		{RetryAfterFormatHTTPDate, 1500 * time.Millisecond, "Fri, 01 Mar 2024 05:00:02 GMT"},
+	}
+	for _, tc := range tests {
+		if got := formatRetryAfter(tc.format, tc.delay, now); got != tc.want {
+			t.Errorf("formatRetryAfter(%q, %v) = %q, want %q", tc.format, tc.delay, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPRetryAfterHTTPDate(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.RetryAfterFormat = RetryAfterFormatHTTPDate
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+
+	serveAs(handler, "u1")
+	rw := serveAs(handler, "u1")
+	retryAt, err := http.ParseTime(rw.Header().Get("Retry-After"))
+	if rw.Code != http.StatusTooManyRequests || err != nil {
+		t.Fatalf("status %d, Retry-After %q: %v", rw.Code, rw.Header().Get("Retry-After"), err)
+	}
+	if delay := time.Until(retryAt); delay < 50*time.Second || delay > 61*time.Second {
+		t.Fatalf("Retry-After %s is %v away, want about a minute", retryAt, delay)
+	}
+}
+
+func TestServeHTTPIdentifierResponseHeaders(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].ResponseHeaders = map[string]string{"X-Plan": "pro"}
+		c.Identifiers = append(c.Identifiers, IdentifierConfig{
+			Type:      "Header",
+			Name:      "X-User-ID",
+			Value:     "u2",
+			RateLimit: RateLimitConfig{Enabled: true, Rate: 1, Period: "1m"},
+		})
+	})
+
+	if rw := serveAs(handler, "u1"); rw.Header().Get("X-Plan") != "pro" {
+		t.Fatalf("matching identifier: X-Plan %q, want pro", rw.Header().Get("X-Plan"))
+	}
+	if rw := serveAs(handler, "u2"); rw.Code != http.StatusOK || rw.Header().Get("X-Plan") != "" {
+		t.Fatalf("other identifier: status %d, X-Plan %q", rw.Code, rw.Header().Get("X-Plan"))
+	}
+}
+
+func TestServeHTTPSignedRemainingHeader(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota = QuotaSettings{Enabled: true, Limit: 1, OverageAllowance: 5, Period: "Daily", SignedRemaining: true}
+	})
+
+	for _, want := range []string{"0", "-1", "-2"} {
+		if got := serveAs(handler, "u1").Header().Get("X-Quota-Remaining"); got != want {
+			t.Fatalf("X-Quota-Remaining %q, want %q", got, want)
+		}
+	}
+}
+
+func TestServeHTTPHeadersOn(t *testing.T) {
+	tests := []struct {
+		mode                 string
+		onAllowed, onBlocked bool
+	}{
+		{"", true, true},
+		{HeadersOnAlways, true, true},
+		{HeadersOnBlocked, false, true},
+		{HeadersOnNever, false, false},
+	}
+	for _, tc := range tests {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			c.HeadersOn = tc.mode
+			c.Identifiers[0].RateLimit.Rate = 1
+		})
+
+		allowed, blocked := serveAs(handler, "u1"), serveAs(handler, "u1")
+		if blocked.Code != http.StatusTooManyRequests {
+			t.Fatalf("mode %q: second request status %d, want 429", tc.mode, blocked.Code)
+		}
+		for _, name := range []string{"X-RateLimit-Limit", "X-Quota-Limit"} {
+			if got := allowed.Header().Get(name) != ""; got != tc.onAllowed {
+				t.Errorf("mode %q: %s on the allowed response %v, want %v", tc.mode, name, got, tc.onAllowed)
+			}
+		}
+		if got := blocked.Header().Get("X-RateLimit-Limit") != ""; got != tc.onBlocked {
+			t.Errorf("mode %q: X-RateLimit-Limit on the blocked response %v, want %v", tc.mode, got, tc.onBlocked)
+		}
+	}
+}
+
+func TestLimitIdentifierLength(t *testing.T) {
+	long := strings.Repeat("k", 100)
+	tests := []struct {
+		overflow string
+		max      int
+		value    string
+		want     string
+	}{
+		{"", 0, long, long},
+		{"", 100, long, long},
+		{"", 64, long, ""},
+		{IdentifierOverflowHash, 64, long, hashIdentifier(long)},
+		{IdentifierOverflowHash, 16, long, hashIdentifier(long)[:16]},
+		{IdentifierOverflowHash, 64, "short", "short"},
+	}
+	for _, tc := range tests {
+		q := &quotaPlugin{config: &Config{MaxIdentifierLength: tc.max, IdentifierOverflow: tc.overflow}}
+		if got := q.limitIdentifierLength(httptest.NewRequest(http.MethodGet, "/", nil), tc.value); got != tc.want {
+			t.Errorf("overflow %q, max %d, %d bytes: identifier %q, want %q", tc.overflow, tc.max, len(tc.value), got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPMaxIdentifierLength(t *testing.T) {
+	long := "u" + strings.Repeat("x", 100)
+	for _, tc := range []struct {
+		overflow string
+		want     int
+	}{{"", http.StatusForbidden}, {IdentifierOverflowHash, http.StatusOK}} {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			c.MaxIdentifierLength = 64
+			c.IdentifierOverflow = tc.overflow
+			c.Identifiers[0].Type = "Query"
+			c.Identifiers[0].Name = "user"
+			c.Identifiers[0].Value = ""
+		})
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user="+long, nil))
+		if rw.Code != tc.want {
+			t.Errorf("overflow %q: status %d, want %d", tc.overflow, rw.Code, tc.want)
+		}
+		for _, received := range server.commands() {
+			if strings.Contains(received, long) {
+				t.Errorf("overflow %q: oversized identifier reached Redis in %q", tc.overflow, received)
+			}
+		}
+	}
+}
+
+func TestServeHTTPIdentifierTooLongRejected(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.MaxIdentifierLength = 64
+		c.Identifiers[0].Type = "Query"
+		c.Identifiers[0].Name = "user"
+		c.Identifiers[0].Value = ""
+	})
+
+	// The identifier is rejected as too long, not reported as missing
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user="+strings.Repeat("x", 100), nil))
+	if rw.Code != http.StatusForbidden || rw.Body.String() != ReasonIdentifierTooLong {
+		t.Fatalf("status %d, body %q; want 403 with %q", rw.Code, rw.Body.String(), ReasonIdentifierTooLong)
+	}
+}
+
+func TestServeHTTPCostHeader(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].CostHeader = "X-Request-Cost"
+		c.Identifiers[0].MaxCost = 3
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.Limit = 10
+	})
+	serve := func(cost string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		if cost != "" {
+			req.Header.Set("X-Request-Cost", cost)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	for _, tc := range []struct {
+		cost, used string
+	}{{"2", "2"}, {"50", "5"}, {"", "6"}} {
+		if got := serve(tc.cost).Header().Get("X-Quota-Used"); got != tc.used {
+			t.Fatalf("cost header %q: X-Quota-Used %s, want %s", tc.cost, got, tc.used)
+		}
+	}
+}
+
+func TestServeHTTPSuppressHeaders(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].SuppressHeaders = []string{"x-ratelimit-*", "X-Quota-Limit"}
+	})
+
+	for _, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rw := serveAs(handler, "u1")
+		if rw.Code != want {
+			t.Fatalf("status %d, want %d", rw.Code, want)
+		}
+		for name := range rw.Header() {
+			if strings.HasPrefix(name, "X-Ratelimit-") || name == "X-Quota-Limit" {
+				t.Fatalf("status %d: suppressed header %s was sent", rw.Code, name)
+			}
+		}
+		if rw.Code == http.StatusOK && rw.Header().Get("X-Quota-Used") == "" {
+			t.Fatal("unsuppressed X-Quota-Used missing")
+		}
+	}
+}
+
+func TestServeHTTPAliasesShareCounters(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].Aliases = []string{"u1-rotated"}
+	})
+
+	for i, user := range []string{"u1", "u1-rotated"} {
+		rw := serveAs(handler, user)
+		if want := strconv.Itoa(i + 1); rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Used") != want {
+			t.Fatalf("request as %s: status %d, X-Quota-Used %q, want %s", user, rw.Code, rw.Header().Get("X-Quota-Used"), want)
+		}
+	}
+	// Both values drew from the one rate limit of 2
+	if rw := serveAs(handler, "u1-rotated"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d, want the shared rate limit exhausted", rw.Code)
+	}
+
+	if mentions(server, "u1-rotated") {
+		t.Fatal("the alias got its own Redis keys")
+	}
+}
+
+func TestIdentifierAliasesRequireValue(t *testing.T) {
+	config := IdentifierConfig{Type: "Header", Name: "X-User-ID", Aliases: []string{"k2"}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("aliases without a canonical value were accepted")
+	}
+}
+
+func TestManagerKeysDoNotCollide(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers = []IdentifierConfig{
+			{Type: "Header", Name: "X-Key", Value: "a:b", RateLimit: RateLimitConfig{Enabled: true, Rate: 1, Period: "1m"}},
+			{Type: "Header", Name: "X-Key:a", Value: "b", RateLimit: RateLimitConfig{Enabled: true, Rate: 1, Period: "1m"}},
+		}
+	})
+
+	if managers := handler.(*quotaPlugin).currentManagers(); len(managers) != 2 {
+		t.Fatalf("%d managers, want one per identifier", len(managers))
+	}
+}
+
+func TestServeHTTPRejectUnknownKeys(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.RejectUnknownKeys = true
+	})
+
+	if rw := serveAs(handler, ""); rw.Code != http.StatusForbidden {
+		t.Fatalf("missing header: status %d, want 403", rw.Code)
+	}
+	rw := serveAs(handler, "unknown")
+	if rw.Code != http.StatusUnauthorized || !strings.Contains(rw.Body.String(), "Invalid key") {
+		t.Fatalf("unknown key: status %d, body %s; want 401 invalid key", rw.Code, rw.Body.String())
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("known key: status %d, want 200", rw.Code)
+	}
+
+	// Without the option an unknown key is a missing identifier
+	handler = newTestPlugin(t, server, nil)
+	if rw := serveAs(handler, "unknown"); rw.Code != http.StatusForbidden {
+		t.Fatalf("unknown key without the option: status %d, want 403", rw.Code)
+	}
+}
+
+func TestServeHTTPUnknownKeyResponse(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.RejectUnknownKeys = true
+		c.UnknownKeyResponseCode = http.StatusForbidden
+		c.UnknownKeyResponseBody = "bad key"
+	})
+
+	rw := serveAs(handler, "unknown")
+	if rw.Code != http.StatusForbidden || rw.Body.String() != "bad key" || !strings.HasPrefix(rw.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("status %d, Content-Type %q, body %q", rw.Code, rw.Header().Get("Content-Type"), rw.Body.String())
+	}
+}
+
+func TestServeHTTPServerTiming(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.ServerTiming = true
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rw := serveAs(handler, "u1")
+		timing := rw.Header().Get("Server-Timing")
+		var duration float64
+		if _, err := fmt.Sscanf(timing, "quota;dur=%g;", &duration); rw.Code != want || err != nil || duration < 0 || duration > 5000 {
+			t.Fatalf("status %d: Server-Timing %q, want a plausible duration", rw.Code, timing)
+		}
+	}
+
+	handler = newTestPlugin(t, server, nil)
+	if timing := serveAs(handler, "u1").Header().Get("Server-Timing"); timing != "" {
+		t.Fatalf("Server-Timing %q without the option", timing)
+	}
+}
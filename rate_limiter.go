@@ -2,16 +2,42 @@ package traefik_quota_plugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strconv"
+	"sync"
 	"time"
 )
 
-// RateLimiter implements token bucket algorithm for rate limiting
+// Rate limiting algorithms
+const (
+	AlgorithmTokenBucket   = "TokenBucket"
+	AlgorithmSlidingWindow = "SlidingWindow"
+)
+
+// Roundings of the fractional tokens of a bucket reported as available
+const (
+	RoundingFloor = "floor" // Whole tokens only (default)
+	RoundingRound = "round" // Nearest whole token
+	RoundingCeil  = "ceil"  // Any partial token counts as one
+)
+
+// RateLimiter implements token bucket (default) or sliding window rate limiting
 type RateLimiter struct {
 	redisClient RedisClient
 	config      RateLimitConfig
+	cache       *readCache    // Optional cache of bucket reads (nil when disabled)
+	log         *pluginLogger // Logger of the owning plugin instance (nil for the default)
+	optimistic  bool          // Update buckets with WATCH/MULTI/EXEC instead of a Lua script
+
+	// scriptingFallback is used once Redis rejected EVAL
+	scriptingFallback    string
+	mu                   sync.Mutex
+	scriptingUnsupported bool
+
+	// fixedKey, when set, is the bucket of every identifier (the global limiter)
+	fixedKey string
 }
 
 // TokenBucket represents the current state of a token bucket
@@ -31,75 +57,114 @@ func NewRateLimiter(redisClient RedisClient, config RateLimitConfig) *RateLimite
 	}
 }
 
+// bucketKey returns the Redis key of identifier's bucket
+func (rl *RateLimiter) bucketKey(identifier string) string {
+	if rl.fixedKey != "" {
+		return rl.fixedKey
+	}
+	return GetRateLimitKey(identifier)
+}
+
 // Allow checks if a request is allowed under the rate limit
 func (rl *RateLimiter) Allow(ctx context.Context, identifier string) (bool, error) {
-	key := GetRateLimitKey(identifier)
+	return rl.AllowN(ctx, identifier, 1)
+}
 
-	// Get current bucket state
-	bucket, err := rl.getBucket(ctx, key)
-	if err != nil {
-		return false, fmt.Errorf("failed to get bucket: %w", err)
+// AllowN checks if N requests are allowed under the rate limit, taking them
+// atomically when they are
+func (rl *RateLimiter) AllowN(ctx context.Context, identifier string, n int) (bool, error) {
+	return rl.AllowNKeeping(ctx, identifier, n, 0)
+}
+
+// AllowNKeeping is AllowN allowing the requests only when at least reserve
+// tokens are left afterwards, in the same atomic update
+func (rl *RateLimiter) AllowNKeeping(ctx context.Context, identifier string, n, reserve int) (bool, error) {
+	if n <= 0 {
+		return true, nil
 	}
 
-	// Refill tokens
-	now := time.Now()
-	bucket = rl.refillBucket(bucket, now)
+	if rl.config.Algorithm == AlgorithmSlidingWindow {
+		result, err := rl.evalSlidingWindow(ctx, identifier, n, reserve)
+		return result.Allowed, err
+	}
 
-	// Check if we have tokens
-	if bucket.Tokens >= 1.0 {
-		// Consume one token
-		bucket.Tokens -= 1.0
+	switch rl.tokenBucketMode() {
+	case ScriptingFallbackOptimistic:
+		return rl.watchTokenBucket(ctx, identifier, n, reserve)
+	case ScriptingFallbackNonAtomic:
+		return rl.takeTokensNonAtomic(ctx, identifier, n, reserve)
+	}
+	return rl.evalTokenBucket(ctx, identifier, n, reserve)
+}
 
-		// Save updated bucket
-		if err := rl.saveBucket(ctx, key, bucket); err != nil {
-			return false, fmt.Errorf("failed to save bucket: %w", err)
-		}
+// Wait blocks for delay, or until ctx is cancelled, and then tries to take n tokens
+func (rl *RateLimiter) Wait(ctx context.Context, identifier string, n int, delay time.Duration) (bool, error) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-		return true, nil
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-timer.C:
 	}
 
-	// No tokens available
-	return false, nil
+	return rl.AllowN(ctx, identifier, n)
 }
 
-// AllowN checks if N requests are allowed under the rate limit
-func (rl *RateLimiter) AllowN(ctx context.Context, identifier string, n int) (bool, error) {
-	if n <= 0 {
-		return true, nil
+// WaitDelay returns how long until n tokens are available to identifier, or
+// false when n exceeds what the limit can ever grant at once
+func (rl *RateLimiter) WaitDelay(ctx context.Context, identifier string, n int) (time.Duration, bool, error) {
+	if rl.config.Algorithm == AlgorithmSlidingWindow {
+		if n > rl.config.Rate {
+			return 0, false, nil
+		}
+		info, err := rl.slidingWindowInfo(ctx, identifier)
+		if err != nil {
+			return 0, false, err
+		}
+		if info.Available >= n {
+			return 0, true, nil
+		}
+		if n == 1 {
+			return info.RetryAfter, true, nil
+		}
+		// Only the oldest request is known; every request in the window has
+		// slid out after a whole window
+		window, _ := rl.config.ParseRateLimitPeriod()
+		return window, true, nil
 	}
 
-	key := GetRateLimitKey(identifier)
-
-	// Get current bucket state
-	bucket, err := rl.getBucket(ctx, key)
+	bucket, err := rl.getBucket(ctx, rl.bucketKey(identifier))
 	if err != nil {
-		return false, fmt.Errorf("failed to get bucket: %w", err)
+		return 0, false, fmt.Errorf("failed to get bucket: %w", err)
+	}
+	if n > bucket.Burst {
+		return 0, false, nil
 	}
 
-	// Refill tokens
 	now := time.Now()
 	bucket = rl.refillBucket(bucket, now)
-
-	// Check if we have enough tokens
-	if bucket.Tokens >= float64(n) {
-		// Consume tokens
-		bucket.Tokens -= float64(n)
-
-		// Save updated bucket
-		if err := rl.saveBucket(ctx, key, bucket); err != nil {
-			return false, fmt.Errorf("failed to save bucket: %w", err)
-		}
-
-		return true, nil
+	missing := float64(n) - bucket.Tokens
+	if missing <= 0 {
+		return 0, true, nil
 	}
 
-	// Not enough tokens available
-	return false, nil
+	if rl.config.AlignWindow {
+		// The bucket refills fully at the next aligned boundary
+		return now.Truncate(bucket.RefillPeriod).Add(bucket.RefillPeriod).Sub(now), true, nil
+	}
+	if interval, _ := rl.config.ParseRefillInterval(); interval > 0 && rl.config.RefillRate > 0 {
+		// RefillRate tokens arrive at the end of each interval
+		intervals := math.Ceil(missing / float64(rl.config.RefillRate))
+		return time.Duration(intervals)*interval - now.Sub(bucket.LastRefill), true, nil
+	}
+	seconds := missing * bucket.RefillPeriod.Seconds() / float64(bucket.Rate)
+	return time.Duration(seconds * float64(time.Second)), true, nil
 }
 
 // GetCurrentTokens returns the current number of tokens available
 func (rl *RateLimiter) GetCurrentTokens(ctx context.Context, identifier string) (float64, error) {
-	key := GetRateLimitKey(identifier)
+	key := rl.bucketKey(identifier)
 
 	// Get current bucket state
 	bucket, err := rl.getBucket(ctx, key)
@@ -114,9 +179,52 @@ func (rl *RateLimiter) GetCurrentTokens(ctx context.Context, identifier string)
 	return bucket.Tokens, nil
 }
 
+// Inspect returns the stored token bucket of identifier without refilling it or
+// refreshing its expiration; it returns nil when no bucket is stored
+func (rl *RateLimiter) Inspect(ctx context.Context, identifier string) (*TokenBucket, error) {
+	key := rl.bucketKey(identifier)
+
+	period, err := rl.config.ParseRateLimitPeriod()
+	if err != nil {
+		return nil, fmt.Errorf("invalid period: %w", err)
+	}
+
+	tokensData, err := rl.redisClient.Get(ctx, key+":tokens")
+	if errors.Is(err, errKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tokens: %w", err)
+	}
+	tokens, err := strconv.ParseFloat(tokensData, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored tokens %q: %w", tokensData, err)
+	}
+
+	lastRefillData, err := rl.redisClient.Get(ctx, key+":last_refill")
+	if errors.Is(err, errKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last refill: %w", err)
+	}
+	lastRefillUnix, err := strconv.ParseInt(lastRefillData, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored last refill %q: %w", lastRefillData, err)
+	}
+
+	return &TokenBucket{
+		Tokens:       tokens,
+		LastRefill:   time.Unix(0, lastRefillUnix),
+		Rate:         rl.config.Rate,
+		Burst:        rl.config.Burst,
+		RefillPeriod: period,
+	}, nil
+}
+
 // Reset resets the rate limiter for a specific identifier
 func (rl *RateLimiter) Reset(ctx context.Context, identifier string) error {
-	key := GetRateLimitKey(identifier)
+	key := rl.bucketKey(identifier)
 
 	// Create a new full bucket
 	period, err := rl.config.ParseRateLimitPeriod()
@@ -135,33 +243,58 @@ func (rl *RateLimiter) Reset(ctx context.Context, identifier string) error {
 	return rl.saveBucket(ctx, key, bucket)
 }
 
-// getBucket retrieves the current bucket state from Redis
+// getBucket retrieves the current bucket state for read-only uses, serving hot
+// identifiers from the read cache when enabled
 func (rl *RateLimiter) getBucket(ctx context.Context, key string) (TokenBucket, error) {
-	// Try to get existing bucket
-	bucketData, err := rl.redisClient.Get(ctx, key+":tokens")
+	if cached, ok := rl.cache.get(key); ok {
+		return cached.(TokenBucket), nil
+	}
+
+	bucket, err := rl.loadBucket(ctx, key)
 	if err != nil {
-		// Bucket doesn't exist, create new one
-		return rl.createNewBucket()
+		return TokenBucket{}, err
 	}
+	rl.cache.set(key, bucket)
+	return bucket, nil
+}
 
-	tokens, err := strconv.ParseFloat(bucketData, 64)
+// loadBucket reads the current bucket state from Redis, bypassing the read
+// cache; updates must start from it so instances don't overwrite each other
+func (rl *RateLimiter) loadBucket(ctx context.Context, key string) (TokenBucket, error) {
+	period, err := rl.config.ParseRateLimitPeriod()
 	if err != nil {
+		return TokenBucket{}, fmt.Errorf("invalid period: %w", err)
+	}
+
+	// Reading refreshes the TTL (same window as saveBucket) so active
+	// buckets are kept alive between writes
+	expiration := rl.config.bucketTTL(period)
+
+	// Try to get existing bucket; only a missing key starts a new one
+	bucketData, err := rl.getEx(ctx, key+":tokens", expiration)
+	if errors.Is(err, errKeyNotFound) {
 		return rl.createNewBucket()
 	}
+	if err != nil {
+		return TokenBucket{}, err
+	}
 
-	lastRefillData, err := rl.redisClient.Get(ctx, key+":last_refill")
+	tokens, err := strconv.ParseFloat(bucketData, 64)
 	if err != nil {
 		return rl.createNewBucket()
 	}
 
-	lastRefillUnix, err := strconv.ParseInt(lastRefillData, 10, 64)
-	if err != nil {
+	lastRefillData, err := rl.getEx(ctx, key+":last_refill", expiration)
+	if errors.Is(err, errKeyNotFound) {
 		return rl.createNewBucket()
 	}
+	if err != nil {
+		return TokenBucket{}, err
+	}
 
-	period, err := rl.config.ParseRateLimitPeriod()
+	lastRefillUnix, err := strconv.ParseInt(lastRefillData, 10, 64)
 	if err != nil {
-		return TokenBucket{}, fmt.Errorf("invalid period: %w", err)
+		return rl.createNewBucket()
 	}
 
 	return TokenBucket{
@@ -173,15 +306,40 @@ func (rl *RateLimiter) getBucket(ctx context.Context, key string) (TokenBucket,
 	}, nil
 }
 
-// createNewBucket creates a new token bucket with default values
+// getEx reads key and refreshes its expiration with GETEX, or with GET and
+// PEXPIRE on servers older than Redis 6.2 that don't know GETEX
+func (rl *RateLimiter) getEx(ctx context.Context, key string, expiration time.Duration) (string, error) {
+	value, err := rl.redisClient.GetEx(ctx, key, expiration)
+	if !isUnknownCommand(err) {
+		return value, err
+	}
+
+	value, err = rl.redisClient.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if expiration > 0 {
+		if _, err := rl.redisClient.PExpire(ctx, key, expiration); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// createNewBucket creates a new token bucket holding the configured initial tokens
 func (rl *RateLimiter) createNewBucket() (TokenBucket, error) {
 	period, err := rl.config.ParseRateLimitPeriod()
 	if err != nil {
 		return TokenBucket{}, fmt.Errorf("invalid period: %w", err)
 	}
 
+	initialTokens, err := rl.config.ParseInitialTokens()
+	if err != nil {
+		return TokenBucket{}, fmt.Errorf("invalid initial tokens: %w", err)
+	}
+
 	return TokenBucket{
-		Tokens:       float64(rl.config.Burst),
+		Tokens:       initialTokens,
 		LastRefill:   time.Now(),
 		Rate:         rl.config.Rate,
 		Burst:        rl.config.Burst,
@@ -191,9 +349,30 @@ func (rl *RateLimiter) createNewBucket() (TokenBucket, error) {
 
 // refillBucket refills tokens based on elapsed time
 func (rl *RateLimiter) refillBucket(bucket TokenBucket, now time.Time) TokenBucket {
+	// Aligned windows refill fully at each wall-clock Period boundary
+	if rl.config.AlignWindow {
+		windowStart := now.Truncate(bucket.RefillPeriod)
+		if bucket.LastRefill.Before(windowStart) {
+			bucket.Tokens = float64(bucket.Burst)
+			bucket.LastRefill = windowStart
+		}
+		return bucket
+	}
+
 	// Calculate time elapsed since last refill
 	elapsed := now.Sub(bucket.LastRefill)
 
+	// With an explicit refill cadence, add RefillRate tokens per whole interval elapsed
+	if interval, _ := rl.config.ParseRefillInterval(); interval > 0 && rl.config.RefillRate > 0 {
+		intervals := int64(elapsed / interval)
+		bucket.Tokens = math.Min(bucket.Tokens+float64(intervals)*float64(rl.config.RefillRate), float64(bucket.Burst))
+		bucket.LastRefill = bucket.LastRefill.Add(time.Duration(intervals) * interval)
+		if bucket.Tokens >= float64(bucket.Burst) {
+			bucket.LastRefill = now
+		}
+		return bucket
+	}
+
 	// Calculate tokens to add based on rate
 	tokensToAdd := float64(bucket.Rate) * elapsed.Seconds() / bucket.RefillPeriod.Seconds()
 
@@ -206,8 +385,9 @@ func (rl *RateLimiter) refillBucket(bucket TokenBucket, now time.Time) TokenBuck
 
 // saveBucket saves the bucket state to Redis
 func (rl *RateLimiter) saveBucket(ctx context.Context, key string, bucket TokenBucket) error {
-	// Calculate expiration (2x the refill period to be safe)
-	expiration := bucket.RefillPeriod * 2
+	// Idle buckets expire after BucketTTL, 2x the refill period by default
+	expiration := rl.config.bucketTTL(bucket.RefillPeriod)
+	defer rl.cache.invalidate(key)
 
 	// Save tokens
 	if err := rl.redisClient.Set(ctx, key+":tokens", bucket.Tokens, expiration); err != nil {
@@ -224,7 +404,11 @@ func (rl *RateLimiter) saveBucket(ctx context.Context, key string, bucket TokenB
 
 // GetLimitInfo returns information about the current rate limit state
 func (rl *RateLimiter) GetLimitInfo(ctx context.Context, identifier string) (RateLimitInfo, error) {
-	key := GetRateLimitKey(identifier)
+	if rl.config.Algorithm == AlgorithmSlidingWindow {
+		return rl.slidingWindowInfo(ctx, identifier)
+	}
+
+	key := rl.bucketKey(identifier)
 
 	bucket, err := rl.getBucket(ctx, key)
 	if err != nil {
@@ -238,24 +422,55 @@ func (rl *RateLimiter) GetLimitInfo(ctx context.Context, identifier string) (Rat
 	// Calculate time until next token
 	var timeUntilReset time.Duration
 	if bucket.Tokens < float64(bucket.Burst) {
-		timeForOneToken := bucket.RefillPeriod.Seconds() / float64(bucket.Rate)
-		timeUntilReset = time.Duration(timeForOneToken * float64(time.Second))
+		if rl.config.AlignWindow {
+			// The bucket refills at the next aligned boundary
+			timeUntilReset = now.Truncate(bucket.RefillPeriod).Add(bucket.RefillPeriod).Sub(now)
+		} else if interval, _ := rl.config.ParseRefillInterval(); interval > 0 && rl.config.RefillRate > 0 {
+			// Tokens arrive at the end of the current refill interval
+			timeUntilReset = interval - now.Sub(bucket.LastRefill)
+		} else {
+			timeForOneToken := bucket.RefillPeriod.Seconds() / float64(bucket.Rate)
+			timeUntilReset = time.Duration(timeForOneToken * float64(time.Second))
+		}
+	}
+
+	// A token bucket has no discrete window, so "used" is approximated from
+	// the tokens missing relative to the limit
+	available := rl.config.roundAvailable(bucket.Tokens)
+	used := bucket.Rate - available
+	if used < 0 {
+		used = 0
 	}
 
 	return RateLimitInfo{
 		Limit:      bucket.Rate,
 		Burst:      bucket.Burst,
-		Available:  int(bucket.Tokens),
+		Available:  available,
+		Used:       used,
 		ResetTime:  now.Add(timeUntilReset),
 		RetryAfter: timeUntilReset,
 	}, nil
 }
 
+// roundAvailable converts a bucket's tokens to the whole number reported as
+// available; the allow decision keeps using the exact tokens
+func (rlc RateLimitConfig) roundAvailable(tokens float64) int {
+	switch rlc.AvailableRounding {
+	case RoundingRound:
+		return int(math.Round(tokens))
+	case RoundingCeil:
+		return int(math.Ceil(tokens))
+	default:
+		return int(math.Floor(tokens))
+	}
+}
+
 // RateLimitInfo contains information about rate limit state
 type RateLimitInfo struct {
 	Limit      int           `json:"limit"`       // Requests per period
 	Burst      int           `json:"burst"`       // Burst capacity
 	Available  int           `json:"available"`   // Available tokens
+	Used       int           `json:"used"`        // Requests used in the current window (approximate)
 	ResetTime  time.Time     `json:"reset_time"`  // When limit resets
 	RetryAfter time.Duration `json:"retry_after"` // Time to wait before retry
 }
@@ -0,0 +1,361 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetLimitInfoUsedReconcilesWithLimit(t *testing.T) {
+	for _, algorithm := range []string{"", AlgorithmSlidingWindow} {
+		t.Run("algorithm="+algorithm, func(t *testing.T) {
+			ctx := context.Background()
+			config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 10, Period: "1h", Algorithm: algorithm}
+			limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+			for i := 1; i <= 4; i++ {
+				if allowed, err := limiter.Allow(ctx, "u1"); !allowed || err != nil {
+					t.Fatalf("request %d: allowed %v, %v", i, allowed, err)
+				}
+				info, err := limiter.GetLimitInfo(ctx, "u1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Used != i || info.Used+info.Available != info.Limit {
+					t.Fatalf("after %d requests: used %d + available %d, limit %d", i, info.Used, info.Available, info.Limit)
+				}
+			}
+		})
+	}
+}
+
+func TestGetLimitInfoUsedNeverNegative(t *testing.T) {
+	// A burst above the rate leaves more tokens than the limit
+	config := RateLimitConfig{Enabled: true, Rate: 2, Burst: 5, Period: "1h"}
+	limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+	info, err := limiter.GetLimitInfo(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Used != 0 || info.Available != 5 {
+		t.Fatalf("fresh bucket: used %d, available %d", info.Used, info.Available)
+	}
+}
+
+func TestInitialTokens(t *testing.T) {
+	for _, tc := range []struct {
+		initial string
+		allowed int
+	}{{"", 3}, {"full", 3}, {"zero", 0}, {"2", 2}, {"10", 3}} {
+		t.Run("initial="+tc.initial, func(t *testing.T) {
+			ctx := context.Background()
+			config := RateLimitConfig{Enabled: true, Rate: 3, Burst: 3, Period: "1h", InitialTokens: tc.initial}
+			limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				if ok, err := limiter.Allow(ctx, "u1"); err != nil {
+					t.Fatal(err)
+				} else if ok {
+					allowed++
+				}
+			}
+			if allowed != tc.allowed {
+				t.Fatalf("allowed %d requests of a new bucket, want %d", allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+func TestParseInitialTokensRejectsInvalid(t *testing.T) {
+	for _, initial := range []string{"empty", "-1"} {
+		config := RateLimitConfig{Rate: 3, Burst: 3, InitialTokens: initial}
+		if _, err := config.ParseInitialTokens(); err == nil {
+			t.Errorf("initial tokens %q accepted", initial)
+		}
+	}
+}
+
+func TestRefillBucketCadence(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	empty := TokenBucket{LastRefill: start, Rate: 10, Burst: 10, RefillPeriod: time.Minute}
+
+	// Period-derived refill adds fractions of a token continuously; an
+	// explicit cadence adds whole tokens per elapsed interval
+	derived := RateLimitConfig{Enabled: true, Rate: 10, Period: "1m"}
+	explicit := RateLimitConfig{Enabled: true, Rate: 10, Period: "1m", RefillRate: 1, RefillInterval: "6s"}
+	tests := []struct {
+		config  RateLimitConfig
+		elapsed time.Duration
+		tokens  float64
+	}{
+		{derived, 3 * time.Second, 0.5},
+		{derived, 13 * time.Second, 13.0 / 6},
+		{explicit, 3 * time.Second, 0},
+		{explicit, 6 * time.Second, 1},
+		{explicit, 13 * time.Second, 2},
+		{explicit, time.Hour, 10},
+	}
+	for _, tc := range tests {
+		limiter := NewRateLimiter(NewMemoryRedisClient(), tc.config)
+		bucket := limiter.refillBucket(empty, start.Add(tc.elapsed))
+		if math.Abs(bucket.Tokens-tc.tokens) > 1e-9 {
+			t.Errorf("refill interval %q after %v: %v tokens, want %v", tc.config.RefillInterval, tc.elapsed, bucket.Tokens, tc.tokens)
+		}
+	}
+}
+
+func TestRefillBucketKeepsPartialInterval(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 10, Period: "1m", RefillRate: 1, RefillInterval: "6s"}
+	limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+	// 4s + 4s add up to one interval although neither does alone
+	bucket := TokenBucket{LastRefill: start, Rate: 10, Burst: 10, RefillPeriod: time.Minute}
+	bucket = limiter.refillBucket(bucket, start.Add(4*time.Second))
+	bucket = limiter.refillBucket(bucket, start.Add(8*time.Second))
+	if bucket.Tokens != 1 {
+		t.Fatalf("%v tokens after 8s, want 1", bucket.Tokens)
+	}
+}
+
+func TestValidateRefillSettingsTogether(t *testing.T) {
+	for _, config := range []RateLimitConfig{
+		{Enabled: true, Rate: 10, Burst: 10, RefillRate: 1},
+		{Enabled: true, Rate: 10, Burst: 10, RefillInterval: "6s"},
+		{Enabled: true, Rate: 10, Burst: 10, RefillRate: 1, RefillInterval: "soon"},
+	} {
+		if err := (&IdentifierConfig{Type: "IP", RateLimit: config}).Validate(); err == nil {
+			t.Errorf("refill rate %d, interval %q accepted", config.RefillRate, config.RefillInterval)
+		}
+	}
+}
+
+func TestGetBucketRefreshesTTL(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+	config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 10, Period: "1m"}
+	limiter := NewRateLimiter(client, config)
+
+	if _, err := limiter.Allow(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	key := GetRateLimitKey("u1")
+	client.Expire(ctx, key+":tokens", time.Second)
+
+	if _, err := limiter.getBucket(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := client.TTL(ctx, key+":tokens"); ttl <= time.Minute {
+		t.Fatalf("bucket TTL after a read %v, want twice the period", ttl)
+	}
+}
+
+func TestGetBucketWithoutGetEx(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	server.setHook(func(args []string) string {
+		if strings.EqualFold(args[0], "GETEX") {
+			return "-ERR unknown command 'GETEX'\r\n"
+		}
+		return ""
+	})
+	config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 10, Period: "1m"}
+	limiter := NewRateLimiter(newTestRedisClient(t, server, RedisConfig{}), config)
+	key := GetRateLimitKey("u1")
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Allow(ctx, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bucket, err := limiter.getBucket(ctx, key)
+	if err != nil || bucket.Tokens >= 9 {
+		t.Fatalf("bucket read with GET = %+v, %v; want the 2 spent tokens kept", bucket, err)
+	}
+	if !hasCommand(server, "PEXPIRE "+key+":tokens 120000") {
+		t.Fatalf("commands %q lack the PEXPIRE refreshing the bucket TTL", server.commands())
+	}
+
+	// Other failures are errors, not a fresh bucket
+	server.setHook(func(args []string) string {
+		if strings.EqualFold(args[0], "GETEX") {
+			return "-ERR out of memory\r\n"
+		}
+		return ""
+	})
+	if _, err := limiter.getBucket(ctx, key); err == nil {
+		t.Fatal("failed bucket read returned a fresh bucket")
+	}
+}
+
+func TestInspectIsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	client := NewMemoryRedisClient()
+	client.SetClock(clock.Now)
+	config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 20, Period: "1m"}
+	limiter := NewRateLimiter(client, config)
+
+	if bucket, err := limiter.Inspect(ctx, "u1"); bucket != nil || err != nil {
+		t.Fatalf("Inspect without a bucket = %+v, %v; want nil", bucket, err)
+	}
+
+	key := GetRateLimitKey("u1")
+	lastRefill := clock.Now().Add(-30 * time.Second)
+	client.Set(ctx, key+":tokens", "3.5", time.Minute)
+	client.Set(ctx, key+":last_refill", strconv.FormatInt(lastRefill.UnixNano(), 10), time.Minute)
+
+	bucket, err := limiter.Inspect(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket.Tokens != 3.5 || !bucket.LastRefill.Equal(lastRefill) || bucket.Rate != 10 || bucket.Burst != 20 || bucket.RefillPeriod != time.Minute {
+		t.Fatalf("Inspect = %+v, want the stored state", bucket)
+	}
+
+	// Half a period has passed, but nothing is refilled or re-expired
+	if tokens, _ := client.Get(ctx, key+":tokens"); tokens != "3.5" {
+		t.Fatalf("stored tokens after Inspect %s, want 3.5", tokens)
+	}
+	if ttl, _ := client.TTL(ctx, key+":tokens"); ttl != time.Minute {
+		t.Fatalf("bucket TTL after Inspect %v, want it untouched", ttl)
+	}
+}
+
+func TestRefillBucketAlignWindow(t *testing.T) {
+	config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 10, Period: "1m", AlignWindow: true}
+	limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+	lastRefill := time.Date(2024, 1, 1, 0, 0, 10, 0, time.UTC)
+	empty := TokenBucket{LastRefill: lastRefill, Rate: 10, Burst: 10, RefillPeriod: time.Minute}
+
+	// Nothing trickles in within the window
+	if bucket := limiter.refillBucket(empty, lastRefill.Add(49*time.Second)); bucket.Tokens != 0 {
+		t.Fatalf("%v tokens before the boundary, want 0", bucket.Tokens)
+	}
+	// The whole burst arrives at the top of the minute, however recent the last refill
+	bucket := limiter.refillBucket(empty, lastRefill.Add(50*time.Second))
+	if want := time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC); bucket.Tokens != 10 || !bucket.LastRefill.Equal(want) {
+		t.Fatalf("at the boundary: %v tokens refilled at %v, want 10 at %v", bucket.Tokens, bucket.LastRefill, want)
+	}
+}
+
+func TestGetLimitInfoAlignWindowReset(t *testing.T) {
+	ctx := context.Background()
+	// An hour-long window keeps the test clear of a boundary passing mid-test
+	config := RateLimitConfig{Enabled: true, Rate: 10, Burst: 10, Period: "1h", AlignWindow: true}
+	limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+	if _, err := limiter.Allow(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := limiter.GetLimitInfo(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().Truncate(time.Hour).Add(time.Hour); !info.ResetTime.Equal(want) {
+		t.Fatalf("reset %v, want the top of the next hour %v", info.ResetTime, want)
+	}
+}
+
+func TestValidateAlignWindow(t *testing.T) {
+	for _, config := range []RateLimitConfig{
+		{Enabled: true, Rate: 10, Burst: 10, Period: "1m", AlignWindow: true, Algorithm: AlgorithmSlidingWindow},
+		{Enabled: true, Rate: 10, Burst: 10, Period: "1m", AlignWindow: true, RefillRate: 1, RefillInterval: "6s"},
+	} {
+		if err := (&IdentifierConfig{Type: "IP", RateLimit: config}).Validate(); err == nil {
+			t.Errorf("align window with algorithm %q, refill interval %q accepted", config.Algorithm, config.RefillInterval)
+		}
+	}
+}
+
+func TestGetLimitInfoAvailableRounding(t *testing.T) {
+	tests := []struct {
+		rounding string
+		tokens   string
+		want     int
+	}{
+		{"", "0.6", 0},
+		{RoundingFloor, "1.4", 1},
+		{RoundingRound, "0.6", 1},
+		{RoundingRound, "1.4", 1},
+		{RoundingCeil, "0.6", 1},
+		{RoundingCeil, "1.4", 2},
+	}
+	for _, tc := range tests {
+		ctx := context.Background()
+		client := NewMemoryRedisClient()
+		config := RateLimitConfig{Enabled: true, Rate: 10, Period: "1h", AvailableRounding: tc.rounding}
+		config.Normalize()
+		limiter := NewRateLimiter(client, config)
+		key := limiter.bucketKey("u1")
+		client.Set(ctx, key+":tokens", tc.tokens, time.Hour)
+		client.Set(ctx, key+":last_refill", strconv.FormatInt(time.Now().UnixNano(), 10), time.Hour)
+
+		info, err := limiter.GetLimitInfo(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Available != tc.want || info.Used != 10-tc.want {
+			t.Errorf("rounding %q of %s tokens: available %d, used %d; want %d available", tc.rounding, tc.tokens, info.Available, info.Used, tc.want)
+		}
+	}
+}
+
+func TestAvailableRoundingKeepsDecision(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+	config := RateLimitConfig{Enabled: true, Rate: 10, Period: "1h", AvailableRounding: RoundingCeil}
+	config.Normalize()
+	limiter := NewRateLimiter(client, config)
+	key := limiter.bucketKey("u1")
+	client.Set(ctx, key+":tokens", "0.6", time.Hour)
+	client.Set(ctx, key+":last_refill", strconv.FormatInt(time.Now().UnixNano(), 10), time.Hour)
+
+	// One token is reported, but 0.6 can't pay for a request
+	if allowed, err := limiter.Allow(ctx, "u1"); err != nil || allowed {
+		t.Fatalf("allowed %v, %v with 0.6 tokens", allowed, err)
+	}
+}
+
+func TestValidateAvailableRounding(t *testing.T) {
+	for rounding, valid := range map[string]bool{"": true, RoundingFloor: true, RoundingRound: true, RoundingCeil: true, "truncate": false} {
+		config := RateLimitConfig{Enabled: true, Rate: 10, Period: "1h", AvailableRounding: rounding}
+		config.Normalize()
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("rounding %q: error %v, want valid %v", rounding, err, valid)
+		}
+	}
+}
+
+func TestAllowNKeepingReserve(t *testing.T) {
+	for _, algorithm := range []string{"", AlgorithmSlidingWindow} {
+		t.Run("algorithm="+algorithm, func(t *testing.T) {
+			ctx := context.Background()
+			config := RateLimitConfig{Enabled: true, Rate: 5, Burst: 5, Period: "1h", Algorithm: algorithm}
+			limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+			for i, want := range []bool{true, true, true, false} {
+				allowed, err := limiter.AllowNKeeping(ctx, "u1", 1, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allowed != want {
+					t.Fatalf("request %d keeping 2: allowed %v, want %v", i+1, allowed, want)
+				}
+			}
+
+			// The reserve is still there for requests that may use it
+			for i := 0; i < 2; i++ {
+				if allowed, err := limiter.Allow(ctx, "u1"); !allowed || err != nil {
+					t.Fatalf("reserved token %d: allowed %v, %v", i+1, allowed, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package traefik_quota_plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// readCacheMaxEntries bounds the entries a read cache holds; once full, new
+// reads are not cached until expired entries are swept
+const readCacheMaxEntries = 10000
+
+// readCache is a short-lived in-process cache of Redis reads for hot
+// identifiers. Writes made through this instance invalidate their keys; writes
+// by other instances are only seen once an entry expires. A nil cache is
+// disabled: every lookup misses and every update is a no-op.
+type readCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]readCacheEntry
+}
+
+// readCacheEntry is a cached value and the instant it stops being served
+type readCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// newReadCache creates a read cache whose entries live for ttl
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]readCacheEntry),
+	}
+}
+
+// get returns the cached value of key while it is fresh
+func (c *readCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// set caches value for key for the cache TTL
+func (c *readCache) set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= readCacheMaxEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= readCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[key] = readCacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// invalidate drops the cached values of keys after they were written
+func (c *readCache) invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
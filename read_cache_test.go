@@ -0,0 +1,104 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadCacheExpiry(t *testing.T) {
+	clock := newFakeClock()
+	cache := newReadCache(100 * time.Millisecond)
+	cache.now = clock.Now
+
+	cache.set("k", int64(3))
+	clock.Advance(99 * time.Millisecond)
+	if value, ok := cache.get("k"); !ok || value != int64(3) {
+		t.Fatalf("get within the TTL = %v, %v; want a hit", value, ok)
+	}
+
+	clock.Advance(time.Millisecond)
+	if _, ok := cache.get("k"); ok {
+		t.Fatal("get after the TTL hit")
+	}
+}
+
+func TestReadCacheInvalidate(t *testing.T) {
+	cache := newReadCache(time.Minute)
+	cache.set("a", 1)
+	cache.set("b", 2)
+
+	cache.invalidate("a")
+	if _, ok := cache.get("a"); ok {
+		t.Fatal("get of an invalidated key hit")
+	}
+	if _, ok := cache.get("b"); !ok {
+		t.Fatal("invalidating one key dropped another")
+	}
+
+	// A nil cache is disabled
+	var disabled *readCache
+	disabled.set("a", 1)
+	disabled.invalidate("a")
+	if _, ok := disabled.get("a"); ok {
+		t.Fatal("nil cache hit")
+	}
+}
+
+func TestQuotaManagerReadCache(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	client := NewMemoryRedisClient()
+	qm := NewQuotaManager(client, QuotaSettings{Enabled: true, Limit: 10, Period: "Daily"})
+	qm.cache = newReadCache(100 * time.Millisecond)
+	qm.cache.now = clock.Now
+
+	if _, err := qm.ConsumeQuota(ctx, "u1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := qm.GetQuotaInfo(ctx, "u1"); info.Used != 2 {
+		t.Fatalf("first read used %d, want 2", info.Used)
+	}
+
+	// Another instance's write is not seen within the TTL
+	client.IncrBy(ctx, GetQuotaKey("u1", qm.periodKey()), 5)
+	if info, _ := qm.GetQuotaInfo(ctx, "u1"); info.Used != 2 {
+		t.Fatalf("cached read used %d, want 2", info.Used)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if info, _ := qm.GetQuotaInfo(ctx, "u1"); info.Used != 7 {
+		t.Fatalf("read after the TTL used %d, want 7", info.Used)
+	}
+
+	// This instance's own consumption invalidates the entry at once
+	if _, err := qm.ConsumeQuota(ctx, "u1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := qm.GetQuotaInfo(ctx, "u1"); info.Used != 8 {
+		t.Fatalf("read after consuming used %d, want 8", info.Used)
+	}
+}
+
+func TestRateLimiterWritesBypassReadCache(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryRedisClient()
+	config := RateLimitConfig{Enabled: true, Rate: 2, Burst: 2, Period: "1h"}
+	cached := NewRateLimiter(client, config)
+	cached.cache = newReadCache(time.Hour)
+	other := NewRateLimiter(client, config)
+
+	// Warm the cache with a full bucket, then drain it from another instance
+	if _, err := cached.GetLimitInfo(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if allowed, err := other.takeTokensNonAtomic(ctx, "u1", 1, 0); err != nil || !allowed {
+			t.Fatalf("take %d: allowed %v, %v", i+1, allowed, err)
+		}
+	}
+
+	// The write path starts from Redis, not the stale cached bucket
+	if allowed, err := cached.takeTokensNonAtomic(ctx, "u1", 1, 0); err != nil || allowed {
+		t.Fatalf("take from a drained bucket: allowed %v, %v", allowed, err)
+	}
+}
@@ -3,10 +3,14 @@ package traefik_quota_plugin
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,59 +18,129 @@ import (
 type RedisClient interface {
 	Ping(ctx context.Context) (string, error)
 	Get(ctx context.Context, key string) (string, error)
+	GetEx(ctx context.Context, key string, expiration time.Duration) (string, error)
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
 	Incr(ctx context.Context, key string) (int64, error)
 	IncrBy(ctx context.Context, key string, value int64) (int64, error)
-	Expire(ctx context.Context, key string, expiration time.Duration) error
+	IncrByFloat(ctx context.Context, key string, value float64) (float64, error)
+	Expire(ctx context.Context, key string, expiration time.Duration) (bool, error)
+	PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error)
 	TTL(ctx context.Context, key string) (time.Duration, error)
 	Exists(ctx context.Context, keys ...string) (int64, error)
+	Eval(ctx context.Context, script string, keys []string, args ...string) (interface{}, error)
+	Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error)
+	Del(ctx context.Context, keys ...string) (int64, error)
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
+	PFAdd(ctx context.Context, key string, elements ...string) (int64, error)
+	PFCount(ctx context.Context, keys ...string) (int64, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	SAdd(ctx context.Context, key string, members ...string) (int64, error)
+	SMembers(ctx context.Context, key string) ([]string, error)
+	Watch(ctx context.Context, fn func(tx RedisTx) error, keys ...string) error
 	Close() error
 }
 
-// SimpleRedisClient implements a basic Redis client using raw TCP connection
+// defaultConnectionName identifies plugin connections in CLIENT LIST
+const defaultConnectionName = "traefik-quota-plugin"
+
+// SimpleRedisClient implements a basic Redis client over a pool of raw TCP connections
 type SimpleRedisClient struct {
 	address  string
 	password string
 	db       int
-	conn     net.Conn
-	reader   *bufio.Reader
+	name     string
 	timeout  time.Duration
+
+	// Connection pool state, guarded by mu
+	mu          sync.Mutex
+	cond        *sync.Cond
+	idle        []*redisConn
+	active      int
+	closed      bool
+	maxIdle     int
+	maxActive   int
+	idleTimeout time.Duration
+
+	// loadingRetries is how often a command refused with LOADING is retried
+	loadingRetries int
+	// keepAliveInterval is how often idle connections are pinged (0 for never)
+	keepAliveInterval time.Duration
+
+	log          *pluginLogger // Logger of the owning plugin instance (nil for the default)
+	nameRefusals sync.Once     // Logs the first refused CLIENT SETNAME
 }
 
 // NewRedisClient creates a new simple Redis client
 func NewRedisClient(config RedisConfig) (RedisClient, error) {
+	return newRedisClient(config, nil)
+}
+
+// newRedisClient creates a simple Redis client logging to log
+func newRedisClient(config RedisConfig, log *pluginLogger) (RedisClient, error) {
 	client := &SimpleRedisClient{
-		address:  config.Address,
-		password: config.Password,
-		db:       config.DB,
-		timeout:  5 * time.Second,
+		address:   config.Address,
+		password:  config.Password,
+		db:        config.DB,
+		name:      config.ConnectionName,
+		timeout:   5 * time.Second,
+		maxIdle:   config.MaxIdle,
+		maxActive: config.MaxActive,
+		log:       log,
+	}
+	client.cond = sync.NewCond(&client.mu)
+	if client.name == "" {
+		client.name = defaultConnectionName
+	}
+	if client.maxIdle <= 0 {
+		client.maxIdle = defaultMaxIdle
+	}
+	switch {
+	case config.LoadingRetries < 0:
+		client.loadingRetries = 0
+	case config.LoadingRetries == 0:
+		client.loadingRetries = defaultLoadingRetries
+	default:
+		client.loadingRetries = config.LoadingRetries
+	}
+	if config.IdleTimeout != "" {
+		idleTimeout, err := time.ParseDuration(config.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid idle timeout: %w", err)
+		}
+		client.idleTimeout = idleTimeout
+	}
+	if config.KeepAliveInterval != "" {
+		keepAliveInterval, err := time.ParseDuration(config.KeepAliveInterval)
+		if err != nil || keepAliveInterval <= 0 {
+			return nil, fmt.Errorf("invalid keep alive interval: %s", config.KeepAliveInterval)
+		}
+		client.keepAliveInterval = keepAliveInterval
 	}
 
-	fmt.Printf("Redis: Creating client with address=%s, db=%d\n", config.Address, config.DB)
+	client.log.infof("Redis: Creating client with address=%s, db=%d", config.Address, config.DB)
 
 	// Test connection
-	if err := client.connect(); err != nil {
+	cn, err := client.connect()
+	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
+	client.active++
+	client.putConn(cn, nil)
 
 	// Test with ping
-	_, err := client.Ping(context.Background())
+	_, err = client.Ping(context.Background())
 	if err != nil {
 		client.Close()
 		return nil, fmt.Errorf("failed to ping Redis: %w", err)
 	}
 
-	fmt.Printf("Redis: Successfully connected to database %d\n", config.DB)
+	client.log.infof("Redis: Successfully connected to database %d", config.DB)
 	return client, nil
 }
 
 // Ping sends a PING command to Redis
 func (c *SimpleRedisClient) Ping(ctx context.Context) (string, error) {
-	if err := c.writeCommand("PING"); err != nil {
-		return "", err
-	}
-
-	resp, err := c.readResponse()
+	resp, err := c.do(ctx, "PING")
 	if err != nil {
 		return "", err
 	}
@@ -76,14 +150,28 @@ func (c *SimpleRedisClient) Ping(ctx context.Context) (string, error) {
 
 // Get retrieves a value from Redis
 func (c *SimpleRedisClient) Get(ctx context.Context, key string) (string, error) {
-	if err := c.writeCommand("GET", key); err != nil {
+	resp, err := c.do(ctx, "GET", key)
+	if err != nil {
+		if errors.Is(err, errKeyNotFound) {
+			return "", errKeyNotFound
+		}
 		return "", err
 	}
 
-	resp, err := c.readResponse()
+	return resp, nil
+}
+
+// GetEx retrieves a value and refreshes its expiration in one round trip
+func (c *SimpleRedisClient) GetEx(ctx context.Context, key string, expiration time.Duration) (string, error) {
+	if expiration <= 0 {
+		return c.Get(ctx, key)
+	}
+
+	seconds := int(math.Ceil(expiration.Seconds()))
+	resp, err := c.do(ctx, "GETEX", key, "EX", strconv.Itoa(seconds))
 	if err != nil {
-		if strings.Contains(err.Error(), "key not found") {
-			return "", fmt.Errorf("key not found")
+		if errors.Is(err, errKeyNotFound) {
+			return "", errKeyNotFound
 		}
 		return "", err
 	}
@@ -95,18 +183,13 @@ func (c *SimpleRedisClient) Get(ctx context.Context, key string) (string, error)
 func (c *SimpleRedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	valueStr := fmt.Sprintf("%v", value)
 
+	args := []string{"SET", key, valueStr}
 	if expiration > 0 {
 		seconds := int(expiration.Seconds())
-		if err := c.writeCommand("SETEX", key, strconv.Itoa(seconds), valueStr); err != nil {
-			return err
-		}
-	} else {
-		if err := c.writeCommand("SET", key, valueStr); err != nil {
-			return err
-		}
+		args = []string{"SETEX", key, strconv.Itoa(seconds), valueStr}
 	}
 
-	resp, err := c.readResponse()
+	resp, err := c.do(ctx, args...)
 	if err != nil {
 		return err
 	}
@@ -121,11 +204,7 @@ func (c *SimpleRedisClient) Set(ctx context.Context, key string, value interface
 
 // Incr increments a key's value by 1
 func (c *SimpleRedisClient) Incr(ctx context.Context, key string) (int64, error) {
-	if err := c.writeCommand("INCR", key); err != nil {
-		return 0, err
-	}
-
-	resp, err := c.readResponse()
+	resp, err := c.do(ctx, "INCR", key)
 	if err != nil {
 		return 0, err
 	}
@@ -140,49 +219,72 @@ func (c *SimpleRedisClient) Incr(ctx context.Context, key string) (int64, error)
 
 // IncrBy increments a key's value by a specified amount
 func (c *SimpleRedisClient) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
-	if err := c.writeCommand("INCRBY", key, strconv.FormatInt(value, 10)); err != nil {
+	resp, err := c.do(ctx, "INCRBY", key, strconv.FormatInt(value, 10))
+	if err != nil {
 		return 0, err
 	}
 
-	resp, err := c.readResponse()
+	result, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer response: %s", resp)
+	}
+
+	return result, nil
+}
+
+// IncrByFloat increments a key's value by a fractional amount (INCRBYFLOAT)
+func (c *SimpleRedisClient) IncrByFloat(ctx context.Context, key string, value float64) (float64, error) {
+	resp, err := c.do(ctx, "INCRBYFLOAT", key, strconv.FormatFloat(value, 'f', -1, 64))
 	if err != nil {
 		return 0, err
 	}
 
-	result, err := strconv.ParseInt(resp, 10, 64)
+	result, err := strconv.ParseFloat(resp, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid integer response: %s", resp)
+		return 0, fmt.Errorf("invalid float response: %s", resp)
 	}
 
 	return result, nil
 }
 
-// Expire sets an expiration time for a key
-func (c *SimpleRedisClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
-	seconds := int(expiration.Seconds())
-	if err := c.writeCommand("EXPIRE", key, strconv.Itoa(seconds)); err != nil {
-		return err
+// Expire sets an expiration time for a key, rounded up to whole seconds, and
+// reports whether the key existed
+func (c *SimpleRedisClient) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	// Round up so a sub-second expiration doesn't become EXPIRE 0, which deletes the key
+	seconds := int64(math.Ceil(expiration.Seconds()))
+	resp, err := c.do(ctx, "EXPIRE", key, strconv.FormatInt(seconds, 10))
+	if err != nil {
+		return false, err
 	}
 
-	resp, err := c.readResponse()
+	return parseExpireReply(resp)
+}
+
+// PExpire sets an expiration time for a key with millisecond precision and
+// reports whether the key existed
+func (c *SimpleRedisClient) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	milliseconds := int64(math.Ceil(float64(expiration) / float64(time.Millisecond)))
+	resp, err := c.do(ctx, "PEXPIRE", key, strconv.FormatInt(milliseconds, 10))
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if resp != "1" {
-		return fmt.Errorf("expire failed: key may not exist")
+	return parseExpireReply(resp)
+}
+
+// parseExpireReply interprets the integer reply of EXPIRE and PEXPIRE
+func parseExpireReply(resp string) (bool, error) {
+	result, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid expire response: %s", resp)
 	}
 
-	return nil
+	return result == 1, nil
 }
 
 // TTL returns the remaining time to live for a key
 func (c *SimpleRedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
-	if err := c.writeCommand("TTL", key); err != nil {
-		return 0, err
-	}
-
-	resp, err := c.readResponse()
+	resp, err := c.do(ctx, "TTL", key)
 	if err != nil {
 		return 0, err
 	}
@@ -196,7 +298,7 @@ func (c *SimpleRedisClient) TTL(ctx context.Context, key string) (time.Duration,
 		return -1, nil // No expiration
 	}
 	if seconds == -2 {
-		return 0, fmt.Errorf("key does not exist")
+		return 0, errKeyNotFound
 	}
 
 	return time.Duration(seconds) * time.Second, nil
@@ -205,67 +307,259 @@ func (c *SimpleRedisClient) TTL(ctx context.Context, key string) (time.Duration,
 // Exists checks if keys exist
 func (c *SimpleRedisClient) Exists(ctx context.Context, keys ...string) (int64, error) {
 	args := append([]string{"EXISTS"}, keys...)
-	if err := c.writeCommand(args...); err != nil {
+	resp, err := c.do(ctx, args...)
+	if err != nil {
 		return 0, err
 	}
 
-	resp, err := c.readResponse()
+	count, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid exists response: %s", resp)
+	}
+
+	return count, nil
+}
+
+// Eval runs a Lua script atomically and returns its reply. Arrays are returned as
+// []interface{}, integers as int64, strings as string and nil replies as nil.
+func (c *SimpleRedisClient) Eval(ctx context.Context, script string, keys []string, args ...string) (interface{}, error) {
+	command := append([]string{"EVAL", script, strconv.Itoa(len(keys))}, keys...)
+	command = append(command, args...)
+	return c.doReply(ctx, command...)
+}
+
+// Scan iterates keys matching a glob pattern, returning a page of keys and the next cursor
+func (c *SimpleRedisClient) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	reply, err := c.doReply(ctx, "SCAN", strconv.FormatUint(cursor, 10), "MATCH", match, "COUNT", strconv.FormatInt(count, 10))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, 0, fmt.Errorf("invalid scan response: %v", reply)
+	}
+
+	cursorStr, _ := values[0].(string)
+	next, err := strconv.ParseUint(cursorStr, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid scan cursor: %v", values[0])
+	}
+
+	items, _ := values[1].([]interface{})
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if key, ok := item.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, next, nil
+}
+
+// Del deletes keys and returns how many existed
+func (c *SimpleRedisClient) Del(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	resp, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
 	if err != nil {
 		return 0, err
 	}
 
 	count, err := strconv.ParseInt(resp, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid exists response: %s", resp)
+		return 0, fmt.Errorf("invalid del response: %s", resp)
 	}
 
 	return count, nil
 }
 
-// Close closes the Redis connection
+// MGet retrieves the values of keys in one round trip, in key order; missing
+// keys yield nil and present ones a string
+func (c *SimpleRedisClient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return []interface{}{}, nil
+	}
+
+	reply, err := c.doReply(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(keys) {
+		return nil, fmt.Errorf("invalid mget response: %v", reply)
+	}
+
+	return values, nil
+}
+
+// PFAdd adds elements to a HyperLogLog, returning 1 if its estimate changed
+func (c *SimpleRedisClient) PFAdd(ctx context.Context, key string, elements ...string) (int64, error) {
+	resp, err := c.do(ctx, append([]string{"PFADD", key}, elements...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	changed, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pfadd response: %s", resp)
+	}
+
+	return changed, nil
+}
+
+// PFCount returns the approximate number of distinct elements across HyperLogLogs
+func (c *SimpleRedisClient) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	resp, err := c.do(ctx, append([]string{"PFCOUNT"}, keys...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pfcount response: %s", resp)
+	}
+
+	return count, nil
+}
+
+// HGetAll returns the fields and values of a hash; a missing key yields an empty map
+func (c *SimpleRedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	reply, err := c.doReply(ctx, "HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values)%2 != 0 {
+		return nil, fmt.Errorf("invalid hgetall response: %v", reply)
+	}
+
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		field, fieldOK := values[i].(string)
+		value, valueOK := values[i+1].(string)
+		if !fieldOK || !valueOK {
+			return nil, fmt.Errorf("invalid hgetall response: %v", reply)
+		}
+		fields[field] = value
+	}
+
+	return fields, nil
+}
+
+// SAdd adds members to a set, returning how many were not already members
+func (c *SimpleRedisClient) SAdd(ctx context.Context, key string, members ...string) (int64, error) {
+	resp, err := c.do(ctx, append([]string{"SADD", key}, members...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	added, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sadd response: %s", resp)
+	}
+
+	return added, nil
+}
+
+// SMembers returns the members of a set in no particular order; a missing key yields an empty slice
+func (c *SimpleRedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	reply, err := c.doReply(ctx, "SMEMBERS", key)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid smembers response: %v", reply)
+	}
+
+	members := make([]string, 0, len(values))
+	for _, value := range values {
+		member, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid smembers response: %v", reply)
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// Close closes all pooled Redis connections
 func (c *SimpleRedisClient) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	var firstErr error
+	for _, cn := range c.idle {
+		if err := cn.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		c.active--
 	}
-	return nil
+	c.idle = nil
+	c.cond.Broadcast()
+	return firstErr
 }
 
-// connect establishes connection to Redis
-func (c *SimpleRedisClient) connect() error {
+// connect establishes a new connection to Redis
+func (c *SimpleRedisClient) connect() (*redisConn, error) {
 	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c.conn = conn
-	c.reader = bufio.NewReader(conn)
+	cn := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
 
 	// Authenticate if password is provided
 	if c.password != "" {
-		if err := c.auth(); err != nil {
-			c.conn.Close()
-			return err
+		if err := cn.auth(c.password); err != nil {
+			conn.Close()
+			return nil, err
 		}
 	}
 
 	// Always select database (even if it's 0 to ensure we're on the right DB)
-	if err := c.selectDB(); err != nil {
-		c.conn.Close()
-		return err
+	if err := cn.selectDB(c.db); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.log.infof("Redis: Successfully selected database %d", c.db)
+
+	// Name the connection so operators can spot it in CLIENT LIST. Naming is
+	// cosmetic, so proxies or ACLs refusing CLIENT leave the connection unnamed.
+	if err := cn.setName(c.name); err != nil {
+		if isConnectionError(err) {
+			conn.Close()
+			return nil, err
+		}
+		c.nameRefusals.Do(func() {
+			c.log.errorf("Redis: Connections stay unnamed, CLIENT SETNAME %s was refused: %v", c.name, err)
+		})
 	}
 
-	return nil
+	cn.lastUsed = time.Now()
+	return cn, nil
 }
 
 // auth authenticates with Redis
-func (c *SimpleRedisClient) auth() error {
-	cmd := fmt.Sprintf("*2\r\n$4\r\nAUTH\r\n$%d\r\n%s\r\n", len(c.password), c.password)
-	_, err := c.conn.Write([]byte(cmd))
+func (cn *redisConn) auth(password string) error {
+	cmd := fmt.Sprintf("*2\r\n$4\r\nAUTH\r\n$%d\r\n%s\r\n", len(password), password)
+	_, err := cn.conn.Write([]byte(cmd))
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.readResponse()
+	resp, err := cn.readResponse()
 	if err != nil {
 		return err
 	}
@@ -279,66 +573,75 @@ func (c *SimpleRedisClient) auth() error {
 }
 
 // selectDB selects Redis database
-func (c *SimpleRedisClient) selectDB() error {
-	dbStr := strconv.Itoa(c.db)
+func (cn *redisConn) selectDB(db int) error {
+	dbStr := strconv.Itoa(db)
 	cmd := fmt.Sprintf("*2\r\n$6\r\nSELECT\r\n$%d\r\n%s\r\n", len(dbStr), dbStr)
-	_, err := c.conn.Write([]byte(cmd))
+	_, err := cn.conn.Write([]byte(cmd))
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.readResponse()
+	resp, err := cn.readResponse()
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Redis: SELECT DB %d response: '%s'\n", c.db, resp)
-
 	// Redis SELECT command can return either "+OK" or just "OK"
 	if !strings.HasPrefix(resp, "+OK") && resp != "OK" {
 		return fmt.Errorf("select database failed: %s", resp)
 	}
-
-	fmt.Printf("Redis: Successfully selected database %d\n", c.db)
 	return nil
 }
 
-// writeCommand sends a Redis command
-func (c *SimpleRedisClient) writeCommand(args ...string) error {
-	if c.conn == nil {
-		if err := c.connect(); err != nil {
-			return err
-		}
+// setName sets the connection name via CLIENT SETNAME
+func (cn *redisConn) setName(name string) error {
+	cmd := fmt.Sprintf("*3\r\n$6\r\nCLIENT\r\n$7\r\nSETNAME\r\n$%d\r\n%s\r\n", len(name), name)
+	_, err := cn.conn.Write([]byte(cmd))
+	if err != nil {
+		return err
 	}
 
+	resp, err := cn.readResponse()
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(resp, "+OK") && resp != "OK" {
+		return fmt.Errorf("client setname failed: %s", resp)
+	}
+
+	return nil
+}
+
+// writeCommand sends a Redis command
+func (cn *redisConn) writeCommand(args ...string) error {
 	// Build RESP command
 	cmd := fmt.Sprintf("*%d\r\n", len(args))
 	for _, arg := range args {
 		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
 	}
 
-	_, err := c.conn.Write([]byte(cmd))
+	_, err := cn.conn.Write([]byte(cmd))
 	return err
 }
 
 // readResponse reads Redis response
-func (c *SimpleRedisClient) readResponse() (string, error) {
-	if c.reader == nil {
-		return "", fmt.Errorf("no connection")
-	}
-
-	line, err := c.reader.ReadString('\n')
+func (cn *redisConn) readResponse() (string, error) {
+	line, err := cn.reader.ReadString('\n')
 	if err != nil {
 		return "", err
 	}
 
 	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", errEmptyReply
+	}
 
 	switch line[0] {
 	case '+': // Simple string
 		return line[1:], nil
 	case '-': // Error
-		return "", fmt.Errorf("redis error: %s", line[1:])
+		return "", &RedisError{Message: line[1:]}
 	case ':': // Integer
 		return line[1:], nil
 	case '$': // Bulk string
@@ -347,19 +650,20 @@ func (c *SimpleRedisClient) readResponse() (string, error) {
 			return "", err
 		}
 		if length == -1 {
-			return "", fmt.Errorf("key not found")
+			return "", errKeyNotFound
 		}
-		if length == 0 {
-			c.reader.ReadString('\n') // consume \r\n
-			return "", nil
+		if length < 0 {
+			return "", fmt.Errorf("invalid bulk string length: %s", line)
 		}
 
 		data := make([]byte, length)
-		_, err = c.reader.Read(data)
+		_, err = io.ReadFull(cn.reader, data)
 		if err != nil {
 			return "", err
 		}
-		c.reader.ReadString('\n') // consume \r\n
+		if _, err := cn.reader.ReadString('\n'); err != nil { // consume \r\n
+			return "", fmt.Errorf("truncated bulk string reply: %w", err)
+		}
 		return string(data), nil
 	case '*': // Array
 		// For simplicity, we'll handle basic cases
@@ -369,19 +673,102 @@ func (c *SimpleRedisClient) readResponse() (string, error) {
 	}
 }
 
+// readReply reads a complete Redis reply, including nested arrays
+func (cn *redisConn) readReply() (interface{}, error) {
+	line, err := cn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, errEmptyReply
+	}
+
+	switch line[0] {
+	case '+': // Simple string
+		return line[1:], nil
+	case '-': // Error
+		return nil, &RedisError{Message: line[1:]}
+	case ':': // Integer
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$': // Bulk string
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if length == -1 {
+			return nil, nil
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("invalid bulk string length: %s", line)
+		}
+
+		data := make([]byte, length+2) // include trailing \r\n
+		if _, err := io.ReadFull(cn.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:length]), nil
+	case '*': // Array
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count == -1 {
+			return nil, nil
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("invalid array length: %s", line)
+		}
+
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := cn.readReply()
+			var redisErr *RedisError
+			if errors.As(err, &redisErr) {
+				// Error items (e.g. failed EXEC commands) are kept so the
+				// rest of the array is still consumed
+				items = append(items, redisErr)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply: %s", line)
+	}
+}
+
+// keyComponentEscaper percent-encodes the key separator (and the escape
+// character itself) so values containing colons, e.g. IPv6 addresses, can't
+// collide with other keys; values without them are left unchanged
+var keyComponentEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// escapeKeyComponent escapes a value embedded in a colon-separated key
+func escapeKeyComponent(value string) string {
+	return keyComponentEscaper.Replace(value)
+}
+
 // GetQuotaKey generates a Redis key for quota tracking
 func GetQuotaKey(identifier, period string) string {
-	return fmt.Sprintf("quota:%s:%s", identifier, period)
+	return fmt.Sprintf("quota:%s:%s", escapeKeyComponent(identifier), period)
 }
 
 // GetRateLimitKey generates a Redis key for rate limiting
 func GetRateLimitKey(identifier string) string {
-	return fmt.Sprintf("ratelimit:%s", identifier)
+	return fmt.Sprintf("ratelimit:%s", escapeKeyComponent(identifier))
 }
 
 // GetQuotaPeriodKey generates a period-specific key
 func GetQuotaPeriodKey(period string) string {
-	now := time.Now()
+	return quotaPeriodKey(period, time.Now())
+}
+
+// quotaPeriodKey generates the period-specific key for the given time
+func quotaPeriodKey(period string, now time.Time) string {
 	switch period {
 	case "Daily":
 		return now.Format("2006-01-02")
@@ -390,6 +777,8 @@ func GetQuotaPeriodKey(period string) string {
 		return fmt.Sprintf("%d-W%02d", year, week)
 	case "Monthly":
 		return now.Format("2006-01")
+	case "Lifetime":
+		return "lifetime"
 	default:
 		return now.Format("2006-01-02")
 	}
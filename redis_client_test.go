@@ -0,0 +1,284 @@
+package traefik_quota_plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hasCommand reports whether server received command
+func hasCommand(server *testRedisServer, command string) bool {
+	for _, received := range server.commands() {
+		if received == command {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewRedisClientSetsConnectionName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"", "CLIENT SETNAME " + defaultConnectionName},
+		{"billing-gateway", "CLIENT SETNAME billing-gateway"},
+	} {
+		server := newTestRedisServer(t)
+		client, err := NewRedisClient(RedisConfig{Address: server.addr, ConnectionName: tc.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		if !hasCommand(server, tc.want) {
+			t.Errorf("commands %v, want %q", server.commands(), tc.want)
+		}
+	}
+}
+
+func TestNewRedisClientToleratesRefusedSetName(t *testing.T) {
+	server := newTestRedisServer(t)
+	server.setHook(func(args []string) string {
+		if strings.EqualFold(args[0], "CLIENT") {
+			return "-ERR unknown command 'CLIENT'\r\n"
+		}
+		return ""
+	})
+
+	client, err := NewRedisClient(RedisConfig{Address: server.addr})
+	if err != nil {
+		t.Fatalf("refused CLIENT SETNAME failed the dial: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Set(context.Background(), "k", "v", 0); err != nil {
+		t.Fatalf("Set on an unnamed connection: %v", err)
+	}
+}
+
+func TestNewRedisClientLogsThroughItsLogger(t *testing.T) {
+	server := newTestRedisServer(t)
+	var buf bytes.Buffer
+	client, err := newRedisClient(RedisConfig{Address: server.addr}, &pluginLogger{out: log.New(&buf, "", 0), level: LogLevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if !strings.Contains(buf.String(), "Redis: Successfully connected to database 0") {
+		t.Fatalf("client log %q, want the connection reported", buf.String())
+	}
+
+	buf.Reset()
+	client, err = newRedisClient(RedisConfig{Address: server.addr}, &pluginLogger{out: log.New(&buf, "", 0), level: LogLevelError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if buf.Len() != 0 {
+		t.Fatalf("client logged %q below the logger's level", buf.String())
+	}
+}
+
+func TestSimpleRedisClientGetEx(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+
+	client.Set(ctx, "k", "v", 2*time.Second)
+	if value, err := client.GetEx(ctx, "k", 90*time.Minute); err != nil || value != "v" {
+		t.Fatalf("GetEx = %q, %v", value, err)
+	}
+	if !hasCommand(server, "GETEX k EX 5400") {
+		t.Fatalf("commands %q lack GETEX k EX 5400", server.commands())
+	}
+	if ttl, _ := client.TTL(ctx, "k"); ttl <= time.Hour {
+		t.Fatalf("TTL after GetEx %v, want it refreshed", ttl)
+	}
+
+	if _, err := client.GetEx(ctx, "missing", time.Minute); !errors.Is(err, errKeyNotFound) {
+		t.Fatalf("GetEx of a missing key error %v, want errKeyNotFound", err)
+	}
+}
+
+func TestSimpleRedisClientExpire(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+	client.Set(ctx, "k", "v", 0)
+
+	tests := []struct {
+		name    string
+		expire  func(key string) (bool, error)
+		command string
+	}{
+		{"Expire", func(key string) (bool, error) { return client.Expire(ctx, key, 1500*time.Millisecond) }, "EXPIRE k 2"},
+		{"PExpire", func(key string) (bool, error) { return client.PExpire(ctx, key, 1500*time.Millisecond) }, "PEXPIRE k 1500"},
+	}
+	for _, tc := range tests {
+		if existed, err := tc.expire("k"); err != nil || !existed {
+			t.Errorf("%s of an existing key = %v, %v", tc.name, existed, err)
+		}
+		if !hasCommand(server, tc.command) {
+			t.Errorf("%s: commands lack %q", tc.name, tc.command)
+		}
+		if existed, err := tc.expire("missing"); err != nil || existed {
+			t.Errorf("%s of a missing key = %v, %v", tc.name, existed, err)
+		}
+	}
+}
+
+func TestParseExpireReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply   string
+		existed bool
+		valid   bool
+	}{{"1", true, true}, {"0", false, true}, {"OK", false, false}} {
+		existed, err := parseExpireReply(tc.reply)
+		if existed != tc.existed || (err == nil) != tc.valid {
+			t.Errorf("parseExpireReply(%q) = %v, %v", tc.reply, existed, err)
+		}
+	}
+}
+
+func TestKeyComponentsDoNotCollide(t *testing.T) {
+	pairs := [][2]string{
+		{"2001:db8::1", "2001:db8:"},
+		{"a:b", "a%3Ab"},
+		{"a%b", "a%25b"},
+	}
+	for _, pair := range pairs {
+		if GetQuotaKey(pair[0], "2024-01-01") == GetQuotaKey(pair[1], "2024-01-01") {
+			t.Errorf("quota keys of %q and %q collide", pair[0], pair[1])
+		}
+		if GetRateLimitKey(pair[0]) == GetRateLimitKey(pair[1]) {
+			t.Errorf("rate limit keys of %q and %q collide", pair[0], pair[1])
+		}
+		if GetConcurrencyKey(pair[0]) == GetConcurrencyKey(pair[1]) {
+			t.Errorf("concurrency keys of %q and %q collide", pair[0], pair[1])
+		}
+	}
+
+	// The identifier and the period can't trade a colon
+	if GetQuotaKey("u1:2024", "01") == GetQuotaKey("u1", "2024:01") {
+		t.Error("quota keys differing only by colon placement collide")
+	}
+	if got := GetQuotaKey("u1", "2024-01-01"); got != "quota:u1:2024-01-01" {
+		t.Errorf("quota key without separators %q, want it unchanged", got)
+	}
+}
+
+// replyConn returns a connection that reads the given raw server replies
+func replyConn(raw string) *redisConn {
+	return &redisConn{reader: bufio.NewReader(strings.NewReader(raw))}
+}
+
+func TestReadResponseMalformedReplies(t *testing.T) {
+	tests := map[string]string{
+		"empty line":        "\r\n",
+		"half closed":       "",
+		"truncated bulk":    "$5\r\nab",
+		"missing bulk CRLF": "$2\r\nab",
+		"negative length":   "$-2\r\n",
+	}
+	for name, raw := range tests {
+		if _, err := replyConn(raw).readResponse(); err == nil {
+			t.Errorf("%s: readResponse succeeded", name)
+		} else if !isConnectionError(err) {
+			t.Errorf("%s: error %v keeps the connection", name, err)
+		}
+		if _, err := replyConn(raw).readReply(); err == nil {
+			t.Errorf("%s: readReply succeeded", name)
+		}
+	}
+
+	if _, err := replyConn("*-3\r\n").readReply(); err == nil {
+		t.Error("negative array length accepted")
+	}
+}
+
+func TestReadResponseEmptyBulk(t *testing.T) {
+	cn := replyConn("$0\r\n\r\n+OK\r\n")
+	if value, err := cn.readResponse(); err != nil || value != "" {
+		t.Fatalf("empty bulk = %q, %v", value, err)
+	}
+	// The terminator was consumed, keeping the connection in sync
+	if value, err := cn.readResponse(); err != nil || value != "OK" {
+		t.Fatalf("next reply = %q, %v", value, err)
+	}
+}
+
+func TestReadResponseErrorKinds(t *testing.T) {
+	sentinels := []error{ErrNoScript, ErrMoved, ErrAsk, ErrLoading, ErrReadOnly}
+	tests := []struct {
+		reply string
+		want  error
+	}{
+		{"-NOSCRIPT No matching script. Please use EVAL.", ErrNoScript},
+		{"-MOVED 3999 127.0.0.1:6381", ErrMoved},
+		{"-ASK 3999 127.0.0.1:6381", ErrAsk},
+		{"-LOADING Redis is loading the dataset in memory", ErrLoading},
+		{"-READONLY You can't write against a read only replica.", ErrReadOnly},
+		{"-ERR unknown command", nil},
+	}
+	for _, tc := range tests {
+		_, err := replyConn(tc.reply + "\r\n").readResponse()
+		var redisErr *RedisError
+		if !errors.As(err, &redisErr) {
+			t.Fatalf("%s: error %v, want a RedisError", tc.reply, err)
+		}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == tc.want) {
+				t.Errorf("%s: errors.Is(%v) = %v", tc.reply, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestRedisErrorRedirect(t *testing.T) {
+	slot, address, ok := (&RedisError{Message: "MOVED 3999 127.0.0.1:6381"}).Redirect()
+	if !ok || slot != 3999 || address != "127.0.0.1:6381" {
+		t.Fatalf("Redirect = %d, %q, %v", slot, address, ok)
+	}
+	for _, message := range []string{"ERR wrong", "MOVED x 127.0.0.1:6381", "ASK 1"} {
+		if _, _, ok := (&RedisError{Message: message}).Redirect(); ok {
+			t.Errorf("%q parsed as a redirect", message)
+		}
+	}
+}
+
+func TestSAddSMembers(t *testing.T) {
+	server := newTestRedisServer(t)
+	clients := map[string]RedisClient{
+		"simple": newTestRedisClient(t, server, RedisConfig{}),
+		"memory": NewMemoryRedisClient(),
+	}
+	for name, client := range clients {
+		ctx := context.Background()
+
+		if members, err := client.SMembers(ctx, "set:"+name); err != nil || members == nil || len(members) != 0 {
+			t.Fatalf("%s: SMembers of a missing key = %v, %v; want an empty slice", name, members, err)
+		}
+		if added, err := client.SAdd(ctx, "set:"+name, "b", "a"); err != nil || added != 2 {
+			t.Fatalf("%s: SAdd = %d, %v; want 2", name, added, err)
+		}
+		// Members already in the set are not counted again
+		if added, err := client.SAdd(ctx, "set:"+name, "a", "c", "c"); err != nil || added != 1 {
+			t.Fatalf("%s: duplicate SAdd = %d, %v; want 1", name, added, err)
+		}
+
+		members, err := client.SMembers(ctx, "set:"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(members)
+		if strings.Join(members, ",") != "a,b,c" {
+			t.Fatalf("%s: members %v, want a, b and c", name, members)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultConnectAttempts is how often the initial connection is tried when ConnectAttempts is not configured
+	defaultConnectAttempts = 3
+	// defaultConnectMaxDelay caps the backoff between connection attempts
+	defaultConnectMaxDelay = 2 * time.Second
+	// connectBaseDelay is the delay after the first failed attempt; it doubles per attempt
+	connectBaseDelay = 100 * time.Millisecond
+)
+
+// ConnectRedisClient creates a Redis client, retrying the initial connect and
+// ping with exponential backoff so a Redis that is still starting up during a
+// deploy doesn't disable the plugin. It gives up early when ctx is cancelled.
+func ConnectRedisClient(ctx context.Context, config RedisConfig) (RedisClient, error) {
+	return connectWithRetry(ctx, config, newRedisClient, nil)
+}
+
+// validateConnect checks the settings of the initial connection attempts
+func (rc RedisConfig) validateConnect() error {
+	if rc.ConnectAttempts < 0 {
+		return fmt.Errorf("connect attempts must not be negative")
+	}
+	if rc.ConnectMaxDelay != "" {
+		if delay, err := time.ParseDuration(rc.ConnectMaxDelay); err != nil || delay <= 0 {
+			return fmt.Errorf("invalid connect max delay: %s", rc.ConnectMaxDelay)
+		}
+	}
+	return nil
+}
+
+// connectWithRetry runs dial until it succeeds, the attempts are exhausted or
+// ctx is done, logging failed attempts to log
+func connectWithRetry(ctx context.Context, config RedisConfig, dial func(RedisConfig, *pluginLogger) (RedisClient, error), log *pluginLogger) (RedisClient, error) {
+	attempts := config.ConnectAttempts
+	if attempts <= 0 {
+		attempts = defaultConnectAttempts
+	}
+
+	maxDelay := defaultConnectMaxDelay
+	if config.ConnectMaxDelay != "" {
+		parsed, err := time.ParseDuration(config.ConnectMaxDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connect max delay: %w", err)
+		}
+		maxDelay = parsed
+	}
+
+	delay := connectBaseDelay
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		client, err := dial(config, log)
+		if err == nil {
+			return client, nil
+		}
+		lastErr = err
+
+		if attempt == attempts {
+			break
+		}
+
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+		log.errorf("Redis connection attempt %d/%d to %s failed, retrying in %v: %v", attempt, attempts, config.Address, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("gave up connecting to Redis: %w", ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+
+	return nil, fmt.Errorf("failed to connect to Redis after %d attempts: %w", attempts, lastErr)
+}
@@ -0,0 +1,104 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyDial fails the first failures dials, counting every dial
+func flakyDial(failures int, dials *int) func(RedisConfig, *pluginLogger) (RedisClient, error) {
+	return func(RedisConfig, *pluginLogger) (RedisClient, error) {
+		*dials++
+		if *dials <= failures {
+			return nil, errors.New("connection refused")
+		}
+		return NewMemoryRedisClient(), nil
+	}
+}
+
+func TestConnectWithRetrySucceedsAfterFailures(t *testing.T) {
+	dials := 0
+	config := RedisConfig{ConnectAttempts: 3, ConnectMaxDelay: "10ms"}
+	client, err := connectWithRetry(context.Background(), config, flakyDial(2, &dials), nil)
+	if err != nil || client == nil {
+		t.Fatalf("connectWithRetry = %v, %v", client, err)
+	}
+	if dials != 3 {
+		t.Fatalf("%d dials, want 3", dials)
+	}
+}
+
+func TestConnectWithRetryGivesUpAfterAttempts(t *testing.T) {
+	dials := 0
+	config := RedisConfig{ConnectAttempts: 3, ConnectMaxDelay: "10ms"}
+	_, err := connectWithRetry(context.Background(), config, flakyDial(10, &dials), nil)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("error %v, want giving up after 3 attempts", err)
+	}
+	if dials != 3 {
+		t.Fatalf("%d dials, want 3", dials)
+	}
+}
+
+func TestConnectWithRetryBacksOffExponentially(t *testing.T) {
+	dials := 0
+	config := RedisConfig{ConnectAttempts: 4, ConnectMaxDelay: "250ms"}
+	start := time.Now()
+	connectWithRetry(context.Background(), config, flakyDial(10, &dials), nil)
+
+	// 100ms + 200ms + 250ms (capped)
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("four attempts took %v, want about 550ms", elapsed)
+	}
+}
+
+func TestConnectWithRetryHonorsCancellation(t *testing.T) {
+	dials := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	config := RedisConfig{ConnectAttempts: 10, ConnectMaxDelay: "1h"}
+
+	start := time.Now()
+	_, err := connectWithRetry(ctx, config, flakyDial(10, &dials), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error %v, want the context's", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cancelled connect returned after %v", elapsed)
+	}
+}
+
+func TestConnectRedisClient(t *testing.T) {
+	server := newTestRedisServer(t)
+	client, err := ConnectRedisClient(context.Background(), RedisConfig{Address: server.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	// Nothing listens on a closed server's address
+	server.Close()
+	if _, err := ConnectRedisClient(context.Background(), RedisConfig{Address: server.addr, ConnectAttempts: 2, ConnectMaxDelay: "10ms"}); err == nil {
+		t.Fatal("connected to a closed server")
+	}
+}
+
+func TestValidateConnectSettings(t *testing.T) {
+	for _, redis := range []RedisConfig{{ConnectAttempts: -1}, {ConnectMaxDelay: "soon"}, {ConnectMaxDelay: "0s"}} {
+		config := validConfig()
+		config.Persistence.Redis.ConnectAttempts = redis.ConnectAttempts
+		config.Persistence.Redis.ConnectMaxDelay = redis.ConnectMaxDelay
+		if err := config.Validate(); err == nil {
+			t.Errorf("attempts %d, max delay %q accepted", redis.ConnectAttempts, redis.ConnectMaxDelay)
+		}
+
+		config = validConfig()
+		config.Persistence.Connections = map[string]RedisConfig{"tenants": {Address: "localhost:6380", ConnectAttempts: redis.ConnectAttempts, ConnectMaxDelay: redis.ConnectMaxDelay}}
+		if err := config.Validate(); err == nil {
+			t.Errorf("connection with attempts %d, max delay %q accepted", redis.ConnectAttempts, redis.ConnectMaxDelay)
+		}
+	}
+}
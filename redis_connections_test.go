@@ -0,0 +1,73 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// mentions reports whether server received a command naming value
+func mentions(server *testRedisServer, value string) bool {
+	for _, received := range server.commands() {
+		if strings.Contains(received, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIdentifiersUseTheirNamedConnection(t *testing.T) {
+	primary, shard := newTestRedisServer(t), newTestRedisServer(t)
+	handler := newTestPlugin(t, primary, func(c *Config) {
+		c.Persistence.Connections = map[string]RedisConfig{"tenants": {Address: shard.addr}}
+		c.Identifiers = append(c.Identifiers, IdentifierConfig{
+			Type:            "Header",
+			Name:            "X-Tenant",
+			Value:           "acme",
+			RedisConnection: "tenants",
+			Quota:           QuotaSettings{Enabled: true, Limit: 5, Period: "Daily"},
+		})
+	})
+
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("primary identifier: status %d", rw.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant", "acme")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("sharded identifier: status %d", rw.Code)
+	}
+
+	if !mentions(primary, "u1") || mentions(primary, "acme") {
+		t.Errorf("primary received %q", primary.commands())
+	}
+	if !mentions(shard, "acme") || mentions(shard, "u1") {
+		t.Errorf("shard received %q", shard.commands())
+	}
+}
+
+func TestValidateRejectsUnknownConnection(t *testing.T) {
+	config := validConfig()
+	config.Identifiers[0].RedisConnection = "missing"
+	if err := config.Validate(); err == nil {
+		t.Fatal("identifier referencing an unknown connection accepted")
+	}
+}
+
+func TestServeHTTPUnreachableConnectionFailureMode(t *testing.T) {
+	for mode, want := range map[string]int{"open": http.StatusOK, "closed": http.StatusServiceUnavailable} {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			c.FailureMode = mode
+			c.Persistence.Connections = map[string]RedisConfig{"tenants": {Address: "127.0.0.1:1", ConnectAttempts: 1}}
+			c.Identifiers[0].RedisConnection = "tenants"
+		})
+
+		if rw := serveAs(handler, "u1"); rw.Code != want {
+			t.Errorf("failure mode %s: status %d with the connection down, want %d", mode, rw.Code, want)
+		}
+	}
+}
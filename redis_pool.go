@@ -0,0 +1,394 @@
+package traefik_quota_plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxIdle is the number of idle connections kept when MaxIdle is not configured
+const defaultMaxIdle = 4
+
+// errKeyNotFound is returned for nil bulk replies
+var errKeyNotFound = errors.New("key not found")
+
+// errEmptyReply is returned for blank reply lines, e.g. from a half-closed
+// connection, which leave the connection out of sync with the server
+var errEmptyReply = errors.New("empty redis reply")
+
+// RedisError is an error reply sent by the Redis server
+type RedisError struct {
+	Message string
+}
+
+// Error implements the error interface
+func (e *RedisError) Error() string {
+	return fmt.Sprintf("redis error: %s", e.Message)
+}
+
+// Error reply kinds callers react to, matched against a RedisError with errors.Is
+var (
+	ErrNoScript = errors.New("redis: script not cached (NOSCRIPT)") // Load the script again
+	ErrMoved    = errors.New("redis: slot moved (MOVED)")           // Redirect to the node in the reply
+	ErrAsk      = errors.New("redis: slot migrating (ASK)")         // Redirect this command only
+	ErrLoading  = errors.New("redis: dataset loading (LOADING)")    // Retry once Redis has loaded
+	ErrReadOnly = errors.New("redis: read-only replica (READONLY)") // Reconnect to the primary
+)
+
+// redisErrorKinds maps the prefix of an error reply to its sentinel
+var redisErrorKinds = map[string]error{
+	"NOSCRIPT": ErrNoScript,
+	"MOVED":    ErrMoved,
+	"ASK":      ErrAsk,
+	"LOADING":  ErrLoading,
+	"READONLY": ErrReadOnly,
+}
+
+// Prefix returns the error code the reply starts with (e.g. ERR or MOVED)
+func (e *RedisError) Prefix() string {
+	prefix, _, _ := strings.Cut(e.Message, " ")
+	return prefix
+}
+
+// Is makes errors.Is match the sentinel of the reply's prefix, e.g. ErrMoved
+func (e *RedisError) Is(target error) bool {
+	kind, ok := redisErrorKinds[e.Prefix()]
+	return ok && kind == target
+}
+
+// Redirect returns the hash slot and node address of a MOVED or ASK reply
+// (e.g. MOVED 3999 127.0.0.1:6381)
+func (e *RedisError) Redirect() (slot int, address string, ok bool) {
+	fields := strings.Fields(e.Message)
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return 0, "", false
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return slot, fields[2], true
+}
+
+// redisConn is a single pooled connection to Redis
+type redisConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	lastUsed time.Time
+}
+
+// Retries of commands Redis refuses while it loads its dataset after a restart
+const (
+	defaultLoadingRetries = 3
+	loadingRetryBaseDelay = 50 * time.Millisecond // Delay before the first retry; it doubles per retry
+	loadingRetryMaxDelay  = 5 * time.Second       // Longest delay between retries
+)
+
+// retryLoading reports whether a command that failed with err should be
+// retried because Redis is still loading, waiting the backoff of the retry-th
+// retry first. Once the retries are exhausted or ctx is done the error is
+// returned as is.
+func (c *SimpleRedisClient) retryLoading(ctx context.Context, err error, retry int) bool {
+	if retry >= c.loadingRetries || !errors.Is(err, ErrLoading) {
+		return false
+	}
+
+	timer := time.NewTimer(loadingRetryDelay(retry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// loadingRetryDelay returns the backoff of the retry-th retry, doubling from
+// loadingRetryBaseDelay up to loadingRetryMaxDelay
+func loadingRetryDelay(retry int) time.Duration {
+	delay := loadingRetryBaseDelay
+	for i := 0; i < retry && delay < loadingRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, loadingRetryMaxDelay)
+}
+
+// do runs a single command, retrying while Redis loads, and returns its reply
+func (c *SimpleRedisClient) do(ctx context.Context, args ...string) (string, error) {
+	for retry := 0; ; retry++ {
+		resp, err := c.doOnce(args...)
+		if !c.retryLoading(ctx, err, retry) {
+			return resp, err
+		}
+	}
+}
+
+// doOnce runs a single command on a pooled connection and returns its reply
+func (c *SimpleRedisClient) doOnce(args ...string) (string, error) {
+	cn, err := c.getConn()
+	if err != nil {
+		return "", err
+	}
+
+	if err := cn.writeCommand(args...); err != nil {
+		c.putConn(cn, err)
+		return "", err
+	}
+
+	resp, err := cn.readResponse()
+	c.putConn(cn, err)
+	return resp, err
+}
+
+// doReply runs a single command, retrying while Redis loads, and returns its full reply
+func (c *SimpleRedisClient) doReply(ctx context.Context, args ...string) (interface{}, error) {
+	for retry := 0; ; retry++ {
+		reply, err := c.doReplyOnce(args...)
+		if !c.retryLoading(ctx, err, retry) {
+			return reply, err
+		}
+	}
+}
+
+// doReplyOnce runs a single command on a pooled connection and returns its full reply
+func (c *SimpleRedisClient) doReplyOnce(args ...string) (interface{}, error) {
+	cn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cn.writeCommand(args...); err != nil {
+		c.putConn(cn, err)
+		return nil, err
+	}
+
+	reply, err := cn.readReply()
+	c.putConn(cn, err)
+	return reply, err
+}
+
+// getConn takes an idle connection from the pool or dials a new one, waiting
+// while MaxActive connections are in use
+func (c *SimpleRedisClient) getConn() (*redisConn, error) {
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("redis client is closed")
+		}
+
+		// Reuse the most recently used idle connection, evicting stale ones
+		for len(c.idle) > 0 {
+			cn := c.idle[len(c.idle)-1]
+			c.idle = c.idle[:len(c.idle)-1]
+			if c.isStale(cn, time.Now()) {
+				c.active--
+				cn.conn.Close()
+				continue
+			}
+			c.mu.Unlock()
+			return cn, nil
+		}
+
+		if c.maxActive <= 0 || c.active < c.maxActive {
+			c.active++
+			c.mu.Unlock()
+
+			cn, err := c.connect()
+			if err != nil {
+				c.mu.Lock()
+				c.active--
+				c.cond.Signal()
+				c.mu.Unlock()
+				return nil, err
+			}
+			return cn, nil
+		}
+
+		c.cond.Wait()
+	}
+}
+
+// putConn returns a connection to the pool. Connections that failed with a
+// transport error, or that exceed MaxIdle, are closed.
+func (c *SimpleRedisClient) putConn(cn *redisConn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.cond.Signal()
+
+	if isConnectionError(err) || c.closed || len(c.idle) >= c.maxIdle {
+		c.active--
+		cn.conn.Close()
+		return
+	}
+
+	cn.lastUsed = time.Now()
+	c.idle = append(c.idle, cn)
+}
+
+// isStale reports whether an idle connection exceeded the idle timeout. Callers must hold c.mu.
+func (c *SimpleRedisClient) isStale(cn *redisConn, now time.Time) bool {
+	return c.idleTimeout > 0 && now.Sub(cn.lastUsed) > c.idleTimeout
+}
+
+// StartReaper closes connections idle longer than IdleTimeout in the background
+// until ctx is done
+func (c *SimpleRedisClient) StartReaper(ctx context.Context) {
+	if c.idleTimeout <= 0 {
+		return
+	}
+
+	interval := c.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.reapIdle(now)
+			}
+		}
+	}()
+}
+
+// StartKeepAlive pings idle connections every KeepAliveInterval in the
+// background until ctx is done, so a middlebox dropping idle TCP connections
+// fails the ping instead of the next request
+func (c *SimpleRedisClient) StartKeepAlive(ctx context.Context) {
+	if c.keepAliveInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.keepAliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.pingIdle()
+			}
+		}
+	}()
+}
+
+// pingIdle pings the idle connections, closing those that fail. Each one is
+// taken out of the pool only while its own ping runs, so commands meanwhile
+// still find the others idle.
+func (c *SimpleRedisClient) pingIdle() {
+	c.mu.Lock()
+	idle := append([]*redisConn(nil), c.idle...)
+	c.mu.Unlock()
+
+	for _, cn := range idle {
+		if !c.takeIdle(cn) {
+			// A command used it since, which proves it alive
+			continue
+		}
+
+		err := cn.ping(c.timeout)
+		if err != nil {
+			c.log.errorf("Redis: Closing idle connection that failed keepalive: %v", err)
+			cn.conn.Close()
+		}
+
+		c.mu.Lock()
+		switch {
+		case err != nil:
+			c.active--
+		case c.closed || len(c.idle) >= c.maxIdle:
+			c.active--
+			cn.conn.Close()
+		default:
+			// Back at the least recently used end, keeping its lastUsed so
+			// IdleTimeout still reaps it
+			c.idle = append([]*redisConn{cn}, c.idle...)
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+// takeIdle removes cn from the idle connections, reporting whether it was still there
+func (c *SimpleRedisClient) takeIdle(cn *redisConn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, idle := range c.idle {
+		if idle == cn {
+			c.idle = append(c.idle[:i], c.idle[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ping sends PING on the connection, failing after timeout
+func (cn *redisConn) ping(timeout time.Duration) error {
+	if err := cn.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer cn.conn.SetDeadline(time.Time{})
+
+	if err := cn.writeCommand("PING"); err != nil {
+		return err
+	}
+	resp, err := cn.readResponse()
+	if err != nil {
+		return err
+	}
+	if resp != "PONG" {
+		return fmt.Errorf("unexpected ping response: %s", resp)
+	}
+	return nil
+}
+
+// reapIdle closes idle connections past the idle timeout
+func (c *SimpleRedisClient) reapIdle(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kept := c.idle[:0]
+	for _, cn := range c.idle {
+		if c.isStale(cn, now) {
+			c.active--
+			cn.conn.Close()
+			continue
+		}
+		kept = append(kept, cn)
+	}
+	c.idle = kept
+	c.cond.Broadcast()
+}
+
+// isConnectionError reports whether err leaves the connection unusable.
+// Error replies and nil replies keep the protocol in sync.
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, errKeyNotFound) {
+		return false
+	}
+	var redisErr *RedisError
+	return !errors.As(err, &redisErr)
+}
+
+// isUnknownCommand reports whether err is Redis rejecting a command it doesn't
+// know, e.g. one newer than the server
+func isUnknownCommand(err error) bool {
+	var redisErr *RedisError
+	return errors.As(err, &redisErr) && strings.Contains(strings.ToLower(redisErr.Message), "unknown command")
+}
@@ -0,0 +1,322 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dials counts the connections opened to server, each of which names itself
+func dials(server *testRedisServer) int {
+	n := 0
+	for _, received := range server.commands() {
+		if strings.HasPrefix(received, "CLIENT SETNAME") {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestRedisClient connects a SimpleRedisClient of config to server
+func newTestRedisClient(t *testing.T, server *testRedisServer, config RedisConfig) *SimpleRedisClient {
+	t.Helper()
+	config.Address = server.addr
+	client, err := NewRedisClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client.(*SimpleRedisClient)
+}
+
+func TestPoolReusesIdleConnections(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Exists(context.Background(), "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := dials(server); n != 1 {
+		t.Fatalf("%d connections dialed, want 1", n)
+	}
+}
+
+func TestPoolRedialsAfterIdleTimeout(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{IdleTimeout: "20ms"})
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.Exists(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := dials(server); n != 2 {
+		t.Fatalf("%d connections dialed, want a new one after the idle timeout", n)
+	}
+}
+
+func TestReapIdleClosesStaleConnections(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{IdleTimeout: "1m"})
+
+	client.reapIdle(time.Now())
+	if len(client.idle) != 1 {
+		t.Fatalf("%d idle connections, want the fresh one kept", len(client.idle))
+	}
+	client.reapIdle(time.Now().Add(time.Hour))
+	if len(client.idle) != 0 || client.active != 0 {
+		t.Fatalf("%d idle and %d active connections after reaping", len(client.idle), client.active)
+	}
+}
+
+func TestPutConnClosesConnectionsBeyondMaxIdle(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{MaxIdle: 1})
+
+	first, err := client.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.putConn(first, nil)
+	client.putConn(second, nil)
+	if len(client.idle) != 1 || client.active != 1 {
+		t.Fatalf("%d idle and %d active connections, want 1 each", len(client.idle), client.active)
+	}
+}
+
+// loadingFor makes server refuse the first n GETs with LOADING and returns
+// a function reporting how many GETs it received
+func loadingFor(server *testRedisServer, n int) func() int {
+	var mu sync.Mutex
+	gets := 0
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) != "GET" {
+			return ""
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		gets++
+		if gets <= n {
+			return "-LOADING Redis is loading the dataset in memory\r\n"
+		}
+		return ""
+	})
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return gets
+	}
+}
+
+func TestRetriesWhileLoading(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+	server.mem.Set(ctx, "k", "v", 0)
+	gets := loadingFor(server, 2)
+
+	if value, err := client.Get(ctx, "k"); err != nil || value != "v" {
+		t.Fatalf("Get = %q, %v; want the value once Redis has loaded", value, err)
+	}
+	if n := gets(); n != 3 {
+		t.Fatalf("%d GETs, want two refused and one answered", n)
+	}
+}
+
+func TestRetriesWhileLoadingGiveUp(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		retries, gets int
+	}{
+		{-1, 1},
+		{2, 3},
+	} {
+		server := newTestRedisServer(t)
+		client := newTestRedisClient(t, server, RedisConfig{LoadingRetries: tc.retries})
+		gets := loadingFor(server, 10)
+
+		if _, err := client.Get(ctx, "k"); !errors.Is(err, ErrLoading) {
+			t.Fatalf("LoadingRetries %d: error %v, want ErrLoading", tc.retries, err)
+		}
+		if n := gets(); n != tc.gets {
+			t.Fatalf("LoadingRetries %d: %d GETs, want %d", tc.retries, n, tc.gets)
+		}
+	}
+}
+
+func TestRetriesWhileLoadingStopWithContext(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{LoadingRetries: 10})
+	gets := loadingFor(server, 100)
+
+	// The backoff of the later retries alone would take seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Get(ctx, "k"); !errors.Is(err, ErrLoading) {
+		t.Fatalf("error %v, want ErrLoading", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Get returned after %v, want it to stop with its context", elapsed)
+	}
+	if n := gets(); n >= 10 {
+		t.Fatalf("%d GETs, want the retries cut short", n)
+	}
+}
+
+func TestLoadingRetryDelay(t *testing.T) {
+	for retry, want := range map[int]time.Duration{
+		0:   50 * time.Millisecond,
+		3:   400 * time.Millisecond,
+		6:   3200 * time.Millisecond,
+		7:   loadingRetryMaxDelay,
+		40:  loadingRetryMaxDelay,
+		100: loadingRetryMaxDelay,
+	} {
+		if got := loadingRetryDelay(retry); got != want {
+			t.Errorf("retry %d: delay %v, want %v", retry, got, want)
+		}
+	}
+}
+
+func TestWatchRetriesWhileLoading(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+	server.mem.Set(ctx, "k", "v", 0)
+
+	var mu sync.Mutex
+	mgets := 0
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) != "MGET" {
+			return ""
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		mgets++
+		if mgets == 1 {
+			return "-LOADING Redis is loading the dataset in memory\r\n"
+		}
+		return ""
+	})
+
+	var values []interface{}
+	err := client.Watch(ctx, func(tx RedisTx) error {
+		var err error
+		values, err = tx.MGet(ctx, "k")
+		return err
+	}, "k")
+	if err != nil || len(values) != 1 || values[0] != "v" {
+		t.Fatalf("Watch read %v, %v; want the value once Redis has loaded", values, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if mgets != 2 {
+		t.Fatalf("%d MGETs, want one refused and one answered", mgets)
+	}
+}
+
+func TestValidateLoadingRetries(t *testing.T) {
+	for retries, valid := range map[int]bool{-2: false, -1: true, 0: true, 5: true} {
+		config := validConfig()
+		config.Persistence.Redis.LoadingRetries = retries
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("LoadingRetries %d: error %v, want valid %v", retries, err, valid)
+		}
+	}
+}
+
+func TestPingIdleRemovesFailedConnections(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+	l, buf := bufferLogger()
+	client.log = l
+
+	// A healthy connection answers PONG and stays pooled
+	client.pingIdle()
+	if len(client.idle) != 1 || client.active != 1 {
+		t.Fatalf("%d idle and %d active connections after a good keepalive, want 1 and 1", len(client.idle), client.active)
+	}
+
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) == "PING" {
+			return "-ERR keepalive refused\r\n"
+		}
+		return ""
+	})
+	client.pingIdle()
+	if len(client.idle) != 0 || client.active != 0 {
+		t.Fatalf("%d idle and %d active connections after a failed keepalive, want none", len(client.idle), client.active)
+	}
+	if !strings.Contains(buf.String(), "Closing idle connection that failed keepalive") {
+		t.Fatalf("log %q, want the closed connection reported", buf.String())
+	}
+
+	// The next command dials a replacement
+	server.setHook(nil)
+	before := dials(server)
+	if err := client.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if dials(server) != before+1 {
+		t.Fatal("no connection dialed to replace the closed one")
+	}
+}
+
+func TestPingIdleLeavesOtherConnectionsIdle(t *testing.T) {
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{MaxIdle: 2})
+	first, err := client.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.putConn(first, nil)
+	client.putConn(second, nil)
+
+	// The first keepalive stalls until released
+	pinging := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) == "PING" {
+			pinging <- struct{}{}
+			<-release
+		}
+		return ""
+	})
+	done := make(chan struct{})
+	go func() {
+		client.pingIdle()
+		close(done)
+	}()
+	<-pinging
+
+	// A command meanwhile gets the connection not being pinged
+	before := dials(server)
+	cn, err := client.getConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dials(server) != before {
+		t.Fatal("dialed while an idle connection was available")
+	}
+	client.putConn(cn, nil)
+
+	close(release)
+	<-done
+	if len(client.idle) != 2 || client.active != 2 {
+		t.Fatalf("%d idle and %d active connections after the keepalive, want 2 and 2", len(client.idle), client.active)
+	}
+}
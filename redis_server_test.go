@@ -0,0 +1,315 @@
+package traefik_quota_plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRedisServer speaks enough RESP over TCP for SimpleRedisClient, storing
+// its data in a MemoryRedisClient
+type testRedisServer struct {
+	addr string
+	mem  *MemoryRedisClient
+	ln   net.Listener
+
+	mu       sync.Mutex
+	received []string
+	hook     func(args []string) string // Overrides a command's reply unless it returns ""
+}
+
+// newTestRedisServer starts a server on a free local port, closed when the test ends
+func newTestRedisServer(t testing.TB) *testRedisServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &testRedisServer{addr: ln.Addr().String(), mem: NewMemoryRedisClient(), ln: ln}
+	t.Cleanup(f.Close)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+// Close stops accepting connections
+func (f *testRedisServer) Close() { f.ln.Close() }
+
+// setHook installs a function overriding replies; it returns "" to fall through
+func (f *testRedisServer) setHook(hook func(args []string) string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hook = hook
+}
+
+// commands returns the commands received so far
+func (f *testRedisServer) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.received...)
+}
+
+// serve answers the commands of one connection, queueing MULTI blocks for EXEC
+func (f *testRedisServer) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	var tx RedisTx
+	var queued [][]string
+	inMulti := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line)[1:])
+		args := make([]string, n)
+		for i := 0; i < n; i++ {
+			l, _ := r.ReadString('\n')
+			ln, _ := strconv.Atoi(strings.TrimSpace(l)[1:])
+			buf := make([]byte, ln+2)
+			io.ReadFull(r, buf)
+			args[i] = string(buf[:ln])
+		}
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "WATCH":
+			f.record(args)
+			f.mem.Watch(context.Background(), func(t RedisTx) error { tx = t; return nil }, args[1:]...)
+			c.Write([]byte("+OK\r\n"))
+		case cmd == "UNWATCH":
+			f.record(args)
+			tx = nil
+			c.Write([]byte("+OK\r\n"))
+		case cmd == "MULTI":
+			f.record(args)
+			inMulti, queued = true, nil
+			c.Write([]byte("+OK\r\n"))
+		case cmd == "EXEC":
+			f.mu.Lock()
+			hook := f.hook
+			f.mu.Unlock()
+			if hook != nil {
+				hook(args)
+			}
+			f.record(args)
+			inMulti = false
+			t := tx
+			tx = nil
+			if t == nil {
+				f.mem.Watch(context.Background(), func(x RedisTx) error { t = x; return nil })
+			}
+			ok, err := t.Exec(context.Background(), queued...)
+			if err != nil {
+				c.Write([]byte(respError(err)))
+			} else if !ok {
+				c.Write([]byte("*-1\r\n"))
+			} else {
+				out := fmt.Sprintf("*%d\r\n", len(queued))
+				for range queued {
+					out += "+OK\r\n"
+				}
+				c.Write([]byte(out))
+			}
+		case inMulti:
+			f.record(args)
+			queued = append(queued, args)
+			c.Write([]byte("+QUEUED\r\n"))
+		default:
+			c.Write([]byte(f.handle(args)))
+		}
+	}
+}
+
+// record appends a command to the received log
+func (f *testRedisServer) record(args []string) {
+	f.mu.Lock()
+	f.received = append(f.received, strings.Join(args, " "))
+	f.mu.Unlock()
+}
+
+// RESP encoders of the replies
+func respBulk(s string) string   { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+func respInteger(n int64) string { return fmt.Sprintf(":%d\r\n", n) }
+func respError(err error) string {
+	var re *RedisError
+	if errors.As(err, &re) {
+		return "-" + re.Message + "\r\n"
+	}
+	return "-ERR " + err.Error() + "\r\n"
+}
+
+func respBool(b bool) string {
+	if b {
+		return ":1\r\n"
+	}
+	return ":0\r\n"
+}
+
+func respEncode(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "$-1\r\n"
+	case int64:
+		return respInteger(x)
+	case string:
+		return respBulk(x)
+	case []interface{}:
+		s := fmt.Sprintf("*%d\r\n", len(x))
+		for _, e := range x {
+			s += respEncode(e)
+		}
+		return s
+	}
+	return respBulk(fmt.Sprint(v))
+}
+
+// handle answers a single command from the in-memory client
+func (f *testRedisServer) handle(args []string) string {
+	f.mu.Lock()
+	f.received = append(f.received, strings.Join(args, " "))
+	hook := f.hook
+	f.mu.Unlock()
+	if hook != nil {
+		if reply := hook(args); reply != "" {
+			return reply
+		}
+	}
+	ctx := context.Background()
+	m := f.mem
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT", "CLIENT":
+		return "+OK\r\n"
+	case "GET", "GETEX":
+		var ttl time.Duration
+		if cmd == "GETEX" && len(args) == 4 {
+			s, _ := strconv.Atoi(args[3])
+			ttl = time.Duration(s) * time.Second
+		}
+		v, err := m.GetEx(ctx, args[1], ttl)
+		if err != nil {
+			return "$-1\r\n"
+		}
+		return respBulk(v)
+	case "SET":
+		m.Set(ctx, args[1], args[2], 0)
+		return "+OK\r\n"
+	case "SETEX":
+		s, _ := strconv.Atoi(args[2])
+		m.Set(ctx, args[1], args[3], time.Duration(s)*time.Second)
+		return "+OK\r\n"
+	case "INCR", "INCRBY":
+		by := int64(1)
+		if len(args) > 2 {
+			by, _ = strconv.ParseInt(args[2], 10, 64)
+		}
+		v, err := m.IncrBy(ctx, args[1], by)
+		if err != nil {
+			return respError(err)
+		}
+		return respInteger(v)
+	case "INCRBYFLOAT":
+		by, _ := strconv.ParseFloat(args[2], 64)
+		v, err := m.IncrByFloat(ctx, args[1], by)
+		if err != nil {
+			return respError(err)
+		}
+		return respBulk(strconv.FormatFloat(v, 'f', -1, 64))
+	case "EXPIRE":
+		s, _ := strconv.ParseInt(args[2], 10, 64)
+		ok, _ := m.PExpire(ctx, args[1], time.Duration(s)*time.Second)
+		return respBool(ok)
+	case "PEXPIRE":
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		ok, _ := m.PExpire(ctx, args[1], time.Duration(ms)*time.Millisecond)
+		return respBool(ok)
+	case "TTL":
+		ttl, err := m.TTL(ctx, args[1])
+		if err != nil {
+			return ":-2\r\n"
+		}
+		if ttl < 0 {
+			return ":-1\r\n"
+		}
+		return respInteger(int64(ttl / time.Second))
+	case "EXISTS":
+		n, _ := m.Exists(ctx, args[1:]...)
+		return respInteger(n)
+	case "MGET":
+		vals, _ := m.MGet(ctx, args[1:]...)
+		out := fmt.Sprintf("*%d\r\n", len(vals))
+		for _, v := range vals {
+			if v == nil {
+				out += "$-1\r\n"
+			} else {
+				out += respBulk(v.(string))
+			}
+		}
+		return out
+	case "DEL":
+		n, _ := m.Del(ctx, args[1:]...)
+		return respInteger(n)
+	case "PFADD":
+		n, _ := m.PFAdd(ctx, args[1], args[2:]...)
+		return respInteger(n)
+	case "PFCOUNT":
+		n, _ := m.PFCount(ctx, args[1:]...)
+		return respInteger(n)
+	case "SADD":
+		n, _ := m.SAdd(ctx, args[1], args[2:]...)
+		return respInteger(n)
+	case "SMEMBERS":
+		members, _ := m.SMembers(ctx, args[1])
+		items := []interface{}{}
+		for _, v := range members {
+			items = append(items, v)
+		}
+		return respEncode(items)
+	case "HGETALL":
+		h, _ := m.HGetAll(ctx, args[1])
+		items := []interface{}{}
+		for k, v := range h {
+			items = append(items, k, v)
+		}
+		return respEncode(items)
+	case "SCAN":
+		match := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				match = args[i+1]
+			}
+		}
+		keys, _, _ := m.Scan(ctx, 0, match, 0)
+		items := []interface{}{"0"}
+		list := []interface{}{}
+		for _, k := range keys {
+			list = append(list, k)
+		}
+		items = append(items, list)
+		return respEncode(items)
+	case "EVAL":
+		nk, _ := strconv.Atoi(args[2])
+		reply, err := m.Eval(ctx, args[1], args[3:3+nk], args[3+nk:]...)
+		if err != nil {
+			return respError(err)
+		}
+		return respEncode(reply)
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
@@ -0,0 +1,155 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// RedisTx is a connection pinned by Watch for an optimistic transaction: read
+// the watched keys, compute, then Exec the writes. Exec reports false without
+// applying anything when a watched key was modified since Watch.
+type RedisTx interface {
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
+	Exec(ctx context.Context, commands ...[]string) (bool, error)
+	Unwatch(ctx context.Context) error
+}
+
+// Watch runs fn on a connection watching keys (WATCH). The connection is
+// unwatched and returned to the pool when fn returns. Like single commands,
+// the whole transaction is retried while Redis loads its dataset.
+func (c *SimpleRedisClient) Watch(ctx context.Context, fn func(tx RedisTx) error, keys ...string) error {
+	for retry := 0; ; retry++ {
+		err := c.watchOnce(ctx, fn, keys...)
+		if !c.retryLoading(ctx, err, retry) {
+			return err
+		}
+	}
+}
+
+// watchOnce runs fn once on a pooled connection watching keys
+func (c *SimpleRedisClient) watchOnce(ctx context.Context, fn func(tx RedisTx) error, keys ...string) error {
+	cn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+
+	tx := &redisTx{cn: cn}
+	if _, err := tx.command(append([]string{"WATCH"}, keys...)...); err != nil {
+		c.putConn(cn, err)
+		return fmt.Errorf("failed to watch keys: %w", err)
+	}
+	tx.watching = true
+
+	err = fn(tx)
+
+	// Never hand a connection that still watches keys back to the pool
+	if tx.watching && !isConnectionError(tx.err) {
+		tx.Unwatch(ctx)
+	}
+	c.putConn(cn, tx.err)
+	return err
+}
+
+// redisTx is the RedisTx of a pooled connection
+type redisTx struct {
+	cn       *redisConn
+	watching bool
+	err      error // last transport error, which discards the connection
+}
+
+// command sends a single command and reads its full reply
+func (tx *redisTx) command(args ...string) (interface{}, error) {
+	if err := tx.cn.writeCommand(args...); err != nil {
+		tx.err = err
+		return nil, err
+	}
+
+	reply, err := tx.cn.readReply()
+	if isConnectionError(err) {
+		tx.err = err
+	}
+	return reply, err
+}
+
+// MGet reads keys on the watching connection; missing keys yield nil
+func (tx *redisTx) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	reply, err := tx.command(append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(keys) {
+		return nil, fmt.Errorf("invalid mget response: %v", reply)
+	}
+
+	return values, nil
+}
+
+// Exec runs commands inside MULTI/EXEC. A nil EXEC reply means a watched key
+// changed and nothing was applied.
+func (tx *redisTx) Exec(ctx context.Context, commands ...[]string) (bool, error) {
+	// EXEC always ends the WATCH, even when it fails
+	tx.watching = false
+
+	// Pipeline the whole transaction, then read every reply to stay in sync
+	if err := tx.cn.writeCommand("MULTI"); err != nil {
+		tx.err = err
+		return false, err
+	}
+	for _, command := range commands {
+		if err := tx.cn.writeCommand(command...); err != nil {
+			tx.err = err
+			return false, err
+		}
+	}
+	if err := tx.cn.writeCommand("EXEC"); err != nil {
+		tx.err = err
+		return false, err
+	}
+
+	var queueErr error
+	for i := 0; i <= len(commands); i++ {
+		if _, err := tx.cn.readReply(); err != nil {
+			if isConnectionError(err) {
+				tx.err = err
+				return false, err
+			}
+			if queueErr == nil {
+				queueErr = err
+			}
+		}
+	}
+
+	reply, err := tx.cn.readReply()
+	if isConnectionError(err) {
+		tx.err = err
+		return false, err
+	}
+	if queueErr != nil {
+		return false, fmt.Errorf("failed to queue transaction: %w", queueErr)
+	}
+	if err != nil {
+		return false, err
+	}
+	if reply == nil {
+		return false, nil
+	}
+
+	// Commands failing at run time are reported inside the EXEC reply
+	results, _ := reply.([]interface{})
+	for i, result := range results {
+		if resultErr, ok := result.(error); ok {
+			return true, fmt.Errorf("transaction command %s failed: %w", strings.ToUpper(commands[i][0]), resultErr)
+		}
+	}
+	return true, nil
+}
+
+// Unwatch forgets the watched keys
+func (tx *redisTx) Unwatch(ctx context.Context) error {
+	tx.watching = false
+	_, err := tx.command("UNWATCH")
+	return err
+}
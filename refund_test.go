@@ -0,0 +1,74 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestServeHTTPRefundsAuthFailures(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		wantUsed []int
+	}{
+		{http.StatusUnauthorized, []int{1, 1, 1}},
+		{http.StatusForbidden, []int{1, 1, 1}},
+		{http.StatusInternalServerError, []int{1, 2, 3}},
+	} {
+		t.Run(strconv.Itoa(tc.status), func(t *testing.T) {
+			server := newTestRedisServer(t)
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tc.status)
+			})
+			handler := newTestPluginNext(t, server, next, func(c *Config) {
+				c.Identifiers[0].RateLimit.Enabled = false
+				c.Identifiers[0].Quota.AuthFailureStatuses = []int{http.StatusUnauthorized, http.StatusForbidden}
+			})
+
+			for i, want := range tc.wantUsed {
+				rw := serveAs(handler, "u1")
+				if rw.Code != tc.status {
+					t.Fatalf("request %d: status %d, want the upstream %d", i+1, rw.Code, tc.status)
+				}
+				if got := rw.Header().Get("X-Quota-Used"); got != strconv.Itoa(want) {
+					t.Fatalf("request %d: X-Quota-Used %q, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTPRefundsCancelledRequests(t *testing.T) {
+	for _, tc := range []struct {
+		refund   bool
+		wantUsed string
+	}{{true, "1"}, {false, "2"}} {
+		server := newTestRedisServer(t)
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			// The client disconnects before the upstream answers
+			if cancel, ok := req.Context().Value(cancelKey{}).(context.CancelFunc); ok {
+				cancel()
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+		})
+		handler := newTestPluginNext(t, server, next, func(c *Config) {
+			c.Identifiers[0].RateLimit.Enabled = false
+			c.Identifiers[0].Quota.RefundOnCancel = tc.refund
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(context.WithValue(ctx, cancelKey{}, cancel)))
+
+		if got := serveAs(handler, "u1").Header().Get("X-Quota-Used"); got != tc.wantUsed {
+			t.Errorf("refund on cancel %v: X-Quota-Used of the next request %s, want %s", tc.refund, got, tc.wantUsed)
+		}
+	}
+}
+
+// cancelKey carries the function cancelling a test request's context
+type cancelKey struct{}
@@ -0,0 +1,63 @@
+package traefik_quota_plugin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// maxRequestIDLength bounds incoming request ids echoed back to the client
+const maxRequestIDLength = 128
+
+// blockedRequestID returns the id correlating a blocked response with its log
+// line and sets it as the RequestIDHeader of the response: the request's own
+// RequestIDHeader value when it is safe to echo, or a new random id. It
+// returns "" when request ids are disabled.
+func (q *quotaPlugin) blockedRequestID(rw http.ResponseWriter, req *http.Request) string {
+	if q.config.RequestIDHeader == "" {
+		return ""
+	}
+
+	id := req.Header.Get(q.config.RequestIDHeader)
+	if !isValidRequestID(id) {
+		var err error
+		if id, err = newRequestID(); err != nil {
+			q.log.errorf("Failed to generate request id: %v", err)
+		}
+	}
+	rw.Header().Set(q.config.RequestIDHeader, id)
+	return id
+}
+
+// newRequestID generates a random 128-bit request id
+func newRequestID() (string, error) {
+	var buf [16]byte
+	_, err := rand.Read(buf[:])
+	return hex.EncodeToString(buf[:]), err
+}
+
+// isValidRequestID reports whether an incoming request id can be echoed in
+// headers, JSON and log lines as is
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDLogSuffix formats a request id for the end of a log line
+func requestIDLogSuffix(id string) string {
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [request_id=%s]", id)
+}
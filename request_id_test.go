@@ -0,0 +1,73 @@
+package traefik_quota_plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTPBlockedRequestID(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.StructuredErrors = true
+		c.RequestIDHeader = "X-Request-Id"
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+	buf := captureLogs(handler)
+
+	serveAs(handler, "u1")
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rw.Code)
+	}
+	id := rw.Header().Get("X-Request-Id")
+	if len(id) != 32 {
+		t.Fatalf("generated request id %q, want 32 hex characters", id)
+	}
+
+	var envelope structuredError
+	if err := json.Unmarshal(rw.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.RequestID != id {
+		t.Fatalf("envelope request_id %q, want the header's %q", envelope.RequestID, id)
+	}
+	if !strings.Contains(buf.String(), "Request blocked: Rate limit exceeded") || !strings.Contains(buf.String(), "[request_id="+id+"]") {
+		t.Fatalf("log %q doesn't carry the request id %s", buf.String(), id)
+	}
+}
+
+func TestServeHTTPEchoesRequestID(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.RequestIDHeader = "X-Request-Id"
+	})
+
+	tests := []struct {
+		name     string
+		incoming string
+		echoed   bool
+	}{
+		{"valid", "req-42.a:b_c", true},
+		{"unsafe characters", "req 42\"}", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Id", tc.incoming)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req) // No identifier, so blocked
+
+		id := rw.Header().Get("X-Request-Id")
+		if id == "" || (id == tc.incoming) != tc.echoed {
+			t.Errorf("%s: response id %q, want echoed %v", tc.name, id, tc.echoed)
+		}
+	}
+
+	// Allowed responses don't get an id
+	if rw := serveAs(handler, "u1"); rw.Header().Get("X-Request-Id") != "" {
+		t.Fatalf("allowed response has request id %q", rw.Header().Get("X-Request-Id"))
+	}
+}
@@ -0,0 +1,73 @@
+package traefik_quota_plugin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Actions taken on requests whose Content-Length exceeds MaxRequestBytes
+const (
+	OversizeActionReject = "reject" // Block the request with 413 Payload Too Large (default)
+	OversizeActionCost   = "cost"   // Charge the request OversizeCostMultiplier times its cost
+)
+
+// ReasonRequestTooLarge is the block reason of requests over MaxRequestBytes
+const ReasonRequestTooLarge = "Request too large"
+
+// isOversized reports whether the request declares a body larger than
+// MaxRequestBytes. Bodies of unknown length are not checked.
+func (ic *IdentifierConfig) isOversized(req *http.Request) bool {
+	return ic.MaxRequestBytes > 0 && req.ContentLength > ic.MaxRequestBytes
+}
+
+// rejectsOversized reports whether oversized requests are blocked rather than charged more
+func (ic *IdentifierConfig) rejectsOversized() bool {
+	return ic.OversizeAction == "" || ic.OversizeAction == OversizeActionReject
+}
+
+// oversizeCost returns the cost of a request, multiplied by
+// OversizeCostMultiplier when it is oversized and charged for it
+func (ic *IdentifierConfig) oversizeCost(req *http.Request, cost int64) int64 {
+	if ic.rejectsOversized() || !ic.isOversized(req) {
+		return cost
+	}
+	tracef(req, "Request of %d bytes exceeds %d, multiplying its cost by %d", req.ContentLength, ic.MaxRequestBytes, ic.OversizeCostMultiplier)
+	if cost > maxSafeCount/ic.OversizeCostMultiplier {
+		return maxSafeCount
+	}
+	return cost * ic.OversizeCostMultiplier
+}
+
+// oversizedResponse builds the response blocking a request over MaxRequestBytes
+func (m *IdentifierManager) oversizedResponse(identifier string) *QuotaResponse {
+	return &QuotaResponse{
+		Allowed:        false,
+		Identifier:     identifier,
+		IdentifierType: m.config.Type,
+		Reason:         ReasonRequestTooLarge,
+		ResponseCode:   http.StatusRequestEntityTooLarge,
+	}
+}
+
+// validateRequestSize checks the request size limit and its action
+func (ic *IdentifierConfig) validateRequestSize() error {
+	if ic.MaxRequestBytes < 0 {
+		return fmt.Errorf("max request bytes must not be negative")
+	}
+	switch ic.OversizeAction {
+	case "", OversizeActionReject:
+		if ic.OversizeCostMultiplier != 0 {
+			return fmt.Errorf("oversize cost multiplier requires the %s oversize action", OversizeActionCost)
+		}
+	case OversizeActionCost:
+		if ic.OversizeCostMultiplier < 2 || ic.OversizeCostMultiplier > defaultMaxCost {
+			return fmt.Errorf("oversize cost multiplier must be between 2 and %d", defaultMaxCost)
+		}
+	default:
+		return fmt.Errorf("unsupported oversize action: %s", ic.OversizeAction)
+	}
+	if ic.OversizeAction != "" && ic.MaxRequestBytes == 0 {
+		return fmt.Errorf("oversize action requires max request bytes")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postAs serves a POST of size bytes for user
+func postAs(handler http.Handler, user string, size int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", size)))
+	req.Header.Set("X-User-ID", user)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestServeHTTPMaxRequestBytesReject(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].MaxRequestBytes = 100
+	})
+
+	rw := postAs(handler, "u1", 101)
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized request: status %d, want 413", rw.Code)
+	}
+	if mentions(server, "u1") {
+		t.Fatal("oversized request reached Redis")
+	}
+
+	if rw := postAs(handler, "u1", 100); rw.Code != http.StatusOK {
+		t.Fatalf("request at the limit: status %d, want 200", rw.Code)
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("bodyless request: status %d, want 200", rw.Code)
+	}
+}
+
+func TestServeHTTPMaxRequestBytesRejectAlwaysEnforced(t *testing.T) {
+	for name, configure := range map[string]func(*Config){
+		"before EnforceAfter":    func(c *Config) { c.EnforceAfter = time.Now().Add(time.Hour).Format(time.RFC3339) },
+		"outside EnforcePercent": func(c *Config) { c.EnforcePercent = intPtr(0) },
+	} {
+		server := newTestRedisServer(t)
+		handler := newTestPlugin(t, server, func(c *Config) {
+			configure(c)
+			c.Identifiers[0].MaxRequestBytes = 100
+		})
+
+		if rw := postAs(handler, "u1", 101); rw.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: oversized request status %d, want 413", name, rw.Code)
+		}
+	}
+}
+
+func TestServeHTTPMaxRequestBytesCost(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.Limit = 10
+		c.Identifiers[0].MaxRequestBytes = 100
+		c.Identifiers[0].OversizeAction = OversizeActionCost
+		c.Identifiers[0].OversizeCostMultiplier = 4
+	})
+
+	for _, tc := range []struct {
+		size      int
+		remaining string
+	}{
+		{50, "9"},
+		{500, "5"},
+		{500, "1"},
+	} {
+		rw := postAs(handler, "u1", tc.size)
+		if rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Remaining") != tc.remaining {
+			t.Fatalf("%d bytes: status %d, remaining %s; want 200 and %s", tc.size, rw.Code, rw.Header().Get("X-Quota-Remaining"), tc.remaining)
+		}
+	}
+	// One unit is left, not the four an oversized request costs
+	if rw := postAs(handler, "u1", 500); rw.Code != http.StatusForbidden {
+		t.Fatalf("oversized request over the quota: status %d, want 403", rw.Code)
+	}
+}
+
+func TestValidateRequestSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int64
+		action     string
+		multiplier int64
+		valid      bool
+	}{
+		{"disabled", 0, "", 0, true},
+		{"reject", 100, "", 0, true},
+		{"explicit reject", 100, OversizeActionReject, 0, true},
+		{"cost", 100, OversizeActionCost, 3, true},
+		{"negative size", -1, "", 0, false},
+		{"multiplier with reject", 100, OversizeActionReject, 3, false},
+		{"multiplier too low", 100, OversizeActionCost, 1, false},
+		{"unknown action", 100, "truncate", 0, false},
+		{"action without size", 0, OversizeActionCost, 3, false},
+	}
+	for _, tc := range tests {
+		config := validConfig()
+		config.Identifiers[0].MaxRequestBytes = tc.max
+		config.Identifiers[0].OversizeAction = tc.action
+		config.Identifiers[0].OversizeCostMultiplier = tc.multiplier
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: error %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// sampledContextKey marks whether allowed-request events of a request should be recorded
+type sampledContextKey struct{}
+
+// sampler decides which allowed-request events are logged
+type sampler struct {
+	mu   sync.Mutex
+	rate float64
+	rng  *rand.Rand
+}
+
+// newSampler creates a sampler recording the given fraction of events
+func newSampler(rate float64, seed int64) *sampler {
+	return &sampler{
+		rate: rate,
+		rng:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Sample reports whether the current event should be recorded
+func (s *sampler) Sample() bool {
+	if s == nil || s.rate >= 1 {
+		return true
+	}
+	if s.rate <= 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < s.rate
+}
+
+// withSampling stores the sampling decision for a request in its context
+func withSampling(req *http.Request, sampled bool) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), sampledContextKey{}, sampled))
+}
+
+// isSampled reports whether allowed-request events of the request should be recorded
+func isSampled(req *http.Request) bool {
+	sampled, ok := req.Context().Value(sampledContextKey{}).(bool)
+	return !ok || sampled
+}
+
+// tracef logs an allowed-request event if the request was sampled
+func tracef(req *http.Request, format string, args ...interface{}) {
+	if isSampled(req) {
+		requestLogger(req).infof(format, args...)
+	}
+}
@@ -0,0 +1,90 @@
+package traefik_quota_plugin
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSamplerApproximatesRate(t *testing.T) {
+	for _, rate := range []float64{0.01, 0.1, 0.5, 0.9} {
+		s := newSampler(rate, 42)
+		const events = 100000
+		sampled := 0
+		for i := 0; i < events; i++ {
+			if s.Sample() {
+				sampled++
+			}
+		}
+		if got := float64(sampled) / events; math.Abs(got-rate) > 0.01 {
+			t.Errorf("rate %v: sampled fraction %v", rate, got)
+		}
+	}
+}
+
+func TestSamplerIsDeterministicPerSeed(t *testing.T) {
+	a, b := newSampler(0.5, 7), newSampler(0.5, 7)
+	for i := 0; i < 1000; i++ {
+		if a.Sample() != b.Sample() {
+			t.Fatalf("samplers with the same seed diverged at event %d", i)
+		}
+	}
+}
+
+func TestSamplerBounds(t *testing.T) {
+	var none *sampler
+	all, off := newSampler(1, 1), newSampler(0, 1)
+	for i := 0; i < 100; i++ {
+		if !none.Sample() || !all.Sample() {
+			t.Fatal("nil sampler or rate 1 skipped an event")
+		}
+		if off.Sample() {
+			t.Fatal("rate 0 sampled an event")
+		}
+	}
+}
+
+func TestWithSampling(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if !isSampled(req) {
+		t.Fatal("requests without a decision must be sampled")
+	}
+	if isSampled(withSampling(req, false)) {
+		t.Fatal("unsampled request reported as sampled")
+	}
+}
+
+func TestEffectiveSampleRate(t *testing.T) {
+	if rate := CreateConfig().EffectiveSampleRate(); rate != 1 {
+		t.Fatalf("default sample rate %v, want 1", rate)
+	}
+	if rate := (&Config{}).EffectiveSampleRate(); rate != 1 {
+		t.Fatalf("sample rate of a zero Config %v, want 1", rate)
+	}
+
+	// An explicit 0 survives a round trip and logs nothing
+	var config Config
+	if err := json.Unmarshal([]byte(`{"sample_rate": 0}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(config)
+	var decoded Config
+	json.Unmarshal(encoded, &decoded)
+	if decoded.SampleRate == nil || decoded.EffectiveSampleRate() != 0 {
+		t.Fatalf("explicit 0 sample rate lost in %s", encoded)
+	}
+}
+
+func TestValidateSampleRate(t *testing.T) {
+	for _, tc := range []struct {
+		rate  float64
+		valid bool
+	}{{-0.1, false}, {0, true}, {0.5, true}, {1, true}, {1.5, false}} {
+		config := validConfig()
+		config.SampleRate = &tc.rate
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("sample rate %v: Validate() = %v", tc.rate, err)
+		}
+	}
+}
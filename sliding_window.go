@@ -0,0 +1,132 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// slidingWindowScript atomically trims a per-identifier ZSET to the window, counts
+// the remaining requests and, when under the limit, records the new ones with a
+// microsecond score.
+//
+// KEYS[1] window key, ARGV: now (µs), window (µs), limit, cost, member prefix,
+// reserve (slots that must stay free after recording cost). Returns {allowed,
+// count, oldest score or -1}.
+const slidingWindowScript = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local reserve = tonumber(ARGV[6])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if cost > 0 and count + cost + reserve <= limit then
+  for i = 1, cost do
+    redis.call('ZADD', key, ARGV[1], ARGV[5] .. ':' .. i)
+  end
+  count = count + cost
+  allowed = 1
+end
+redis.call('PEXPIRE', key, math.ceil(window / 1000))
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local oldestScore = -1
+if oldest[2] then
+  oldestScore = tonumber(oldest[2])
+end
+return {allowed, count, oldestScore}
+`
+
+// slidingWindowResult is the decoded reply of slidingWindowScript
+type slidingWindowResult struct {
+	Allowed bool
+	Count   int
+	Oldest  time.Time // zero when the window is empty
+}
+
+// evalSlidingWindow runs the sliding window script, recording cost requests
+// when at least reserve slots stay free afterwards. A cost of 0 only inspects
+// the window.
+func (rl *RateLimiter) evalSlidingWindow(ctx context.Context, identifier string, cost, reserve int) (slidingWindowResult, error) {
+	window, err := rl.config.ParseRateLimitPeriod()
+	if err != nil {
+		return slidingWindowResult{}, fmt.Errorf("invalid period: %w", err)
+	}
+
+	now := time.Now().UnixMicro()
+	member := strconv.FormatInt(now, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	reply, err := rl.redisClient.Eval(ctx, slidingWindowScript,
+		[]string{rl.bucketKey(identifier) + ":window"},
+		strconv.FormatInt(now, 10),
+		strconv.FormatInt(window.Microseconds(), 10),
+		strconv.Itoa(rl.config.Rate),
+		strconv.Itoa(cost),
+		member,
+		strconv.Itoa(reserve),
+	)
+	if err != nil {
+		return slidingWindowResult{}, fmt.Errorf("failed to evaluate sliding window: %w", err)
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return slidingWindowResult{}, fmt.Errorf("invalid sliding window reply: %v", reply)
+	}
+
+	allowed, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+	oldest, _ := values[2].(int64)
+
+	result := slidingWindowResult{
+		Allowed: allowed == 1,
+		Count:   int(count),
+	}
+	if oldest >= 0 {
+		result.Oldest = time.UnixMicro(oldest)
+	}
+	return result, nil
+}
+
+// slidingWindowInfo builds rate limit info from the current window state
+func (rl *RateLimiter) slidingWindowInfo(ctx context.Context, identifier string) (RateLimitInfo, error) {
+	window, err := rl.config.ParseRateLimitPeriod()
+	if err != nil {
+		return RateLimitInfo{}, fmt.Errorf("invalid period: %w", err)
+	}
+
+	result, err := rl.evalSlidingWindow(ctx, identifier, 0, 0)
+	if err != nil {
+		return RateLimitInfo{}, err
+	}
+
+	now := time.Now()
+	available := rl.config.Rate - result.Count
+	if available < 0 {
+		available = 0
+	}
+
+	// The window frees up a slot once its oldest request slides out
+	resetTime := now
+	if !result.Oldest.IsZero() {
+		resetTime = result.Oldest.Add(window)
+	}
+
+	var retryAfter time.Duration
+	if available == 0 && resetTime.After(now) {
+		retryAfter = resetTime.Sub(now)
+	}
+
+	return RateLimitInfo{
+		Limit:      rl.config.Rate,
+		Burst:      rl.config.Rate,
+		Available:  available,
+		Used:       result.Count,
+		ResetTime:  resetTime,
+		RetryAfter: retryAfter,
+	}, nil
+}
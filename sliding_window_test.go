@@ -0,0 +1,82 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSlidingWindowLimiter limits to rate requests per period with the sliding window
+func newSlidingWindowLimiter(client RedisClient, rate int, period string) *RateLimiter {
+	config := RateLimitConfig{Enabled: true, Rate: rate, Burst: rate, Period: period, Algorithm: AlgorithmSlidingWindow}
+	return NewRateLimiter(client, config)
+}
+
+func TestSlidingWindowLimitsWithinTheWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter := newSlidingWindowLimiter(NewMemoryRedisClient(), 3, "1h")
+
+	for i, want := range []bool{true, true, true, false} {
+		allowed, err := limiter.Allow(ctx, "u1")
+		if err != nil || allowed != want {
+			t.Fatalf("request %d: allowed %v, %v; want %v", i+1, allowed, err, want)
+		}
+	}
+
+	info, err := limiter.GetLimitInfo(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Used != 3 || info.Available != 0 || info.RetryAfter <= 59*time.Minute {
+		t.Fatalf("full window: used %d, available %d, retry after %v", info.Used, info.Available, info.RetryAfter)
+	}
+}
+
+func TestSlidingWindowFreesSlotsAsRequestsSlideOut(t *testing.T) {
+	ctx := context.Background()
+	limiter := newSlidingWindowLimiter(NewMemoryRedisClient(), 2, "50ms")
+
+	limiter.Allow(ctx, "u1")
+	limiter.Allow(ctx, "u1")
+	if allowed, _ := limiter.Allow(ctx, "u1"); allowed {
+		t.Fatal("request over the limit allowed")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if allowed, err := limiter.Allow(ctx, "u1"); !allowed || err != nil {
+		t.Fatalf("request after the window slid: allowed %v, %v", allowed, err)
+	}
+}
+
+func TestSlidingWindowRejectsCostsBeyondTheLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter := newSlidingWindowLimiter(NewMemoryRedisClient(), 3, "1h")
+
+	if allowed, _ := limiter.AllowN(ctx, "u1", 4); allowed {
+		t.Fatal("cost above the limit allowed")
+	}
+	if allowed, _ := limiter.AllowN(ctx, "u1", 3); !allowed {
+		t.Fatal("cost equal to the limit rejected after a rejected request")
+	}
+}
+
+func TestSlidingWindowRunsAsOneScript(t *testing.T) {
+	server := newTestRedisServer(t)
+	client, err := NewRedisClient(RedisConfig{Address: server.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	limiter := newSlidingWindowLimiter(client, 2, "1h")
+
+	for i, want := range []bool{true, true, false} {
+		if allowed, err := limiter.Allow(context.Background(), "u1"); err != nil || allowed != want {
+			t.Fatalf("request %d: allowed %v, %v; want %v", i+1, allowed, err, want)
+		}
+	}
+	for _, received := range server.commands() {
+		if strings.HasPrefix(received, "ZADD") || strings.HasPrefix(received, "ZCARD") {
+			t.Fatalf("sliding window issued %q outside the script", received)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTPSoftBlock(t *testing.T) {
+	server := newTestRedisServer(t)
+	proxied := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { proxied++ })
+	handler := newTestPluginNext(t, server, next, func(c *Config) {
+		c.Identifiers[0].Type = "Query"
+		c.Identifiers[0].Name = "user"
+		c.Identifiers[0].Value = ""
+		c.Identifiers[0].RateLimit.Rate = 1
+		c.Identifiers[0].SoftBlock = true
+		c.Identifiers[0].Denylist = []string{"u-revoked"}
+	})
+	serveQuery := func(user string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/?user="+user, nil))
+		return rw
+	}
+
+	if rw := serveQuery("u1"); rw.Code != http.StatusOK || rw.Header().Get(SoftBlockWarningHeader) != "" {
+		t.Fatalf("request within the limit: status %d, warning %q", rw.Code, rw.Header().Get(SoftBlockWarningHeader))
+	}
+	for i := 0; i < 2; i++ {
+		rw := serveQuery("u1")
+		if rw.Code != http.StatusOK || rw.Header().Get(SoftBlockWarningHeader) != "Rate limit exceeded" {
+			t.Fatalf("over-limit request %d: status %d, warning %q", i+1, rw.Code, rw.Header().Get(SoftBlockWarningHeader))
+		}
+	}
+	if proxied != 3 {
+		t.Fatalf("%d requests proxied, want every soft-blocked request served", proxied)
+	}
+
+	// The denylist still blocks
+	if rw := serveQuery("u-revoked"); rw.Code != http.StatusForbidden || proxied != 3 {
+		t.Fatalf("denylisted value: status %d, %d proxied", rw.Code, proxied)
+	}
+}
+
+func TestServeHTTPAllowReadsPastQuota(t *testing.T) {
+	server := newTestRedisServer(t)
+	proxied := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { proxied++ })
+	handler := newTestPluginNext(t, server, next, func(c *Config) {
+		c.Identifiers[0].RateLimit.Enabled = false
+		c.Identifiers[0].Quota.Limit = 1
+		c.Identifiers[0].Quota.AllowReadsPastQuota = true
+	})
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := serve(http.MethodGet); rw.Code != http.StatusOK || rw.Header().Get(SoftBlockWarningHeader) != "" {
+		t.Fatalf("read within the quota: status %d, warning %q", rw.Code, rw.Header().Get(SoftBlockWarningHeader))
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rw := serve(method)
+		if rw.Code != http.StatusOK || rw.Header().Get(SoftBlockWarningHeader) != "Quota exceeded" {
+			t.Fatalf("%s past the quota: status %d, warning %q", method, rw.Code, rw.Header().Get(SoftBlockWarningHeader))
+		}
+	}
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		if rw := serve(method); rw.Code != http.StatusForbidden {
+			t.Fatalf("%s past the quota: status %d, want 403", method, rw.Code)
+		}
+	}
+	if proxied != 3 {
+		t.Fatalf("%d requests proxied, want the three reads", proxied)
+	}
+}
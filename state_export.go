@@ -0,0 +1,138 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// exportedKeyTypes are the key prefixes of the plugin's string keys, which are
+// the keys a state dump carries. Sliding window sorted sets and distinct
+// counters are rebuilt by traffic and are not exported.
+var exportedKeyTypes = []string{"quota", "ratelimit", "concurrency", "websocket"}
+
+// keyComponentUnescaper reverses escapeKeyComponent
+var keyComponentUnescaper = strings.NewReplacer("%3A", ":", "%25", "%")
+
+// ExportedKey is a plugin key in a state dump, e.g. the usage of a quota period
+type ExportedKey struct {
+	Connection string `json:"connection,omitempty"` // Named Redis connection holding the key (empty for the primary)
+	Key        string `json:"key"`
+	Type       string `json:"type"`        // quota, ratelimit, concurrency or websocket
+	Identifier string `json:"identifier"`  // Identifier the key belongs to
+	Value      string `json:"value"`       // Stored value, e.g. the quota usage
+	TTLSeconds int64  `json:"ttl_seconds"` // Remaining time to live, -1 when the key never expires
+}
+
+// ExportState reads every plugin key of client with SCAN, GET and TTL. Keys
+// expiring while the dump runs are left out.
+func ExportState(ctx context.Context, client RedisClient) ([]ExportedKey, error) {
+	var exported []ExportedKey
+	for _, keyType := range exportedKeyTypes {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, keyType+":*", adminScanBatch)
+			if err != nil {
+				return exported, fmt.Errorf("failed to scan keys: %w", err)
+			}
+
+			for _, key := range keys {
+				value, err := client.Get(ctx, key)
+				if errors.Is(err, errKeyNotFound) {
+					continue
+				}
+				var redisErr *RedisError
+				if errors.As(err, &redisErr) {
+					// Not a string key, e.g. a sliding window
+					continue
+				}
+				if err != nil {
+					return exported, fmt.Errorf("failed to read %s: %w", key, err)
+				}
+
+				ttl, err := client.TTL(ctx, key)
+				if errors.Is(err, errKeyNotFound) {
+					// Expired between GET and TTL
+					continue
+				}
+				if err != nil {
+					return exported, fmt.Errorf("failed to read the TTL of %s: %w", key, err)
+				}
+
+				exported = append(exported, ExportedKey{
+					Key:        key,
+					Type:       keyType,
+					Identifier: keyIdentifier(key),
+					Value:      value,
+					TTLSeconds: ttlSeconds(ttl),
+				})
+			}
+
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+
+	return exported, nil
+}
+
+// ImportState writes exported keys to client with their remaining TTL and
+// returns how many were written. Keys outside the plugin namespace are refused.
+func ImportState(ctx context.Context, client RedisClient, keys []ExportedKey) (int, error) {
+	for _, key := range keys {
+		if !isExportedKey(key.Key) {
+			return 0, fmt.Errorf("key %q is not a plugin key", key.Key)
+		}
+	}
+
+	imported := 0
+	for _, key := range keys {
+		var expiration time.Duration
+		if key.TTLSeconds >= 0 {
+			// TTLs are reported in whole seconds; never turn an expiring key
+			// into a persistent one
+			expiration = time.Duration(key.TTLSeconds) * time.Second
+			if expiration <= 0 {
+				expiration = time.Second
+			}
+		}
+
+		if err := client.Set(ctx, key.Key, key.Value, expiration); err != nil {
+			return imported, fmt.Errorf("failed to write %s: %w", key.Key, err)
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+// isExportedKey reports whether key belongs to an exported key type
+func isExportedKey(key string) bool {
+	for _, keyType := range exportedKeyTypes {
+		if strings.HasPrefix(key, keyType+":") && len(key) > len(keyType)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// keyIdentifier returns the unescaped identifier component of a plugin key
+func keyIdentifier(key string) string {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return keyComponentUnescaper.Replace(parts[1])
+}
+
+// ttlSeconds converts a TTL reply to whole seconds, -1 for keys without expiry
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
+	}
+	return int64(ttl / time.Second)
+}
@@ -0,0 +1,86 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	source := NewMemoryRedisClient()
+	source.SetClock(clock.Now)
+
+	source.Set(ctx, GetQuotaKey("2001:db8::1", "2024-01-01"), "42", 2*time.Hour)
+	source.Set(ctx, GetRateLimitKey("u1")+":tokens", "3.5", time.Hour)
+	source.Set(ctx, GetQuotaKey("u2", "lifetime"), "7", 0)
+	source.Set(ctx, "session:u1", "unrelated", 0)
+	source.SAdd(ctx, "quota:set", "not a string key")
+
+	clock.Advance(30 * time.Minute)
+	exported, err := ExportState(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 3 {
+		t.Fatalf("exported %+v, want the three plugin string keys", exported)
+	}
+	for _, key := range exported {
+		if key.Key == GetQuotaKey("2001:db8::1", "2024-01-01") && (key.Identifier != "2001:db8::1" || key.Type != "quota" || key.TTLSeconds != 90*60) {
+			t.Fatalf("exported %+v, want the unescaped identifier and 90m left", key)
+		}
+	}
+
+	target := NewMemoryRedisClient()
+	target.SetClock(clock.Now)
+	if n, err := ImportState(ctx, target, exported); err != nil || n != 3 {
+		t.Fatalf("ImportState = %d, %v", n, err)
+	}
+	for key, want := range map[string]struct {
+		value string
+		ttl   time.Duration
+	}{
+		GetQuotaKey("2001:db8::1", "2024-01-01"): {"42", 90 * time.Minute},
+		GetRateLimitKey("u1") + ":tokens":        {"3.5", 30 * time.Minute},
+		GetQuotaKey("u2", "lifetime"):            {"7", -1},
+	} {
+		value, _ := target.Get(ctx, key)
+		ttl, _ := target.TTL(ctx, key)
+		if value != want.value || (want.ttl < 0) != (ttl < 0) || ttl > want.ttl || ttl < want.ttl-time.Second {
+			t.Errorf("%s: %s with TTL %v, want %s with TTL %v", key, value, ttl, want.value, want.ttl)
+		}
+	}
+}
+
+func TestImportStateRefusesForeignKeys(t *testing.T) {
+	client := NewMemoryRedisClient()
+	keys := []ExportedKey{
+		{Key: "quota:u1:2024-01-01", Value: "1", TTLSeconds: 60},
+		{Key: "session:u1", Value: "x", TTLSeconds: -1},
+	}
+	if _, err := ImportState(context.Background(), client, keys); err == nil {
+		t.Fatal("a dump with a foreign key was imported")
+	}
+	if n, _ := client.Exists(context.Background(), "quota:u1:2024-01-01"); n != 0 {
+		t.Fatal("a refused dump was partially imported")
+	}
+}
+
+func TestExportStateTTLError(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	client := newTestRedisClient(t, server, RedisConfig{})
+	server.mem.Set(ctx, GetQuotaKey("u1", "2024-01-01"), "42", time.Hour)
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) == "TTL" {
+			return "-ERR TTL refused\r\n"
+		}
+		return ""
+	})
+
+	if _, err := ExportState(ctx, client); err == nil || !strings.Contains(err.Error(), "TTL refused") {
+		t.Fatalf("error %v, want the failed TTL read reported", err)
+	}
+}
@@ -0,0 +1,122 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPThrottleDelaysThenAllows(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 5, Period: "1s", ThrottleMode: true}
+		c.Identifiers[0].Quota.Enabled = false
+	})
+
+	for i := 0; i < 5; i++ {
+		serveAs(handler, "u1")
+	}
+	start := time.Now()
+	rw := serveAs(handler, "u1")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("throttled request: status %d, want 200", rw.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("throttled request served after %v, want a delay of about 200ms", elapsed)
+	}
+}
+
+func TestServeHTTPThrottleRejectsBeyondMaxDelay(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 1, Period: "1h", ThrottleMode: true, MaxThrottleDelay: "50ms"}
+	})
+
+	serveAs(handler, "u1")
+	start := time.Now()
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429 when the delay exceeds the maximum", rw.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("rejection took %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitHonorsCancellation(t *testing.T) {
+	config := RateLimitConfig{Enabled: true, Rate: 1, Burst: 1, Period: "1h"}
+	limiter := NewRateLimiter(NewMemoryRedisClient(), config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	allowed, err := limiter.Wait(ctx, "u1", 1, time.Hour)
+	if allowed || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, %v; want a deadline error", allowed, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Wait returned after %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitDelay(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		config   RateLimitConfig
+		n        int
+		want     time.Duration
+		possible bool
+	}{
+		{"continuous refill", RateLimitConfig{Rate: 10, Period: "1s"}, 5, 500 * time.Millisecond, true},
+		{"explicit cadence", RateLimitConfig{Rate: 10, Period: "1m", RefillRate: 1, RefillInterval: "6s"}, 3, 18 * time.Second, true},
+		{"beyond the burst", RateLimitConfig{Rate: 10, Period: "1s"}, 11, 0, false},
+		{"sliding window", RateLimitConfig{Rate: 10, Period: "1s", Algorithm: AlgorithmSlidingWindow}, 3, time.Second, true},
+		{"beyond the window limit", RateLimitConfig{Rate: 10, Period: "1s", Algorithm: AlgorithmSlidingWindow}, 11, 0, false},
+	}
+	for _, tc := range tests {
+		tc.config.Enabled = true
+		tc.config.Burst = tc.config.Rate
+		limiter := NewRateLimiter(NewMemoryRedisClient(), tc.config)
+		if allowed, _ := limiter.AllowN(ctx, "u1", 10); !allowed {
+			t.Fatalf("%s: draining the limit rejected", tc.name)
+		}
+
+		delay, possible, err := limiter.WaitDelay(ctx, "u1", tc.n)
+		if err != nil || possible != tc.possible {
+			t.Fatalf("%s: WaitDelay = %v, %v, %v", tc.name, delay, possible, err)
+		}
+		if possible && (delay > tc.want || delay < tc.want-50*time.Millisecond) {
+			t.Errorf("%s: delay for %d tokens %v, want about %v", tc.name, tc.n, delay, tc.want)
+		}
+	}
+}
+
+func TestServeHTTPThrottleWaitsForTheCost(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].CostHeader = "X-Request-Cost"
+		c.Identifiers[0].RateLimit = RateLimitConfig{Enabled: true, Rate: 10, Period: "1s", ThrottleMode: true}
+		c.Identifiers[0].Quota.Enabled = false
+	})
+	serve := func(cost string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		req.Header.Set("X-Request-Cost", cost)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	serve("10")
+	start := time.Now()
+	if code := serve("4"); code != http.StatusOK {
+		t.Fatalf("throttled request of cost 4: status %d, want 200", code)
+	}
+	// Four tokens take 400ms to refill; one token would have taken 100ms
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("throttled request of cost 4 served after %v", elapsed)
+	}
+}
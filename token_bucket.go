@@ -0,0 +1,293 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strategies used when Redis rejects EVAL, e.g. behind a restricted proxy
+const (
+	ScriptingFallbackNonAtomic  = "nonatomic"  // Read, refill and write the bucket with plain commands (default)
+	ScriptingFallbackOptimistic = "optimistic" // Update the bucket with WATCH/MULTI/EXEC
+	ScriptingFallbackFail       = "fail"       // Keep returning the error, handled per FailureMode
+)
+
+// tokenBucketScript atomically refills a token bucket stored as the same
+// ":tokens" and ":last_refill" keys read by getBucket, and takes cost tokens
+// when enough are available, so concurrent requests can never overshoot it.
+//
+// KEYS[1] tokens key, KEYS[2] last refill key (ns), ARGV: now (ns), rate,
+// burst, period (ns), refill rate, refill interval (ns, 0 for continuous),
+// initial tokens, cost, ttl (ms), aligned window start (ns, 0 when not
+// aligned), reserve (tokens that must remain after taking cost). Returns
+// {allowed, tokens as string}.
+const tokenBucketScript = `
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local period = tonumber(ARGV[4])
+local refillRate = tonumber(ARGV[5])
+local refillInterval = tonumber(ARGV[6])
+local cost = tonumber(ARGV[8])
+local reserve = tonumber(ARGV[11])
+local tokens = tonumber(redis.call('GET', KEYS[1]))
+local last = tonumber(redis.call('GET', KEYS[2]))
+local lastRefill = ARGV[1]
+local windowStart = tonumber(ARGV[10])
+if tokens == nil or last == nil then
+  tokens = tonumber(ARGV[7])
+  if windowStart > 0 then
+    lastRefill = ARGV[10]
+  end
+elseif windowStart > 0 then
+  -- Aligned windows refill fully once per boundary crossed
+  if last < windowStart then
+    tokens = burst
+    lastRefill = ARGV[10]
+  else
+    lastRefill = string.format('%d', last)
+  end
+else
+  local elapsed = math.max(now - last, 0)
+  if refillInterval > 0 and refillRate > 0 then
+    local intervals = math.floor(elapsed / refillInterval)
+    tokens = math.min(tokens + intervals * refillRate, burst)
+    if tokens < burst then
+      lastRefill = string.format('%d', last + intervals * refillInterval)
+    end
+  else
+    tokens = math.min(tokens + rate * elapsed / period, burst)
+  end
+end
+local allowed = 0
+if tokens >= cost + reserve then
+  tokens = tokens - cost
+  allowed = 1
+end
+redis.call('SET', KEYS[1], tostring(tokens), 'PX', ARGV[9])
+redis.call('SET', KEYS[2], lastRefill, 'PX', ARGV[9])
+return {allowed, tostring(tokens)}
+`
+
+// evalTokenBucket runs the token bucket script, taking cost tokens when at
+// least reserve tokens remain afterwards
+func (rl *RateLimiter) evalTokenBucket(ctx context.Context, identifier string, cost, reserve int) (bool, error) {
+	period, err := rl.config.ParseRateLimitPeriod()
+	if err != nil {
+		return false, fmt.Errorf("invalid period: %w", err)
+	}
+
+	initialTokens, err := rl.config.ParseInitialTokens()
+	if err != nil {
+		return false, fmt.Errorf("invalid initial tokens: %w", err)
+	}
+
+	refillInterval, _ := rl.config.ParseRefillInterval()
+
+	now := time.Now()
+	var windowStart int64
+	if rl.config.AlignWindow {
+		windowStart = now.Truncate(period).UnixNano()
+	}
+
+	// Same expiration as saveBucket
+	ttl := rl.config.bucketTTL(period)
+
+	key := rl.bucketKey(identifier)
+	reply, err := rl.redisClient.Eval(ctx, tokenBucketScript,
+		[]string{key + ":tokens", key + ":last_refill"},
+		strconv.FormatInt(now.UnixNano(), 10),
+		strconv.Itoa(rl.config.Rate),
+		strconv.Itoa(rl.config.Burst),
+		strconv.FormatInt(period.Nanoseconds(), 10),
+		strconv.Itoa(rl.config.RefillRate),
+		strconv.FormatInt(refillInterval.Nanoseconds(), 10),
+		strconv.FormatFloat(initialTokens, 'f', -1, 64),
+		strconv.Itoa(cost),
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+		strconv.FormatInt(windowStart, 10),
+		strconv.Itoa(reserve),
+	)
+	rl.cache.invalidate(key)
+	if isScriptingUnsupported(err) && rl.scriptingFallback != ScriptingFallbackFail {
+		rl.disableScripting()
+		return rl.AllowNKeeping(ctx, identifier, cost, reserve)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate token bucket: %w", err)
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, fmt.Errorf("invalid token bucket reply: %v", reply)
+	}
+
+	allowed, _ := values[0].(int64)
+	return allowed == 1, nil
+}
+
+// maxWatchAttempts bounds the optimistic retries of a token bucket update
+const maxWatchAttempts = 5
+
+// watchTokenBucket takes cost tokens without Lua: it watches the bucket keys,
+// refills and updates the bucket in Go and commits with MULTI/EXEC, retrying
+// when a concurrent request modified the bucket in between
+func (rl *RateLimiter) watchTokenBucket(ctx context.Context, identifier string, cost, reserve int) (bool, error) {
+	period, err := rl.config.ParseRateLimitPeriod()
+	if err != nil {
+		return false, fmt.Errorf("invalid period: %w", err)
+	}
+
+	// Same expiration as saveBucket
+	ttl := strconv.FormatInt(rl.config.bucketTTL(period).Milliseconds(), 10)
+
+	key := rl.bucketKey(identifier)
+	tokensKey, lastRefillKey := key+":tokens", key+":last_refill"
+	defer rl.cache.invalidate(key)
+
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		var allowed, committed bool
+		err := rl.redisClient.Watch(ctx, func(tx RedisTx) error {
+			values, err := tx.MGet(ctx, tokensKey, lastRefillKey)
+			if err != nil {
+				return err
+			}
+
+			bucket, err := rl.bucketFromValues(values)
+			if err != nil {
+				return err
+			}
+			bucket = rl.refillBucket(bucket, time.Now())
+			if bucket.Tokens >= float64(cost+reserve) {
+				bucket.Tokens -= float64(cost)
+				allowed = true
+			}
+
+			committed, err = tx.Exec(ctx,
+				[]string{"SET", tokensKey, strconv.FormatFloat(bucket.Tokens, 'f', -1, 64), "PX", ttl},
+				[]string{"SET", lastRefillKey, strconv.FormatInt(bucket.LastRefill.UnixNano(), 10), "PX", ttl},
+			)
+			return err
+		}, tokensKey, lastRefillKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to update token bucket: %w", err)
+		}
+		if committed {
+			return allowed, nil
+		}
+	}
+
+	return false, fmt.Errorf("token bucket update conflicted %d times", maxWatchAttempts)
+}
+
+// bucketFromValues builds the bucket from its MGET values, starting a new
+// bucket when either key is missing or unreadable, as getBucket does
+func (rl *RateLimiter) bucketFromValues(values []interface{}) (TokenBucket, error) {
+	tokensData, _ := values[0].(string)
+	lastRefillData, _ := values[1].(string)
+
+	tokens, tokensErr := strconv.ParseFloat(tokensData, 64)
+	lastRefillUnix, lastRefillErr := strconv.ParseInt(lastRefillData, 10, 64)
+	if tokensErr != nil || lastRefillErr != nil {
+		return rl.createNewBucket()
+	}
+
+	period, err := rl.config.ParseRateLimitPeriod()
+	if err != nil {
+		return TokenBucket{}, fmt.Errorf("invalid period: %w", err)
+	}
+
+	return TokenBucket{
+		Tokens:       tokens,
+		LastRefill:   time.Unix(0, lastRefillUnix),
+		Rate:         rl.config.Rate,
+		Burst:        rl.config.Burst,
+		RefillPeriod: period,
+	}, nil
+}
+
+// takeTokensNonAtomic takes cost tokens with separate reads and writes. Racing
+// requests may overshoot the bucket, so it is only used when Redis can't run
+// the token bucket script.
+func (rl *RateLimiter) takeTokensNonAtomic(ctx context.Context, identifier string, cost, reserve int) (bool, error) {
+	key := rl.bucketKey(identifier)
+
+	bucket, err := rl.loadBucket(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to get bucket: %w", err)
+	}
+
+	bucket = rl.refillBucket(bucket, time.Now())
+	allowed := bucket.Tokens >= float64(cost+reserve)
+	if allowed {
+		bucket.Tokens -= float64(cost)
+	}
+
+	if err := rl.saveBucket(ctx, key, bucket); err != nil {
+		return false, fmt.Errorf("failed to save bucket: %w", err)
+	}
+
+	return allowed, nil
+}
+
+// disableScripting switches the limiter to its scripting fallback after Redis
+// rejected EVAL, so later requests don't pay for the failing round trip
+func (rl *RateLimiter) disableScripting() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.scriptingUnsupported {
+		return
+	}
+	rl.scriptingUnsupported = true
+
+	fallback := rl.scriptingFallback
+	if fallback == "" {
+		fallback = ScriptingFallbackNonAtomic
+	}
+	rl.log.errorf("Redis does not support EVAL, falling back to %s token bucket updates", fallback)
+}
+
+// tokenBucketMode returns how the limiter currently updates token buckets:
+// with the script, or with the configured fallback
+func (rl *RateLimiter) tokenBucketMode() string {
+	if rl.optimistic {
+		return ScriptingFallbackOptimistic
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if !rl.scriptingUnsupported {
+		return ""
+	}
+	if rl.scriptingFallback == "" {
+		return ScriptingFallbackNonAtomic
+	}
+	return rl.scriptingFallback
+}
+
+// isScriptingUnsupported reports whether err is Redis rejecting EVAL as an
+// unknown or disallowed command
+func isScriptingUnsupported(err error) bool {
+	var redisErr *RedisError
+	if !errors.As(err, &redisErr) {
+		return false
+	}
+	message := strings.ToLower(redisErr.Message)
+	return strings.Contains(message, "eval") && (strings.Contains(message, "unknown command") || strings.Contains(message, "not allowed") || strings.Contains(message, "disabled"))
+}
+
+// validateScriptingFallback checks the scripting fallback strategy
+func (rc RedisConfig) validateScriptingFallback() error {
+	switch rc.ScriptingFallback {
+	case "", ScriptingFallbackNonAtomic, ScriptingFallbackOptimistic, ScriptingFallbackFail:
+		return nil
+	default:
+		return fmt.Errorf("unsupported scripting fallback: %s", rc.ScriptingFallback)
+	}
+}
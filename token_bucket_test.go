@@ -0,0 +1,252 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// execs counts the EXEC commands server received
+func execs(server *testRedisServer) int {
+	n := 0
+	for _, command := range server.commands() {
+		if command == "EXEC" {
+			n++
+		}
+	}
+	return n
+}
+
+// optimisticLimiter returns a rate limiter of rate tokens per minute that
+// updates its buckets on server with WATCH/MULTI/EXEC
+func optimisticLimiter(t *testing.T, server *testRedisServer, rate int) *RateLimiter {
+	config := RateLimitConfig{Enabled: true, Rate: rate, Burst: rate, Period: "1m"}
+	limiter := NewRateLimiter(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), config)
+	limiter.optimistic = true
+	return limiter
+}
+
+func TestWatchTokenBucketRetriesOnConflict(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	limiter := optimisticLimiter(t, server, 10)
+	tokensKey, lastRefillKey := GetRateLimitKey("u1")+":tokens", GetRateLimitKey("u1")+":last_refill"
+
+	// A concurrent request takes a token between the read and the first EXEC
+	var mu sync.Mutex
+	conflicted := false
+	server.setHook(func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.ToUpper(args[0]) == "EXEC" && !conflicted {
+			conflicted = true
+			server.mem.Set(ctx, tokensKey, "9", 0)
+			server.mem.Set(ctx, lastRefillKey, strconv.FormatInt(time.Now().UnixNano(), 10), 0)
+		}
+		return ""
+	})
+
+	allowed, err := limiter.Allow(ctx, "u1")
+	if err != nil || !allowed {
+		t.Fatalf("Allow = %v, %v", allowed, err)
+	}
+	if n := execs(server); n != 2 {
+		t.Fatalf("%d EXECs, want a retry after the conflict", n)
+	}
+	// The retry read the concurrent write instead of overwriting it
+	if tokens, _ := server.mem.Get(ctx, tokensKey); !strings.HasPrefix(tokens, "8") {
+		t.Fatalf("%s tokens left, want both requests' tokens taken", tokens)
+	}
+	for _, command := range server.commands() {
+		if strings.HasPrefix(command, "EVAL") {
+			t.Fatal("the optimistic limiter ran a script")
+		}
+	}
+}
+
+func TestWatchTokenBucketGivesUpAfterRepeatedConflicts(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	limiter := optimisticLimiter(t, server, 10)
+	tokensKey := GetRateLimitKey("u1") + ":tokens"
+
+	// Every attempt meets a different concurrent write
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) == "EXEC" {
+			server.mem.IncrByFloat(ctx, tokensKey, 1)
+		}
+		return ""
+	})
+
+	if _, err := limiter.Allow(ctx, "u1"); err == nil {
+		t.Fatal("Allow succeeded although every EXEC conflicted")
+	}
+	if n := execs(server); n != maxWatchAttempts {
+		t.Fatalf("%d EXECs, want %d attempts", n, maxWatchAttempts)
+	}
+}
+
+func TestWatchTokenBucketConcurrent(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	limiter := optimisticLimiter(t, server, 20)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowedCount := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Conflicts past the retry budget fail the request, never overshoot
+			if allowed, err := limiter.Allow(ctx, "u1"); err == nil && allowed {
+				mu.Lock()
+				allowedCount++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	tokens, _ := limiter.GetCurrentTokens(ctx, "u1")
+	if tokens+float64(allowedCount) > 20.5 {
+		t.Fatalf("%d requests allowed leaving %v tokens, want no more than 20 taken in total", allowedCount, tokens)
+	}
+}
+
+// rejectEval makes server answer EVAL like a Redis with scripting disabled
+func rejectEval(server *testRedisServer) {
+	server.setHook(func(args []string) string {
+		if strings.ToUpper(args[0]) == "EVAL" {
+			return "-ERR unknown command 'EVAL', with args beginning with: \r\n"
+		}
+		return ""
+	})
+}
+
+// evals counts the EVAL commands server received
+func evals(server *testRedisServer) int {
+	n := 0
+	for _, command := range server.commands() {
+		if strings.HasPrefix(command, "EVAL ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestScriptingFallback(t *testing.T) {
+	for _, fallback := range []string{"", ScriptingFallbackNonAtomic, ScriptingFallbackOptimistic} {
+		t.Run("fallback="+fallback, func(t *testing.T) {
+			ctx := context.Background()
+			server := newTestRedisServer(t)
+			rejectEval(server)
+			config := RateLimitConfig{Enabled: true, Rate: 2, Burst: 2, Period: "1m"}
+			limiter := NewRateLimiter(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), config)
+			limiter.scriptingFallback = fallback
+
+			for i, want := range []bool{true, true, false} {
+				if allowed, err := limiter.Allow(ctx, "u1"); err != nil || allowed != want {
+					t.Fatalf("request %d: allowed %v, %v; want %v", i+1, allowed, err, want)
+				}
+			}
+			// Only the first request paid for the rejected EVAL
+			if n := evals(server); n != 1 {
+				t.Fatalf("%d EVALs, want the script given up after the first", n)
+			}
+			if fallback == ScriptingFallbackOptimistic && execs(server) == 0 {
+				t.Fatal("the optimistic fallback never ran a transaction")
+			}
+		})
+	}
+}
+
+func TestScriptingFallbackFail(t *testing.T) {
+	ctx := context.Background()
+	server := newTestRedisServer(t)
+	rejectEval(server)
+	config := RateLimitConfig{Enabled: true, Rate: 2, Burst: 2, Period: "1m"}
+	limiter := NewRateLimiter(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), config)
+	limiter.scriptingFallback = ScriptingFallbackFail
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Allow(ctx, "u1"); err == nil {
+			t.Fatalf("request %d succeeded without scripting", i+1)
+		}
+	}
+}
+
+func TestIsScriptingUnsupported(t *testing.T) {
+	tests := map[string]bool{
+		"ERR unknown command 'EVAL', with args beginning with: ":   true,
+		"ERR unknown command `evalsha`":                            true,
+		"NOPERM this user has no permissions to run the 'eval'":    false,
+		"ERR command EVAL not allowed":                             true,
+		"ERR Error running script: @user_script:1: bad arguments":  false,
+		"ERR unknown command 'GETEX', with args beginning with: k": false,
+	}
+	for message, want := range tests {
+		if got := isScriptingUnsupported(&RedisError{Message: message}); got != want {
+			t.Errorf("%q: %v, want %v", message, got, want)
+		}
+	}
+	if isScriptingUnsupported(errors.New("unknown command eval")) {
+		t.Error("a transport error was taken for a Redis reply")
+	}
+}
+
+func TestBucketTTL(t *testing.T) {
+	for _, tc := range []struct {
+		mode, bucketTTL string
+		want            time.Duration
+	}{
+		{"script", "", 2 * time.Minute},
+		{"script", "24h", 24 * time.Hour},
+		{ScriptingFallbackOptimistic, "24h", 24 * time.Hour},
+		{ScriptingFallbackNonAtomic, "24h", 24 * time.Hour},
+	} {
+		ctx := context.Background()
+		server := newTestRedisServer(t)
+		config := RateLimitConfig{Enabled: true, Rate: 2, Period: "1m", BucketTTL: tc.bucketTTL}
+		config.Normalize()
+		limiter := NewRateLimiter(newTestRedisClient(t, server, RedisConfig{Address: server.addr}), config)
+		if tc.mode != "script" {
+			rejectEval(server)
+			limiter.scriptingFallback = tc.mode
+		}
+
+		if _, err := limiter.Allow(ctx, "u1"); err != nil {
+			t.Fatal(err)
+		}
+		for _, suffix := range []string{":tokens", ":last_refill"} {
+			if ttl, err := server.mem.TTL(ctx, limiter.bucketKey("u1")+suffix); err != nil || ttl <= tc.want-2*time.Second || ttl > tc.want {
+				t.Errorf("%s, BucketTTL %q: %s TTL %s, %v; want %s", tc.mode, tc.bucketTTL, suffix, ttl, err, tc.want)
+			}
+		}
+	}
+}
+
+func TestValidateBucketTTL(t *testing.T) {
+	tests := []struct {
+		bucketTTL, algorithm string
+		valid                bool
+	}{
+		{"", "", true},
+		{"1m", "", true},
+		{"24h", "", true},
+		{"30s", "", false},
+		{"forever", "", false},
+		{"1h", AlgorithmSlidingWindow, false},
+	}
+	for _, tc := range tests {
+		config := RateLimitConfig{Enabled: true, Rate: 2, Period: "1m", BucketTTL: tc.bucketTTL, Algorithm: tc.algorithm}
+		config.Normalize()
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("BucketTTL %q, algorithm %q: error %v, want valid %v", tc.bucketTTL, tc.algorithm, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package traefik_quota_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// forwardableHeaders lists the request headers that can be injected for the upstream
+var forwardableHeaders = []string{
+	"X-Quota-Identifier",
+	"X-Quota-Identifier-Type",
+	"X-Quota-Decision",
+	"X-Quota-Limit",
+	"X-Quota-Used",
+	"X-Quota-Remaining",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+}
+
+// validateForwardHeaders ensures every configured header is one the plugin can produce
+func validateForwardHeaders(names []string) error {
+	for _, name := range names {
+		if _, ok := forwardableHeader(name); !ok {
+			return fmt.Errorf("unsupported forward header: %s", name)
+		}
+	}
+	return nil
+}
+
+// forwardableHeader returns the forwardable header matching name case-insensitively
+func forwardableHeader(name string) (string, bool) {
+	for _, candidate := range forwardableHeaders {
+		if strings.EqualFold(name, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// injectUpstreamHeaders sets the configured decision headers on the proxied request,
+// replacing any client-supplied copies so they cannot be spoofed
+func (q *quotaPlugin) injectUpstreamHeaders(req *http.Request, response *QuotaResponse) {
+	if len(q.config.ForwardHeaders) == 0 {
+		return
+	}
+
+	for _, name := range forwardableHeaders {
+		req.Header.Del(name)
+	}
+
+	values := map[string]string{
+		"X-Quota-Identifier":      response.Identifier,
+		"X-Quota-Identifier-Type": response.IdentifierType,
+		"X-Quota-Decision":        "allowed",
+	}
+	if !response.Allowed {
+		values["X-Quota-Decision"] = "blocked"
+	}
+	if response.Quota != nil {
+		values["X-Quota-Limit"], values["X-Quota-Used"], values["X-Quota-Remaining"] = response.Quota.headerValues()
+	}
+	if response.RateLimit != nil {
+		values["X-RateLimit-Limit"] = strconv.Itoa(response.RateLimit.Limit)
+		values["X-RateLimit-Remaining"] = strconv.Itoa(response.RateLimit.Available)
+	}
+
+	for _, name := range q.config.ForwardHeaders {
+		header, _ := forwardableHeader(name)
+		if value := values[header]; value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardHeadersReachUpstream(t *testing.T) {
+	server := newTestRedisServer(t)
+	var upstream http.Header
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upstream = req.Header.Clone()
+	})
+	handler := newTestPluginNext(t, server, next, func(c *Config) {
+		c.ForwardHeaders = []string{"X-Quota-Identifier", "X-Quota-Decision", "X-Quota-Remaining", "X-RateLimit-Remaining"}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User-ID", "u1")
+	// Spoofed copies must not reach the backend
+	req.Header.Set("X-Quota-Identifier", "admin")
+	req.Header.Set("X-Quota-Remaining", "1000000")
+	req.Header.Set("X-Quota-Limit", "1000000")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := map[string]string{
+		"X-Quota-Identifier":    "u1",
+		"X-Quota-Decision":      "allowed",
+		"X-Quota-Remaining":     "4",
+		"X-RateLimit-Remaining": "1",
+		"X-Quota-Limit":         "", // Not forwarded, so the client copy is only removed
+	}
+	for name, value := range want {
+		if got := upstream.Get(name); got != value {
+			t.Errorf("upstream %s = %q, want %q", name, got, value)
+		}
+	}
+	if values := upstream.Values("X-Quota-Identifier"); len(values) != 1 {
+		t.Errorf("upstream X-Quota-Identifier values %v, want exactly one", values)
+	}
+}
+
+func TestForwardHeadersDisabledByDefault(t *testing.T) {
+	server := newTestRedisServer(t)
+	var upstream http.Header
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upstream = req.Header.Clone()
+	})
+	handler := newTestPluginNext(t, server, next, nil)
+
+	handler.ServeHTTP(httptest.NewRecorder(), func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User-ID", "u1")
+		return req
+	}())
+	if got := upstream.Get("X-Quota-Identifier"); got != "" {
+		t.Fatalf("X-Quota-Identifier %q forwarded without ForwardHeaders", got)
+	}
+}
+
+func TestValidateForwardHeaders(t *testing.T) {
+	if err := validateForwardHeaders([]string{"x-quota-identifier", "X-RateLimit-Limit"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateForwardHeaders([]string{"X-Quota-Secret"}); err == nil {
+		t.Fatal("unknown forward header accepted")
+	}
+}
@@ -0,0 +1,204 @@
+package traefik_quota_plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Webhook delivery defaults
+const (
+	defaultWebhookInterval = time.Minute     // Shortest time between notifications of one identifier value
+	webhookTimeout         = 5 * time.Second // Deadline of a single delivery
+	webhookPruneThreshold  = 10000           // Tracked identifier values before stale ones are pruned
+	webhookMaxPerSecond    = 10              // Notifications sent per second across all identifier values
+	webhookQueueSize       = 100             // Notifications waiting for delivery before new ones are dropped
+)
+
+// WebhookConfig holds the settings of the webhook notified when an identifier is blocked
+type WebhookConfig struct {
+	URL      string `json:"url,omitempty" yaml:"URL,omitempty"`           // Endpoint receiving a JSON POST per block (disabled when empty)
+	Interval string `json:"interval,omitempty" yaml:"Interval,omitempty"` // Shortest time between notifications of one identifier value (default 1m)
+}
+
+// Validate validates the webhook settings
+func (wc WebhookConfig) Validate() error {
+	if wc.URL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(wc.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook URL: %s", wc.URL)
+	}
+	if wc.Interval != "" {
+		if interval, err := time.ParseDuration(wc.Interval); err != nil || interval <= 0 {
+			return fmt.Errorf("invalid webhook interval: %s", wc.Interval)
+		}
+	}
+	return nil
+}
+
+// webhookPayload is the JSON body POSTed to the webhook
+type webhookPayload struct {
+	Identifier     string    `json:"identifier"`
+	IdentifierType string    `json:"identifier_type"`
+	Reason         string    `json:"reason"`
+	Timestamp      time.Time `json:"timestamp"`
+	RequestID      string    `json:"request_id,omitempty"`
+}
+
+// webhookNotifier POSTs blocks to a webhook in the background, at most once
+// per interval for each identifier value and at most webhookMaxPerSecond times
+// a second overall. A single worker delivers them from a bounded queue, so a
+// slow endpoint drops notifications rather than piling up goroutines; the
+// worker exits whenever the queue runs empty.
+// Deliveries are best effort: failures are logged and never affect the request.
+type webhookNotifier struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	log      *pluginLogger
+	queue    chan webhookPayload
+
+	mu          sync.Mutex
+	sending     bool // Whether the worker is running
+	lastSent    map[string]time.Time
+	lastPrune   time.Time // When lastSent was last pruned
+	windowStart time.Time // Start of the second the global cap is counted in
+	windowSent  int       // Notifications allowed since windowStart
+}
+
+// newWebhookNotifier creates the notifier of config, or returns nil when no URL is configured
+func newWebhookNotifier(config WebhookConfig, log *pluginLogger) *webhookNotifier {
+	if config.URL == "" {
+		return nil
+	}
+
+	interval := defaultWebhookInterval
+	if config.Interval != "" {
+		interval, _ = time.ParseDuration(config.Interval)
+	}
+
+	return &webhookNotifier{
+		url:      config.URL,
+		interval: interval,
+		client:   &http.Client{Timeout: webhookTimeout},
+		log:      log,
+		queue:    make(chan webhookPayload, webhookQueueSize),
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+// notify sends the block of response unless its identifier was notified within the interval
+func (n *webhookNotifier) notify(response *QuotaResponse, now time.Time) {
+	if n == nil || !n.allow(response.Identifier, now) {
+		return
+	}
+
+	payload := webhookPayload{
+		Identifier:     response.Identifier,
+		IdentifierType: response.IdentifierType,
+		Reason:         response.Reason,
+		Timestamp:      now.UTC(),
+		RequestID:      response.RequestID,
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	select {
+	case n.queue <- payload:
+	default:
+		n.log.errorf("Webhook queue full, dropping notification of block of %s", payload.Identifier)
+		return
+	}
+	if !n.sending {
+		n.sending = true
+		go n.run()
+	}
+}
+
+// run delivers queued notifications one at a time until the queue is empty
+func (n *webhookNotifier) run() {
+	for {
+		n.mu.Lock()
+		select {
+		case payload := <-n.queue:
+			n.mu.Unlock()
+			if err := n.send(payload); err != nil {
+				n.log.errorf("Failed to notify webhook of block of %s: %v", payload.Identifier, err)
+			}
+		default:
+			n.sending = false
+			n.mu.Unlock()
+			return
+		}
+	}
+}
+
+// allow records a notification of identifier at now when the previous one is
+// at least an interval old and the global cap of the current second isn't reached
+func (n *webhookNotifier) allow(identifier string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if last, ok := n.lastSent[identifier]; ok && now.Sub(last) < n.interval {
+		return false
+	}
+
+	if now.Sub(n.windowStart) >= time.Second {
+		n.windowStart = now
+		n.windowSent = 0
+	}
+	if n.windowSent >= webhookMaxPerSecond {
+		return false
+	}
+	n.windowSent++
+
+	// Forget identifiers whose interval is over so the map stays bounded. The
+	// scan runs at most once per interval; in between, the global cap bounds
+	// how far the map can grow.
+	if len(n.lastSent) >= webhookPruneThreshold && now.Sub(n.lastPrune) >= n.interval {
+		for value, last := range n.lastSent {
+			if now.Sub(last) >= n.interval {
+				delete(n.lastSent, value)
+			}
+		}
+		n.lastPrune = now
+	}
+
+	n.lastSent[identifier] = now
+	return true
+}
+
+// send POSTs payload to the webhook
+func (n *webhookNotifier) send(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
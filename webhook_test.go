@@ -0,0 +1,147 @@
+package traefik_quota_plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// webhookReceiver returns a webhook endpoint replying status whose received
+// payloads are sent on the returned channel
+func webhookReceiver(t *testing.T, status int) (string, <-chan webhookPayload) {
+	payloads := make(chan webhookPayload, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		payloads <- payload
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(endpoint.Close)
+	return endpoint.URL, payloads
+}
+
+func TestServeHTTPWebhookNotifiesBlocks(t *testing.T) {
+	url, payloads := webhookReceiver(t, http.StatusNoContent)
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Rate = 1
+		c.Identifiers[0].Webhook = WebhookConfig{URL: url}
+	})
+
+	serveAs(handler, "u1")
+	for i := 0; i < 3; i++ {
+		if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+			t.Fatalf("status %d, want 429", rw.Code)
+		}
+	}
+
+	select {
+	case payload := <-payloads:
+		if payload.Identifier != "u1" || payload.Reason != "Rate limit exceeded" || payload.Timestamp.IsZero() {
+			t.Fatalf("payload %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+	// Repeated blocks within the interval are not notified again
+	select {
+	case payload := <-payloads:
+		t.Fatalf("second notification %+v within the interval", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServeHTTPWebhookFailuresAreSwallowed(t *testing.T) {
+	url, payloads := webhookReceiver(t, http.StatusInternalServerError)
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Rate = 1
+		c.Identifiers[0].Webhook = WebhookConfig{URL: url}
+	})
+
+	serveAs(handler, "u1")
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d with a failing webhook, want 429", rw.Code)
+	}
+	select {
+	case <-payloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestWebhookNotifierInterval(t *testing.T) {
+	notifier := newWebhookNotifier(WebhookConfig{URL: "http://example.com", Interval: "1m"}, nil)
+	now := time.Now()
+
+	for _, tc := range []struct {
+		identifier string
+		at         time.Duration
+		want       bool
+	}{
+		{"u1", 0, true},
+		{"u1", 30 * time.Second, false},
+		{"u2", 30 * time.Second, true},
+		{"u1", time.Minute, true},
+	} {
+		if got := notifier.allow(tc.identifier, now.Add(tc.at)); got != tc.want {
+			t.Errorf("%s at +%s: allowed %v, want %v", tc.identifier, tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestWebhookNotifierGlobalCap(t *testing.T) {
+	notifier := newWebhookNotifier(WebhookConfig{URL: "http://example.com"}, nil)
+	now := time.Now()
+
+	for i := 0; i < webhookMaxPerSecond; i++ {
+		if !notifier.allow(fmt.Sprintf("u%d", i), now) {
+			t.Fatalf("notification %d refused under the global cap", i+1)
+		}
+	}
+	if notifier.allow("other", now.Add(500*time.Millisecond)) {
+		t.Fatal("notification allowed past the global cap")
+	}
+	// A refused value isn't recorded, so it's notified once the cap resets
+	if !notifier.allow("other", now.Add(time.Second)) {
+		t.Fatal("notification refused in the next second")
+	}
+}
+
+func TestWebhookNotifierDropsWhenQueueFull(t *testing.T) {
+	notifier := newWebhookNotifier(WebhookConfig{URL: "http://example.com"}, nil)
+	// Keep the worker from draining the queue
+	notifier.sending = true
+	now := time.Now()
+
+	for i := 0; i < webhookQueueSize+5; i++ {
+		response := &QuotaResponse{Identifier: fmt.Sprintf("u%d", i)}
+		notifier.notify(response, now.Add(time.Duration(i)*time.Second))
+	}
+	if n := len(notifier.queue); n != webhookQueueSize {
+		t.Fatalf("%d queued notifications, want %d", n, webhookQueueSize)
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	tests := []struct {
+		config WebhookConfig
+		valid  bool
+	}{
+		{WebhookConfig{}, true},
+		{WebhookConfig{URL: "https://hooks.example.com/quota", Interval: "5m"}, true},
+		{WebhookConfig{URL: "ftp://example.com"}, false},
+		{WebhookConfig{URL: "https://"}, false},
+		{WebhookConfig{URL: "https://example.com", Interval: "0s"}, false},
+	}
+	for _, tc := range tests {
+		if err := tc.config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%+v: error %v, want valid %v", tc.config, err, tc.valid)
+		}
+	}
+}
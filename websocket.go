@@ -0,0 +1,162 @@
+package traefik_quota_plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebSocket policy modes
+const (
+	WebSocketModeRequest     = "request"     // Count the upgrade as a regular request (default)
+	WebSocketModeConnections = "connections" // Limit open connections instead of the request rate and quota
+)
+
+// webSocketKeyTTL bounds how long an open connection counter outlives its last
+// upgrade, so counts leaked by a crashed instance eventually disappear
+const webSocketKeyTTL = 24 * time.Hour
+
+// WebSocketPolicy decides how WebSocket upgrade requests are limited. Upgrades
+// are long-lived, so in connections mode they are checked against a limit of
+// open connections per identifier rather than the request rate and quota.
+type WebSocketPolicy struct {
+	Mode           string `json:"mode,omitempty" yaml:"Mode,omitempty"`                      // request (default) or connections
+	MaxConnections int64  `json:"max_connections,omitempty" yaml:"MaxConnections,omitempty"` // Open connections allowed per identifier in connections mode
+	ResponseCode   int    `json:"response_code,omitempty" yaml:"ResponseCode,omitempty"`     // HTTP status code when too many connections are open (default 429)
+	ResponseBody   string `json:"response_body,omitempty" yaml:"ResponseBody,omitempty"`     // Response body when too many connections are open
+}
+
+// LimitsConnections reports whether upgrades are checked against the connection limit
+func (wp WebSocketPolicy) LimitsConnections() bool {
+	return wp.Mode == WebSocketModeConnections
+}
+
+// Validate checks the WebSocket mode and connection limit
+func (wp WebSocketPolicy) Validate() error {
+	switch wp.Mode {
+	case "", WebSocketModeRequest:
+	case WebSocketModeConnections:
+		if wp.MaxConnections <= 0 {
+			return fmt.Errorf("websocket max connections must be positive in connections mode")
+		}
+	default:
+		return fmt.Errorf("unsupported websocket mode: %s", wp.Mode)
+	}
+	return nil
+}
+
+// isWebSocketUpgrade reports whether req asks to upgrade to a WebSocket
+// (Connection: Upgrade and Upgrade: websocket)
+func isWebSocketUpgrade(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetWebSocketKey generates a Redis key for open WebSocket connection counting
+func GetWebSocketKey(identifier string) string {
+	return fmt.Sprintf("websocket:%s", escapeKeyComponent(identifier))
+}
+
+// WebSocketLimiter limits the open WebSocket connections per identifier in Redis
+type WebSocketLimiter struct {
+	redisClient RedisClient
+	max         int64
+	log         *pluginLogger // Logger of the owning plugin instance (nil for the default)
+}
+
+// NewWebSocketLimiter creates a limiter allowing max open connections per identifier
+func NewWebSocketLimiter(redisClient RedisClient, max int64) *WebSocketLimiter {
+	return &WebSocketLimiter{redisClient: redisClient, max: max}
+}
+
+// Open counts a new connection when it fits under the limit and returns the
+// connections open afterwards. Callers must Close an opened connection when
+// it ends.
+func (wl *WebSocketLimiter) Open(ctx context.Context, identifier string) (bool, int64, error) {
+	key := GetWebSocketKey(identifier)
+
+	open, err := wl.redisClient.Incr(ctx, key)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to count websocket connection: %w", err)
+	}
+
+	if _, err := wl.redisClient.Expire(ctx, key, webSocketKeyTTL); err != nil {
+		wl.log.errorf("Failed to set websocket connection TTL for %s: %v", identifier, err)
+	}
+
+	if open > wl.max {
+		open, err = wl.redisClient.IncrBy(ctx, key, -1)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to roll back websocket connection: %w", err)
+		}
+		return false, open, nil
+	}
+
+	return true, open, nil
+}
+
+// Close records an opened connection ending, never letting the count drop below zero
+func (wl *WebSocketLimiter) Close(ctx context.Context, identifier string) {
+	key := GetWebSocketKey(identifier)
+
+	open, err := wl.redisClient.IncrBy(ctx, key, -1)
+	if err != nil {
+		wl.log.errorf("Failed to release websocket connection for %s: %v", identifier, err)
+		return
+	}
+
+	if open < 0 {
+		if err := wl.redisClient.Set(ctx, key, 0, webSocketKeyTTL); err != nil {
+			wl.log.errorf("Failed to reset websocket connections for %s: %v", identifier, err)
+		}
+	}
+}
+
+// limitsWebSocket reports whether req is an upgrade checked against the
+// manager's WebSocket connection limit instead of its rate limit and quota
+func (m *IdentifierManager) limitsWebSocket(req *http.Request) bool {
+	return m.webSockets != nil && isWebSocketUpgrade(req)
+}
+
+// CheckWebSocket opens a WebSocket connection for identifier when it fits under
+// the connection limit. Allowed responses hold a connection that must be
+// closed when the upgraded connection ends.
+func (m *IdentifierManager) CheckWebSocket(ctx context.Context, identifier string) (*QuotaResponse, error) {
+	response := &QuotaResponse{
+		Identifier:     identifier,
+		IdentifierType: m.config.Type,
+	}
+
+	allowed, _, err := m.webSockets.Open(ctx, identifier)
+	if err != nil {
+		if m.failClosed {
+			return nil, err
+		}
+		// In case of error, allow the upgrade (fail open) without holding a connection
+		m.log.errorf("WebSocket limiter error: %v", err)
+		response.Allowed = true
+		return response, nil
+	}
+
+	if !allowed {
+		response.Reason = "Too many WebSocket connections"
+		response.ResponseCode = m.config.WebSocket.ResponseCode
+		response.ResponseBody = m.config.WebSocket.ResponseBody
+		return response, nil
+	}
+
+	response.Allowed = true
+	response.WebSocket = true
+	return response, nil
+}
@@ -0,0 +1,95 @@
+package traefik_quota_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		connection, upgrade string
+		want                bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", tc.connection)
+		req.Header.Set("Upgrade", tc.upgrade)
+		if got := isWebSocketUpgrade(req); got != tc.want {
+			t.Errorf("Connection %q, Upgrade %q: %v, want %v", tc.connection, tc.upgrade, got, tc.want)
+		}
+	}
+}
+
+// upgradeAs serves a WebSocket upgrade request identified as user
+func upgradeAs(handler http.Handler, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("X-User-ID", user)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestServeHTTPWebSocketConnectionLimit(t *testing.T) {
+	server := newTestRedisServer(t)
+	var handler http.Handler
+	var nested *httptest.ResponseRecorder
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// A second upgrade while the first connection is still open
+		if nested == nil && isWebSocketUpgrade(req) {
+			nested = upgradeAs(handler, "u1")
+		}
+	})
+	handler = newTestPluginNext(t, server, next, func(c *Config) {
+		c.Identifiers[0].RateLimit.Rate = 1
+		c.Identifiers[0].WebSocket = WebSocketPolicy{Mode: WebSocketModeConnections, MaxConnections: 1}
+	})
+
+	if rw := upgradeAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("first upgrade: status %d, want 200", rw.Code)
+	}
+	if nested == nil || nested.Code != http.StatusTooManyRequests {
+		t.Fatalf("upgrade past the open connection limit: %v, want 429", nested)
+	}
+
+	// The connection was released when it ended and upgrades skip the rate limit
+	for i := 0; i < 3; i++ {
+		if rw := upgradeAs(handler, "u1"); rw.Code != http.StatusOK {
+			t.Fatalf("upgrade %d after the connection closed: status %d, want 200", i+1, rw.Code)
+		}
+	}
+	if rw := serveAs(handler, "u1"); rw.Code != http.StatusOK || rw.Header().Get("X-Quota-Used") != "1" {
+		t.Fatalf("regular request: status %d, X-Quota-Used %q; want the first request counted", rw.Code, rw.Header().Get("X-Quota-Used"))
+	}
+}
+
+func TestServeHTTPWebSocketRequestMode(t *testing.T) {
+	server := newTestRedisServer(t)
+	handler := newTestPlugin(t, server, func(c *Config) {
+		c.Identifiers[0].RateLimit.Rate = 1
+	})
+
+	// By default an upgrade is a regular request
+	if rw := upgradeAs(handler, "u1"); rw.Code != http.StatusOK {
+		t.Fatalf("first upgrade: status %d, want 200", rw.Code)
+	}
+	if rw := upgradeAs(handler, "u1"); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("second upgrade: status %d, want the rate limit", rw.Code)
+	}
+}
+
+func TestValidateWebSocketPolicy(t *testing.T) {
+	for _, policy := range []WebSocketPolicy{{Mode: "sessions"}, {Mode: WebSocketModeConnections}} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%+v accepted", policy)
+		}
+	}
+}